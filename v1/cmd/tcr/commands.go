@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/topology"
+)
+
+const peekBodyLimit = 256
+
+func listQueues(tcr *session, args []string) error {
+	flags := flag.NewFlagSet("queues", flag.ExitOnError)
+	vhost := flags.String("vhost", "", "vhost to list, defaults to the ManagementConfig VHost")
+	_ = flags.Parse(args)
+
+	queues, err := tcr.topologer.ListQueues(*vhost)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tTYPE\tREADY\tUNACKED\tTOTAL\tCONSUMERS")
+	for _, queue := range queues {
+		fmt.Fprintf(
+			writer, "%s\t%s\t%d\t%d\t%d\t%d\n",
+			queue.Name, queue.Type, queue.MessagesReady, queue.MessagesUnacknowledged, queue.Messages, queue.Consumers)
+	}
+
+	return writer.Flush()
+}
+
+func peekMessages(tcr *session, args []string) error {
+	flags := flag.NewFlagSet("peek", flag.ExitOnError)
+	queueName := flags.String("queue", "", "queue to peek")
+	count := flags.Int("count", 10, "maximum messages to show")
+	_ = flags.Parse(args)
+
+	if *queueName == "" {
+		return errors.New("peek needs a -queue")
+	}
+
+	deliveries, err := tcr.topologer.PeekMessages(*queueName, *count)
+	if err != nil {
+		return err
+	}
+
+	for i, delivery := range deliveries {
+		fmt.Printf("#%d exchange=%q routingKey=%q messageId=%q contentType=%q redelivered=%t\n",
+			i+1, delivery.Exchange, delivery.RoutingKey, delivery.MessageId, delivery.ContentType, delivery.Redelivered)
+
+		for key, value := range delivery.Headers {
+			fmt.Printf("   %s: %v\n", key, value)
+		}
+
+		fmt.Printf("   %s\n", printableBody(delivery.Body))
+	}
+
+	fmt.Printf("%d message(s) peeked from %s\n", len(deliveries), *queueName)
+	return nil
+}
+
+func moveMessages(tcr *session, args []string) error {
+	flags := flag.NewFlagSet("move", flag.ExitOnError)
+	from := flags.String("from", "", "queue to move messages out of")
+	to := flags.String("to", "", "queue to move messages into")
+	count := flags.Int("count", 0, "maximum messages to move, 0 moves everything")
+	rate := flags.Float64("rate", 0, "maximum messages moved per second, 0 is unlimited")
+	_ = flags.Parse(args)
+
+	if *from == "" || *to == "" {
+		return errors.New("move needs a -from and a -to queue")
+	}
+
+	if *from == *to {
+		return errors.New("can't move messages into the queue they came from")
+	}
+
+	options := &topology.MoveOptions{
+		Rate: *rate,
+		Progress: func(progress topology.MoveProgress) {
+			fmt.Printf("%d message(s) moved in %s\n", progress.Moved, progress.Elapsed.Round(time.Millisecond))
+		},
+	}
+
+	moved, err := tcr.topologer.MoveMessages(*from, *to, *count, nil, options)
+	if err != nil {
+		return fmt.Errorf("moved %d message(s) before failing: %s", moved, err)
+	}
+
+	fmt.Printf("%d message(s) moved from %s to %s\n", moved, *from, *to)
+	return nil
+}
+
+func purgeQueue(tcr *session, args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	queueName := flags.String("queue", "", "queue to purge")
+	_ = flags.Parse(args)
+
+	if *queueName == "" {
+		return errors.New("purge needs a -queue")
+	}
+
+	count, err := tcr.topologer.PurgeQueue(*queueName, false)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d message(s) purged from %s\n", count, *queueName)
+	return nil
+}
+
+func teardownTopology(tcr *session, args []string) error {
+	flags := flag.NewFlagSet("teardown", flag.ExitOnError)
+	topologyPath := flags.String("topology", "", "topology json to tear down")
+	onlyIfEmpty := flags.Bool("only-if-empty", false, "keep queues still holding messages")
+	dryRun := flags.Bool("dry-run", false, "print the plan without changing anything")
+	_ = flags.Parse(args)
+
+	if *topologyPath == "" {
+		return errors.New("teardown needs a -topology")
+	}
+
+	result, err := tcr.topologer.TeardownFromFile(*topologyPath, &topology.TeardownOptions{
+		OnlyIfEmpty: *onlyIfEmpty,
+		DryRun:      *dryRun,
+	})
+	if result == nil {
+		return err
+	}
+
+	for _, action := range result.Actions {
+		fmt.Println(action)
+	}
+
+	for _, skipped := range result.Skipped {
+		fmt.Printf("skipped %s\n", skipped)
+	}
+
+	for _, teardownErr := range result.Errors {
+		fmt.Fprintf(os.Stderr, "failed %s\n", teardownErr)
+	}
+
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d step(s) failed", len(result.Errors))
+	}
+
+	return nil
+}
+
+// PrintableBody shows text bodies (truncated) and summarizes binary ones.
+func printableBody(body []byte) string {
+	if !utf8.Valid(body) {
+		return fmt.Sprintf("<%d bytes binary>", len(body))
+	}
+
+	if len(body) > peekBodyLimit {
+		return fmt.Sprintf("%s... (%d bytes)", body[:peekBodyLimit], len(body))
+	}
+
+	return string(body)
+}
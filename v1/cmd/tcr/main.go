@@ -0,0 +1,119 @@
+// Command tcr inspects and operates on RabbitMQ queues using a RabbitSeasoning config.
+//
+// Usage:
+//
+//	tcr -config seasoning.json queues [-vhost /]
+//	tcr -config seasoning.json peek -queue name [-count 10]
+//	tcr -config seasoning.json move -from name -to name [-count 0] [-rate 0]
+//	tcr -config seasoning.json purge -queue name
+//	tcr -config seasoning.json teardown -topology topology.json [-only-if-empty] [-dry-run]
+//
+// Listing queues needs a ManagementConfig in the seasoning, everything else only needs the PoolConfig.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/topology"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/utils"
+)
+
+type command struct {
+	usage string
+	run   func(tcr *session, args []string) error
+}
+
+var commands = map[string]*command{
+	"queues":   {usage: "list queues with their depths", run: listQueues},
+	"peek":     {usage: "show messages without removing them (get + requeue)", run: peekMessages},
+	"move":     {usage: "move messages from one queue to another", run: moveMessages},
+	"purge":    {usage: "remove every ready message from a queue", run: purgeQueue},
+	"teardown": {usage: "delete the bindings, queues, and exchanges declared in a topology file", run: teardownTopology},
+}
+
+// Session holds what the commands share, built from the RabbitSeasoning.
+type session struct {
+	config      *models.RabbitSeasoning
+	channelPool *pools.ChannelPool
+	topologer   *topology.Topologer
+}
+
+func main() {
+	configPath := flag.String("config", "seasoning.json", "path to the RabbitSeasoning json")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	tcr, err := newSession(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	err = cmd.run(tcr, flag.Args()[1:])
+	tcr.channelPool.Shutdown()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: tcr [-config seasoning.json] <command> [flags]\n\ncommands:\n")
+	for _, name := range []string{"queues", "peek", "move", "purge", "teardown"} {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", name, commands[name].usage)
+	}
+
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
+func newSession(configPath string) (*session, error) {
+	config, err := utils.ConvertJSONFileToConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.PoolConfig == nil {
+		return nil, fmt.Errorf("%s has no PoolConfig", configPath)
+	}
+
+	channelPool, err := pools.NewChannelPool(config.PoolConfig, nil, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var topologer *topology.Topologer
+	if config.ManagementConfig != nil {
+		topologer, err = topology.NewTopologerWithManagement(channelPool, config.ManagementConfig)
+	} else {
+		topologer, err = topology.NewTopologer(channelPool)
+	}
+
+	if err != nil {
+		channelPool.Shutdown()
+		return nil, err
+	}
+
+	return &session{
+		config:      config,
+		channelPool: channelPool,
+		topologer:   topologer,
+	}, nil
+}
@@ -7,5 +7,6 @@ require (
 	github.com/json-iterator/go v1.1.10
 	github.com/klauspost/compress v1.10.10
 	github.com/streadway/amqp v1.0.0
+	github.com/stretchr/testify v1.3.0
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 )
@@ -0,0 +1,143 @@
+package audit_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/audit"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+func TestLogBatches(t *testing.T) {
+	sink := audit.NewMemorySink()
+	log, err := audit.NewLog(sink, &audit.LogOptions{BatchSize: 10, FlushInterval: time.Hour})
+	assert.NoError(t, err)
+
+	models.SetAuditor(log)
+	defer models.SetAuditor(nil)
+
+	for i := 0; i < 25; i++ {
+		models.Audit(&models.AuditRecord{Action: models.AuditAcked, Queue: "ConsumerTestQueue", DeliveryTag: uint64(i + 1)})
+	}
+
+	for len(sink.Records()) < 20 { // two full batches
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 20, len(sink.Records()))
+
+	assert.NoError(t, log.Close(context.Background()))
+	assert.Equal(t, audit.ErrLogClosed, log.Close(context.Background()))
+
+	records := sink.Records()
+	assert.Equal(t, 25, len(records)) // the rest on close
+	for i, record := range records {
+		assert.Equal(t, uint64(i+1), record.DeliveryTag)
+		assert.False(t, record.Time.IsZero())
+	}
+
+	assert.Equal(t, audit.LogStats{Recorded: 25, Appended: 25}, log.Stats())
+}
+
+type flakySink struct {
+	failures int
+	appended []*models.AuditRecord
+	lock     sync.Mutex
+}
+
+func (fs *flakySink) Append(records []*models.AuditRecord) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if fs.failures > 0 {
+		fs.failures--
+		return errors.New("disk unavailable")
+	}
+
+	fs.appended = append(fs.appended, records...)
+	return nil
+}
+
+func TestLogRetriesFailedBatches(t *testing.T) {
+	sink := &flakySink{failures: 2}
+	errs := make(chan error, 2)
+	log, err := audit.NewLog(sink, &audit.LogOptions{
+		BatchSize:     2,
+		RetryInterval: time.Millisecond,
+		OnError:       func(err error) { errs <- err },
+	})
+	assert.NoError(t, err)
+
+	log.Record(&models.AuditRecord{Action: models.AuditPublished, LetterID: 1})
+	log.Record(&models.AuditRecord{Action: models.AuditPublished, LetterID: 2})
+
+	assert.Error(t, <-errs)
+	assert.Error(t, <-errs)
+	assert.NoError(t, log.Close(context.Background()))
+
+	assert.Equal(t, 2, len(sink.appended))
+	assert.Equal(t, uint64(1), sink.appended[0].LetterID)
+	assert.Equal(t, audit.LogStats{Recorded: 2, Appended: 2, SinkErrors: 2}, log.Stats())
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcraudit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	for i := 0; i < 2; i++ { // reopening appends
+		sink, err := audit.NewFileSink(path)
+		assert.NoError(t, err)
+
+		assert.NoError(t, sink.Append([]*models.AuditRecord{
+			{Action: models.AuditConsumed, Queue: "ConsumerTestQueue", MessageID: "posting-1"},
+			{Action: models.AuditAcked, Queue: "ConsumerTestQueue", MessageID: "posting-1"},
+		}))
+		assert.NoError(t, sink.Close())
+		assert.Error(t, sink.Append(nil))
+	}
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := &models.AuditRecord{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), record))
+		assert.Equal(t, "posting-1", record.MessageID)
+		lines++
+	}
+
+	assert.Equal(t, 4, lines)
+}
+
+func TestAuditEvent(t *testing.T) {
+	sink := audit.NewMemorySink()
+	log, err := audit.NewLog(sink, nil)
+	assert.NoError(t, err)
+
+	models.SetAuditor(log)
+	defer models.SetAuditor(nil)
+
+	models.AuditEvent(models.NewEvent(models.ConnectionRecycled, 3, 0, "open for 1h"))
+	models.AuditEvent(models.NewEvent(models.PoolResized, 0, 0, "resized to 4 connections")) // not audited
+
+	assert.NoError(t, log.Close(context.Background()))
+
+	records := sink.Records()
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, models.AuditConnectionCycled, records[0].Action)
+	assert.Equal(t, uint64(3), records[0].ConnectionID)
+}
@@ -0,0 +1,250 @@
+// Package audit writes the library's audit trail (see models.SetAuditor) in batches to an append-only Sink.
+package audit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	defaultBufferSize    = 10000
+	defaultRetryInterval = time.Second
+)
+
+// ErrLogClosed is returned by Close when the Log was already closed.
+var ErrLogClosed = errors.New("audit log is closed")
+
+// Sink stores audit records, append only: records are never changed or removed once appended. Append gets each
+// batch in the order it was recorded and must only return nil once the whole batch is durable.
+type Sink interface {
+	Append(records []*models.AuditRecord) error
+}
+
+// LogOptions tune a Log, zero values use the defaults.
+type LogOptions struct {
+	BatchSize     int           // records per Append, defaults to 100
+	FlushInterval time.Duration // longest a record waits for its batch to fill, defaults to 1s
+	BufferSize    int           // records waiting to be batched, defaults to 10000
+	RetryInterval time.Duration // wait before appending a failed batch again, defaults to 1s
+	DropWhenFull  bool          // drop records (counted in Dropped) instead of blocking the recorder on a full buffer
+	OnError       func(error)   // called with every failed Append
+}
+
+// LogStats are a Log's counters.
+type LogStats struct {
+	Recorded   uint64
+	Appended   uint64
+	Dropped    uint64
+	SinkErrors uint64
+}
+
+// Log is a models.Auditor batching records to a Sink on its own goroutine. A batch that fails to append is
+// retried, in order, until it succeeds or the Log is closed, meanwhile records wait in the buffer and, once it's
+// full, recording blocks (or drops with DropWhenFull) so the trail has no silent gaps.
+type Log struct {
+	sink      Sink
+	options   LogOptions
+	records   chan *models.AuditRecord
+	closing   chan struct{}
+	closed    chan struct{}
+	recorded  uint64
+	appended  uint64
+	dropped   uint64
+	failed    uint64
+	closeOnce *sync.Once
+}
+
+// NewLog starts a Log appending to the sink, set it with models.SetAuditor.
+func NewLog(sink Sink, options *LogOptions) (*Log, error) {
+
+	if sink == nil {
+		return nil, errors.New("can't audit to a nil sink")
+	}
+
+	al := &Log{
+		sink:      sink,
+		closing:   make(chan struct{}),
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+
+	if options != nil {
+		al.options = *options
+	}
+
+	if al.options.BatchSize <= 0 {
+		al.options.BatchSize = defaultBatchSize
+	}
+
+	if al.options.FlushInterval <= 0 {
+		al.options.FlushInterval = defaultFlushInterval
+	}
+
+	if al.options.BufferSize <= 0 {
+		al.options.BufferSize = defaultBufferSize
+	}
+
+	if al.options.RetryInterval <= 0 {
+		al.options.RetryInterval = defaultRetryInterval
+	}
+
+	al.records = make(chan *models.AuditRecord, al.options.BufferSize)
+
+	models.Go("audit.log", al.run)
+
+	return al, nil
+}
+
+// Record queues the record for the next batch, records after Close are dropped.
+func (al *Log) Record(record *models.AuditRecord) {
+
+	select {
+	case <-al.closing:
+		atomic.AddUint64(&al.dropped, 1)
+		return
+	default:
+	}
+
+	if al.options.DropWhenFull {
+		select {
+		case al.records <- record:
+			atomic.AddUint64(&al.recorded, 1)
+		default:
+			atomic.AddUint64(&al.dropped, 1)
+		}
+
+		return
+	}
+
+	select {
+	case al.records <- record:
+		atomic.AddUint64(&al.recorded, 1)
+	case <-al.closing:
+		atomic.AddUint64(&al.dropped, 1)
+	}
+}
+
+// Stats returns the Log's counters.
+func (al *Log) Stats() LogStats {
+	return LogStats{
+		Recorded:   atomic.LoadUint64(&al.recorded),
+		Appended:   atomic.LoadUint64(&al.appended),
+		Dropped:    atomic.LoadUint64(&al.dropped),
+		SinkErrors: atomic.LoadUint64(&al.failed),
+	}
+}
+
+// Close stops recording and appends what's buffered, waiting until it's done or the context ends.
+// Remember to take the Log out of models.SetAuditor first.
+func (al *Log) Close(ctx context.Context) error {
+
+	closed := false
+	al.closeOnce.Do(func() {
+		close(al.closing)
+		closed = true
+	})
+
+	if !closed {
+		return ErrLogClosed
+	}
+
+	select {
+	case <-al.closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (al *Log) run() {
+	defer close(al.closed)
+
+	ticker := time.NewTicker(al.options.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*models.AuditRecord, 0, al.options.BatchSize)
+	for {
+		select {
+		case record := <-al.records:
+			batch = append(batch, record)
+			if len(batch) < al.options.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-al.closing:
+			al.drain(batch)
+			return
+		}
+
+		if !al.appendBatch(batch) {
+			al.drain(batch) // closed while retrying, the failed batch gets one last try
+			return
+		}
+
+		batch = make([]*models.AuditRecord, 0, al.options.BatchSize) // the sink may keep the last one
+	}
+}
+
+// AppendBatch writes the batch, retrying until it succeeds. Returns false when the Log closed before it did.
+func (al *Log) appendBatch(batch []*models.AuditRecord) bool {
+	for {
+		err := al.sink.Append(batch)
+		if err == nil {
+			atomic.AddUint64(&al.appended, uint64(len(batch)))
+			return true
+		}
+
+		atomic.AddUint64(&al.failed, 1)
+		if al.options.OnError != nil {
+			al.options.OnError(err)
+		}
+
+		select {
+		case <-time.After(al.options.RetryInterval):
+		case <-al.closing:
+			return false
+		}
+	}
+}
+
+// Drain appends the batch and everything still buffered once, on close. What fails is counted as dropped.
+func (al *Log) drain(batch []*models.AuditRecord) {
+DrainLoop:
+	for {
+		select {
+		case record := <-al.records:
+			batch = append(batch, record)
+		default:
+			break DrainLoop
+		}
+	}
+
+	for start := 0; start < len(batch); start += al.options.BatchSize {
+		end := start + al.options.BatchSize
+		if end > len(batch) {
+			end = len(batch)
+		}
+
+		if err := al.sink.Append(batch[start:end]); err != nil {
+			atomic.AddUint64(&al.failed, 1)
+			atomic.AddUint64(&al.dropped, uint64(end-start))
+			if al.options.OnError != nil {
+				al.options.OnError(err)
+			}
+
+			continue
+		}
+
+		atomic.AddUint64(&al.appended, uint64(end-start))
+	}
+}
@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// FileSink appends records to a file as JSON lines, opened append only and synced to disk after every batch.
+// Rotate or ship the file with your usual tooling, the sink never truncates or rewrites it.
+type FileSink struct {
+	file     *os.File
+	sinkLock *sync.Mutex
+}
+
+// NewFileSink opens (or creates) the file for appending, readable only by its owner.
+func NewFileSink(path string) (*FileSink, error) {
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{file: file, sinkLock: &sync.Mutex{}}, nil
+}
+
+// Append writes the records, one JSON object per line, and syncs the file.
+func (fs *FileSink) Append(records []*models.AuditRecord) error {
+	fs.sinkLock.Lock()
+	defer fs.sinkLock.Unlock()
+
+	if fs.file == nil {
+		return errors.New("can't append to a closed file sink")
+	}
+
+	writer := bufio.NewWriter(fs.file)
+	encoder := json.NewEncoder(writer)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	return fs.file.Sync()
+}
+
+// Close closes the file.
+func (fs *FileSink) Close() error {
+	fs.sinkLock.Lock()
+	defer fs.sinkLock.Unlock()
+
+	if fs.file == nil {
+		return nil
+	}
+
+	err := fs.file.Close()
+	fs.file = nil
+
+	return err
+}
+
+// MemorySink keeps every record in memory, i.e. for tests.
+type MemorySink struct {
+	records  []*models.AuditRecord
+	sinkLock *sync.Mutex
+}
+
+// NewMemorySink creates an empty MemorySink.
+func NewMemorySink() *MemorySink {
+	return &MemorySink{sinkLock: &sync.Mutex{}}
+}
+
+// Append keeps the records.
+func (ms *MemorySink) Append(records []*models.AuditRecord) error {
+	ms.sinkLock.Lock()
+	ms.records = append(ms.records, records...)
+	ms.sinkLock.Unlock()
+
+	return nil
+}
+
+// Records returns a copy of every record appended so far, in order.
+func (ms *MemorySink) Records() []*models.AuditRecord {
+	ms.sinkLock.Lock()
+	defer ms.sinkLock.Unlock()
+
+	return append([]*models.AuditRecord(nil), ms.records...)
+}
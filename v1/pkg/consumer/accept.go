@@ -0,0 +1,155 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+const eventBuffer = 100
+
+// FormatFilter holds the content types and encodings a Consumer can parse. Content types match on the media type
+// (parameters like charset are ignored), case insensitive, with type/* and */* wildcards. An empty entry accepts
+// deliveries without a content type (or encoding), "identity" also accepts deliveries without an encoding.
+// An empty list accepts anything.
+type formatFilter struct {
+	contentTypes map[string]struct{}
+	encodings    map[string]struct{}
+}
+
+func newFormatFilter(contentTypes []string, encodings []string) *formatFilter {
+	ff := &formatFilter{}
+
+	if len(contentTypes) > 0 {
+		ff.contentTypes = make(map[string]struct{}, len(contentTypes))
+		for _, contentType := range contentTypes {
+			ff.contentTypes[mediaType(contentType)] = struct{}{}
+		}
+	}
+
+	if len(encodings) > 0 {
+		ff.encodings = make(map[string]struct{}, len(encodings))
+		for _, encoding := range encodings {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding == "identity" {
+				ff.encodings[""] = struct{}{}
+			}
+
+			ff.encodings[encoding] = struct{}{}
+		}
+	}
+
+	return ff
+}
+
+// MediaType lowercases the content type and drops its parameters.
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+
+	if parsed, _, err := mime.ParseMediaType(contentType); err == nil {
+		return parsed
+	}
+
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// Unsupported returns why the delivery's format isn't accepted, or empty.
+func (ff *formatFilter) unsupported(delivery *amqp.Delivery) string {
+
+	if ff.contentTypes != nil && !ff.acceptsContentType(mediaType(delivery.ContentType)) {
+		return fmt.Sprintf("unsupported content type %q", delivery.ContentType)
+	}
+
+	if ff.encodings != nil {
+		if _, ok := ff.encodings[strings.ToLower(delivery.ContentEncoding)]; !ok {
+			return fmt.Sprintf("unsupported content encoding %q", delivery.ContentEncoding)
+		}
+	}
+
+	return ""
+}
+
+func (ff *formatFilter) acceptsContentType(contentType string) bool {
+	if _, ok := ff.contentTypes[contentType]; ok {
+		return true
+	}
+
+	if contentType == "" {
+		return false
+	}
+
+	if _, ok := ff.contentTypes["*/*"]; ok {
+		return true
+	}
+
+	if slash := strings.Index(contentType, "/"); slash > 0 {
+		_, ok := ff.contentTypes[contentType[:slash]+"/*"]
+		return ok
+	}
+
+	return false
+}
+
+// SetAcceptedFormats only hands over deliveries in a content type and encoding the Consumer can parse, must be
+// called before consuming starts. Anything else is rejected without requeueing (dead lettered when the queue has
+// a DLX) and reported as an UnsupportedFormat event on Events(). Empty lists accept anything.
+func (con *Consumer) SetAcceptedFormats(contentTypes []string, encodings []string) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't set accepted formats on a started consumer")
+	}
+
+	if len(contentTypes) == 0 && len(encodings) == 0 {
+		con.formatFilter = nil
+		return nil
+	}
+
+	con.formatFilter = newFormatFilter(contentTypes, encodings)
+
+	return nil
+}
+
+// RejectUnsupported rejects the delivery when its format isn't accepted, returning true when it was.
+func (con *Consumer) rejectUnsupported(amqpChan *amqp.Channel, delivery *amqp.Delivery) bool {
+	reason := con.formatFilter.unsupported(delivery)
+	if reason == "" {
+		return false
+	}
+
+	if !con.autoAck {
+		if err := amqpChan.Reject(delivery.DeliveryTag, false); err != nil {
+			con.handleError(err)
+		}
+	}
+
+	con.emitEvent(models.NewEvent(
+		models.UnsupportedFormat,
+		0,
+		0,
+		fmt.Sprintf("%s rejected a delivery from %s (message id: %q): %s", con.ConsumerName, con.QueueName, delivery.MessageId, reason)))
+
+	return true
+}
+
+// EmitEvent sends the event without blocking, events are dropped when nobody is draining Events().
+func (con *Consumer) emitEvent(event *models.Event) {
+	models.CountEvent(event)
+
+	select {
+	case con.events <- event:
+	default:
+	}
+}
+
+// Events yields the Consumer's typed events (i.e. UnsupportedFormat), dropped when not drained.
+func (con *Consumer) Events() <-chan *models.Event {
+	return con.events
+}
@@ -0,0 +1,300 @@
+package consumer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+const (
+	defaultAckBatchInterval = time.Duration(100) * time.Millisecond
+	defaultAckBatchMaxAge   = time.Duration(1) * time.Second
+)
+
+// AckBatcher collects ack decisions from Messages and flushes them as a single multiple ack on a count
+// threshold or timer. A multiple ack is only ever sent up to the highest delivery tag whose predecessors
+// have all been decided, so it never covers a message still being processed. Acks stuck behind an
+// undecided delivery for longer than the max age are sent on their own.
+// Multiple acks cover every outstanding delivery on a channel, so they're only sent on a channel declared
+// exclusive (see SetExclusive), decided acks on any other channel are flushed one by one.
+type AckBatcher struct {
+	batchSize     uint32
+	flushInterval time.Duration
+	maxAge        time.Duration
+	windows       map[*amqp.Channel]*ackWindow
+	errorHandler  func(error)
+	stop          chan bool
+	started       bool
+	lock          *sync.Mutex
+}
+
+// AckWindow holds the outstanding delivery tags of a single channel.
+type ackWindow struct {
+	tags      []uint64             // outstanding delivery tags in delivery order
+	acked     map[uint64]time.Time // decided acks awaiting a flush, by decision time
+	exclusive bool                 // multiple acks can't reach deliveries that aren't tracked, see SetExclusive
+}
+
+// AckSend is an ack a flush sends.
+type ackSend struct {
+	deliveryTag uint64
+	multiple    bool
+}
+
+// NewAckBatcher creates a new AckBatcher. Zero intervals fall back to the defaults.
+func NewAckBatcher(
+	batchSize uint32,
+	flushInterval time.Duration,
+	maxAge time.Duration,
+	errorHandler func(error)) *AckBatcher {
+
+	if flushInterval == 0 {
+		flushInterval = defaultAckBatchInterval
+	}
+
+	if maxAge == 0 {
+		maxAge = defaultAckBatchMaxAge
+	}
+
+	return &AckBatcher{
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		maxAge:        maxAge,
+		windows:       make(map[*amqp.Channel]*ackWindow),
+		errorHandler:  errorHandler,
+		stop:          make(chan bool, 1),
+		lock:          &sync.Mutex{},
+	}
+}
+
+// Start begins the timed flushes.
+func (ab *AckBatcher) Start() {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	if ab.started {
+		return
+	}
+
+	ab.started = true
+	models.Go("consumer.ackbatcher", ab.flushLoop)
+}
+
+// Stop ends the timed flushes and sends every decided ack. Acks made afterwards go straight to the channel.
+func (ab *AckBatcher) Stop() {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	if !ab.started {
+		return
+	}
+
+	ab.started = false
+	ab.stop <- true
+
+	for amqpChan, window := range ab.windows {
+		if err := ab.flushWindow(amqpChan, window, time.Now(), true); err != nil {
+			ab.handleError(err)
+		}
+	}
+
+	ab.windows = make(map[*amqp.Channel]*ackWindow)
+}
+
+func (ab *AckBatcher) flushLoop() {
+	ticker := time.NewTicker(ab.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ab.stop:
+			return
+		case now := <-ticker.C:
+			ab.lock.Lock()
+			for amqpChan, window := range ab.windows {
+				if err := ab.flushWindow(amqpChan, window, now, false); err != nil {
+					ab.handleError(err)
+				}
+			}
+			ab.lock.Unlock()
+		}
+	}
+}
+
+// Track registers a delivery tag, in delivery order, so it can be batched once decided.
+func (ab *AckBatcher) Track(amqpChan *amqp.Channel, deliveryTag uint64) {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	if !ab.started {
+		return
+	}
+
+	window := ab.window(amqpChan)
+	window.tags = append(window.tags, deliveryTag)
+}
+
+// SetExclusive declares the channel the caller's alone (i.e. a dedicated channel, see
+// pools.ChannelPool.GetDedicatedAckableChannel) with every delivery on it tracked, or settled before the next
+// one is tracked. Only then may a run of decided acks with consecutive delivery tags go as a single multiple ack,
+// on any other channel it could ack another consumer's deliveries (or a Get's) before they're processed.
+// Forgetting the channel, or stopping the AckBatcher, forgets it's exclusive.
+func (ab *AckBatcher) SetExclusive(amqpChan *amqp.Channel) {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	if !ab.started {
+		return
+	}
+
+	ab.window(amqpChan).exclusive = true
+}
+
+// Window returns the channel's window, creating it on first use. Must be called while holding the lock.
+func (ab *AckBatcher) window(amqpChan *amqp.Channel) *ackWindow {
+	window, ok := ab.windows[amqpChan]
+	if !ok {
+		window = &ackWindow{acked: make(map[uint64]time.Time)}
+		ab.windows[amqpChan] = window
+	}
+
+	return window
+}
+
+// Forget drops everything tracked for the channel, used when a channel has closed and its tags are void.
+func (ab *AckBatcher) Forget(amqpChan *amqp.Channel) {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	delete(ab.windows, amqpChan)
+}
+
+// Ack records an ack decision, flushing once the batch size has been reached.
+func (ab *AckBatcher) Ack(amqpChan *amqp.Channel, deliveryTag uint64) error {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	window, ok := ab.windows[amqpChan]
+	if !ok || !window.isTracked(deliveryTag) {
+		return amqpChan.Ack(deliveryTag, false)
+	}
+
+	window.acked[deliveryTag] = time.Now()
+	if uint32(len(window.acked)) >= ab.batchSize {
+		return ab.flushWindow(amqpChan, window, time.Now(), false)
+	}
+
+	return nil
+}
+
+// Nack negative acknowledges the delivery immediately and stops tracking it.
+func (ab *AckBatcher) Nack(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	ab.untrack(amqpChan, deliveryTag)
+	return amqpChan.Nack(deliveryTag, false, requeue)
+}
+
+// Reject rejects the delivery immediately and stops tracking it.
+func (ab *AckBatcher) Reject(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	ab.untrack(amqpChan, deliveryTag)
+	return amqpChan.Reject(deliveryTag, requeue)
+}
+
+func (ab *AckBatcher) untrack(amqpChan *amqp.Channel, deliveryTag uint64) {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+
+	if window, ok := ab.windows[amqpChan]; ok {
+		window.remove(deliveryTag)
+	}
+}
+
+// FlushWindow sends the window's flushable acks (see ackWindow.flush). A channel whose ack fails (likely
+// closed) is dropped. Must be called while holding the lock.
+func (ab *AckBatcher) flushWindow(amqpChan *amqp.Channel, window *ackWindow, now time.Time, force bool) error {
+	for _, send := range window.flush(now, ab.maxAge, force) {
+		if err := amqpChan.Ack(send.deliveryTag, send.multiple); err != nil {
+			delete(ab.windows, amqpChan)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ab *AckBatcher) handleError(err error) {
+	if ab.errorHandler != nil {
+		ab.errorHandler(err)
+	}
+}
+
+// Index finds the position of the delivery tag, tags are ascending since they are tracked in delivery order.
+func (aw *ackWindow) index(deliveryTag uint64) int {
+	i := sort.Search(len(aw.tags), func(i int) bool { return aw.tags[i] >= deliveryTag })
+	if i < len(aw.tags) && aw.tags[i] == deliveryTag {
+		return i
+	}
+
+	return -1
+}
+
+// Flush takes the acks to send: the run of decided tags at the front (every tag before them is settled), as a
+// single multiple ack when the channel is exclusive and the run's tags are consecutive (so no delivery in
+// between can be outstanding), one ack each otherwise. Then every other decided tag older than max age (or all
+// of them when forced) on its own, in tag order.
+func (aw *ackWindow) flush(now time.Time, maxAge time.Duration, force bool) []ackSend {
+	var sends []ackSend
+
+	run := 0
+	consecutive := true
+	for run < len(aw.tags) {
+		if _, ok := aw.acked[aw.tags[run]]; !ok {
+			break
+		}
+
+		if run > 0 && aw.tags[run] != aw.tags[run-1]+1 {
+			consecutive = false
+		}
+
+		delete(aw.acked, aw.tags[run])
+		run++
+	}
+
+	if run > 1 && aw.exclusive && consecutive {
+		sends = append(sends, ackSend{deliveryTag: aw.tags[run-1], multiple: true})
+	} else {
+		for _, deliveryTag := range aw.tags[:run] {
+			sends = append(sends, ackSend{deliveryTag: deliveryTag})
+		}
+	}
+	aw.tags = aw.tags[run:]
+
+	aged := make([]uint64, 0, len(aw.acked))
+	for deliveryTag, decided := range aw.acked {
+		if force || now.Sub(decided) >= maxAge {
+			aged = append(aged, deliveryTag)
+		}
+	}
+	sort.Slice(aged, func(i, j int) bool { return aged[i] < aged[j] })
+
+	for _, deliveryTag := range aged {
+		sends = append(sends, ackSend{deliveryTag: deliveryTag})
+		aw.remove(deliveryTag)
+	}
+
+	return sends
+}
+
+func (aw *ackWindow) isTracked(deliveryTag uint64) bool {
+	return aw.index(deliveryTag) >= 0
+}
+
+func (aw *ackWindow) remove(deliveryTag uint64) {
+	delete(aw.acked, deliveryTag)
+
+	if i := aw.index(deliveryTag); i >= 0 {
+		aw.tags = append(aw.tags[:i], aw.tags[i+1:]...)
+	}
+}
@@ -0,0 +1,108 @@
+package consumer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWindow(exclusive bool, tags []uint64, acked map[uint64]time.Time) *ackWindow {
+	return &ackWindow{tags: append([]uint64(nil), tags...), acked: acked, exclusive: exclusive}
+}
+
+func TestAckWindowFlushRuns(t *testing.T) {
+
+	now := time.Now()
+	maxAge := time.Second
+
+	tests := []struct {
+		name      string
+		exclusive bool
+		tags      []uint64
+		acked     []uint64
+		sends     []ackSend
+		remaining []uint64
+	}{
+		{
+			name:      "exclusive consecutive run is one multiple ack",
+			exclusive: true,
+			tags:      []uint64{1, 2, 3, 4},
+			acked:     []uint64{1, 2, 3},
+			sends:     []ackSend{{deliveryTag: 3, multiple: true}},
+			remaining: []uint64{4},
+		},
+		{
+			name:      "shared channel acks one by one",
+			tags:      []uint64{1, 2, 3, 4},
+			acked:     []uint64{1, 2, 3},
+			sends:     []ackSend{{deliveryTag: 1}, {deliveryTag: 2}, {deliveryTag: 3}},
+			remaining: []uint64{4},
+		},
+		{
+			name:      "gap in the run acks one by one",
+			exclusive: true,
+			tags:      []uint64{1, 2, 4, 5},
+			acked:     []uint64{1, 2, 4, 5},
+			sends:     []ackSend{{deliveryTag: 1}, {deliveryTag: 2}, {deliveryTag: 4}, {deliveryTag: 5}},
+			remaining: []uint64{},
+		},
+		{
+			name:      "single decided tag is a plain ack",
+			exclusive: true,
+			tags:      []uint64{7, 8},
+			acked:     []uint64{7},
+			sends:     []ackSend{{deliveryTag: 7}},
+			remaining: []uint64{8},
+		},
+		{
+			name:      "undecided front holds back fresh decisions",
+			exclusive: true,
+			tags:      []uint64{1, 2, 3},
+			acked:     []uint64{2, 3},
+			sends:     nil,
+			remaining: []uint64{1, 2, 3},
+		},
+	}
+
+	for _, test := range tests {
+		acked := make(map[uint64]time.Time)
+		for _, deliveryTag := range test.acked {
+			acked[deliveryTag] = now
+		}
+
+		window := newTestWindow(test.exclusive, test.tags, acked)
+		assert.Equal(t, test.sends, window.flush(now, maxAge, false), test.name)
+		assert.Equal(t, test.remaining, window.tags, test.name)
+	}
+}
+
+func TestAckWindowFlushMaxAge(t *testing.T) {
+
+	now := time.Now()
+	maxAge := time.Second
+
+	window := newTestWindow(true, []uint64{1, 2, 3, 4}, map[uint64]time.Time{
+		2: now.Add(-2 * maxAge), // stuck behind 1 for too long
+		3: now,
+		4: now.Add(-maxAge),
+	})
+
+	assert.Equal(t, []ackSend{{deliveryTag: 2}, {deliveryTag: 4}}, window.flush(now, maxAge, false))
+	assert.Equal(t, []uint64{1, 3}, window.tags)
+	assert.Len(t, window.acked, 1)
+
+	// nothing new is old enough
+	assert.Empty(t, window.flush(now, maxAge, false))
+
+	// the front is decided at last, but 3 is no longer right after it
+	window.acked[1] = now
+	assert.Equal(t, []ackSend{{deliveryTag: 1}, {deliveryTag: 3}}, window.flush(now, maxAge, false))
+	assert.Empty(t, window.tags)
+
+	// a forced flush sends every decision, whatever its age
+	window = newTestWindow(true, []uint64{1, 2, 3}, map[uint64]time.Time{2: now, 3: now})
+	assert.Equal(t, []ackSend{{deliveryTag: 2}, {deliveryTag: 3}}, window.flush(now, maxAge, true))
+	assert.Equal(t, []uint64{1}, window.tags)
+	assert.Empty(t, window.acked)
+}
@@ -0,0 +1,189 @@
+package consumer
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+// AckAction is the outcome for a handler error, Delay is how long a retry is held before it's requeued.
+type AckAction struct {
+	Outcome models.AckOutcome
+	Delay   time.Duration
+}
+
+type ackRule struct {
+	match    func(err error) bool // nil for a category rule
+	category string
+	action   AckAction
+}
+
+// AckPolicy decides what a handler mode Consumer does with an ackable Message the handler left unsettled:
+// a nil error acks it, an error is matched against the rules in the order they were added (errors.Is targets,
+// patterns on the error text, custom matchers, and categories from the classifier), falling back to the default.
+// Rules must be added before the policy is handed to a Consumer.
+type AckPolicy struct {
+	rules      []*ackRule
+	classifier func(err error) string
+	fallback   AckAction
+}
+
+// NewAckPolicy creates an AckPolicy with the outcome for errors no rule matches.
+func NewAckPolicy(fallback AckAction) *AckPolicy {
+	return &AckPolicy{
+		fallback: fallback,
+	}
+}
+
+// NewAckPolicyFromConfig creates an AckPolicy from the pattern and category rules of the config.
+func NewAckPolicyFromConfig(config *models.AckPolicyConfig) (*AckPolicy, error) {
+	fallback := AckAction{Outcome: models.AckOutcomeRequeue}
+	if config.Default != nil {
+		var err error
+		if fallback, err = ackActionFromConfig(config.Default); err != nil {
+			return nil, err
+		}
+	}
+
+	policy := NewAckPolicy(fallback)
+	for _, rule := range config.Rules {
+		action, err := ackActionFromConfig(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		if rule.Pattern != "" {
+			if err = policy.OnPattern(rule.Pattern, action); err != nil {
+				return nil, err
+			}
+		} else {
+			policy.OnCategory(rule.Category, action)
+		}
+	}
+
+	return policy, nil
+}
+
+func ackActionFromConfig(rule *models.AckRuleConfig) (AckAction, error) {
+	outcome, err := models.ParseAckOutcome(rule.Outcome)
+	if err != nil {
+		return AckAction{}, err
+	}
+
+	return AckAction{Outcome: outcome, Delay: time.Duration(rule.Delay) * time.Millisecond}, nil
+}
+
+// OnError matches errors that are (or wrap) the target.
+func (ap *AckPolicy) OnError(target error, action AckAction) {
+	ap.OnMatch(func(err error) bool { return errors.Is(err, target) }, action)
+}
+
+// OnPattern matches errors whose text matches the regular expression.
+func (ap *AckPolicy) OnPattern(pattern string, action AckAction) error {
+	expression, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+
+	ap.OnMatch(func(err error) bool { return expression.MatchString(err.Error()) }, action)
+
+	return nil
+}
+
+// OnMatch matches errors the matcher returns true for.
+func (ap *AckPolicy) OnMatch(match func(err error) bool, action AckAction) {
+	ap.rules = append(ap.rules, &ackRule{match: match, action: action})
+}
+
+// OnCategory matches errors the classifier puts in the category.
+func (ap *AckPolicy) OnCategory(category string, action AckAction) {
+	ap.rules = append(ap.rules, &ackRule{category: category, action: action})
+}
+
+// SetClassifier sets the function sorting errors into the categories of OnCategory.
+func (ap *AckPolicy) SetClassifier(classifier func(err error) string) {
+	ap.classifier = classifier
+}
+
+// Decide returns the action for a handler error, a nil error is always acked.
+func (ap *AckPolicy) Decide(err error) AckAction {
+	if err == nil {
+		return AckAction{Outcome: models.AckOutcomeAck}
+	}
+
+	category := ""
+	classified := false
+	for _, rule := range ap.rules {
+		if rule.match != nil {
+			if rule.match(err) {
+				return rule.action
+			}
+
+			continue
+		}
+
+		if !classified && ap.classifier != nil {
+			category = ap.classifier(err)
+			classified = true
+		}
+
+		if classified && category == rule.category {
+			return rule.action
+		}
+	}
+
+	return ap.fallback
+}
+
+// SetAckPolicy settles, by the AckPolicy, every ackable Message the handler leaves unsettled in handler mode,
+// nil leaves settling to the handler. Must be called before consuming starts.
+func (con *Consumer) SetAckPolicy(policy *AckPolicy) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't set an ack policy on a started consumer")
+	}
+
+	if policy != nil && con.autoAck {
+		return errors.New("can't set an ack policy on an auto ack consumer")
+	}
+
+	con.ackPolicy = policy
+
+	return nil
+}
+
+// ApplyAckPolicy settles the Message by the handler's error. A delayed retry is nacked after the Message is
+// released, so it goes through the acknowledger chain directly.
+func (con *Consumer) applyAckPolicy(msg *models.Message, amqpChan *amqp.Channel, deliveryTag uint64, handlerErr error) {
+	action := con.ackPolicy.Decide(handlerErr)
+
+	var err error
+	switch action.Outcome {
+	case models.AckOutcomeAck:
+		err = msg.Acknowledge()
+	case models.AckOutcomeDeadLetter:
+		err = msg.Nack(false)
+	case models.AckOutcomeRetry:
+		if action.Delay > 0 {
+			time.AfterFunc(action.Delay, func() {
+				if err := con.settleTracker.Nack(amqpChan, deliveryTag, true); err != nil {
+					con.handleError(err)
+				}
+			})
+			return
+		}
+
+		err = msg.Nack(true)
+	default:
+		err = msg.Nack(true)
+	}
+
+	if err != nil {
+		con.handleError(err)
+	}
+}
@@ -0,0 +1,163 @@
+package consumer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// Activity is what a Consumer is doing, or why it isn't processing anything.
+type Activity int32
+
+const (
+	// ActivityStopped means the Consumer isn't consuming.
+	ActivityStopped Activity = iota
+	// ActivityConsuming means deliveries are arriving.
+	ActivityConsuming
+	// ActivityWaitingForChannel means the ChannelPool can't supply a channel (or the consume call is failing).
+	ActivityWaitingForChannel
+	// ActivityQueueEmpty means the Consumer is ready but the queue has nothing to deliver.
+	ActivityQueueEmpty
+	// ActivityNotDraining means deliveries are stuck behind a full Messages() buffer that isn't being read.
+	ActivityNotDraining
+	// ActivityStandby means the Consumer is a warm standby waiting to be promoted (see EnableStandby).
+	ActivityStandby
+)
+
+var activityNames = map[Activity]string{
+	ActivityStopped:           "Stopped",
+	ActivityConsuming:         "Consuming",
+	ActivityWaitingForChannel: "WaitingForChannel",
+	ActivityQueueEmpty:        "QueueEmpty",
+	ActivityNotDraining:       "NotDraining",
+	ActivityStandby:           "Standby",
+}
+
+func (a Activity) String() string {
+	if name, ok := activityNames[a]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Activity(%d)", int32(a))
+}
+
+// ActivityStats is a snapshot of a Consumer's activity, including how long it has spent starved for each reason.
+type ActivityStats struct {
+	Activity        Activity
+	Since           time.Time // when the current activity started
+	Buffered        int       // messages waiting in Messages()
+	BufferCapacity  int
+	PendingHandoffs int64         // deliveries waiting for room in Messages()
+	BufferAge       time.Duration // how long the oldest buffered message has waited, as of the last sample
+	BufferHighWater time.Duration // the longest a message has waited in the buffer
+	QueueEmptyTime  time.Duration
+	NoChannelTime   time.Duration
+	NotDrainingTime time.Duration
+}
+
+type activityTracker struct {
+	current   int32 // Activity, read without the lock on the hot path
+	since     time.Time
+	durations map[Activity]time.Duration
+	lock      *sync.Mutex
+}
+
+func newActivityTracker() *activityTracker {
+	return &activityTracker{
+		since:     time.Now(),
+		durations: make(map[Activity]time.Duration),
+		lock:      &sync.Mutex{},
+	}
+}
+
+// Set moves to the activity, accumulating the time spent in the previous one.
+func (at *activityTracker) set(activity Activity) {
+	if Activity(atomic.LoadInt32(&at.current)) == activity {
+		return
+	}
+
+	at.lock.Lock()
+	defer at.lock.Unlock()
+
+	now := time.Now()
+	previous := Activity(atomic.LoadInt32(&at.current))
+	at.durations[previous] += now.Sub(at.since)
+	at.since = now
+	atomic.StoreInt32(&at.current, int32(activity))
+}
+
+func (at *activityTracker) snapshot() ActivityStats {
+	at.lock.Lock()
+	defer at.lock.Unlock()
+
+	current := Activity(atomic.LoadInt32(&at.current))
+	inCurrent := time.Since(at.since)
+	total := func(activity Activity) time.Duration {
+		if activity == current {
+			return at.durations[activity] + inCurrent
+		}
+
+		return at.durations[activity]
+	}
+
+	return ActivityStats{
+		Activity:        current,
+		Since:           at.since,
+		QueueEmptyTime:  total(ActivityQueueEmpty),
+		NoChannelTime:   total(ActivityWaitingForChannel),
+		NotDrainingTime: total(ActivityNotDraining),
+	}
+}
+
+// Activity returns what the Consumer is doing right now.
+func (con *Consumer) Activity() Activity {
+	return Activity(atomic.LoadInt32(&con.activity.current))
+}
+
+// ActivityStats returns a snapshot of the Consumer's activity and starvation gauges.
+func (con *Consumer) ActivityStats() ActivityStats {
+	stats := con.activity.snapshot()
+	stats.Buffered = len(con.messages)
+	stats.BufferCapacity = cap(con.messages)
+	stats.PendingHandoffs = atomic.LoadInt64(&con.pendingHandoffs)
+	stats.BufferAge, stats.BufferHighWater = con.bufferAge.stats()
+
+	return stats
+}
+
+// IdleActivity decides why no delivery arrived: a full, unread buffer or an empty queue.
+func (con *Consumer) idleActivity() Activity {
+	if atomic.LoadInt64(&con.pendingHandoffs) > 0 || (cap(con.messages) > 0 && len(con.messages) == cap(con.messages)) {
+		return ActivityNotDraining
+	}
+
+	return ActivityQueueEmpty
+}
+
+// State is a snapshot of a Consumer for admin endpoints: what it's doing and, when it can't subscribe,
+// how often it has failed and when it will try again.
+type State struct {
+	QueueName    string
+	ConsumerName string
+	Started      bool
+	Activity     Activity
+	Retry        models.RetryState
+}
+
+// State returns a snapshot of the Consumer's activity and retry state.
+func (con *Consumer) State() State {
+	con.conLock.Lock()
+	started := con.started
+	con.conLock.Unlock()
+
+	return State{
+		QueueName:    con.QueueName,
+		ConsumerName: con.ConsumerName,
+		Started:      started,
+		Activity:     con.Activity(),
+		Retry:        con.retry.State(),
+	}
+}
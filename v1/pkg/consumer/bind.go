@@ -0,0 +1,28 @@
+package consumer
+
+import (
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// BoundHandler handles a Message bound to a struct (see models.Message.Bind).
+type BoundHandler func(msg *models.Message, target interface{}) error
+
+// BindHandler binds every Message into a new target (a pointer to a struct, see models.Message.Bind) before calling
+// the handler, so typed handlers don't extract and assert headers themselves. A Message that can't be bound isn't
+// handed to the handler, its *models.BindError is returned instead (match models.ErrBindFailed in an AckPolicy to
+// reject it). Fails when the target's tags can't be bound.
+func BindHandler(newTarget func() interface{}, handler BoundHandler) (MessageHandler, error) {
+
+	if err := models.CheckBindings(newTarget()); err != nil {
+		return nil, err
+	}
+
+	return func(msg *models.Message) error {
+		target := newTarget()
+		if err := msg.Bind(target); err != nil {
+			return err
+		}
+
+		return handler(msg, target)
+	}, nil
+}
@@ -0,0 +1,31 @@
+package consumer
+
+import (
+	"fmt"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// DeliveryBody is the body a Message is handed: a copy with ImmutableBody, so handlers can't write into the
+// delivery the recording tap, crash reports, and shadow comparisons still read.
+func (con *Consumer) deliveryBody(body []byte) []byte {
+	if con.immutableBody {
+		return models.CopyBody(body)
+	}
+
+	return body
+}
+
+// GuardMessage checksums the Message's body with GuardBody, raising BodyMutated when a handler changed it.
+func (con *Consumer) guardMessage(msg *models.Message) {
+	if con.guardBody {
+		msg.GuardBody(con.bodyMutated)
+	}
+}
+
+func (con *Consumer) bodyMutated(msg *models.Message) {
+	reason := fmt.Sprintf("a handler changed the body of a message from %s (message id: %q)", con.QueueName, msg.MessageID)
+
+	con.emitEvent(models.NewEvent(models.BodyMutated, 0, 0, reason))
+	con.handleError(fmt.Errorf("consumer %s: %s", con.ConsumerName, reason))
+}
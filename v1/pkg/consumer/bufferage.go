@@ -0,0 +1,143 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+const bufferAgeInterval = time.Duration(100) * time.Millisecond
+
+// BufferAge tracks how long Messages sit in the Consumer's buffer before they're read, however they're read
+// (Messages(), ReadMessage, or a handler). The buffer is a FIFO, so the times Messages went in are kept in the
+// same order and, every interval, the oldest ones are taken out for the Messages that have left the buffer since.
+// Ages are accurate to the interval.
+type bufferAge struct {
+	queueName string
+	warnAfter time.Duration // 0 never warns
+	buffered  []time.Time   // when each buffered Message went in, oldest first
+	oldest    time.Duration
+	highWater time.Duration
+	warned    bool
+	ageLock   *sync.Mutex
+}
+
+func newBufferAge() *bufferAge {
+	return &bufferAge{ageLock: &sync.Mutex{}}
+}
+
+// Record notes a Message going into the buffer, called right after it went in.
+func (ba *bufferAge) record() {
+	ba.ageLock.Lock()
+	ba.buffered = append(ba.buffered, time.Now())
+	ba.ageLock.Unlock()
+}
+
+// Sample observes the ages of the Messages that left the buffer, leaving the buffered ones tracked. Returns the
+// age of the oldest buffered Message and whether it just went over warnAfter.
+func (ba *bufferAge) sample(now time.Time, buffered int) (time.Duration, bool) {
+	ba.ageLock.Lock()
+	defer ba.ageLock.Unlock()
+
+	left := len(ba.buffered) - buffered
+	if left < 0 {
+		left = 0 // in the buffer but not recorded yet
+	}
+
+	intervalHigh := time.Duration(0)
+	for _, in := range ba.buffered[:left] {
+		age := now.Sub(in)
+		models.GetMetrics().Histogram(models.MetricBufferSeconds, age.Seconds(), models.Labels{"queue": ba.queueName})
+		if age > intervalHigh {
+			intervalHigh = age
+		}
+	}
+
+	ba.buffered = ba.buffered[left:]
+	if len(ba.buffered) == 0 {
+		ba.buffered = nil // let the array go
+	}
+
+	ba.oldest = 0
+	if len(ba.buffered) > 0 {
+		ba.oldest = now.Sub(ba.buffered[0])
+	}
+
+	if ba.oldest > intervalHigh {
+		intervalHigh = ba.oldest
+	}
+
+	if intervalHigh > ba.highWater {
+		ba.highWater = intervalHigh
+	}
+
+	models.GetMetrics().Gauge(models.MetricBufferAgeHighWater, intervalHigh.Seconds(), models.Labels{"queue": ba.queueName})
+
+	if ba.warnAfter == 0 {
+		return ba.oldest, false
+	}
+
+	if ba.oldest < ba.warnAfter {
+		ba.warned = false
+		return ba.oldest, false
+	}
+
+	warn := !ba.warned
+	ba.warned = true
+
+	return ba.oldest, warn
+}
+
+func (ba *bufferAge) stats() (time.Duration, time.Duration) {
+	ba.ageLock.Lock()
+	defer ba.ageLock.Unlock()
+
+	return ba.oldest, ba.highWater
+}
+
+// WatchBufferAge samples the buffer's ages until the Consumer stops, raising BufferAging when the oldest
+// buffered Message has waited longer than the warning threshold (once, until the buffer catches up again).
+func (con *Consumer) watchBufferAge(stopped <-chan struct{}) {
+	ticker := time.NewTicker(bufferAgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopped:
+			return
+		case now := <-ticker.C:
+			oldest, warn := con.bufferAge.sample(now, len(con.messages))
+			if warn {
+				con.emitEvent(models.NewEvent(
+					models.BufferAging,
+					0,
+					0,
+					fmt.Sprintf("%s has buffered a message from %s unread for %s, over %s", con.ConsumerName, con.QueueName, oldest.Round(time.Millisecond), con.bufferAge.warnAfter)))
+			}
+		}
+	}
+}
+
+// SetBufferAgeWarning raises a BufferAging event when a Message has sat in the buffer unread for longer than the
+// threshold, 0 turns the warning off. Must be called before consuming starts.
+func (con *Consumer) SetBufferAgeWarning(threshold time.Duration) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't set a buffer age warning on a started consumer")
+	}
+
+	if threshold < 0 {
+		return errors.New("can't use a negative buffer age warning")
+	}
+
+	con.bufferAge.ageLock.Lock()
+	con.bufferAge.warnAfter = threshold
+	con.bufferAge.ageLock.Unlock()
+
+	return nil
+}
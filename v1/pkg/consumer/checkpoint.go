@@ -0,0 +1,194 @@
+package consumer
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const checkpointBuffer = 100
+
+// Checkpoint is a snapshot of a Consumer's progress for batch workloads, counted from when the checkpoints
+// were enabled. Auto ack deliveries count as acked once handed off.
+type Checkpoint struct {
+	Sequence        uint64
+	Acked           uint64
+	Nacked          uint64
+	Rejected        uint64
+	AckedSinceLast  uint64
+	LastDeliveryTag uint64 // of the last ack, tags restart on every new channel
+	Started         time.Time
+	Time            time.Time
+	Elapsed         time.Duration // since Started
+	SinceLast       time.Duration // since the previous checkpoint
+	Rate            float64       // acks per second since the previous checkpoint
+	Final           bool          // raised as the Consumer stops
+}
+
+type settleOutcome int
+
+const (
+	settledAck settleOutcome = iota
+	settledNack
+	settledReject
+)
+
+// SettleOutcomeNames label the settle metrics.
+var settleOutcomeNames = map[settleOutcome]string{
+	settledAck:    "ack",
+	settledNack:   "nack",
+	settledReject: "reject",
+}
+
+// Checkpointer counts settled deliveries and raises a Checkpoint every N acks and/or every interval.
+type checkpointer struct {
+	every           uint64
+	interval        time.Duration
+	callback        func(*Checkpoint)
+	checkpoints     chan *Checkpoint
+	sequence        uint64
+	acked           uint64
+	nacked          uint64
+	rejected        uint64
+	ackedAtLast     uint64
+	lastDeliveryTag uint64
+	started         time.Time
+	last            time.Time
+	checkpointLock  *sync.Mutex
+}
+
+// EnableCheckpoints raises a Checkpoint every N acked messages and/or every interval (zero disables either),
+// must be called before consuming starts. Checkpoints are sent to Checkpoints() (dropped when not drained) and,
+// when provided, to the callback on the goroutine that settled the message or on the interval's goroutine.
+// A final Checkpoint is raised when the Consumer stops.
+func (con *Consumer) EnableCheckpoints(every uint64, interval time.Duration, callback func(*Checkpoint)) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't enable checkpoints on a started consumer")
+	}
+
+	if every == 0 && interval <= 0 {
+		return errors.New("checkpoints need a message count and/or an interval")
+	}
+
+	now := time.Now()
+	con.checkpoints = &checkpointer{
+		every:          every,
+		interval:       interval,
+		callback:       callback,
+		checkpoints:    make(chan *Checkpoint, checkpointBuffer),
+		started:        now,
+		last:           now,
+		checkpointLock: &sync.Mutex{},
+	}
+
+	return nil
+}
+
+// Checkpoints yields the Consumer's progress checkpoints, nil unless EnableCheckpoints was called.
+func (con *Consumer) Checkpoints() <-chan *Checkpoint {
+	if con.checkpoints == nil {
+		return nil
+	}
+
+	return con.checkpoints.checkpoints
+}
+
+// Record counts a settled delivery, raising a checkpoint when the ack count reaches the next N.
+func (cp *checkpointer) record(outcome settleOutcome, deliveryTag uint64) {
+	cp.checkpointLock.Lock()
+
+	switch outcome {
+	case settledAck:
+		cp.acked++
+		cp.lastDeliveryTag = deliveryTag
+	case settledNack:
+		cp.nacked++
+	case settledReject:
+		cp.rejected++
+	}
+
+	if outcome != settledAck || cp.every == 0 || cp.acked-cp.ackedAtLast < cp.every {
+		cp.checkpointLock.Unlock()
+		return
+	}
+
+	checkpoint := cp.take(false)
+	cp.checkpointLock.Unlock()
+
+	cp.emit(checkpoint)
+}
+
+// Take builds the next Checkpoint and resets the interval counters, must hold the lock.
+func (cp *checkpointer) take(final bool) *Checkpoint {
+	now := time.Now()
+	sinceLast := now.Sub(cp.last)
+
+	cp.sequence++
+	checkpoint := &Checkpoint{
+		Sequence:        cp.sequence,
+		Acked:           cp.acked,
+		Nacked:          cp.nacked,
+		Rejected:        cp.rejected,
+		AckedSinceLast:  cp.acked - cp.ackedAtLast,
+		LastDeliveryTag: cp.lastDeliveryTag,
+		Started:         cp.started,
+		Time:            now,
+		Elapsed:         now.Sub(cp.started),
+		SinceLast:       sinceLast,
+		Final:           final,
+	}
+
+	if sinceLast > 0 {
+		checkpoint.Rate = float64(checkpoint.AckedSinceLast) / sinceLast.Seconds()
+	}
+
+	cp.ackedAtLast = cp.acked
+	cp.last = now
+
+	return checkpoint
+}
+
+func (cp *checkpointer) emit(checkpoint *Checkpoint) {
+	if cp.callback != nil {
+		cp.callback(checkpoint)
+	}
+
+	select {
+	case cp.checkpoints <- checkpoint:
+	default:
+	}
+}
+
+// Checkpoint raises a checkpoint now.
+func (cp *checkpointer) checkpoint(final bool) {
+	cp.checkpointLock.Lock()
+	checkpoint := cp.take(final)
+	cp.checkpointLock.Unlock()
+
+	cp.emit(checkpoint)
+}
+
+// RunInterval raises a checkpoint every interval until the consume loop stops, then a final one.
+func (cp *checkpointer) runInterval(stopped <-chan struct{}) {
+	if cp.interval <= 0 {
+		<-stopped
+		cp.checkpoint(true)
+		return
+	}
+
+	ticker := time.NewTicker(cp.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopped:
+			cp.checkpoint(true)
+			return
+		case <-ticker.C:
+			cp.checkpoint(false)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// Committer is an external transactional sink (i.e. a database transaction, a transactional Kafka produce)
+// that a Message's ack is deferred behind: the Message is only acked once its effects are committed.
+// Acking can still fail after a commit (i.e. the channel closed) and the Message is then redelivered, so
+// Commit must be idempotent, keyed by the Message's MessageID, for end to end exactly once.
+type Committer interface {
+	// Commit makes the Message's staged effects durable.
+	Commit(ctx context.Context, msg *models.Message) error
+	// Rollback discards the Message's staged effects after a failed handler, commit, or commit timeout.
+	Rollback(ctx context.Context, msg *models.Message) error
+}
+
+// CommitterFuncs adapts a pair of functions to the Committer interface.
+type CommitterFuncs struct {
+	CommitFunc   func(ctx context.Context, msg *models.Message) error
+	RollbackFunc func(ctx context.Context, msg *models.Message) error // optional
+}
+
+// Commit calls CommitFunc.
+func (cf *CommitterFuncs) Commit(ctx context.Context, msg *models.Message) error {
+	return cf.CommitFunc(ctx, msg)
+}
+
+// Rollback calls RollbackFunc when set.
+func (cf *CommitterFuncs) Rollback(ctx context.Context, msg *models.Message) error {
+	if cf.RollbackFunc == nil {
+		return nil
+	}
+
+	return cf.RollbackFunc(ctx, msg)
+}
+
+// CommitMessage is the second phase for a Message whose effects have been staged: it commits them within the
+// timeout (zero waits as long as ctx allows) and acks the Message. When the commit fails or times out, the
+// effects are rolled back (with the same timeout) and the Message is nacked for redelivery.
+// A commit that times out may still land in the sink, which is why Commit must be idempotent.
+func CommitMessage(ctx context.Context, committer Committer, msg *models.Message, timeout time.Duration) error {
+
+	if !msg.IsAckable {
+		return errors.New("can't defer the ack of a message that isn't ackable")
+	}
+
+	commitCtx, cancel := withOptionalTimeout(ctx, timeout)
+	err := committer.Commit(commitCtx, msg)
+	cancel()
+
+	if err != nil {
+		return abortMessage(ctx, committer, msg, timeout, fmt.Errorf("commit failed: %w", err))
+	}
+
+	if err = msg.Acknowledge(); err != nil {
+		return fmt.Errorf("committed but the ack failed, the message will be redelivered: %w", err)
+	}
+
+	return nil
+}
+
+// CommitHandler wraps a handler that stages a Message's effects in the Committer: a Message is acked only once
+// the handler succeeded and CommitMessage committed it, otherwise it's rolled back and nacked for redelivery.
+// The handler must not ack, nack, or reject the Message itself.
+func CommitHandler(committer Committer, timeout time.Duration, handler MessageHandler) MessageHandler {
+	return func(msg *models.Message) (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				_ = abortMessage(context.Background(), committer, msg, timeout, nil)
+				panic(recovered) // reported on Crashes() by the Consumer
+			}
+		}()
+
+		if err = handler(msg); err != nil {
+			return abortMessage(context.Background(), committer, msg, timeout, fmt.Errorf("handler failed: %w", err))
+		}
+
+		return CommitMessage(context.Background(), committer, msg, timeout)
+	}
+}
+
+// AbortMessage rolls back the Message's effects and nacks it for redelivery, returning cause (with any
+// rollback or nack error appended).
+func abortMessage(ctx context.Context, committer Committer, msg *models.Message, timeout time.Duration, cause error) error {
+
+	rollbackCtx, cancel := withOptionalTimeout(ctx, timeout)
+	rollbackErr := committer.Rollback(rollbackCtx, msg)
+	cancel()
+
+	nackErr := msg.Nack(true)
+
+	switch {
+	case cause == nil:
+		return nil
+	case rollbackErr != nil:
+		return fmt.Errorf("%w\r\n[rollback error: %s]", cause, rollbackErr)
+	case nackErr != nil:
+		return fmt.Errorf("%w\r\n[nack error: %s]", cause, nackErr)
+	}
+
+	return cause
+}
+
+func withOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
@@ -3,7 +3,9 @@ package consumer
 import (
 	"errors"
 	"fmt"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
@@ -11,6 +13,10 @@ import (
 	"github.com/streadway/amqp"
 )
 
+// MessageHandler processes a single Message when the Consumer is running in handler mode.
+// Errors returned are sent to the Consumer's Errors().
+type MessageHandler func(msg *models.Message) error
+
 // Consumer receives messages from a RabbitMQ location.
 type Consumer struct {
 	Config               *models.RabbitSeasoning
@@ -19,6 +25,7 @@ type Consumer struct {
 	QueueName            string
 	ConsumerName         string
 	errors               chan error
+	crashes              chan *models.Crash
 	sleepOnErrorInterval time.Duration
 	sleepOnIdleInterval  time.Duration
 	messageGroup         *sync.WaitGroup
@@ -31,6 +38,41 @@ type Consumer struct {
 	noWait               bool
 	args                 amqp.Table
 	qosCountOverride     int
+	qosGlobal            bool
+	chanHost             *pools.ChannelHost
+	retiring             []*pools.ChannelHost // dedicated channels released by the consume, see retireChannels
+	deliveryCount        uint64
+	handler              MessageHandler
+	ackBatcher           *AckBatcher
+	replayGuard          *ReplayGuard
+	dedup                *Deduplicator
+	streamFilter         *streamFilter
+	settleTracker        *settleTracker
+	bufferAge            *bufferAge
+	immutableBody        bool // handlers get a copy of each body (see deliveryBody)
+	guardBody            bool // raise BodyMutated when a handler changes a body
+	rejectPoison         bool // reject a redelivered message the handler panics on (see invokeHandler)
+	requeuedOnStop       int64
+	pendingHandoffs      int64
+	activity             *activityTracker
+	subscription         *subscription
+	stopped              chan struct{} // closed once the consume loop exits
+	lastConsumeErr       error
+	sampler              *sampler
+	shadow               *shadowMode
+	retry                *models.RetryTracker
+	checkpoints          *checkpointer
+	resultRouting        *models.ResultRouting
+	formatFilter         *formatFilter
+	recorder             *Recorder
+	ackPolicy            *AckPolicy
+	tenants              *tenantScheduler
+	standby              *standby
+	draining             bool
+	ringBuffer           bool
+	droppedErrors        uint64
+	overwritten          uint64
+	events               chan *models.Event
 	conLock              *sync.Mutex
 }
 
@@ -52,13 +94,14 @@ func NewConsumerFromConfig(
 		return nil, errors.New("message and/or error buffer in config can't be 0")
 	}
 
-	return &Consumer{
+	con := &Consumer{
 		Config:               nil,
 		channelPool:          channelPool,
 		Enabled:              config.Enabled,
 		QueueName:            config.QueueName,
 		ConsumerName:         config.ConsumerName,
 		errors:               make(chan error, config.ErrorBuffer),
+		crashes:              make(chan *models.Crash, config.ErrorBuffer),
 		sleepOnErrorInterval: time.Duration(config.SleepOnErrorInterval) * time.Millisecond,
 		sleepOnIdleInterval:  time.Duration(config.SleepOnIdleInterval) * time.Millisecond,
 		messageGroup:         &sync.WaitGroup{},
@@ -69,8 +112,127 @@ func NewConsumerFromConfig(
 		noWait:               config.NoWait,
 		args:                 amqp.Table(config.Args),
 		qosCountOverride:     config.QosCountOverride,
+		sampler:              newSampler(),
+		settleTracker:        newSettleTracker(),
+		bufferAge:            newBufferAge(),
+		activity:             newActivityTracker(),
+		retry:                models.NewRetryTracker(),
+		resultRouting:        config.ResultRouting,
+		ringBuffer:           config.BufferStrategy == models.BufferStrategyRing,
+		events:               make(chan *models.Event, eventBuffer),
 		conLock:              &sync.Mutex{},
-	}, nil
+	}
+
+	switch config.BufferStrategy {
+	case "", models.BufferStrategyBlock, models.BufferStrategyRing:
+	default:
+		return nil, fmt.Errorf("can't use unknown buffer strategy %q", config.BufferStrategy)
+	}
+
+	if config.AckBatchSize > 1 && !config.AutoAck {
+		con.ackBatcher = NewAckBatcher(
+			config.AckBatchSize,
+			time.Duration(config.AckBatchInterval)*time.Millisecond,
+			time.Duration(config.AckBatchMaxAge)*time.Millisecond,
+			con.handleError)
+	}
+
+	if len(config.StreamFilters) > 0 {
+		con.streamFilter = newStreamFilter(config.StreamFilters, config.StreamMatchUnfiltered)
+	}
+
+	if len(config.AcceptContentTypes) > 0 || len(config.AcceptContentEncodings) > 0 {
+		con.formatFilter = newFormatFilter(config.AcceptContentTypes, config.AcceptContentEncodings)
+	}
+
+	if config.ReplayWindow > 0 {
+		con.replayGuard = NewReplayGuard(
+			time.Duration(config.ReplayWindow)*time.Millisecond,
+			config.ReplayExpectedCount,
+			config.ReplayFalsePositive)
+	}
+
+	if config.DedupTTL > 0 {
+		dedup, err := NewDeduplicator(NewMemoryDedupStore(), &DedupOptions{
+			Header:   config.DedupHeader,
+			TTL:      time.Duration(config.DedupTTL) * time.Millisecond,
+			ClaimTTL: time.Duration(config.DedupClaimTTL) * time.Millisecond,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		dedup.onError = con.handleError
+		con.dedup = dedup
+	}
+
+	if config.ShadowMode {
+		if config.AutoAck {
+			return nil, errors.New("can't enable shadow mode on an auto ack consumer")
+		}
+
+		con.shadow = newShadowMode(time.Duration(config.ShadowRequeueDelay)*time.Millisecond, config.ErrorBuffer)
+	}
+
+	if config.AckPolicy != nil {
+		if config.AutoAck {
+			return nil, errors.New("can't set an ack policy on an auto ack consumer")
+		}
+
+		ackPolicy, err := NewAckPolicyFromConfig(config.AckPolicy)
+		if err != nil {
+			return nil, err
+		}
+
+		con.ackPolicy = ackPolicy
+	}
+
+	if config.ConsumerTimeout > 0 {
+		if err := con.SetConsumerTimeout(
+			time.Duration(config.ConsumerTimeout)*time.Millisecond,
+			time.Duration(config.ConsumerTimeoutMargin)*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+
+	con.bufferAge.warnAfter = time.Duration(config.BufferAgeWarning) * time.Millisecond
+	con.immutableBody = config.ImmutableBody
+	con.guardBody = config.GuardBody
+	con.rejectPoison = config.RejectPoisonPanics
+
+	if config.Standby {
+		if err := con.EnableStandby(config.StandbyFailover, time.Duration(config.StandbyInterval)*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.CheckpointEvery > 0 || config.CheckpointInterval > 0 {
+		if err := con.EnableCheckpoints(
+			uint64(config.CheckpointEvery),
+			time.Duration(config.CheckpointInterval)*time.Millisecond,
+			nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return con, nil
+}
+
+// NewConsumerFromQueue creates a new Consumer for a declared queue (see Topologer.DeclareQueue), i.e. one the server
+// named. The config's QueueName is ignored, the config itself is left untouched.
+func NewConsumerFromQueue(
+	config *models.ConsumerConfig,
+	queue amqp.Queue,
+	channelPool *pools.ChannelPool) (*Consumer, error) {
+
+	if queue.Name == "" {
+		return nil, errors.New("can't consume a queue without a name")
+	}
+
+	queueConfig := *config
+	queueConfig.QueueName = queue.Name
+
+	return NewConsumerFromConfig(&queueConfig, channelPool)
 }
 
 // NewConsumer creates a new Consumer to receive messages from a specific queuename.
@@ -107,6 +269,7 @@ func NewConsumer(
 		QueueName:            queuename,
 		ConsumerName:         consumerName,
 		errors:               make(chan error, errorBuffer),
+		crashes:              make(chan *models.Crash, errorBuffer),
 		sleepOnErrorInterval: time.Duration(sleepOnErrorInterval) * time.Millisecond,
 		sleepOnIdleInterval:  time.Duration(sleepOnIdleInterval) * time.Millisecond,
 		messageGroup:         &sync.WaitGroup{},
@@ -119,10 +282,127 @@ func NewConsumer(
 		noWait:               noWait,
 		args:                 amqp.Table(args),
 		qosCountOverride:     qosCountOverride,
+		sampler:              newSampler(),
+		settleTracker:        newSettleTracker(),
+		bufferAge:            newBufferAge(),
+		activity:             newActivityTracker(),
+		retry:                models.NewRetryTracker(),
+		events:               make(chan *models.Event, eventBuffer),
 		conLock:              &sync.Mutex{},
 	}, nil
 }
 
+// EnableAckBatching batches acks into multiple acks (see AckBatcher), must be called before consuming starts.
+// Zero intervals fall back to the defaults.
+func (con *Consumer) EnableAckBatching(batchSize uint32, flushInterval time.Duration, maxAge time.Duration) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't enable ack batching on a started consumer")
+	}
+
+	if con.autoAck {
+		return errors.New("can't enable ack batching on an auto ack consumer")
+	}
+
+	if batchSize < 2 {
+		return errors.New("ack batch size must be at least 2")
+	}
+
+	con.ackBatcher = NewAckBatcher(batchSize, flushInterval, maxAge, con.handleError)
+
+	return nil
+}
+
+// EnableReplayProtection drops redeliveries of already acked message-ids within the window (see ReplayGuard),
+// must be called before consuming starts. The expected count is per window.
+func (con *Consumer) EnableReplayProtection(window time.Duration, expectedCount uint32, falsePositiveRate float64) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't enable replay protection on a started consumer")
+	}
+
+	if window <= 0 {
+		return errors.New("replay window must be greater than 0")
+	}
+
+	con.replayGuard = NewReplayGuard(window, expectedCount, falsePositiveRate)
+
+	return nil
+}
+
+// SetStreamFilter only delivers stream messages whose filter value is one of the values (RabbitMQ 3.13+),
+// must be called before consuming starts. The stream filters by chunk, deliveries are also filtered client side
+// (non-matching ones are acked and skipped) so only matching messages are ever seen.
+func (con *Consumer) SetStreamFilter(values []string, matchUnfiltered bool) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't set a stream filter on a started consumer")
+	}
+
+	if len(values) == 0 {
+		con.streamFilter = nil
+		return nil
+	}
+
+	con.streamFilter = newStreamFilter(values, matchUnfiltered)
+
+	return nil
+}
+
+// DuplicatesDropped returns how many deliveries replay protection has dropped.
+func (con *Consumer) DuplicatesDropped() uint64 {
+	if con.replayGuard == nil {
+		return 0
+	}
+
+	return con.replayGuard.Dropped()
+}
+
+// Sample copies a fraction (0.0 - 1.0) of consumed messages to the sink without affecting normal processing.
+// Sampled copies are not ackable. Safe to call at runtime, a rate of 0 or a nil sink turns sampling off.
+func (con *Consumer) Sample(rate float64, sink func(*models.Message)) {
+	con.sampler.set(rate, sink)
+}
+
+// SetPrefetch changes the Consumer's prefetch (QoS) at runtime, re-issued channel wide on the current channel
+// so it applies to the running consume, and on every channel after. A shared ackable channel (not dedicated,
+// see pools.ChannelPool.GetDedicatedAckableChannel) is refused, the prefetch would reach everyone consuming on it.
+func (con *Consumer) SetPrefetch(count int) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if count < 1 {
+		return errors.New("prefetch must be at least 1")
+	}
+
+	con.qosCountOverride = count
+	con.qosGlobal = true
+
+	if con.started && con.chanHost != nil {
+		if con.chanHost.IsAckable() && !con.chanHost.IsDedicated() {
+			return errors.New("can't set a channel wide prefetch on a shared ackable channel")
+		}
+
+		started := time.Now()
+		err := con.chanHost.Channel.Qos(count, 0, true)
+		models.Trace("qos", con.chanHost.ConnectionID, con.chanHost.ChannelID, started, err, "queue: %s, prefetch: %d, global: true", con.QueueName, count)
+		return err
+	}
+
+	return nil
+}
+
+// Deliveries returns how many deliveries the Consumer has received since it was created.
+func (con *Consumer) Deliveries() uint64 {
+	return atomic.LoadUint64(&con.deliveryCount)
+}
+
 // Get gets a single message from any queue.
 func (con *Consumer) Get(queueName string, autoAck bool) (*models.Message, error) {
 
@@ -141,18 +421,38 @@ func (con *Consumer) Get(queueName string, autoAck bool) (*models.Message, error
 	}
 
 	// Get Single Message
+	started := time.Now()
 	amqpDelivery, ok, getErr := chanHost.Channel.Get(queueName, autoAck)
+	models.Trace("basic.get", chanHost.ConnectionID, chanHost.ChannelID, started, getErr, "queue: %s, autoack: %t, empty: %t", queueName, autoAck, !ok)
 	if getErr != nil {
 		con.channelPool.ReturnChannel(chanHost, true)
 		return nil, getErr
 	}
 
 	if ok {
-		return models.NewMessage(
+		msg := models.NewMessage(
 			!autoAck,
-			amqpDelivery.Body,
+			con.deliveryBody(amqpDelivery.Body),
 			amqpDelivery.DeliveryTag,
-			chanHost.Channel), nil
+			chanHost.Channel)
+		msg.MessageID = amqpDelivery.MessageId
+		msg.CorrelationID = amqpDelivery.CorrelationId
+		msg.Headers = amqpDelivery.Headers
+		msg.Exchange = amqpDelivery.Exchange
+		msg.RoutingKey = amqpDelivery.RoutingKey
+		msg.Type = amqpDelivery.Type
+		msg.ContentType = amqpDelivery.ContentType
+		msg.ContentEncoding = amqpDelivery.ContentEncoding
+		msg.Priority = amqpDelivery.Priority
+		msg.Queue = queueName
+		con.guardMessage(msg)
+		auditConsumed(msg, amqpDelivery.DeliveryTag)
+
+		if autoAck { // an ackable message is settled on its channel, which stays shared in the pool
+			con.channelPool.ReturnChannel(chanHost, false)
+		}
+
+		return msg, nil
 	}
 	con.channelPool.ReturnChannel(chanHost, false)
 	return nil, nil
@@ -188,7 +488,9 @@ GetBatchLoop:
 			break GetBatchLoop
 		}
 
+		started := time.Now()
 		amqpDelivery, ok, getErr := chanHost.Channel.Get(queueName, autoAck)
+		models.Trace("basic.get", chanHost.ConnectionID, chanHost.ChannelID, started, getErr, "queue: %s, autoack: %t, empty: %t", queueName, autoAck, !ok)
 		if getErr != nil {
 			con.channelPool.ReturnChannel(chanHost, true)
 			return nil, getErr
@@ -198,18 +500,54 @@ GetBatchLoop:
 			break GetBatchLoop
 		}
 
-		messages = append(messages, models.NewMessage(
+		msg := models.NewMessage(
 			!autoAck,
-			amqpDelivery.Body,
+			con.deliveryBody(amqpDelivery.Body),
 			amqpDelivery.DeliveryTag,
-			chanHost.Channel))
+			chanHost.Channel)
+		msg.MessageID = amqpDelivery.MessageId
+		msg.CorrelationID = amqpDelivery.CorrelationId
+		msg.Headers = amqpDelivery.Headers
+		msg.Exchange = amqpDelivery.Exchange
+		msg.RoutingKey = amqpDelivery.RoutingKey
+		msg.Type = amqpDelivery.Type
+		msg.ContentType = amqpDelivery.ContentType
+		msg.ContentEncoding = amqpDelivery.ContentEncoding
+		msg.Priority = amqpDelivery.Priority
+		msg.Queue = queueName
+		con.guardMessage(msg)
+		auditConsumed(msg, amqpDelivery.DeliveryTag)
+
+		messages = append(messages, msg)
+	}
+
+	if autoAck { // ackable messages are settled on their channel, which stays shared in the pool
+		con.channelPool.ReturnChannel(chanHost, false)
 	}
 
 	return messages, nil
 }
 
-// StartConsuming starts the Consumer.
+// StartConsuming starts the Consumer, subscribing happens asynchronously (see WaitUntilConsuming).
 func (con *Consumer) StartConsuming() error {
+	return con.start(nil)
+}
+
+// StartConsumingWithHandler starts the Consumer in handler mode.
+// Deliveries are handed straight to the handler on the consuming goroutine (or on tenant workers, see
+// EnableTenantIsolation), skipping the internal Messages() buffer and the goroutine per delivery. Messages are pooled and recycled once the handler
+// returns so the Message itself must not be retained after the handler call.
+// Handler panics are recovered and reported on Crashes(). With an AckPolicy (see SetAckPolicy), messages the
+// handler leaves unsettled are settled by the handler's error.
+func (con *Consumer) StartConsumingWithHandler(handler MessageHandler) error {
+	if handler == nil {
+		return errors.New("can't start consuming with a nil handler")
+	}
+
+	return con.start(handler)
+}
+
+func (con *Consumer) start(handler MessageHandler) error {
 	con.conLock.Lock()
 	defer con.conLock.Unlock()
 
@@ -219,15 +557,77 @@ func (con *Consumer) StartConsuming() error {
 
 	if con.Enabled {
 
+		if con.shadow != nil {
+			if err := con.validateShadowMode(handler); err != nil {
+				return err
+			}
+		}
+
+		if con.tenants != nil && (handler == nil || con.shadow != nil) {
+			return errors.New("tenant isolation needs a handler, use StartConsumingWithHandler (without shadow mode)")
+		}
+
 		con.FlushErrors()
 		con.FlushStop()
 
-		go con.startConsuming()
+		con.handler = handler
+		con.subscription = newSubscription()
+		con.stopped = make(chan struct{})
+		con.lastConsumeErr = nil
+		con.draining = false
+
+		if con.standby != nil {
+			con.standby.reset()
+		}
+
+		if con.ackBatcher != nil {
+			con.ackBatcher.Start()
+		}
+
+		// settles pass through the tracker, dedup, replay guard, then ack batcher
+		var next models.Acknowledger
+		if con.ackBatcher != nil {
+			next = con.ackBatcher
+		}
+
+		if con.replayGuard != nil {
+			con.replayGuard.next = next
+			next = con.replayGuard
+		}
+
+		if con.dedup != nil {
+			con.dedup.next = next
+			next = con.dedup
+		}
+
+		con.settleTracker.next = next
+		con.settleTracker.forgetRequeued()
+
+		if con.tenants != nil {
+			con.tenants.start(func(job *tenantJob) { con.handleDelivery(job.amqpChan, job.delivery, job.isAckable) })
+		}
+
+		con.settleTracker.checkpoints = con.checkpoints
+		con.settleTracker.queueName = con.QueueName
+		if con.checkpoints != nil {
+			stopped := con.stopped
+			models.Go("consumer.checkpoints", func() { con.checkpoints.runInterval(stopped) })
+		}
+
+		if timeouts := con.settleTracker.timeouts; timeouts != nil {
+			stopped := con.stopped
+			models.Go("consumer.timeouts", func() { con.watchTimeouts(timeouts, stopped) })
+		}
+
+		con.bufferAge.queueName = con.QueueName
+		stopped := con.stopped
+		models.Go("consumer.bufferage", func() { con.watchBufferAge(stopped) })
+
+		models.Go("consumer.consume", con.startConsuming)
 		con.started = true
 	}
 
 	return nil
-
 }
 
 func (con *Consumer) startConsuming() {
@@ -244,11 +644,23 @@ ConsumerOuterLoop:
 			break
 		}
 
+		if con.isDraining() { // the consume was cancelled, don't start another
+			break ConsumerOuterLoop
+		}
+
+		if con.standby.waiting() && !con.waitForPromotion() {
+			break ConsumerOuterLoop
+		}
+
 		deliveryChan, chanHost, err := con.getDeliveryChannel()
 		if err != nil {
+			con.retry.Failed(err, con.sleepOnErrorInterval)
+			time.Sleep(con.sleepOnErrorInterval)
 			continue // retry
 		}
 
+		con.retry.Succeeded()
+
 		//ProcessDeliveries InnerLoop - Returns true when consumer stop is called.
 		if con.processDeliveries(deliveryChan, chanHost) {
 			break ConsumerOuterLoop
@@ -259,25 +671,45 @@ ConsumerOuterLoop:
 	immediateStop := con.stopImmediate
 	con.conLock.Unlock()
 
+	if con.tenants != nil {
+		con.tenants.stop(immediateStop) // an immediate stop already requeued the queued deliveries
+	}
+
 	if !immediateStop {
 		con.messageGroup.Wait() // wait for every message to be received to the internal queue
 	}
 
+	if con.ackBatcher != nil {
+		con.ackBatcher.Stop()
+	}
+
+	con.retireChannels()
+
 	con.conLock.Lock()
 	con.started = false
 	con.stopImmediate = false
+	close(con.stopped)
 	con.conLock.Unlock()
+
+	con.activity.set(ActivityStopped)
 }
 
 // GetDeliveryChannel attempts to get the amqp.Delivery chan and a viable ChannelHost from the ChannelPool.
 func (con *Consumer) getDeliveryChannel() (<-chan amqp.Delivery, *pools.ChannelHost, error) {
 
+	con.activity.set(ActivityWaitingForChannel)
+
 	// Get Channel
 	var chanHost *pools.ChannelHost
 	var err error
 
 	if con.autoAck {
 		chanHost, err = con.channelPool.GetChannel()
+	} else if con.ackBatcher != nil {
+		chanHost, err = con.channelPool.GetDedicatedAckableChannel() // batched multiple acks only reach this consume
+		if errors.Is(err, pools.ErrAckChannelLimit) {
+			chanHost, err = con.channelPool.GetAckableChannel() // the batcher acks one by one on a shared channel
+		}
 	} else {
 		chanHost, err = con.channelPool.GetAckableChannel()
 	}
@@ -288,23 +720,67 @@ func (con *Consumer) getDeliveryChannel() (<-chan amqp.Delivery, *pools.ChannelH
 	}
 
 	// Quality of Service channel overrides
-	if con.qosCountOverride > 0 {
-		err := chanHost.Channel.Qos(con.qosCountOverride, 0, false)
+	con.conLock.Lock()
+	qosCount, qosGlobal := con.qosCountOverride, con.qosGlobal
+	con.chanHost = chanHost
+	sub := con.subscription
+	con.conLock.Unlock()
+
+	if qosCount > 0 {
+		started := time.Now()
+		err := chanHost.Channel.Qos(qosCount, 0, qosGlobal)
+		models.Trace("qos", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, prefetch: %d, global: %t", con.QueueName, qosCount, qosGlobal)
 		if err != nil {
 			return nil, nil, err
 		}
 	}
 
 	// Start Consuming
-	deliveryChan, err := chanHost.Channel.Consume(con.QueueName, con.ConsumerName, con.autoAck, con.exclusive, false, con.noWait, nil)
+	started := time.Now()
+	deliveryChan, err := chanHost.Channel.Consume(con.QueueName, con.ConsumerName, con.autoAck, con.exclusive, false, con.noWait, con.consumeArgs())
+	models.Trace("consume", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, consumer: %s, autoack: %t", con.QueueName, con.ConsumerName, con.autoAck)
 	if err != nil {
+		con.conLock.Lock()
+		con.lastConsumeErr = err
+		con.conLock.Unlock()
+
+		// a standby that lost the failover race goes back to waiting, it wasn't refused for good
+		if !con.standby.refused(err) && isPermanentConsumeError(err) {
+			sub.complete(err)
+		}
+
 		con.handleErrorAndChannel(err, chanHost)
 		return nil, nil, err // Retry
 	}
 
+	con.settleTracker.identify(chanHost)
+	if con.ackBatcher != nil && chanHost.IsDedicated() {
+		con.ackBatcher.SetExclusive(chanHost.Channel)
+	}
+	sub.complete(nil)
+	con.standby.consuming()
+
 	return deliveryChan, chanHost, nil
 }
 
+// ConsumeArgs merges the stream filter arguments into the configured consume arguments.
+func (con *Consumer) consumeArgs() amqp.Table {
+	if con.streamFilter == nil {
+		return con.args
+	}
+
+	args := make(amqp.Table, len(con.args)+2)
+	for key, value := range con.args {
+		args[key] = value
+	}
+
+	for key, value := range con.streamFilter.args() {
+		args[key] = value
+	}
+
+	return args
+}
+
 // ProcessDeliveries is the inner loop for processing the deliveries and returns true to break outer loop.
 func (con *Consumer) processDeliveries(deliveryChan <-chan amqp.Delivery, chanHost *pools.ChannelHost) bool {
 
@@ -315,6 +791,21 @@ ProcessDeliveriesInnerLoop:
 		select {
 		case errorMessage := <-chanHost.CloseErrors():
 			if errorMessage != nil {
+				if con.ackBatcher != nil {
+					con.ackBatcher.Forget(chanHost.Channel)
+				}
+
+				if con.replayGuard != nil {
+					con.replayGuard.Forget(chanHost.Channel)
+				}
+
+				if con.dedup != nil {
+					con.dedup.Forget(chanHost.Channel)
+				}
+
+				con.settleTracker.take(chanHost.Channel)
+				con.settleTracker.forgetIDs(chanHost.Channel)
+
 				con.handleErrorAndChannel(fmt.Errorf("consumer's current channel closed\r\n[reason: %s]\r\n[code: %d]", errorMessage.Reason, errorMessage.Code), chanHost)
 				break ProcessDeliveriesInnerLoop
 			}
@@ -324,10 +815,83 @@ ProcessDeliveriesInnerLoop:
 
 		// Convert amqp.Delivery into our internal struct for later use.
 		select {
-		case delivery := <-deliveryChan: // all buffered deliveries are wipe on a channel close error
+		case delivery, ok := <-deliveryChan: // all buffered deliveries are wipe on a channel close error
+			if !ok { // the consume was cancelled, by Drain or the server
+				con.releaseChannel(chanHost)
+				return con.isDraining()
+			}
+
+			atomic.AddUint64(&con.deliveryCount, 1)
+			con.activity.set(ActivityConsuming)
+			models.GetMetrics().Counter(models.MetricDeliveries, 1, models.Labels{"queue": con.QueueName})
+
+			if con.recorder != nil {
+				if err := con.recorder.Record(&delivery); err != nil {
+					con.handleError(err)
+				}
+			}
+
+			if con.streamFilter != nil && !con.streamFilter.matches(&delivery) {
+				if !con.autoAck {
+					if err := chanHost.Channel.Ack(delivery.DeliveryTag, false); err != nil {
+						con.handleError(err)
+					}
+				}
+
+				break
+			}
+
+			if con.formatFilter != nil && con.rejectUnsupported(chanHost.Channel, &delivery) {
+				break
+			}
+
+			if con.replayGuard != nil {
+				duplicate, err := con.replayGuard.isDuplicate(chanHost.Channel, &delivery, !con.autoAck)
+				if err != nil {
+					con.handleError(err)
+				}
+
+				if duplicate {
+					break
+				}
+			}
+
+			if con.dedup != nil {
+				admitted, event := con.dedup.admit(chanHost.Channel, &delivery, !con.autoAck)
+				if event != nil {
+					con.emitEvent(event)
+				}
+
+				if !admitted {
+					break
+				}
+			}
+
+			if con.ackBatcher != nil && !con.autoAck {
+				con.ackBatcher.Track(chanHost.Channel, delivery.DeliveryTag)
+			}
+
+			con.sampler.sample(&delivery, con.QueueName)
+
+			if con.shadow != nil {
+				con.handleShadowDelivery(chanHost.Channel, &delivery)
+				break
+			}
+
+			if con.handler != nil {
+				if con.tenants != nil {
+					con.tenants.submit(chanHost.Channel, &delivery, !con.autoAck)
+					break
+				}
+
+				con.handleDelivery(chanHost.Channel, &delivery, !con.autoAck)
+				break
+			}
+
 			con.messageGroup.Add(1)
 			con.convertDelivery(chanHost.Channel, &delivery, !con.autoAck)
 		default:
+			con.activity.set(con.idleActivity())
 			time.Sleep(con.sleepOnIdleInterval)
 			break
 		}
@@ -336,7 +900,15 @@ ProcessDeliveriesInnerLoop:
 		select {
 		case stop := <-con.consumeStop:
 			if stop {
-				con.channelPool.ReturnChannel(chanHost, false)
+				con.conLock.Lock()
+				immediateStop := con.stopImmediate
+				con.conLock.Unlock()
+
+				if immediateStop && !con.autoAck {
+					atomic.StoreInt64(&con.requeuedOnStop, con.requeueUnacked(deliveryChan, chanHost.Channel))
+				}
+
+				con.releaseChannel(chanHost)
 				return true
 			}
 		default:
@@ -349,6 +921,8 @@ ProcessDeliveriesInnerLoop:
 
 // StopConsuming allows you to signal stop to the consumer.
 // Will stop on the consumer channelclose or responding to signal after getting all remaining deviveries.
+// An immediate stop of an ackable consumer requeues every unacked delivery on its channel (see RequeuedOnStop),
+// settling their Messages afterwards returns ErrDeliveryExpired.
 // FlushMessages empties the internal buffer of messages received by queue. Ackable messages are still in
 // RabbitMQ queue, while noAck messages will unfortunately be lost. Use wisely.
 func (con *Consumer) StopConsuming(immediate bool, flushMessages bool) error {
@@ -370,6 +944,11 @@ func (con *Consumer) StopConsuming(immediate bool, flushMessages bool) error {
 	return nil
 }
 
+// RequeuedOnStop returns how many unacked deliveries the last immediate stop requeued.
+func (con *Consumer) RequeuedOnStop() int64 {
+	return atomic.LoadInt64(&con.requeuedOnStop)
+}
+
 // Messages yields all the internal messages ready for consuming.
 func (con *Consumer) Messages() <-chan *models.Message {
 	return con.messages
@@ -381,7 +960,10 @@ func (con *Consumer) handleErrorAndChannel(err error, chanHost *pools.ChannelHos
 }
 
 func (con *Consumer) handleError(err error) {
-	go func() { con.errors <- err }()
+	if !models.SendError("consumer.errors", con.errors, err) {
+		atomic.AddUint64(&con.droppedErrors, 1)
+		models.GetMetrics().Counter(models.MetricDroppedErrors, 1, models.Labels{"source": "consumer"})
+	}
 }
 
 // Errors yields all the internal errs for consuming messages.
@@ -389,18 +971,167 @@ func (con *Consumer) Errors() <-chan error {
 	return con.errors
 }
 
+// DroppedErrors returns how many errors were dropped instead of sent to Errors() (see models.SetErrorDelivery).
+func (con *Consumer) DroppedErrors() uint64 {
+	return atomic.LoadUint64(&con.droppedErrors)
+}
+
 func (con *Consumer) convertDelivery(amqpChan *amqp.Channel, delivery *amqp.Delivery, isAckable bool) {
 	msg := models.NewMessage(
 		isAckable,
-		delivery.Body,
+		con.deliveryBody(delivery.Body),
 		delivery.DeliveryTag,
 		amqpChan)
+	msg.MessageID = delivery.MessageId
+	msg.CorrelationID = delivery.CorrelationId
+	msg.Headers = delivery.Headers
+	msg.Exchange = delivery.Exchange
+	msg.RoutingKey = delivery.RoutingKey
+	msg.Type = delivery.Type
+	msg.ContentType = delivery.ContentType
+	msg.ContentEncoding = delivery.ContentEncoding
+	msg.Priority = delivery.Priority
+	msg.Queue = con.QueueName
+	con.guardMessage(msg)
+	auditConsumed(msg, delivery.DeliveryTag)
+
+	if isAckable {
+		con.setAcknowledger(amqpChan, msg, delivery.DeliveryTag)
+	} else if con.checkpoints != nil {
+		con.checkpoints.record(settledAck, delivery.DeliveryTag)
+	}
 
-	go func() {
+	if con.ringBuffer {
+		defer con.messageGroup.Done()
+		con.pushOverwriting(msg)
+		return
+	}
+
+	atomic.AddInt64(&con.pendingHandoffs, 1)
+	models.Go("consumer.handoff", func() {
 		defer con.messageGroup.Done() // finished after getting the message in the channel
 
 		con.messages <- msg
+		con.bufferAge.record()
+		atomic.AddInt64(&con.pendingHandoffs, -1)
+	})
+}
+
+// AuditConsumed records the Message's receipt to the Auditor, if any (see models.SetAuditor).
+func auditConsumed(msg *models.Message, deliveryTag uint64) {
+	if !models.Auditing() {
+		return
+	}
+
+	models.Audit(&models.AuditRecord{
+		Action:        models.AuditConsumed,
+		Exchange:      msg.Exchange,
+		RoutingKey:    msg.RoutingKey,
+		Queue:         msg.Queue,
+		MessageID:     msg.MessageID,
+		CorrelationID: msg.CorrelationID,
+		DeliveryTag:   deliveryTag,
+	})
+}
+
+// SetAcknowledger tracks the Message until it's settled and routes its ack decisions through replay protection
+// and/or ack batching.
+func (con *Consumer) setAcknowledger(amqpChan *amqp.Channel, msg *models.Message, deliveryTag uint64) {
+	con.settleTracker.track(amqpChan, deliveryTag)
+	msg.SetAcknowledger(con.settleTracker)
+}
+
+// HandleDelivery hands a pooled Message to the handler and recycles it afterwards.
+func (con *Consumer) handleDelivery(amqpChan *amqp.Channel, delivery *amqp.Delivery, isAckable bool) {
+	msg := models.GetPooledMessage(
+		isAckable,
+		con.deliveryBody(delivery.Body),
+		delivery.DeliveryTag,
+		amqpChan)
+	msg.MessageID = delivery.MessageId
+	msg.CorrelationID = delivery.CorrelationId
+	msg.Headers = delivery.Headers
+	msg.Exchange = delivery.Exchange
+	msg.RoutingKey = delivery.RoutingKey
+	msg.Type = delivery.Type
+	msg.ContentType = delivery.ContentType
+	msg.ContentEncoding = delivery.ContentEncoding
+	msg.Priority = delivery.Priority
+	msg.Queue = con.QueueName
+	con.guardMessage(msg)
+	auditConsumed(msg, delivery.DeliveryTag)
+
+	if isAckable {
+		con.setAcknowledger(amqpChan, msg, delivery.DeliveryTag)
+	} else if con.checkpoints != nil {
+		con.checkpoints.record(settledAck, delivery.DeliveryTag)
+	}
+
+	err := con.invokeHandler(msg, delivery)
+	if err != nil {
+		con.handleError(err)
+	}
+
+	if con.ackPolicy != nil && isAckable && !msg.Settled() {
+		con.applyAckPolicy(msg, amqpChan, delivery.DeliveryTag, err)
+	}
+
+	msg.CheckBody()
+	models.ReleaseMessage(msg)
+}
+
+// InvokeHandler calls the handler, recovering a panic into a Crash. An unsettled ackable message is requeued,
+// or rejected when it was already a redelivery and RejectPoisonPanics is set, so a poison message can't panic
+// forever. A failed settle is returned wrapped in the panic's error.
+func (con *Consumer) invokeHandler(msg *models.Message, delivery *amqp.Delivery) (err error) {
+	defer func(start time.Time) {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+
+		models.ObserveSince(models.MetricHandlerSeconds, start, models.Labels{"queue": con.QueueName, "result": result})
+	}(time.Now())
+
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		crash := models.NewCrash(recovered, debug.Stack(), con.ConsumerName, con.QueueName, delivery)
+		var settleErr error
+		if msg.IsAckable && !msg.Settled() {
+			if con.rejectPoison && delivery.Redelivered {
+				settleErr = msg.Reject(false)
+			} else {
+				settleErr = msg.Nack(true)
+				crash.Requeued = settleErr == nil
+			}
+		}
+
+		con.sendCrash(crash)
+		if settleErr != nil {
+			err = fmt.Errorf("consumer handler panicked: %v\r\n[settle: %w]", recovered, settleErr)
+		} else {
+			err = fmt.Errorf("consumer handler panicked: %v", recovered)
+		}
 	}()
+
+	return con.handler(msg)
+}
+
+// SendCrash sends the Crash without blocking, crashes are dropped when nobody is draining Crashes().
+func (con *Consumer) sendCrash(crash *models.Crash) {
+	select {
+	case con.crashes <- crash:
+	default:
+	}
+}
+
+// Crashes yields a Crash for every handler panic in handler mode: the panic, the stack, and the delivery.
+func (con *Consumer) Crashes() <-chan *models.Crash {
+	return con.crashes
 }
 
 // FlushStop allows you to flush out all previous Stop signals.
@@ -2,8 +2,10 @@ package consumer_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,10 +13,13 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/consumer"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/metrics"
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/publisher"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/topology"
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/utils"
+	"github.com/streadway/amqp"
 )
 
 var Seasoning *models.RabbitSeasoning
@@ -245,6 +250,52 @@ ErrorLoop:
 	cancel()
 }
 
+func TestPublishAndConsumeWithHandler(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, publisher)
+
+	consumerConfig, ok := Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"]
+	assert.True(t, ok)
+
+	con, err := consumer.NewConsumerFromConfig(consumerConfig, channelPool)
+	assert.NoError(t, err)
+	assert.NotNil(t, con)
+
+	received := make(chan []byte, 1)
+	err = con.StartConsumingWithHandler(func(msg *models.Message) error {
+		body := make([]byte, len(msg.Body))
+		copy(body, msg.Body)
+
+		select {
+		case received <- body:
+		default:
+		}
+
+		return nil
+	})
+	assert.NoError(t, err)
+
+	publisher.Publish(utils.CreateMockRandomLetter("ConsumerTestQueue"))
+
+	select {
+	case body := <-received:
+		assert.NotEmpty(t, body)
+	case <-time.After(time.Duration(2) * time.Second):
+		t.Error("handler was never called")
+	}
+
+	assert.NoError(t, con.StopConsuming(false, true))
+	channelPool.Shutdown()
+}
+
 func TestPublishAndConsumeMany(t *testing.T) {
 
 	t.Logf("%s: Benchmark started...", time.Now())
@@ -372,3 +423,1321 @@ ConsumeMessages:
 	t.Logf("%s: Messages Failed to Publish: %d\r\n", time.Now(), messagesFailedToPublish)
 	t.Logf("%s: Messages Received: %d\r\n", time.Now(), messagesReceived)
 }
+
+func TestWaitUntilConsuming(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	consumerConfig, ok := Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"]
+	assert.True(t, ok)
+
+	con, err := consumer.NewConsumerFromConfig(consumerConfig, channelPool)
+	assert.NoError(t, err)
+
+	assert.Error(t, con.WaitUntilConsuming(context.Background())) // not started
+
+	assert.NoError(t, con.StartConsuming())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	assert.NoError(t, con.WaitUntilConsuming(ctx))
+	cancel()
+
+	assert.NoError(t, con.StopConsuming(true, true))
+	channelPool.Shutdown()
+}
+
+func TestWaitUntilConsumingMissingQueue(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	con, err := consumer.NewConsumer(
+		Seasoning, channelPool, "ConsumerTestQueueThatDoesNotExist", "", true, false, false, nil, 0, 10, 10, 50, 5)
+	assert.NoError(t, err)
+	con.Enabled = true
+
+	assert.NoError(t, con.StartConsuming())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	err = con.WaitUntilConsuming(ctx)
+	cancel()
+
+	assert.Error(t, err)
+	assert.NotEqual(t, context.DeadlineExceeded, err) // refused by the broker, not timed out
+
+	assert.NoError(t, con.StopConsuming(true, true))
+	channelPool.Shutdown()
+}
+
+func TestReadMessage(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig, ok := Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"]
+	assert.True(t, ok)
+
+	con, err := consumer.NewConsumerFromConfig(consumerConfig, channelPool)
+	assert.NoError(t, err)
+
+	_, err = con.ReadMessage(context.Background())
+	assert.Error(t, err) // not started
+
+	assert.NoError(t, con.StartConsuming())
+
+	publisher.Publish(utils.CreateMockRandomLetter("ConsumerTestQueue"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	msg, err := con.ReadMessage(ctx)
+	cancel()
+	assert.NoError(t, err)
+	assert.NotNil(t, msg)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Duration(50)*time.Millisecond)
+	_, err = con.ReadMessage(ctx)
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.NoError(t, con.StopConsuming(false, true))
+
+	_, err = con.ReadMessage(context.Background())
+	assert.Equal(t, consumer.ErrConsumerStopped, err)
+
+	channelPool.Shutdown()
+}
+
+type recordingAcker struct {
+	acked    int
+	nacked   int
+	rejected int
+}
+
+func (ra *recordingAcker) Ack(amqpChan *amqp.Channel, deliveryTag uint64) error {
+	ra.acked++
+	return nil
+}
+
+func (ra *recordingAcker) Nack(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	ra.nacked++
+	return nil
+}
+
+func (ra *recordingAcker) Reject(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	ra.rejected++
+	return nil
+}
+
+func TestCommitHandler(t *testing.T) {
+
+	committed, rolledBack := 0, 0
+	committer := &consumer.CommitterFuncs{
+		CommitFunc: func(ctx context.Context, msg *models.Message) error {
+			if string(msg.Body) == "fail" {
+				return errors.New("sink unavailable")
+			}
+
+			committed++
+			return nil
+		},
+		RollbackFunc: func(ctx context.Context, msg *models.Message) error {
+			rolledBack++
+			return nil
+		},
+	}
+
+	handler := consumer.CommitHandler(committer, time.Second, func(msg *models.Message) error { return nil })
+
+	acker := &recordingAcker{}
+	msg := models.NewMessage(true, []byte("ok"), 1, &amqp.Channel{})
+	msg.SetAcknowledger(acker)
+
+	assert.NoError(t, handler(msg))
+	assert.Equal(t, 1, committed)
+	assert.Equal(t, 1, acker.acked)
+
+	msg = models.NewMessage(true, []byte("fail"), 2, &amqp.Channel{})
+	msg.SetAcknowledger(acker)
+
+	assert.Error(t, handler(msg))
+	assert.Equal(t, 1, rolledBack)
+	assert.Equal(t, 1, acker.nacked)
+}
+
+func TestConsumerState(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	con, err := consumer.NewConsumer(
+		Seasoning, channelPool, "ConsumerTestQueueThatDoesNotExist", "", true, false, false, nil, 0, 10, 10, 50, 5)
+	assert.NoError(t, err)
+	con.Enabled = true
+
+	assert.NoError(t, con.StartConsuming())
+	time.Sleep(time.Duration(200) * time.Millisecond)
+
+	state := con.State()
+	assert.True(t, state.Started)
+	assert.True(t, state.Retry.Retrying)
+	assert.True(t, state.Retry.Attempts > 0)
+	assert.Error(t, state.Retry.LastError)
+
+	assert.NoError(t, con.StopConsuming(true, true))
+	channelPool.Shutdown()
+}
+
+func TestCheckpoints(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig, ok := Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"]
+	assert.True(t, ok)
+
+	con, err := consumer.NewConsumerFromConfig(consumerConfig, channelPool)
+	assert.NoError(t, err)
+	assert.Error(t, con.EnableCheckpoints(0, 0, nil))
+	assert.NoError(t, con.EnableCheckpoints(2, time.Minute, nil))
+
+	assert.NoError(t, con.StartConsuming())
+
+	for i := 0; i < 2; i++ {
+		publisher.Publish(utils.CreateMockRandomLetter("ConsumerTestQueue"))
+	}
+
+	select {
+	case checkpoint := <-con.Checkpoints():
+		assert.Equal(t, uint64(1), checkpoint.Sequence)
+		assert.Equal(t, uint64(2), checkpoint.AckedSinceLast)
+		assert.False(t, checkpoint.Final)
+	case <-time.After(time.Duration(5) * time.Second):
+		assert.Fail(t, "no checkpoint after 2 acks")
+	}
+
+	assert.NoError(t, con.StopConsuming(false, true))
+
+	select {
+	case checkpoint := <-con.Checkpoints():
+		assert.True(t, checkpoint.Final)
+	case <-time.After(time.Duration(5) * time.Second):
+		assert.Fail(t, "no final checkpoint")
+	}
+
+	channelPool.Shutdown()
+}
+
+func TestConsumeServerNamedQueue(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	topologer, err := topology.NewTopologer(channelPool)
+	assert.NoError(t, err)
+
+	_, err = topologer.DeclareQueue(&models.Queue{Exclusive: true})
+	assert.Error(t, err)
+
+	queue, err := topologer.DeclareQueue(&models.Queue{AutoDelete: true})
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", queue.Name)
+
+	consumerConfig, ok := Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"]
+	assert.True(t, ok)
+
+	con, err := consumer.NewConsumerFromQueue(consumerConfig, queue, channelPool)
+	assert.NoError(t, err)
+	assert.Equal(t, queue.Name, con.QueueName)
+	assert.Equal(t, "ConsumerTestQueue", consumerConfig.QueueName) // left untouched
+
+	assert.NoError(t, con.StartConsuming())
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+	publisher.Publish(utils.CreateMockRandomLetter(queue.Name))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	msg, err := con.ReadMessage(ctx)
+	cancel()
+	assert.NoError(t, err)
+	assert.NotNil(t, msg)
+
+	assert.NoError(t, con.StopConsuming(false, true))
+	channelPool.Shutdown()
+}
+
+type recordingResultPublisher struct {
+	published []amqp.Publishing
+	keys      []string
+}
+
+func (rrp *recordingResultPublisher) PublishRaw(exchange, key string, mandatory, immediate bool, publishing amqp.Publishing) error {
+	rrp.published = append(rrp.published, publishing)
+	rrp.keys = append(rrp.keys, key)
+	return nil
+}
+
+func TestResultHandler(t *testing.T) {
+
+	publisher := &recordingResultPublisher{}
+	routing := &models.ResultRouting{
+		Success:      &models.ResultRoute{Exchange: "results", RoutingKey: "orders.done"},
+		Failure:      &models.ResultRoute{Exchange: "results", RoutingKey: "orders.failed"},
+		CarryHeaders: []string{"x-tenant"},
+	}
+
+	handler := consumer.ResultHandler(publisher, routing, func(msg *models.Message) ([]byte, error) {
+		if string(msg.Body) == "fail" {
+			return nil, errors.New("can't transform")
+		}
+
+		return append([]byte("done:"), msg.Body...), nil
+	})
+
+	acker := &recordingAcker{}
+	msg := models.NewMessage(true, []byte("ok"), 1, &amqp.Channel{})
+	msg.CorrelationID = "request-1"
+	msg.Headers = amqp.Table{"x-tenant": "acme", "x-other": true}
+	msg.SetAcknowledger(acker)
+
+	assert.NoError(t, handler(msg))
+	assert.Equal(t, 1, acker.acked)
+	assert.Equal(t, "orders.done", publisher.keys[0])
+	assert.Equal(t, "done:ok", string(publisher.published[0].Body))
+	assert.Equal(t, "request-1", publisher.published[0].CorrelationId)
+	assert.Equal(t, "acme", publisher.published[0].Headers["x-tenant"])
+	assert.NotContains(t, publisher.published[0].Headers, "x-other")
+
+	msg = models.NewMessage(true, []byte("fail"), 2, &amqp.Channel{})
+	msg.MessageID = "request-2"
+	msg.SetAcknowledger(acker)
+
+	assert.Error(t, handler(msg))
+	assert.Equal(t, 2, acker.acked) // the failure was routed
+	assert.Equal(t, "orders.failed", publisher.keys[1])
+	assert.Equal(t, "can't transform", string(publisher.published[1].Body))
+	assert.Equal(t, "request-2", publisher.published[1].CorrelationId)
+	assert.Equal(t, "failure", publisher.published[1].Headers[consumer.ResultHeader])
+
+	routing.Failure = nil
+	msg = models.NewMessage(true, []byte("fail"), 3, &amqp.Channel{})
+	msg.SetAcknowledger(acker)
+
+	assert.Error(t, handler(msg))
+	assert.Equal(t, 1, acker.rejected)
+}
+
+func TestRejectUnsupportedFormat(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig, ok := Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"]
+	assert.True(t, ok)
+
+	con, err := consumer.NewConsumerFromConfig(consumerConfig, channelPool)
+	assert.NoError(t, err)
+	assert.NoError(t, con.SetAcceptedFormats([]string{"application/json", "text/*"}, nil))
+	assert.NoError(t, con.StartConsuming())
+
+	letter := utils.CreateMockRandomLetter("ConsumerTestQueue")
+	letter.Envelope.ContentType = "application/xml"
+	publisher.Publish(letter)
+
+	select {
+	case event := <-con.Events():
+		assert.Equal(t, models.UnsupportedFormat, event.Type)
+	case <-time.After(time.Duration(5) * time.Second):
+		assert.Fail(t, "unsupported format wasn't reported")
+	}
+
+	letter = utils.CreateMockRandomLetter("ConsumerTestQueue")
+	letter.Envelope.ContentType = "text/plain; charset=utf-8"
+	publisher.Publish(letter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	msg, err := con.ReadMessage(ctx)
+	cancel()
+	assert.NoError(t, err)
+	assert.NotNil(t, msg)
+
+	assert.NoError(t, con.StopConsuming(false, true))
+	channelPool.Shutdown()
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig, ok := Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"]
+	assert.True(t, ok)
+
+	con, err := consumer.NewConsumerFromConfig(consumerConfig, channelPool)
+	assert.NoError(t, err)
+
+	path := fmt.Sprintf("%s/tcr-recording-%d.jsonl", os.TempDir(), time.Now().UnixNano())
+	defer os.Remove(path)
+
+	recorder, err := consumer.NewFileRecorder(path)
+	assert.NoError(t, err)
+	assert.NoError(t, con.SetRecorder(recorder))
+	assert.NoError(t, con.StartConsuming())
+
+	messageIDs := make([]string, 3)
+	for i := range messageIDs {
+		letter := utils.CreateMockRandomLetter("ConsumerTestQueue")
+		letter.Envelope.MessageID = fmt.Sprintf("recorded-%d", i)
+		messageIDs[i] = letter.Envelope.MessageID
+		publisher.Publish(letter)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+		_, err = con.ReadMessage(ctx)
+		cancel()
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, con.StopConsuming(false, true))
+	assert.NoError(t, recorder.Close())
+	assert.Equal(t, uint64(3), recorder.Count())
+
+	replayer, err := consumer.NewReplayer(path, 0)
+	assert.NoError(t, err)
+
+	replayed := make([]string, 0, 3)
+	stats := replayer.Run(func(msg *models.Message) error {
+		replayed = append(replayed, msg.MessageID)
+		if len(replayed) == 2 {
+			return msg.Nack(false)
+		}
+
+		return msg.Acknowledge()
+	})
+
+	assert.Equal(t, messageIDs, replayed)
+	assert.Equal(t, uint64(3), stats.Delivered)
+	assert.Equal(t, uint64(2), stats.Acked)
+	assert.Equal(t, uint64(1), stats.Nacked)
+
+	channelPool.Shutdown()
+}
+
+func TestAckPolicyDecide(t *testing.T) {
+	errTimeout := errors.New("timeout")
+
+	policy, err := consumer.NewAckPolicyFromConfig(&models.AckPolicyConfig{
+		Rules: []*models.AckRuleConfig{
+			{Pattern: "^invalid", Outcome: "deadletter"},
+			{Category: "transient", Outcome: "retry", Delay: 250},
+		},
+		Default: &models.AckRuleConfig{Outcome: "requeue"},
+	})
+	assert.NoError(t, err)
+
+	policy.OnError(errTimeout, consumer.AckAction{Outcome: models.AckOutcomeRetry, Delay: time.Second})
+	policy.SetClassifier(func(err error) string {
+		if err.Error() == "unavailable" {
+			return "transient"
+		}
+
+		return ""
+	})
+
+	assert.Equal(t, models.AckOutcomeAck, policy.Decide(nil).Outcome)
+	assert.Equal(t, models.AckOutcomeDeadLetter, policy.Decide(errors.New("invalid payload")).Outcome)
+	assert.Equal(t, consumer.AckAction{Outcome: models.AckOutcomeRetry, Delay: time.Duration(250) * time.Millisecond}, policy.Decide(errors.New("unavailable")))
+	assert.Equal(t, consumer.AckAction{Outcome: models.AckOutcomeRetry, Delay: time.Second}, policy.Decide(fmt.Errorf("fetching: %w", errTimeout)))
+	assert.Equal(t, models.AckOutcomeRequeue, policy.Decide(errors.New("something else")).Outcome)
+
+	_, err = consumer.NewAckPolicyFromConfig(&models.AckPolicyConfig{Default: &models.AckRuleConfig{Outcome: "drop"}})
+	assert.Error(t, err)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig, ok := Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"]
+	assert.True(t, ok)
+
+	con, err := consumer.NewConsumerFromConfig(consumerConfig, channelPool)
+	assert.NoError(t, err)
+	assert.NoError(t, con.EnableTenantIsolation(2, consumer.TenantHeaderExtractor("tenant"), consumer.TenantQuota{MaxConcurrent: 1}))
+	assert.Error(t, con.StartConsuming())
+
+	handled := make(chan string, 11)
+	assert.NoError(t, con.StartConsumingWithHandler(func(msg *models.Message) error {
+		time.Sleep(time.Duration(50) * time.Millisecond)
+		handled <- msg.Headers["tenant"].(string)
+		return nil
+	}))
+
+	for i := 0; i < 11; i++ {
+		letter := utils.CreateMockRandomLetter("ConsumerTestQueue")
+		letter.Envelope.Headers = map[string]interface{}{"tenant": "noisy"}
+		if i == 10 {
+			letter.Envelope.Headers["tenant"] = "quiet"
+		}
+		publisher.Publish(letter)
+	}
+
+	// the noisy tenant is held to one worker, so the quiet one gets the other right away
+	quietAt := -1
+	for i := 0; i < 11; i++ {
+		select {
+		case tenant := <-handled:
+			if tenant == "quiet" {
+				quietAt = i
+			}
+		case <-time.After(time.Duration(5) * time.Second):
+			assert.Fail(t, "deliveries weren't handled")
+		}
+	}
+
+	assert.True(t, quietAt >= 0 && quietAt < 5)
+	assert.NoError(t, con.StopConsuming(false, true))
+
+	stats := con.TenantStats()
+	assert.Equal(t, 2, len(stats))
+	assert.Equal(t, uint64(10), stats[0].Handled) // noisy
+	assert.Equal(t, uint64(1), stats[1].Handled)  // quiet
+
+	channelPool.Shutdown()
+}
+
+func TestDrain(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig, ok := Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+	assert.True(t, ok)
+
+	con, err := consumer.NewConsumerFromConfig(consumerConfig, channelPool)
+	assert.NoError(t, err)
+	assert.Error(t, con.Drain(context.Background()))
+	assert.NoError(t, con.StartConsuming())
+	assert.NoError(t, con.WaitUntilConsuming(context.Background()))
+
+	for i := 0; i < 10; i++ {
+		publisher.Publish(utils.CreateMockRandomLetter("ConsumerTestQueue"))
+	}
+
+	// settle every message after a while, the drain waits for them
+	models.Go("test.settle", func() {
+		for i := 0; i < 10; i++ {
+			msg := <-con.Messages()
+			time.Sleep(time.Duration(10) * time.Millisecond)
+			assert.NoError(t, msg.Acknowledge())
+		}
+	})
+
+	time.Sleep(time.Duration(100) * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	defer cancel()
+
+	assert.NoError(t, con.Drain(ctx))
+	assert.Equal(t, int64(0), con.Unsettled())
+	assert.False(t, con.State().Started)
+
+	channelPool.Shutdown()
+}
+
+func TestChannelPoolDrainAfterGet(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig, ok := Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+	assert.True(t, ok)
+
+	con, err := consumer.NewConsumerFromConfig(consumerConfig, channelPool)
+	assert.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		publisher.Publish(utils.CreateMockRandomLetter("ConsumerTestQueue"))
+	}
+
+	time.Sleep(time.Duration(100) * time.Millisecond)
+
+	msg, err := con.Get("ConsumerTestQueue", false)
+	assert.NoError(t, err)
+	assert.NotNil(t, msg)
+	assert.NoError(t, msg.Acknowledge())
+
+	_, err = con.Get("ConsumerTestQueue", true)
+	assert.NoError(t, err)
+
+	msgs, err := con.GetBatch("ConsumerTestQueue", 2, true)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, msgs)
+
+	// neither the shared ackable channel nor the auto ack ones are left out
+	assert.Equal(t, int64(0), channelPool.DrainState().Outstanding)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2)*time.Second)
+	defer cancel()
+
+	assert.NoError(t, channelPool.Drain(ctx))
+	assert.True(t, channelPool.DrainState().Drained)
+}
+
+func TestMessageAnnotations(t *testing.T) {
+	const tenantKey models.AnnotationKey = "tenant"
+	const payloadKey models.AnnotationKey = "payload"
+
+	msg := models.GetPooledMessage(false, []byte(`{"id":1}`), 1, nil)
+
+	_, ok := msg.Annotation(tenantKey)
+	assert.False(t, ok)
+	assert.Equal(t, context.Background(), msg.Context())
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		models.Go("test.annotate", func() {
+			defer wg.Done()
+			msg.Annotate(tenantKey, "acme")
+			_, _ = msg.AnnotationString(tenantKey)
+		})
+	}
+	wg.Wait()
+
+	msg.Annotate(payloadKey, map[string]int{"id": 1})
+
+	tenant, ok := msg.AnnotationString(tenantKey)
+	assert.True(t, ok)
+	assert.Equal(t, "acme", tenant)
+
+	_, ok = msg.AnnotationString(payloadKey)
+	assert.False(t, ok)
+	assert.Equal(t, 2, len(msg.Annotations()))
+
+	msg.RemoveAnnotation(payloadKey)
+	assert.Equal(t, 1, len(msg.Annotations()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	msg.SetContext(ctx)
+	assert.Equal(t, ctx, msg.Context())
+
+	models.ReleaseMessage(msg)
+	msg = models.GetPooledMessage(false, nil, 2, nil)
+	assert.Equal(t, 0, len(msg.Annotations()))
+	assert.Equal(t, context.Background(), msg.Context())
+	models.ReleaseMessage(msg)
+}
+
+func TestRingBuffer(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+	consumerConfig.MessageBuffer = 2
+	consumerConfig.BufferStrategy = models.BufferStrategyRing
+
+	con, err := consumer.NewConsumerFromConfig(&consumerConfig, channelPool)
+	assert.NoError(t, err)
+	assert.NoError(t, con.StartConsuming())
+	assert.NoError(t, con.WaitUntilConsuming(context.Background()))
+
+	// nothing reads the buffer, so only the 2 freshest messages are kept
+	for i := 0; i < 10; i++ {
+		publisher.Publish(utils.CreateMockRandomLetter("ConsumerTestQueue"))
+	}
+
+	time.Sleep(time.Duration(500) * time.Millisecond)
+
+	assert.Equal(t, uint64(8), con.Overwritten())
+	assert.Equal(t, 2, len(con.Messages()))
+
+	for i := 0; i < 2; i++ {
+		msg := <-con.Messages()
+		assert.NoError(t, msg.Acknowledge())
+	}
+
+	assert.NoError(t, con.StopConsuming(false, true))
+
+	consumerConfig.BufferStrategy = "lifo"
+	_, err = consumer.NewConsumerFromConfig(&consumerConfig, channelPool)
+	assert.Error(t, err)
+
+	channelPool.Shutdown()
+}
+
+func TestConsumerTimeout(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+	consumerConfig.ConsumerTimeout = 1000
+	consumerConfig.ConsumerTimeoutMargin = 200
+
+	con, err := consumer.NewConsumerFromConfig(&consumerConfig, channelPool)
+	assert.NoError(t, err)
+	assert.NoError(t, con.StartConsuming())
+	assert.NoError(t, con.WaitUntilConsuming(context.Background()))
+
+	publisher.Publish(utils.CreateMockRandomLetter("ConsumerTestQueue"))
+
+	// held past the margin, the delivery is requeued and redelivered
+	msg := <-con.Messages()
+	for _, eventType := range []models.EventType{models.ConsumerTimeoutNear, models.DeliveryExpired} {
+		select {
+		case event := <-con.Events():
+			assert.Equal(t, eventType, event.Type)
+		case <-time.After(time.Duration(2) * time.Second):
+			assert.Fail(t, "consumer timeout events weren't raised")
+		}
+	}
+
+	assert.Equal(t, consumer.ErrDeliveryExpired, msg.Acknowledge())
+
+	select {
+	case redelivered := <-con.Messages():
+		assert.NoError(t, redelivered.Acknowledge())
+	case <-time.After(time.Duration(2) * time.Second):
+		assert.Fail(t, "expired delivery wasn't redelivered")
+	}
+
+	assert.NoError(t, con.StopConsuming(false, true))
+
+	channelPool.Shutdown()
+}
+
+func TestSendErrorDelivery(t *testing.T) {
+	defer models.SetErrorDelivery(models.ErrorDeliveryImmediate)
+
+	errs := make(chan error, 1)
+
+	// immediate never waits, the second error finds the buffer full
+	assert.True(t, models.SendError("test.errors", errs, errors.New("first")))
+	assert.False(t, models.SendError("test.errors", errs, errors.New("second")))
+
+	// deferred waits for room on a goroutine
+	models.SetErrorDelivery(models.ErrorDeliveryDeferred)
+	assert.True(t, models.SendError("test.errors", errs, errors.New("third")))
+
+	assert.Equal(t, "first", (<-errs).Error())
+	assert.Equal(t, "third", (<-errs).Error())
+}
+
+func TestMessageMux(t *testing.T) {
+	handled := make([]string, 0)
+	handler := func(name string) consumer.MessageHandler {
+		return func(msg *models.Message) error {
+			handled = append(handled, name)
+			return nil
+		}
+	}
+
+	mux := consumer.NewMessageMux()
+	mux.HandleType("order.created", handler("created"))
+	mux.HandleRoutingKey("audit.*.login", handler("login"))
+	mux.HandleRoutingKey("audit.#", handler("audit"))
+
+	mux.Use(func(next consumer.MessageHandler) consumer.MessageHandler {
+		return func(msg *models.Message) error {
+			handled = append(handled, "outer")
+			return next(msg)
+		}
+	}, func(next consumer.MessageHandler) consumer.MessageHandler {
+		return func(msg *models.Message) error {
+			handled = append(handled, "inner")
+			return next(msg)
+		}
+	})
+
+	msg := models.NewMessage(false, nil, 1, nil)
+	msg.Type = "order.created"
+	msg.RoutingKey = "audit.eu.login" // the type wins
+	assert.NoError(t, mux.HandleMessage(msg))
+	assert.Equal(t, []string{"outer", "inner", "created"}, handled)
+
+	handled = handled[:0]
+	msg.Type = ""
+	assert.NoError(t, mux.HandleMessage(msg))
+	msg.RoutingKey = "audit.eu.logout.failed"
+	assert.NoError(t, mux.HandleMessage(msg))
+	msg.RoutingKey = "audit"
+	assert.NoError(t, mux.HandleMessage(msg))
+	assert.Equal(t, []string{"outer", "inner", "login", "outer", "inner", "audit", "outer", "inner", "audit"}, handled)
+
+	msg.RoutingKey = "billing.paid"
+	assert.Error(t, mux.HandleMessage(msg))
+
+	mux.SetTypeHeader("x-type")
+	msg.Headers = amqp.Table{"x-type": "order.created"}
+	handled = handled[:0]
+	assert.NoError(t, consumer.Chain(mux.HandleMessage)(msg))
+	assert.Equal(t, []string{"outer", "inner", "created"}, handled)
+
+	var _ consumer.Handler = mux
+	var _ consumer.Handler = consumer.MessageHandler(mux.HandleMessage)
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	store := consumer.NewMemoryDedupStore()
+	ctx := context.Background()
+	ttl := time.Duration(100) * time.Millisecond
+
+	state, err := store.Claim(ctx, "posting-1", ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, consumer.DedupClaimed, state)
+
+	state, err = store.Claim(ctx, "posting-1", ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, consumer.DedupProcessing, state)
+
+	// a released claim is free again
+	assert.NoError(t, store.Release(ctx, "posting-1"))
+	state, err = store.Claim(ctx, "posting-1", ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, consumer.DedupClaimed, state)
+
+	// a processed key outlives releases until its ttl runs out
+	assert.NoError(t, store.Mark(ctx, "posting-1", ttl))
+	assert.NoError(t, store.Release(ctx, "posting-1"))
+	state, err = store.Claim(ctx, "posting-1", ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, consumer.DedupProcessed, state)
+
+	time.Sleep(ttl * 2)
+
+	state, err = store.Claim(ctx, "posting-1", ttl)
+	assert.NoError(t, err)
+	assert.Equal(t, consumer.DedupClaimed, state)
+}
+
+func TestDedup(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+
+	con, err := consumer.NewConsumerFromConfig(&consumerConfig, channelPool)
+	assert.NoError(t, err)
+	assert.NoError(t, con.EnableDedup(consumer.NewMemoryDedupStore(), &consumer.DedupOptions{TTL: time.Minute}))
+	assert.NoError(t, con.StartConsuming())
+	assert.NoError(t, con.WaitUntilConsuming(context.Background()))
+
+	for i := 0; i < 2; i++ {
+		letter := utils.CreateMockRandomLetter("ConsumerTestQueue")
+		letter.Envelope.MessageID = "posting-1"
+		publisher.Publish(letter)
+	}
+
+	msg := <-con.Messages()
+	assert.NoError(t, msg.Acknowledge())
+
+	select {
+	case event := <-con.Events():
+		assert.Equal(t, models.DuplicateDropped, event.Type)
+	case <-time.After(time.Duration(2) * time.Second):
+		assert.Fail(t, "duplicate wasn't dropped")
+	}
+
+	assert.Equal(t, uint64(1), con.DedupStats().Dropped)
+	assert.Equal(t, 0, len(con.Messages()))
+
+	assert.NoError(t, con.StopConsuming(false, true))
+
+	channelPool.Shutdown()
+}
+
+func TestStandbyFailover(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	activeConfig := *Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+	activeConfig.ConsumerName = "TurboCookedRabbitConsumer-Active"
+	activeConfig.Exclusive = true
+
+	standbyConfig := activeConfig
+	standbyConfig.ConsumerName = "TurboCookedRabbitConsumer-Standby"
+	standbyConfig.Standby = true
+	standbyConfig.StandbyFailover = true
+
+	active, err := consumer.NewConsumerFromConfig(&activeConfig, channelPool)
+	assert.NoError(t, err)
+	assert.NoError(t, active.StartConsuming())
+	assert.NoError(t, active.WaitUntilConsuming(context.Background()))
+
+	standby, err := consumer.NewConsumerFromConfig(&standbyConfig, channelPool)
+	assert.NoError(t, err)
+	assert.NoError(t, standby.StartConsuming())
+
+	time.Sleep(time.Duration(500) * time.Millisecond) // a few queue checks, the active still holds the queue
+	assert.True(t, standby.InStandby())
+	assert.Equal(t, consumer.ActivityStandby, standby.Activity())
+
+	assert.NoError(t, active.StopConsuming(true, true))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	assert.NoError(t, standby.WaitUntilConsuming(ctx)) // took over within a second
+	cancel()
+
+	assert.False(t, standby.InStandby())
+
+	select {
+	case event := <-standby.Events():
+		assert.Equal(t, models.StandbyPromoted, event.Type)
+	default:
+		assert.Fail(t, "standby wasn't promoted")
+	}
+
+	assert.NoError(t, standby.StopConsuming(true, true))
+	channelPool.Shutdown()
+}
+
+func TestStandbyPromote(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	con, err := consumer.NewConsumerFromConfig(Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"], channelPool)
+	assert.NoError(t, err)
+
+	assert.Error(t, con.EnableStandby(true, 0)) // failover needs an exclusive consume
+	assert.NoError(t, con.EnableStandby(false, 0))
+	assert.Error(t, con.Promote()) // not started
+
+	assert.NoError(t, con.StartConsuming())
+	assert.True(t, con.InStandby())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(500)*time.Millisecond)
+	assert.Error(t, con.WaitUntilConsuming(ctx)) // no basic.consume in standby
+	cancel()
+
+	assert.NoError(t, con.Promote())
+	assert.NoError(t, con.WaitUntilConsuming(context.Background()))
+
+	assert.NoError(t, con.StopConsuming(true, true))
+	channelPool.Shutdown()
+}
+
+func TestNewIConsumer(t *testing.T) {
+	var con consumer.IConsumer
+	con, err := consumer.New(Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"], nil)
+	assert.Error(t, err)
+	assert.Nil(t, con)
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	con, err = consumer.New(Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"], channelPool)
+	assert.NoError(t, err)
+	assert.False(t, con.State().Started)
+
+	channelPool.Shutdown()
+}
+
+type boundOrder struct {
+	ID            int     `json:"id"`
+	TenantID      string  `amqp:"header=x-tenant-id,required"`
+	Attempt       int     `amqp:"header=x-attempt"`
+	Region        *string `amqp:"header=x-region"`
+	CorrelationID string  `amqp:"property=correlation_id"`
+}
+
+func TestBindHandler(t *testing.T) {
+
+	var bound *boundOrder
+	handler, err := consumer.BindHandler(
+		func() interface{} { return &boundOrder{} },
+		func(msg *models.Message, target interface{}) error {
+			bound = target.(*boundOrder)
+			return nil
+		})
+	assert.NoError(t, err)
+
+	msg := models.NewMessage(false, []byte(`{"id":42}`), 1, nil)
+	msg.CorrelationID = "order-42"
+	msg.Headers = amqp.Table{"x-tenant-id": "acme", "x-attempt": int32(3)}
+
+	assert.NoError(t, handler(msg))
+	assert.Equal(t, 42, bound.ID)
+	assert.Equal(t, "acme", bound.TenantID)
+	assert.Equal(t, 3, bound.Attempt)
+	assert.Nil(t, bound.Region)
+	assert.Equal(t, "order-42", bound.CorrelationID)
+
+	msg.Headers = amqp.Table{"x-tenant-id": "acme", "x-attempt": "4", "x-region": []byte("eu")}
+	assert.NoError(t, handler(msg))
+	assert.Equal(t, 4, bound.Attempt)
+	assert.Equal(t, "eu", *bound.Region)
+
+	msg.Headers = amqp.Table{"x-attempt": int64(1)}
+	err = handler(msg)
+	assert.True(t, errors.Is(err, models.ErrBindFailed))
+	assert.True(t, errors.Is(err, models.ErrRequiredHeader))
+
+	_, err = consumer.BindHandler(func() interface{} {
+		return &struct {
+			Tenant string `amqp:"header"`
+		}{}
+	}, nil)
+	assert.Error(t, err)
+}
+
+type batchAcker struct {
+	recordingAcker
+	ackedAll  [][]uint64
+	nackedAll [][]uint64
+}
+
+func (ba *batchAcker) AckAll(amqpChan *amqp.Channel, deliveryTags []uint64) error {
+	ba.ackedAll = append(ba.ackedAll, deliveryTags)
+	return nil
+}
+
+func (ba *batchAcker) NackAll(amqpChan *amqp.Channel, deliveryTags []uint64, requeue bool) error {
+	ba.nackedAll = append(ba.nackedAll, deliveryTags)
+	return nil
+}
+
+func TestBatchSettle(t *testing.T) {
+	amqpChan := &amqp.Channel{}
+	acker := &batchAcker{}
+
+	batch := &consumer.Batch{}
+	for tag := uint64(1); tag <= 4; tag++ {
+		msg := models.NewMessage(true, []byte("batch"), tag, amqpChan)
+		msg.SetAcknowledger(acker)
+		batch.Messages = append(batch.Messages, msg)
+	}
+
+	assert.Error(t, batch.Settle([]int{4}, true))
+
+	assert.NoError(t, batch.Settle([]int{1, 3}, true))
+	assert.Equal(t, [][]uint64{{1, 3}}, acker.ackedAll)
+	assert.Equal(t, [][]uint64{{2, 4}}, acker.nackedAll)
+
+	for _, msg := range batch.Messages {
+		assert.True(t, msg.Settled())
+	}
+
+	assert.NoError(t, batch.Ack()) // already settled
+	assert.Equal(t, 1, len(acker.ackedAll))
+
+	single := &recordingAcker{} // not a BatchAcknowledger, settled one by one
+	msg := models.NewMessage(true, []byte("batch"), 5, amqpChan)
+	msg.SetAcknowledger(single)
+	assert.NoError(t, (&consumer.Batch{Messages: []*models.Message{msg}}).Nack(false))
+	assert.Equal(t, 1, single.nacked)
+}
+
+func TestReadBatch(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	con, err := consumer.NewConsumerFromConfig(Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"], channelPool)
+	assert.NoError(t, err)
+
+	_, err = con.ReadBatch(context.Background(), 0, time.Second)
+	assert.Error(t, err)
+
+	assert.NoError(t, con.StartConsuming())
+
+	for i := 0; i < 3; i++ {
+		publisher.Publish(utils.CreateMockRandomLetter("ConsumerTestQueue"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	batch, err := con.ReadBatch(ctx, 10, time.Second)
+	cancel()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(batch.Messages))
+
+	assert.NoError(t, batch.Settle([]int{1}, false))
+	assert.Equal(t, int64(0), con.Unsettled())
+
+	assert.NoError(t, con.StopConsuming(false, true))
+
+	_, err = con.ReadBatch(context.Background(), 10, 0)
+	assert.Equal(t, consumer.ErrConsumerStopped, err)
+
+	channelPool.Shutdown()
+}
+
+func TestBufferAge(t *testing.T) {
+	memory := metrics.NewMemory()
+	models.SetMetrics(memory)
+	defer models.SetMetrics(nil)
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+	consumerConfig.BufferAgeWarning = 300
+
+	con, err := consumer.NewConsumerFromConfig(&consumerConfig, channelPool)
+	assert.NoError(t, err)
+	assert.NoError(t, con.StartConsuming())
+	assert.Error(t, con.SetBufferAgeWarning(time.Second)) // started
+
+	publisher.Publish(utils.CreateMockRandomLetter("ConsumerTestQueue"))
+
+	// left unread past the warning
+	select {
+	case event := <-con.Events():
+		assert.Equal(t, models.BufferAging, event.Type)
+	case <-time.After(time.Duration(2) * time.Second):
+		assert.Fail(t, "buffer aging event wasn't raised")
+	}
+
+	assert.True(t, con.ActivityStats().BufferAge >= time.Duration(300)*time.Millisecond)
+
+	msg := <-con.Messages()
+	assert.NoError(t, msg.Acknowledge())
+
+	time.Sleep(time.Duration(300) * time.Millisecond)
+	stats := con.ActivityStats()
+	assert.Equal(t, time.Duration(0), stats.BufferAge)
+	assert.True(t, stats.BufferHighWater >= time.Duration(300)*time.Millisecond)
+
+	observations := memory.Observations(models.MetricBufferSeconds, models.Labels{"queue": consumerConfig.QueueName})
+	assert.Equal(t, 1, len(observations))
+	assert.True(t, observations[0] >= 0.3)
+
+	assert.NoError(t, con.StopConsuming(false, true))
+
+	channelPool.Shutdown()
+}
+
+func TestBodyGuard(t *testing.T) {
+	body := []byte("original")
+	copied := models.CopyBody(body)
+	copied[0] = 'X'
+	assert.Equal(t, "original", string(body))
+
+	var mutated []*models.Message
+	msg := models.NewMessage(true, []byte("guarded"), 1, &amqp.Channel{})
+	msg.SetAcknowledger(&recordingAcker{})
+	msg.GuardBody(func(msg *models.Message) { mutated = append(mutated, msg) })
+	assert.True(t, msg.CheckBody())
+
+	msg.Body[0] = 'G'
+	assert.NoError(t, msg.Acknowledge())
+	assert.Equal(t, 1, len(mutated))
+	assert.True(t, msg.CheckBody()) // reported once
+	assert.Equal(t, 1, len(mutated))
+}
+
+func TestImmutableBody(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	consumerConfig := *Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+	consumerConfig.ImmutableBody = true
+	consumerConfig.GuardBody = true
+
+	con, err := consumer.NewConsumerFromConfig(&consumerConfig, channelPool)
+	assert.NoError(t, err)
+
+	assert.NoError(t, con.StartConsumingWithHandler(func(msg *models.Message) error {
+		for i := range msg.Body {
+			msg.Body[i] = 0 // a handler bug
+		}
+		panic("handler gave up")
+	}))
+
+	letter := utils.CreateMockRandomLetter("ConsumerTestQueue")
+	publisher.Publish(letter)
+
+	select {
+	case crash := <-con.Crashes():
+		assert.Equal(t, letter.Body, crash.Body) // the crash report still has the delivered body
+	case <-time.After(time.Duration(2) * time.Second):
+		assert.Fail(t, "crash wasn't reported")
+	}
+
+	select {
+	case event := <-con.Events():
+		assert.Equal(t, models.BodyMutated, event.Type)
+	case <-time.After(time.Duration(2) * time.Second):
+		assert.Fail(t, "body mutated event wasn't raised")
+	}
+
+	assert.NoError(t, con.StopConsuming(false, true))
+
+	channelPool.Shutdown()
+}
+
+func TestImmediateStopRequeuesOwnDeliveries(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	queueName := "TcrImmediateStopQueue"
+	chanHost, err := channelPool.GetChannel()
+	assert.NoError(t, err)
+	_, err = chanHost.Channel.QueueDeclare(queueName, false, true, false, false, nil)
+	assert.NoError(t, err)
+	_, err = chanHost.Channel.QueuePurge(queueName, false)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, chanHost.Channel.Publish("", queueName, false, false, amqp.Publishing{Body: []byte("requeue me")}))
+	}
+
+	consumerConfig := *Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+	consumerConfig.QueueName = queueName
+
+	con, err := consumer.NewConsumerFromConfig(&consumerConfig, channelPool)
+	assert.NoError(t, err)
+
+	// held on a shared ackable channel, the consumer's stop must not reach it
+	var got *models.Message
+	for i := 0; i < 50 && got == nil; i++ {
+		got, err = con.Get(queueName, false)
+		assert.NoError(t, err)
+		time.Sleep(time.Duration(10) * time.Millisecond)
+	}
+	assert.NotNil(t, got)
+
+	assert.NoError(t, con.StartConsuming())
+	assert.NoError(t, con.WaitUntilConsuming(context.Background()))
+
+	held := []*models.Message{<-con.Messages(), <-con.Messages()}
+
+	assert.NoError(t, con.StopConsuming(true, false))
+	for i := 0; i < 100 && con.State().Started; i++ {
+		time.Sleep(time.Duration(10) * time.Millisecond)
+	}
+	assert.Equal(t, int64(2), con.RequeuedOnStop())
+
+	for _, msg := range held {
+		assert.True(t, errors.Is(msg.Acknowledge(), consumer.ErrDeliveryExpired))
+	}
+
+	assert.NoError(t, got.Acknowledge())
+	time.Sleep(time.Duration(200) * time.Millisecond)
+
+	queue, err := chanHost.Channel.QueueInspect(queueName)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, queue.Messages) // the consumer's two, not the one acked on the shared channel
+
+	channelPool.ReturnChannel(chanHost, false)
+	channelPool.Shutdown()
+}
+
+func TestGracefulStopSettlesBufferedMessages(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	queueName := "TcrGracefulStopQueue"
+	chanHost, err := channelPool.GetChannel()
+	assert.NoError(t, err)
+	_, err = chanHost.Channel.QueueDeclare(queueName, false, true, false, false, nil)
+	assert.NoError(t, err)
+	_, err = chanHost.Channel.QueuePurge(queueName, false)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, chanHost.Channel.Publish("", queueName, false, false, amqp.Publishing{Body: []byte("ack me after the stop")}))
+	}
+
+	consumerConfig := *Seasoning.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+	consumerConfig.QueueName = queueName
+	consumerConfig.AckBatchSize = 10 // consumes on a dedicated channel
+
+	con, err := consumer.NewConsumerFromConfig(&consumerConfig, channelPool)
+	assert.NoError(t, err)
+
+	assert.NoError(t, con.StartConsuming())
+	assert.NoError(t, con.WaitUntilConsuming(context.Background()))
+
+	for i := 0; i < 100 && len(con.Messages()) < 3; i++ {
+		time.Sleep(time.Duration(10) * time.Millisecond)
+	}
+	assert.Equal(t, 3, len(con.Messages()))
+
+	assert.NoError(t, con.StopConsuming(false, false))
+	for i := 0; i < 100 && con.State().Started; i++ {
+		time.Sleep(time.Duration(10) * time.Millisecond)
+	}
+	assert.False(t, con.State().Started)
+
+	// the buffered messages are settled after the stop, their channel is only closed once they are
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, (<-con.Messages()).Acknowledge())
+	}
+	assert.Equal(t, int64(0), con.Unsettled())
+	time.Sleep(time.Duration(200) * time.Millisecond)
+
+	queue, err := chanHost.Channel.QueueInspect(queueName)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, queue.Messages) // nothing was redelivered
+
+	channelPool.ReturnChannel(chanHost, false)
+	channelPool.Shutdown()
+}
@@ -0,0 +1,86 @@
+package consumer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+// settleRecorder records a Message's settle, failing it with err.
+type settleRecorder struct {
+	settle  string
+	requeue bool
+	err     error
+}
+
+func (sr *settleRecorder) Ack(amqpChan *amqp.Channel, deliveryTag uint64) error {
+	sr.settle = "ack"
+	return sr.err
+}
+
+func (sr *settleRecorder) Nack(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	sr.settle, sr.requeue = "nack", requeue
+	return sr.err
+}
+
+func (sr *settleRecorder) Reject(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	sr.settle, sr.requeue = "reject", requeue
+	return sr.err
+}
+
+func TestInvokeHandlerPanics(t *testing.T) {
+
+	errNackFailed := errors.New("channel closed")
+
+	tests := []struct {
+		name         string
+		rejectPoison bool
+		redelivered  bool
+		settleErr    error
+		settle       string
+		requeue      bool
+		requeued     bool
+	}{
+		{name: "first delivery is requeued", settle: "nack", requeue: true, requeued: true},
+		{name: "redelivery is requeued by default", redelivered: true, settle: "nack", requeue: true, requeued: true},
+		{name: "poison redelivery is rejected when opted in", rejectPoison: true, redelivered: true, settle: "reject"},
+		{name: "first delivery is requeued when opted in", rejectPoison: true, settle: "nack", requeue: true, requeued: true},
+		{name: "failed requeue keeps the panic", settleErr: errNackFailed, settle: "nack", requeue: true},
+	}
+
+	for _, test := range tests {
+		con := &Consumer{
+			ConsumerName: "TcrCrashConsumer",
+			QueueName:    "TcrCrashQueue",
+			crashes:      make(chan *models.Crash, 1),
+			rejectPoison: test.rejectPoison,
+			handler:      func(msg *models.Message) error { panic("handler blew up") },
+		}
+
+		recorder := &settleRecorder{err: test.settleErr}
+		msg := models.NewMessage(true, []byte("poison"), 1, nil)
+		msg.SetAcknowledger(recorder)
+
+		err := con.invokeHandler(msg, &amqp.Delivery{Body: msg.Body, DeliveryTag: 1, Redelivered: test.redelivered})
+		assert.Error(t, err, test.name)
+		assert.True(t, strings.Contains(err.Error(), "handler blew up"), test.name)
+		assert.Equal(t, test.settleErr != nil, errors.Is(err, errNackFailed), test.name)
+
+		assert.Equal(t, test.settle, recorder.settle, test.name)
+		assert.Equal(t, test.requeue, recorder.requeue, test.name)
+
+		select {
+		case crash := <-con.Crashes():
+			assert.Equal(t, "handler blew up", crash.Panic, test.name)
+			assert.Equal(t, "TcrCrashQueue", crash.QueueName, test.name)
+			assert.Equal(t, test.redelivered, crash.Redelivered, test.name)
+			assert.Equal(t, test.requeued, crash.Requeued, test.name)
+		default:
+			t.Errorf("%s: no crash sent", test.name)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+const (
+	defaultDedupClaimTTL   = time.Duration(30) * time.Second
+	defaultDedupRetryDelay = time.Second
+	defaultDedupTimeout    = time.Duration(5) * time.Second
+	memoryDedupSweep       = time.Second
+)
+
+// DedupState is what a DedupStore knows about a key.
+type DedupState int
+
+const (
+	// DedupClaimed means the key was free (or its claim expired) and is now claimed by the caller.
+	DedupClaimed DedupState = iota
+	// DedupProcessing means another delivery holds an unexpired claim on the key.
+	DedupProcessing
+	// DedupProcessed means a delivery with the key was processed and acked within the key's TTL.
+	DedupProcessed
+)
+
+// DedupStore persists the keys of processed messages for exact deduplication, i.e. in Redis (SET NX PX) or
+// Postgres (INSERT ... ON CONFLICT). Every method must be atomic across the consumers sharing the store.
+type DedupStore interface {
+	// Claim takes the key for processing for the ttl, unless it's claimed or processed already.
+	Claim(ctx context.Context, key string, ttl time.Duration) (DedupState, error)
+	// Mark records the key as processed for the ttl, replacing its claim.
+	Mark(ctx context.Context, key string, ttl time.Duration) error
+	// Release drops the key's claim so a redelivery is processed again.
+	Release(ctx context.Context, key string) error
+}
+
+// DedupOptions configure a Consumer's deduplication (see EnableDedup).
+type DedupOptions struct {
+	Header     string        // header holding the key, empty uses the MessageId
+	TTL        time.Duration // how long a processed key is remembered
+	ClaimTTL   time.Duration // how long a delivery being processed holds its key, 0 is 30 seconds
+	RetryDelay time.Duration // wait before requeueing a delivery whose key another delivery holds, 0 is a second
+	Timeout    time.Duration // per store call, 0 is 5 seconds
+}
+
+// DedupStats are a Consumer's deduplication counters.
+type DedupStats struct {
+	Dropped     uint64 // processed before, acked without processing
+	Deferred    uint64 // held by another delivery, requeued after the RetryDelay
+	StoreErrors uint64 // store calls that failed, the delivery was requeued
+}
+
+// Deduplicator drops deliveries whose key (the MessageId or a header) a DedupStore has recorded as processed.
+// Unlike the ReplayGuard nothing is probabilistic: a key is claimed while its delivery is processed, marked as
+// processed before the ack is sent, and released on a nack or reject. A delivery whose key is claimed elsewhere
+// is requeued to retry after the claim settles, a crashed claim expires after the ClaimTTL. When the store is
+// unreachable deliveries are requeued rather than risk processing them twice. Deliveries without a key are
+// never dropped.
+type Deduplicator struct {
+	store       DedupStore
+	options     DedupOptions
+	next        models.Acknowledger // nil acks directly on the channel
+	pending     map[pendingDelivery]string
+	dropped     uint64
+	deferred    uint64
+	storeErrors uint64
+	onError     func(error)
+	lock        *sync.Mutex
+}
+
+// NewDeduplicator creates a Deduplicator over the store.
+func NewDeduplicator(store DedupStore, options *DedupOptions) (*Deduplicator, error) {
+	if store == nil {
+		return nil, errors.New("can't deduplicate without a dedup store")
+	}
+
+	if options == nil || options.TTL <= 0 {
+		return nil, errors.New("can't deduplicate without a ttl")
+	}
+
+	dedup := &Deduplicator{
+		store:   store,
+		options: *options,
+		pending: make(map[pendingDelivery]string),
+		lock:    &sync.Mutex{},
+	}
+
+	if dedup.options.ClaimTTL <= 0 {
+		dedup.options.ClaimTTL = defaultDedupClaimTTL
+	}
+
+	if dedup.options.RetryDelay <= 0 {
+		dedup.options.RetryDelay = defaultDedupRetryDelay
+	}
+
+	if dedup.options.Timeout <= 0 {
+		dedup.options.Timeout = defaultDedupTimeout
+	}
+
+	return dedup, nil
+}
+
+// EnableDedup deduplicates deliveries against the store, call before starting the Consumer.
+// Replaces the in-memory store a ConsumerConfig's DedupTTL sets up.
+func (con *Consumer) EnableDedup(store DedupStore, options *DedupOptions) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't enable dedup on a started consumer")
+	}
+
+	dedup, err := NewDeduplicator(store, options)
+	if err != nil {
+		return err
+	}
+
+	dedup.onError = con.handleError
+	con.dedup = dedup
+
+	return nil
+}
+
+// DedupStats returns the Consumer's deduplication counters, zeros without dedup.
+func (con *Consumer) DedupStats() DedupStats {
+	if con.dedup == nil {
+		return DedupStats{}
+	}
+
+	return con.dedup.Stats()
+}
+
+// Stats returns the deduplication counters.
+func (dd *Deduplicator) Stats() DedupStats {
+	return DedupStats{
+		Dropped:     atomic.LoadUint64(&dd.dropped),
+		Deferred:    atomic.LoadUint64(&dd.deferred),
+		StoreErrors: atomic.LoadUint64(&dd.storeErrors),
+	}
+}
+
+// Key returns the delivery's dedup key, empty when it has none.
+func (dd *Deduplicator) key(delivery *amqp.Delivery) string {
+	if dd.options.Header == "" {
+		return delivery.MessageId
+	}
+
+	switch value := delivery.Headers[dd.options.Header].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// Admit claims the delivery's key and returns true when the delivery should be processed. Anything else has
+// been settled here: processed duplicates are acked, deliveries held elsewhere (or failing the store) requeued.
+func (dd *Deduplicator) admit(amqpChan *amqp.Channel, delivery *amqp.Delivery, isAckable bool) (bool, *models.Event) {
+	key := dd.key(delivery)
+	if key == "" {
+		return true, nil
+	}
+
+	ttl := dd.options.ClaimTTL
+	if !isAckable { // nothing settles an auto acked delivery, it's processed as it's claimed
+		ttl = dd.options.TTL
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dd.options.Timeout)
+	state, err := dd.store.Claim(ctx, key, ttl)
+	cancel()
+
+	if err != nil {
+		atomic.AddUint64(&dd.storeErrors, 1)
+		dd.report(fmt.Errorf("can't claim dedup key %s: %w", key, err))
+
+		if isAckable {
+			dd.requeueLater(amqpChan, delivery.DeliveryTag)
+		}
+
+		return false, nil
+	}
+
+	switch state {
+	case DedupClaimed:
+		if isAckable {
+			dd.lock.Lock()
+			dd.pending[pendingDelivery{amqpChan: amqpChan, deliveryTag: delivery.DeliveryTag}] = key
+			dd.lock.Unlock()
+		}
+
+		return true, nil
+	case DedupProcessing:
+		atomic.AddUint64(&dd.deferred, 1)
+
+		if isAckable {
+			dd.requeueLater(amqpChan, delivery.DeliveryTag)
+		}
+
+		return false, nil
+	default:
+		atomic.AddUint64(&dd.dropped, 1)
+
+		if isAckable {
+			if err = amqpChan.Ack(delivery.DeliveryTag, false); err != nil {
+				dd.report(err)
+			}
+		}
+
+		return false, models.NewEvent(models.DuplicateDropped, 0, 0, fmt.Sprintf("dropped a duplicate of %s", key))
+	}
+}
+
+// RequeueLater nacks the delivery for redelivery after the RetryDelay, so a held key isn't spun on.
+func (dd *Deduplicator) requeueLater(amqpChan *amqp.Channel, deliveryTag uint64) {
+	models.Go("consumer.dedupretry", func() {
+		time.Sleep(dd.options.RetryDelay)
+
+		if err := amqpChan.Nack(deliveryTag, false, true); err != nil {
+			dd.report(err)
+		}
+	})
+}
+
+func (dd *Deduplicator) report(err error) {
+	if dd.onError != nil {
+		dd.onError(err)
+	}
+}
+
+// Forget drops the pending deliveries of a closed channel, their claims expire after the ClaimTTL.
+func (dd *Deduplicator) Forget(amqpChan *amqp.Channel) {
+	dd.lock.Lock()
+	defer dd.lock.Unlock()
+
+	for pending := range dd.pending {
+		if pending.amqpChan == amqpChan {
+			delete(dd.pending, pending)
+		}
+	}
+}
+
+// Ack marks the key as processed, then acks. A failed mark is reported and the ack still sent, the work is done.
+func (dd *Deduplicator) Ack(amqpChan *amqp.Channel, deliveryTag uint64) error {
+	if key, ok := dd.settle(amqpChan, deliveryTag); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), dd.options.Timeout)
+		err := dd.store.Mark(ctx, key, dd.options.TTL)
+		cancel()
+
+		if err != nil {
+			atomic.AddUint64(&dd.storeErrors, 1)
+			dd.report(fmt.Errorf("can't mark dedup key %s as processed: %w", key, err))
+		}
+	}
+
+	if dd.next != nil {
+		return dd.next.Ack(amqpChan, deliveryTag)
+	}
+
+	return amqpChan.Ack(deliveryTag, false)
+}
+
+// Nack releases the key so a requeued redelivery is processed again.
+func (dd *Deduplicator) Nack(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	dd.release(amqpChan, deliveryTag)
+
+	if dd.next != nil {
+		return dd.next.Nack(amqpChan, deliveryTag, requeue)
+	}
+
+	return amqpChan.Nack(deliveryTag, false, requeue)
+}
+
+// Reject releases the key so a requeued redelivery is processed again.
+func (dd *Deduplicator) Reject(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	dd.release(amqpChan, deliveryTag)
+
+	if dd.next != nil {
+		return dd.next.Reject(amqpChan, deliveryTag, requeue)
+	}
+
+	return amqpChan.Reject(deliveryTag, requeue)
+}
+
+func (dd *Deduplicator) release(amqpChan *amqp.Channel, deliveryTag uint64) {
+	key, ok := dd.settle(amqpChan, deliveryTag)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dd.options.Timeout)
+	defer cancel()
+
+	if err := dd.store.Release(ctx, key); err != nil {
+		atomic.AddUint64(&dd.storeErrors, 1)
+		dd.report(fmt.Errorf("can't release dedup key %s: %w", key, err))
+	}
+}
+
+func (dd *Deduplicator) settle(amqpChan *amqp.Channel, deliveryTag uint64) (string, bool) {
+	dd.lock.Lock()
+	defer dd.lock.Unlock()
+
+	key := pendingDelivery{amqpChan: amqpChan, deliveryTag: deliveryTag}
+	messageKey, ok := dd.pending[key]
+	delete(dd.pending, key)
+
+	return messageKey, ok
+}
+
+// MemoryDedupStore is an in-process DedupStore. It only deduplicates within the process and forgets everything
+// on a restart, use a shared persistent store across consumers and deploys.
+type MemoryDedupStore struct {
+	entries   map[string]memoryDedupEntry
+	lastSweep time.Time
+	storeLock *sync.Mutex
+}
+
+type memoryDedupEntry struct {
+	processed bool
+	expires   time.Time
+}
+
+// NewMemoryDedupStore creates an empty MemoryDedupStore.
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{
+		entries:   make(map[string]memoryDedupEntry),
+		lastSweep: time.Now(),
+		storeLock: &sync.Mutex{},
+	}
+}
+
+// Claim takes the key for processing for the ttl, unless it's claimed or processed already.
+func (mds *MemoryDedupStore) Claim(ctx context.Context, key string, ttl time.Duration) (DedupState, error) {
+	mds.storeLock.Lock()
+	defer mds.storeLock.Unlock()
+
+	now := time.Now()
+	if now.Sub(mds.lastSweep) > memoryDedupSweep {
+		for entryKey, entry := range mds.entries {
+			if now.After(entry.expires) {
+				delete(mds.entries, entryKey)
+			}
+		}
+		mds.lastSweep = now
+	}
+
+	if entry, ok := mds.entries[key]; ok && now.Before(entry.expires) {
+		if entry.processed {
+			return DedupProcessed, nil
+		}
+
+		return DedupProcessing, nil
+	}
+
+	mds.entries[key] = memoryDedupEntry{expires: now.Add(ttl)}
+
+	return DedupClaimed, nil
+}
+
+// Mark records the key as processed for the ttl.
+func (mds *MemoryDedupStore) Mark(ctx context.Context, key string, ttl time.Duration) error {
+	mds.storeLock.Lock()
+	defer mds.storeLock.Unlock()
+
+	mds.entries[key] = memoryDedupEntry{processed: true, expires: time.Now().Add(ttl)}
+
+	return nil
+}
+
+// Release drops the key's claim, a processed key is kept.
+func (mds *MemoryDedupStore) Release(ctx context.Context, key string) error {
+	mds.storeLock.Lock()
+	defer mds.storeLock.Unlock()
+
+	if entry, ok := mds.entries[key]; ok && !entry.processed {
+		delete(mds.entries, key)
+	}
+
+	return nil
+}
+
+// Len returns how many keys are held, expired ones included until they're swept.
+func (mds *MemoryDedupStore) Len() int {
+	mds.storeLock.Lock()
+	defer mds.storeLock.Unlock()
+
+	return len(mds.entries)
+}
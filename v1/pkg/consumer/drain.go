@@ -0,0 +1,82 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const drainPollInterval = time.Duration(10) * time.Millisecond
+
+// Drain stops the Consumer gracefully for a deploy: the consume is cancelled so the broker sends nothing new,
+// deliveries already received are still handed off (buffer or handler), and then Drain waits for every ackable
+// delivery to be acked, nacked, or rejected. When the context ends first, the Consumer is stopped immediately
+// (requeueing what's unacked on its channel, see RequeuedOnStop) and the context error is returned with the
+// unsettled count. Messages read from the buffer must still be settled for Drain to finish.
+func (con *Consumer) Drain(ctx context.Context) error {
+	con.conLock.Lock()
+	if !con.started {
+		con.conLock.Unlock()
+		return errors.New("can't drain a stopped consumer")
+	}
+
+	if con.draining {
+		con.conLock.Unlock()
+		return errors.New("can't drain a consumer that is already draining")
+	}
+
+	con.draining = true
+	chanHost, stopped := con.chanHost, con.stopped
+	con.conLock.Unlock()
+
+	cancelled := false
+	if chanHost != nil && con.ConsumerName != "" {
+		cancelled = chanHost.Channel.Cancel(con.ConsumerName, false) == nil
+	}
+
+	if !cancelled { // without a consumer tag (or a channel) there's no consume to cancel, stop after the buffer
+		if err := con.StopConsuming(false, false); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		if cancelled { // a graceful stop is already queued otherwise
+			_ = con.StopConsuming(true, false)
+		}
+
+		return con.drainErr(ctx.Err())
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for con.settleTracker.outstanding() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return con.drainErr(ctx.Err())
+		}
+	}
+
+	return nil
+}
+
+// Unsettled returns how many ackable deliveries haven't been acked, nacked, or rejected yet.
+func (con *Consumer) Unsettled() int64 {
+	return con.settleTracker.outstanding()
+}
+
+func (con *Consumer) isDraining() bool {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	return con.draining
+}
+
+func (con *Consumer) drainErr(err error) error {
+	return fmt.Errorf("%w\r\n[unsettled: %d]", err, con.settleTracker.outstanding())
+}
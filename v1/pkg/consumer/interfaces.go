@@ -0,0 +1,82 @@
+package consumer
+
+import (
+	"context"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
+)
+
+// IConsumer is the full API of a Consumer, depend on it instead of *Consumer to mock or decorate consumers.
+type IConsumer interface {
+	// Setup, before consuming starts.
+	EnableAckBatching(batchSize uint32, flushInterval time.Duration, maxAge time.Duration) error
+	EnableReplayProtection(window time.Duration, expectedCount uint32, falsePositiveRate float64) error
+	EnableDedup(store DedupStore, options *DedupOptions) error
+	EnableCheckpoints(every uint64, interval time.Duration, callback func(*Checkpoint)) error
+	EnableShadowMode(requeueDelay time.Duration) error
+	EnableStandby(failover bool, interval time.Duration) error
+	EnableTenantIsolation(workers int, extractor TenantExtractor, quota TenantQuota) error
+	SetStreamFilter(values []string, matchUnfiltered bool) error
+	SetAcceptedFormats(contentTypes []string, encodings []string) error
+	SetAckPolicy(policy *AckPolicy) error
+	SetConsumerTimeout(timeout time.Duration, margin time.Duration) error
+	SetBufferAgeWarning(threshold time.Duration) error
+	SetRecorder(recorder *Recorder) error
+	SetPrefetch(count int) error
+	SetTenantQuota(tenant string, quota TenantQuota) error
+	Sample(rate float64, sink func(*models.Message))
+
+	// Consuming.
+	StartConsuming() error
+	StartConsumingWithHandler(handler MessageHandler) error
+	StartConsumingWithResults(publisher ResultPublisher, handler ResultFunc) error
+	WaitUntilConsuming(ctx context.Context) error
+	Promote() error
+	Get(queueName string, autoAck bool) (*models.Message, error)
+	GetBatch(queueName string, batchSize int, autoAck bool) ([]*models.Message, error)
+	ReadMessage(ctx context.Context) (*models.Message, error)
+	ReadBatch(ctx context.Context, max int, wait time.Duration) (*Batch, error)
+	Messages() <-chan *models.Message
+	Errors() <-chan error
+	Crashes() <-chan *models.Crash
+	Events() <-chan *models.Event
+	Checkpoints() <-chan *Checkpoint
+	ShadowResults() <-chan *ShadowResult
+
+	// Stopping.
+	StopConsuming(immediate bool, flushMessages bool) error
+	Drain(ctx context.Context) error
+	FlushStop()
+	FlushErrors()
+	FlushMessages()
+
+	// Stats.
+	State() State
+	Activity() Activity
+	ActivityStats() ActivityStats
+	InStandby() bool
+	Deliveries() uint64
+	Unsettled() int64
+	RequeuedOnStop() int64
+	DroppedErrors() uint64
+	DuplicatesDropped() uint64
+	Overwritten() uint64
+	DedupStats() DedupStats
+	ShadowStats() ShadowStats
+	TenantStats() []TenantStats
+}
+
+var _ IConsumer = (*Consumer)(nil)
+
+// New creates a Consumer from the config (see NewConsumerFromConfig) as an IConsumer.
+func New(config *models.ConsumerConfig, channelPool *pools.ChannelPool) (IConsumer, error) {
+
+	con, err := NewConsumerFromConfig(config, channelPool)
+	if err != nil {
+		return nil, err // not a nil *Consumer in a non nil IConsumer
+	}
+
+	return con, nil
+}
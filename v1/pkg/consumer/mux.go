@@ -0,0 +1,172 @@
+package consumer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// Handler handles a Message, the interface counterpart of MessageHandler (as http.Handler is to http.HandlerFunc).
+type Handler interface {
+	HandleMessage(msg *models.Message) error
+}
+
+// HandleMessage calls the MessageHandler, making every MessageHandler a Handler.
+func (handler MessageHandler) HandleMessage(msg *models.Message) error {
+	return handler(msg)
+}
+
+// Middleware wraps a MessageHandler with behavior of its own (i.e. logging, metrics, tracing, annotations),
+// calling next to continue down the chain.
+type Middleware func(next MessageHandler) MessageHandler
+
+// Chain wraps the handler in the middleware, the first middleware is the outermost and runs first.
+func Chain(handler MessageHandler, middleware ...Middleware) MessageHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+type routingKeyRoute struct {
+	pattern []string
+	handler MessageHandler
+}
+
+// MessageMux routes each Message to the handler registered for its type or, failing that, its routing key, so one
+// consumer can serve many kinds of messages without a switch. Types come from the type property, or a header
+// (see SetTypeHeader). Routing keys match exactly or as topic exchange patterns, where * is one word and # is zero
+// or more words, in the order they were registered. Middleware (see Use) runs around every routed handler.
+// Pass mux.HandleMessage to StartConsumingWithHandler.
+type MessageMux struct {
+	typeHeader  string
+	types       map[string]MessageHandler
+	routingKeys []*routingKeyRoute
+	middleware  []Middleware
+	notFound    MessageHandler
+	handler     MessageHandler // the routing wrapped in the middleware
+	muxLock     *sync.RWMutex
+}
+
+// NewMessageMux creates a MessageMux that returns an error for messages no route matches.
+func NewMessageMux() *MessageMux {
+	mux := &MessageMux{
+		types:   make(map[string]MessageHandler),
+		muxLock: &sync.RWMutex{},
+	}
+
+	mux.notFound = func(msg *models.Message) error {
+		return fmt.Errorf("no handler for message type %q or routing key %q", mux.messageType(msg), msg.RoutingKey)
+	}
+	mux.handler = mux.route
+
+	return mux
+}
+
+// SetTypeHeader reads the message type from the header instead of the type property, "" uses the property.
+func (mux *MessageMux) SetTypeHeader(header string) {
+	mux.muxLock.Lock()
+	defer mux.muxLock.Unlock()
+
+	mux.typeHeader = header
+}
+
+// HandleType routes messages of the type to the handler, replacing any handler registered for it.
+func (mux *MessageMux) HandleType(messageType string, handler MessageHandler) {
+	mux.muxLock.Lock()
+	defer mux.muxLock.Unlock()
+
+	mux.types[messageType] = handler
+}
+
+// HandleRoutingKey routes messages whose routing key matches the pattern to the handler.
+func (mux *MessageMux) HandleRoutingKey(pattern string, handler MessageHandler) {
+	mux.muxLock.Lock()
+	defer mux.muxLock.Unlock()
+
+	mux.routingKeys = append(mux.routingKeys, &routingKeyRoute{pattern: strings.Split(pattern, "."), handler: handler})
+}
+
+// NotFound sets the handler for messages no route matches.
+func (mux *MessageMux) NotFound(handler MessageHandler) {
+	mux.muxLock.Lock()
+	defer mux.muxLock.Unlock()
+
+	mux.notFound = handler
+}
+
+// Use adds middleware around every routed handler, in the order given (see Chain).
+func (mux *MessageMux) Use(middleware ...Middleware) {
+	mux.muxLock.Lock()
+	defer mux.muxLock.Unlock()
+
+	mux.middleware = append(mux.middleware, middleware...)
+	mux.handler = Chain(mux.route, mux.middleware...)
+}
+
+// HandleMessage runs the middleware and the handler routed to.
+func (mux *MessageMux) HandleMessage(msg *models.Message) error {
+	mux.muxLock.RLock()
+	handler := mux.handler
+	mux.muxLock.RUnlock()
+
+	return handler(msg)
+}
+
+// Handler returns the handler the Message would be routed to.
+func (mux *MessageMux) Handler(msg *models.Message) MessageHandler {
+	mux.muxLock.RLock()
+	defer mux.muxLock.RUnlock()
+
+	if handler, ok := mux.types[mux.messageType(msg)]; ok {
+		return handler
+	}
+
+	keyWords := strings.Split(msg.RoutingKey, ".")
+	for _, route := range mux.routingKeys {
+		if matchTopic(route.pattern, keyWords) {
+			return route.handler
+		}
+	}
+
+	return mux.notFound
+}
+
+func (mux *MessageMux) route(msg *models.Message) error {
+	return mux.Handler(msg)(msg)
+}
+
+// MessageType reads the type the mux routes on.
+func (mux *MessageMux) messageType(msg *models.Message) string {
+	if mux.typeHeader == "" {
+		return msg.Type
+	}
+
+	messageType, _ := msg.Headers[mux.typeHeader].(string)
+	return messageType
+}
+
+// MatchTopic matches routing key words against topic pattern words, * is exactly one word and # zero or more.
+func matchTopic(pattern []string, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for skip := 0; skip <= len(words); skip++ {
+			if matchTopic(pattern[1:], words[skip:]) {
+				return true
+			}
+		}
+
+		return false
+	case "*":
+		return len(words) > 0 && matchTopic(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && words[0] == pattern[0] && matchTopic(pattern[1:], words[1:])
+	}
+}
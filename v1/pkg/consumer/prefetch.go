@@ -0,0 +1,205 @@
+package consumer
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+const (
+	defaultPrefetchInterval = time.Duration(5) * time.Second
+	prefetchSmoothing       = 0.5 // weight of the latest interval in the moving throughput average
+)
+
+// PrefetchCoordinator divides a global prefetch budget among in-process Consumers proportionally to their
+// throughput, re-issuing QoS as the balance shifts. Every Consumer always keeps the minimum prefetch.
+// QoS is re-issued channel wide (global) so it applies to the running consume, which only reaches the Consumer's
+// own deliveries on a dedicated channel (an ackable Consumer batching acks consumes on one, see
+// pools.ChannelPool.GetDedicatedAckableChannel). A Consumer on a shared ackable channel refuses the prefetch.
+type PrefetchCoordinator struct {
+	budget    int
+	minimum   int
+	interval  time.Duration
+	shares    map[*Consumer]*prefetchShare
+	stop      chan bool
+	started   bool
+	coordLock *sync.Mutex
+}
+
+type prefetchShare struct {
+	lastCount uint64
+	rate      float64 // smoothed deliveries per interval
+	prefetch  int
+}
+
+// NewPrefetchCoordinator creates a new PrefetchCoordinator. A zero interval falls back to the default (5s).
+func NewPrefetchCoordinator(budget int, minimum int, interval time.Duration) (*PrefetchCoordinator, error) {
+
+	if minimum < 1 {
+		return nil, errors.New("minimum prefetch must be at least 1")
+	}
+
+	if budget < minimum {
+		return nil, errors.New("prefetch budget can't be less than the minimum prefetch")
+	}
+
+	if interval <= 0 {
+		interval = defaultPrefetchInterval
+	}
+
+	return &PrefetchCoordinator{
+		budget:    budget,
+		minimum:   minimum,
+		interval:  interval,
+		shares:    make(map[*Consumer]*prefetchShare),
+		stop:      make(chan bool, 1),
+		coordLock: &sync.Mutex{},
+	}, nil
+}
+
+// Register adds the Consumer and rebalances. Fails if the budget can't cover the minimum for every Consumer.
+func (pc *PrefetchCoordinator) Register(con *Consumer) error {
+	pc.coordLock.Lock()
+	defer pc.coordLock.Unlock()
+
+	if _, ok := pc.shares[con]; ok {
+		return nil
+	}
+
+	if (len(pc.shares)+1)*pc.minimum > pc.budget {
+		return errors.New("can't register consumer, prefetch budget can't cover the minimum for every consumer")
+	}
+
+	pc.shares[con] = &prefetchShare{lastCount: con.Deliveries()}
+	pc.rebalance()
+
+	return nil
+}
+
+// Unregister removes the Consumer and rebalances, the Consumer keeps its last prefetch.
+func (pc *PrefetchCoordinator) Unregister(con *Consumer) {
+	pc.coordLock.Lock()
+	defer pc.coordLock.Unlock()
+
+	delete(pc.shares, con)
+	pc.rebalance()
+}
+
+// Start begins periodically rebalancing the budget.
+func (pc *PrefetchCoordinator) Start() {
+	pc.coordLock.Lock()
+	defer pc.coordLock.Unlock()
+
+	if pc.started {
+		return
+	}
+
+	pc.started = true
+	models.Go("consumer.prefetch", pc.rebalanceLoop)
+}
+
+// Stop stops rebalancing, Consumers keep their last prefetch.
+func (pc *PrefetchCoordinator) Stop() {
+	pc.coordLock.Lock()
+	defer pc.coordLock.Unlock()
+
+	if !pc.started {
+		return
+	}
+
+	pc.started = false
+	pc.stop <- true
+}
+
+func (pc *PrefetchCoordinator) rebalanceLoop() {
+	ticker := time.NewTicker(pc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pc.stop:
+			return
+		case <-ticker.C:
+			pc.coordLock.Lock()
+			pc.measure()
+			pc.rebalance()
+			pc.coordLock.Unlock()
+		}
+	}
+}
+
+// Measure updates every Consumer's smoothed throughput, must be called while holding the lock.
+func (pc *PrefetchCoordinator) measure() {
+	for con, share := range pc.shares {
+		count := con.Deliveries()
+		share.rate = prefetchSmoothing*float64(count-share.lastCount) + (1-prefetchSmoothing)*share.rate
+		share.lastCount = count
+	}
+}
+
+// Rebalance re-issues QoS for every Consumer whose share changed, must be called while holding the lock.
+func (pc *PrefetchCoordinator) rebalance() {
+	if len(pc.shares) == 0 {
+		return
+	}
+
+	consumers := make([]*Consumer, 0, len(pc.shares))
+	rates := make([]float64, 0, len(pc.shares))
+	for con, share := range pc.shares {
+		consumers = append(consumers, con)
+		rates = append(rates, share.rate)
+	}
+
+	prefetches := dividePrefetch(pc.budget, pc.minimum, rates)
+	for i, con := range consumers {
+		share := pc.shares[con]
+		if share.prefetch == prefetches[i] {
+			continue
+		}
+
+		if err := con.SetPrefetch(prefetches[i]); err != nil {
+			con.handleError(err)
+			continue // retried next rebalance
+		}
+
+		share.prefetch = prefetches[i]
+	}
+}
+
+// DividePrefetch gives everyone the minimum and splits the rest proportionally to the rates (evenly when idle).
+// Rounding leftovers (always fewer than the number of rates) go one each to the busiest.
+func dividePrefetch(budget int, minimum int, rates []float64) []int {
+	prefetches := make([]int, len(rates))
+	spare := budget - minimum*len(rates)
+
+	total := 0.0
+	for _, rate := range rates {
+		total += rate
+	}
+
+	leftover := spare
+	for i, rate := range rates {
+		extra := spare / len(rates)
+		if total > 0 {
+			extra = int(float64(spare) * rate / total)
+		}
+
+		prefetches[i] = minimum + extra
+		leftover -= extra
+	}
+
+	busiest := make([]int, len(rates))
+	for i := range busiest {
+		busiest[i] = i
+	}
+
+	sort.SliceStable(busiest, func(i, j int) bool { return rates[busiest[i]] > rates[busiest[j]] })
+	for i := 0; i < leftover; i++ {
+		prefetches[busiest[i]]++
+	}
+
+	return prefetches
+}
@@ -0,0 +1,37 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDividePrefetch(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		budget     int
+		minimum    int
+		rates      []float64
+		prefetches []int
+	}{
+		{name: "idle splits evenly", budget: 10, minimum: 1, rates: []float64{0, 0, 0}, prefetches: []int{4, 3, 3}},
+		{name: "proportional to throughput", budget: 20, minimum: 2, rates: []float64{3, 1}, prefetches: []int{14, 6}},
+		{name: "leftover goes to the busiest", budget: 11, minimum: 1, rates: []float64{1, 2, 4}, prefetches: []int{2, 3, 6}},
+		{name: "budget only covers the minimum", budget: 3, minimum: 1, rates: []float64{5, 0, 1}, prefetches: []int{1, 1, 1}},
+		{name: "idle consumer keeps the minimum", budget: 12, minimum: 2, rates: []float64{0, 10}, prefetches: []int{2, 10}},
+		{name: "single consumer takes everything", budget: 5, minimum: 2, rates: []float64{0}, prefetches: []int{5}},
+	}
+
+	for _, test := range tests {
+		prefetches := dividePrefetch(test.budget, test.minimum, test.rates)
+		assert.Equal(t, test.prefetches, prefetches, test.name)
+
+		total := 0
+		for _, prefetch := range prefetches {
+			assert.True(t, prefetch >= test.minimum, test.name)
+			total += prefetch
+		}
+		assert.Equal(t, test.budget, total, test.name)
+	}
+}
@@ -0,0 +1,153 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// ErrConsumerStopped is returned by ReadMessage once the Consumer has stopped and its buffer is empty.
+var ErrConsumerStopped = errors.New("consumer stopped")
+
+// ReadMessage blocks until a Message is available, the context ends (returning the context error), or the Consumer
+// stops (returning ErrConsumerStopped). Messages already buffered are still returned after a stop.
+// Consume errors aren't returned here as the Consumer retries them itself, they are still sent to Errors().
+func (con *Consumer) ReadMessage(ctx context.Context) (*models.Message, error) {
+	con.conLock.Lock()
+	stopped, handler := con.stopped, con.handler
+	con.conLock.Unlock()
+
+	if handler != nil {
+		return nil, errors.New("can't read messages from a consumer running a handler")
+	}
+
+	if stopped == nil {
+		return nil, errors.New("can't read messages from a consumer that hasn't been started")
+	}
+
+	// buffered messages win over a stop or cancellation that happened at the same time
+	select {
+	case msg := <-con.messages:
+		return msg, nil
+	default:
+	}
+
+	select {
+	case msg := <-con.messages:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-stopped:
+		select {
+		case msg := <-con.messages:
+			return msg, nil
+		default:
+			return nil, ErrConsumerStopped
+		}
+	}
+}
+
+// Batch is a batch of Messages read with ReadBatch.
+type Batch struct {
+	Messages []*models.Message
+}
+
+// Ack acks the batch's Messages, with a single multiple ack per channel when nothing else on it is outstanding.
+func (batch *Batch) Ack() error {
+	return models.AckMessages(batch.Messages)
+}
+
+// Nack nacks the batch's Messages, with a single multiple nack per channel when nothing else on it is outstanding.
+func (batch *Batch) Nack(requeue bool) error {
+	return models.NackMessages(batch.Messages, requeue)
+}
+
+// Settle settles a partially processed batch: the Messages at the failed indexes are nacked (requeued or not),
+// the rest acked. The acks go first, so the nacks can still use the multiple flag.
+func (batch *Batch) Settle(failed []int, requeue bool) error {
+	if len(failed) == 0 {
+		return batch.Ack()
+	}
+
+	isFailed := make(map[int]bool, len(failed))
+	for _, index := range failed {
+		if index < 0 || index >= len(batch.Messages) {
+			return fmt.Errorf("can't settle the batch, index %d is out of range", index)
+		}
+		isFailed[index] = true
+	}
+
+	succeeded := make([]*models.Message, 0, len(batch.Messages)-len(isFailed))
+	nacked := make([]*models.Message, 0, len(isFailed))
+	for i, msg := range batch.Messages {
+		if isFailed[i] {
+			nacked = append(nacked, msg)
+		} else {
+			succeeded = append(succeeded, msg)
+		}
+	}
+
+	ackErr := models.AckMessages(succeeded)
+	if err := models.NackMessages(nacked, requeue); err != nil && ackErr == nil {
+		return err
+	}
+
+	return ackErr
+}
+
+// ReadBatch blocks for a first Message like ReadMessage, then collects up to max Messages until wait has passed,
+// the context ends, or the Consumer stops, returning the partial batch without an error. A zero wait only takes
+// what's already buffered. Settle the batch with its Ack, Nack, or Settle.
+func (con *Consumer) ReadBatch(ctx context.Context, max int, wait time.Duration) (*Batch, error) {
+	if max < 1 {
+		return nil, errors.New("can't read a batch of fewer than 1 message")
+	}
+
+	msg, err := con.ReadMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &Batch{Messages: make([]*models.Message, 1, max)}
+	batch.Messages[0] = msg
+
+	con.conLock.Lock()
+	stopped := con.stopped
+	con.conLock.Unlock()
+
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for len(batch.Messages) < max {
+		select {
+		case msg := <-con.messages:
+			batch.Messages = append(batch.Messages, msg)
+			continue
+		default:
+		}
+
+		if timeout == nil {
+			break
+		}
+
+		select {
+		case msg := <-con.messages:
+			batch.Messages = append(batch.Messages, msg)
+		case <-timeout:
+			return batch, nil
+		case <-ctx.Done():
+			return batch, nil
+		case <-stopped:
+			return batch, nil
+		}
+	}
+
+	return batch, nil
+}
@@ -0,0 +1,329 @@
+package consumer
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+// RecordedDelivery is a consumed delivery (metadata and body) as written by a Recorder, one JSON object per line.
+// Headers go through JSON, so numbers come back as float64 and tables as maps.
+type RecordedDelivery struct {
+	Received        time.Time  `json:"Received"`
+	Exchange        string     `json:"Exchange"`
+	RoutingKey      string     `json:"RoutingKey"`
+	Redelivered     bool       `json:"Redelivered"`
+	ContentType     string     `json:"ContentType"`
+	ContentEncoding string     `json:"ContentEncoding"`
+	DeliveryMode    uint8      `json:"DeliveryMode"`
+	Priority        uint8      `json:"Priority"`
+	CorrelationID   string     `json:"CorrelationID"`
+	ReplyTo         string     `json:"ReplyTo"`
+	Expiration      string     `json:"Expiration"`
+	MessageID       string     `json:"MessageID"`
+	Timestamp       time.Time  `json:"Timestamp"`
+	Type            string     `json:"Type"`
+	UserID          string     `json:"UserID"`
+	AppID           string     `json:"AppID"`
+	Headers         amqp.Table `json:"Headers"`
+	Body            []byte     `json:"Body"` // base64 in the file
+}
+
+// NewRecordedDelivery copies the delivery's metadata and body.
+func NewRecordedDelivery(delivery *amqp.Delivery, received time.Time) *RecordedDelivery {
+	return &RecordedDelivery{
+		Received:        received,
+		Exchange:        delivery.Exchange,
+		RoutingKey:      delivery.RoutingKey,
+		Redelivered:     delivery.Redelivered,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    delivery.DeliveryMode,
+		Priority:        delivery.Priority,
+		CorrelationID:   delivery.CorrelationId,
+		ReplyTo:         delivery.ReplyTo,
+		Expiration:      delivery.Expiration,
+		MessageID:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		UserID:          delivery.UserId,
+		AppID:           delivery.AppId,
+		Headers:         delivery.Headers,
+		Body:            delivery.Body,
+	}
+}
+
+// Recorder writes consumed deliveries to a recording that a Replayer can play back.
+type Recorder struct {
+	encoder *json.Encoder
+	closer  io.Closer
+	count   uint64
+	recLock *sync.Mutex
+}
+
+// NewRecorder records to the writer.
+func NewRecorder(writer io.Writer) *Recorder {
+	return &Recorder{
+		encoder: json.NewEncoder(writer),
+		recLock: &sync.Mutex{},
+	}
+}
+
+// NewFileRecorder records to a new (or truncated) file, Close closes the file.
+func NewFileRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := NewRecorder(file)
+	rec.closer = file
+
+	return rec, nil
+}
+
+// Record writes the delivery, received now.
+func (rec *Recorder) Record(delivery *amqp.Delivery) error {
+	recorded := NewRecordedDelivery(delivery, time.Now())
+
+	rec.recLock.Lock()
+	defer rec.recLock.Unlock()
+
+	if err := rec.encoder.Encode(recorded); err != nil {
+		return err
+	}
+
+	rec.count++
+
+	return nil
+}
+
+// Count returns how many deliveries have been recorded.
+func (rec *Recorder) Count() uint64 {
+	rec.recLock.Lock()
+	defer rec.recLock.Unlock()
+
+	return rec.count
+}
+
+// Close closes the file of a file Recorder, it's a no-op otherwise.
+func (rec *Recorder) Close() error {
+	rec.recLock.Lock()
+	defer rec.recLock.Unlock()
+
+	if rec.closer == nil {
+		return nil
+	}
+
+	err := rec.closer.Close()
+	rec.closer = nil
+
+	return err
+}
+
+// SetRecorder records every delivery the Consumer receives (before any filtering) until consuming stops,
+// nil stops recording. Must be called before consuming starts. Deliveries are written as they arrive,
+// so recording slows consumption down to the speed of the writer.
+func (con *Consumer) SetRecorder(recorder *Recorder) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't set a recorder on a started consumer")
+	}
+
+	con.recorder = recorder
+
+	return nil
+}
+
+// ReadRecording reads every delivery in a recording.
+func ReadRecording(reader io.Reader) ([]*RecordedDelivery, error) {
+	decoder := json.NewDecoder(reader)
+
+	deliveries := make([]*RecordedDelivery, 0)
+	for {
+		recorded := &RecordedDelivery{}
+		if err := decoder.Decode(recorded); err != nil {
+			if err == io.EOF {
+				return deliveries, nil
+			}
+
+			return nil, err
+		}
+
+		deliveries = append(deliveries, recorded)
+	}
+}
+
+// ReplayStats counts what happened to the replayed messages.
+type ReplayStats struct {
+	Delivered uint64
+	Acked     uint64
+	Nacked    uint64
+	Rejected  uint64
+	Failed    uint64 // handler errors
+}
+
+// Replayer plays a recording back as Messages, like a Consumer's Messages() or handler, without a broker.
+// Replayed messages are ackable, their acks, nacks, and rejects are only counted.
+type Replayer struct {
+	deliveries []*RecordedDelivery
+	speed      float64
+	messages   chan *models.Message
+	stats      ReplayStats
+	stop       chan struct{}
+	stopOnce   *sync.Once
+}
+
+// NewReplayer loads the recording at path. A speed of 1 replays at the original timing, 10 ten times faster,
+// and 0 as fast as the messages are taken.
+func NewReplayer(path string, speed float64) (*Replayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	deliveries, err := ReadRecording(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReplayerFromDeliveries(deliveries, speed), nil
+}
+
+// NewReplayerFromDeliveries replays already loaded (or hand built) deliveries, see NewReplayer for the speed.
+func NewReplayerFromDeliveries(deliveries []*RecordedDelivery, speed float64) *Replayer {
+	return &Replayer{
+		deliveries: deliveries,
+		speed:      speed,
+		messages:   make(chan *models.Message),
+		stop:       make(chan struct{}),
+		stopOnce:   &sync.Once{},
+	}
+}
+
+// Start replays the recording into Messages() in the background, Messages() is closed once it's done.
+func (rp *Replayer) Start() {
+	models.Go("consumer.replay", func() {
+		defer close(rp.messages)
+
+		rp.replay(func(msg *models.Message) bool {
+			select {
+			case rp.messages <- msg:
+				return true
+			case <-rp.stop:
+				return false
+			}
+		})
+	})
+}
+
+// Messages yields the replayed messages after Start.
+func (rp *Replayer) Messages() <-chan *models.Message {
+	return rp.messages
+}
+
+// Run replays the recording through the handler, returning once every message has been handled or Stop is called.
+// Handler errors are counted as failures and don't stop the replay.
+func (rp *Replayer) Run(handler MessageHandler) ReplayStats {
+	rp.replay(func(msg *models.Message) bool {
+		if err := handler(msg); err != nil {
+			atomic.AddUint64(&rp.stats.Failed, 1)
+		}
+
+		return true
+	})
+
+	return rp.Stats()
+}
+
+// Stop ends the replay early.
+func (rp *Replayer) Stop() {
+	rp.stopOnce.Do(func() { close(rp.stop) })
+}
+
+// Stats returns the replay counts so far.
+func (rp *Replayer) Stats() ReplayStats {
+	return ReplayStats{
+		Delivered: atomic.LoadUint64(&rp.stats.Delivered),
+		Acked:     atomic.LoadUint64(&rp.stats.Acked),
+		Nacked:    atomic.LoadUint64(&rp.stats.Nacked),
+		Rejected:  atomic.LoadUint64(&rp.stats.Rejected),
+		Failed:    atomic.LoadUint64(&rp.stats.Failed),
+	}
+}
+
+// Replay hands each delivery to deliver at its (scaled) offset from the first one, until deliver returns false.
+func (rp *Replayer) replay(deliver func(*models.Message) bool) {
+	if len(rp.deliveries) == 0 {
+		return
+	}
+
+	first := rp.deliveries[0].Received
+	started := time.Now()
+
+	for i, recorded := range rp.deliveries {
+		if rp.speed > 0 {
+			due := started.Add(time.Duration(float64(recorded.Received.Sub(first)) / rp.speed))
+			if wait := time.Until(due); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-rp.stop:
+					timer.Stop()
+					return
+				}
+			}
+		}
+
+		select {
+		case <-rp.stop:
+			return
+		default:
+		}
+
+		msg := models.NewMessage(true, recorded.Body, uint64(i+1), nil)
+		msg.MessageID = recorded.MessageID
+		msg.CorrelationID = recorded.CorrelationID
+		msg.Headers = recorded.Headers
+		msg.Exchange = recorded.Exchange
+		msg.RoutingKey = recorded.RoutingKey
+		msg.Type = recorded.Type
+		msg.ContentType = recorded.ContentType
+		msg.ContentEncoding = recorded.ContentEncoding
+		msg.SetAcknowledger((*replayAcker)(&rp.stats))
+
+		if !deliver(msg) {
+			return
+		}
+
+		atomic.AddUint64(&rp.stats.Delivered, 1)
+	}
+}
+
+// ReplayAcker counts the ack decisions of replayed messages, there is no channel to send them to.
+type replayAcker ReplayStats
+
+func (ra *replayAcker) Ack(amqpChan *amqp.Channel, deliveryTag uint64) error {
+	atomic.AddUint64(&ra.Acked, 1)
+	return nil
+}
+
+func (ra *replayAcker) Nack(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	atomic.AddUint64(&ra.Nacked, 1)
+	return nil
+}
+
+func (ra *replayAcker) Reject(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	atomic.AddUint64(&ra.Rejected, 1)
+	return nil
+}
@@ -0,0 +1,134 @@
+package consumer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/utils"
+	"github.com/streadway/amqp"
+)
+
+// ReplayGuard drops deliveries whose message-id has already been acked (or auto acked) within a time window.
+// Message-ids are remembered in rotating bloom filters, so a false positive rate of unique messages will be
+// dropped as duplicates. Ids are only remembered once acked, a nacked or rejected message that comes back is
+// processed again. Deliveries without a message-id are never dropped.
+type ReplayGuard struct {
+	filter  *utils.RotatingBloomFilter
+	next    models.Acknowledger // nil acks directly on the channel
+	pending map[pendingDelivery]string
+	dropped uint64
+	lock    *sync.Mutex
+}
+
+type pendingDelivery struct {
+	amqpChan    *amqp.Channel
+	deliveryTag uint64
+}
+
+// NewReplayGuard creates a new ReplayGuard remembering message-ids for the window (at least one, at most two).
+// The expected count is the number of messages per window the false positive rate is sized for.
+func NewReplayGuard(window time.Duration, expectedCount uint32, falsePositiveRate float64) *ReplayGuard {
+	return &ReplayGuard{
+		filter:  utils.NewRotatingBloomFilter(window, expectedCount, falsePositiveRate),
+		pending: make(map[pendingDelivery]string),
+		lock:    &sync.Mutex{},
+	}
+}
+
+// Dropped returns how many deliveries have been dropped as duplicates.
+func (rg *ReplayGuard) Dropped() uint64 {
+	return atomic.LoadUint64(&rg.dropped)
+}
+
+// IsDuplicate checks the delivery against the window, remembering it (or tracking it for its ack) when it isn't.
+// Duplicates of ackable deliveries are acked, they were already acked once.
+func (rg *ReplayGuard) isDuplicate(amqpChan *amqp.Channel, delivery *amqp.Delivery, isAckable bool) (bool, error) {
+	if delivery.MessageId == "" {
+		return false, nil
+	}
+
+	if rg.filter.Contains(delivery.MessageId) {
+		atomic.AddUint64(&rg.dropped, 1)
+
+		if isAckable {
+			return true, amqpChan.Ack(delivery.DeliveryTag, false)
+		}
+
+		return true, nil
+	}
+
+	if !isAckable {
+		rg.filter.Add(delivery.MessageId)
+		return false, nil
+	}
+
+	rg.lock.Lock()
+	rg.pending[pendingDelivery{amqpChan: amqpChan, deliveryTag: delivery.DeliveryTag}] = delivery.MessageId
+	rg.lock.Unlock()
+
+	return false, nil
+}
+
+// Forget drops the pending deliveries of a closed channel.
+func (rg *ReplayGuard) Forget(amqpChan *amqp.Channel) {
+	rg.lock.Lock()
+	defer rg.lock.Unlock()
+
+	for pending := range rg.pending {
+		if pending.amqpChan == amqpChan {
+			delete(rg.pending, pending)
+		}
+	}
+}
+
+// Ack remembers the message-id once the ack succeeds.
+func (rg *ReplayGuard) Ack(amqpChan *amqp.Channel, deliveryTag uint64) error {
+	var err error
+	if rg.next != nil {
+		err = rg.next.Ack(amqpChan, deliveryTag)
+	} else {
+		err = amqpChan.Ack(deliveryTag, false)
+	}
+
+	messageID, ok := rg.settle(amqpChan, deliveryTag)
+	if err == nil && ok {
+		rg.filter.Add(messageID)
+	}
+
+	return err
+}
+
+// Nack forgets the delivery so a requeued redelivery is processed again.
+func (rg *ReplayGuard) Nack(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	rg.settle(amqpChan, deliveryTag)
+
+	if rg.next != nil {
+		return rg.next.Nack(amqpChan, deliveryTag, requeue)
+	}
+
+	return amqpChan.Nack(deliveryTag, false, requeue)
+}
+
+// Reject forgets the delivery so a requeued redelivery is processed again.
+func (rg *ReplayGuard) Reject(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	rg.settle(amqpChan, deliveryTag)
+
+	if rg.next != nil {
+		return rg.next.Reject(amqpChan, deliveryTag, requeue)
+	}
+
+	return amqpChan.Reject(deliveryTag, requeue)
+}
+
+func (rg *ReplayGuard) settle(amqpChan *amqp.Channel, deliveryTag uint64) (string, bool) {
+	rg.lock.Lock()
+	defer rg.lock.Unlock()
+
+	key := pendingDelivery{amqpChan: amqpChan, deliveryTag: deliveryTag}
+	messageID, ok := rg.pending[key]
+	delete(rg.pending, key)
+
+	return messageID, ok
+}
@@ -0,0 +1,460 @@
+package consumer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
+	"github.com/streadway/amqp"
+)
+
+// SettleTracker tracks the ackable deliveries per channel that haven't been acked, nacked, or rejected yet,
+// so an immediate stop can hand them back to the queue and report how many it did.
+type settleTracker struct {
+	next        models.Acknowledger               // nil acks directly on the channel
+	checkpoints *checkpointer                     // nil without checkpoints
+	timeouts    *timeoutWatch                     // nil without a consumer timeout
+	queueName   string                            // labels the settle metrics
+	unsettled   map[*amqp.Channel]map[uint64]bool // delivery tags by channel
+	requeued    map[*amqp.Channel]bool            // dedicated channels an immediate stop requeued everything on
+	requeuedOn  map[*amqp.Channel]map[uint64]bool // delivery tags an immediate stop requeued on shared channels
+	lock        *sync.Mutex
+	channelIDs  map[*amqp.Channel][2]uint64 // connection and channel IDs by channel, for tracing
+	exclusive   map[*amqp.Channel]bool      // dedicated channels, a multiple settle on them only reaches this consumer
+	idsLock     *sync.Mutex
+}
+
+func newSettleTracker() *settleTracker {
+	return &settleTracker{
+		unsettled:  make(map[*amqp.Channel]map[uint64]bool),
+		requeued:   make(map[*amqp.Channel]bool),
+		requeuedOn: make(map[*amqp.Channel]map[uint64]bool),
+		lock:       &sync.Mutex{},
+		channelIDs: make(map[*amqp.Channel][2]uint64),
+		exclusive:  make(map[*amqp.Channel]bool),
+		idsLock:    &sync.Mutex{},
+	}
+}
+
+// Identify remembers the IDs of a channel the consumer consumes on, so its settles are traced with them, and
+// whether the channel is dedicated to the consumer.
+func (st *settleTracker) identify(chanHost *pools.ChannelHost) {
+	st.idsLock.Lock()
+	st.channelIDs[chanHost.Channel] = [2]uint64{chanHost.ConnectionID, chanHost.ChannelID}
+	if chanHost.IsDedicated() {
+		st.exclusive[chanHost.Channel] = true
+	}
+	st.idsLock.Unlock()
+}
+
+// ForgetIDs drops the channel's IDs once the consumer is done with it.
+func (st *settleTracker) forgetIDs(amqpChan *amqp.Channel) {
+	st.idsLock.Lock()
+	delete(st.channelIDs, amqpChan)
+	delete(st.exclusive, amqpChan)
+	st.idsLock.Unlock()
+}
+
+func (st *settleTracker) isExclusive(amqpChan *amqp.Channel) bool {
+	st.idsLock.Lock()
+	defer st.idsLock.Unlock()
+
+	return st.exclusive[amqpChan]
+}
+
+// Trace logs a settle sent on the channel when tracing (see models.SetTracing).
+func (st *settleTracker) trace(operation string, amqpChan *amqp.Channel, deliveryTag uint64, multiple bool, started time.Time, err error) {
+	if !models.Tracing() {
+		return
+	}
+
+	st.idsLock.Lock()
+	ids := st.channelIDs[amqpChan]
+	st.idsLock.Unlock()
+
+	models.Trace(operation, ids[0], ids[1], started, err, "queue: %s, delivery tag: %d, multiple: %t", st.queueName, deliveryTag, multiple)
+}
+
+func (st *settleTracker) track(amqpChan *amqp.Channel, deliveryTag uint64) {
+	st.lock.Lock()
+	if st.unsettled[amqpChan] == nil {
+		st.unsettled[amqpChan] = make(map[uint64]bool)
+	}
+	st.unsettled[amqpChan][deliveryTag] = true
+	st.lock.Unlock()
+
+	if st.timeouts != nil {
+		st.timeouts.track(amqpChan, deliveryTag)
+	}
+}
+
+// Take returns the channel's unsettled delivery tags and stops tracking the channel.
+func (st *settleTracker) take(amqpChan *amqp.Channel) []uint64 {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	deliveryTags := make([]uint64, 0, len(st.unsettled[amqpChan]))
+	for deliveryTag := range st.unsettled[amqpChan] {
+		deliveryTags = append(deliveryTags, deliveryTag)
+	}
+	delete(st.unsettled, amqpChan)
+
+	if st.timeouts != nil {
+		st.timeouts.forget(amqpChan)
+	}
+
+	return deliveryTags
+}
+
+// MarkRequeued takes the channel's unsettled delivery tags like take, and refuses every later settle of them,
+// or of anything on the channel when it's dedicated.
+func (st *settleTracker) markRequeued(amqpChan *amqp.Channel, dedicated bool) []uint64 {
+	deliveryTags := st.take(amqpChan)
+
+	st.lock.Lock()
+	if dedicated {
+		st.requeued[amqpChan] = true
+	} else {
+		if st.requeuedOn[amqpChan] == nil {
+			st.requeuedOn[amqpChan] = make(map[uint64]bool)
+		}
+
+		for _, deliveryTag := range deliveryTags {
+			st.requeuedOn[amqpChan][deliveryTag] = true
+		}
+	}
+	st.lock.Unlock()
+
+	return deliveryTags
+}
+
+func (st *settleTracker) isRequeued(amqpChan *amqp.Channel, deliveryTag uint64) bool {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	return st.requeued[amqpChan] || st.requeuedOn[amqpChan][deliveryTag]
+}
+
+// ForgetRequeued drops the deliveries an earlier consume requeued, when consuming starts again.
+func (st *settleTracker) forgetRequeued() {
+	st.lock.Lock()
+	st.requeued = make(map[*amqp.Channel]bool)
+	st.requeuedOn = make(map[*amqp.Channel]map[uint64]bool)
+	st.lock.Unlock()
+}
+
+// Outstanding returns the unsettled count over every channel.
+func (st *settleTracker) outstanding() int64 {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	total := int64(0)
+	for _, deliveryTags := range st.unsettled {
+		total += int64(len(deliveryTags))
+	}
+
+	return total
+}
+
+// UnsettledOn returns the channel's unsettled count.
+func (st *settleTracker) unsettledOn(amqpChan *amqp.Channel) int64 {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	return int64(len(st.unsettled[amqpChan]))
+}
+
+func (st *settleTracker) settle(amqpChan *amqp.Channel, deliveryTag uint64) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	st.decrement(amqpChan, deliveryTag)
+}
+
+// Decrement counts a delivery as settled, must be called while holding the lock.
+func (st *settleTracker) decrement(amqpChan *amqp.Channel, deliveryTag uint64) {
+	if deliveryTags, ok := st.unsettled[amqpChan]; ok {
+		delete(deliveryTags, deliveryTag)
+		if len(deliveryTags) == 0 {
+			delete(st.unsettled, amqpChan)
+		}
+	}
+}
+
+// SettleDirect counts a delivery as settled and sends its settle on the channel under the lock, so a multiple
+// settle (see settleAll) never covers a delivery whose own settle is still on its way.
+func (st *settleTracker) settleDirect(amqpChan *amqp.Channel, deliveryTag uint64, send func() error) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	st.decrement(amqpChan, deliveryTag)
+	return send()
+}
+
+func (st *settleTracker) count(outcome string) {
+	models.GetMetrics().Counter(models.MetricSettles, 1, models.Labels{"queue": st.queueName, "outcome": outcome})
+}
+
+// Claim returns false when the delivery was requeued, for nearing the consumer timeout or by an immediate stop.
+func (st *settleTracker) claim(amqpChan *amqp.Channel, deliveryTag uint64) bool {
+	if st.isRequeued(amqpChan, deliveryTag) {
+		return false
+	}
+
+	return st.timeouts == nil || st.timeouts.settle(amqpChan, deliveryTag)
+}
+
+func (st *settleTracker) Ack(amqpChan *amqp.Channel, deliveryTag uint64) error {
+	if !st.claim(amqpChan, deliveryTag) {
+		return ErrDeliveryExpired
+	}
+
+	if delay := models.GetChaos().AckDelay(); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	st.count("ack")
+	if st.checkpoints != nil {
+		st.checkpoints.record(settledAck, deliveryTag)
+	}
+
+	started := time.Now()
+	var err error
+	if st.next != nil {
+		st.settle(amqpChan, deliveryTag)
+		err = st.next.Ack(amqpChan, deliveryTag)
+	} else {
+		err = st.settleDirect(amqpChan, deliveryTag, func() error { return amqpChan.Ack(deliveryTag, false) })
+	}
+
+	st.trace("ack", amqpChan, deliveryTag, false, started, err)
+	return err
+}
+
+func (st *settleTracker) Nack(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	if !st.claim(amqpChan, deliveryTag) {
+		return ErrDeliveryExpired
+	}
+
+	return st.nack(amqpChan, deliveryTag, requeue)
+}
+
+// RequeueExpired requeues a delivery the timeout watch already claimed.
+func (st *settleTracker) requeueExpired(amqpChan *amqp.Channel, deliveryTag uint64) error {
+	return st.nack(amqpChan, deliveryTag, true)
+}
+
+func (st *settleTracker) nack(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	st.count("nack")
+	if st.checkpoints != nil {
+		st.checkpoints.record(settledNack, deliveryTag)
+	}
+
+	started := time.Now()
+	var err error
+	if st.next != nil {
+		st.settle(amqpChan, deliveryTag)
+		err = st.next.Nack(amqpChan, deliveryTag, requeue)
+	} else {
+		err = st.settleDirect(amqpChan, deliveryTag, func() error { return amqpChan.Nack(deliveryTag, false, requeue) })
+	}
+
+	st.trace("nack", amqpChan, deliveryTag, false, started, err)
+	return err
+}
+
+func (st *settleTracker) Reject(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	if !st.claim(amqpChan, deliveryTag) {
+		return ErrDeliveryExpired
+	}
+
+	st.count("reject")
+	if st.checkpoints != nil {
+		st.checkpoints.record(settledReject, deliveryTag)
+	}
+
+	started := time.Now()
+	var err error
+	if st.next != nil {
+		st.settle(amqpChan, deliveryTag)
+		err = st.next.Reject(amqpChan, deliveryTag, requeue)
+	} else {
+		err = st.settleDirect(amqpChan, deliveryTag, func() error { return amqpChan.Reject(deliveryTag, requeue) })
+	}
+
+	st.trace("reject", amqpChan, deliveryTag, false, started, err)
+	return err
+}
+
+// AckAll acks the channel's deliveries, with a single multiple ack when they're everything outstanding on a
+// dedicated channel.
+func (st *settleTracker) AckAll(amqpChan *amqp.Channel, deliveryTags []uint64) error {
+	if delay := models.GetChaos().AckDelay(); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	return st.settleAll(
+		amqpChan,
+		deliveryTags,
+		settledAck,
+		func(deliveryTag uint64) error { return st.next.Ack(amqpChan, deliveryTag) },
+		func(deliveryTag uint64, multiple bool) error { return amqpChan.Ack(deliveryTag, multiple) })
+}
+
+// NackAll nacks the channel's deliveries, with a single multiple nack when they're everything outstanding on a
+// dedicated channel.
+func (st *settleTracker) NackAll(amqpChan *amqp.Channel, deliveryTags []uint64, requeue bool) error {
+	return st.settleAll(
+		amqpChan,
+		deliveryTags,
+		settledNack,
+		func(deliveryTag uint64) error { return st.next.Nack(amqpChan, deliveryTag, requeue) },
+		func(deliveryTag uint64, multiple bool) error { return amqpChan.Nack(deliveryTag, multiple, requeue) })
+}
+
+// SettleAll settles the deliveries through next, or on the channel: a single multiple settle up to the highest
+// tag when the channel is dedicated to the consumer and the deliveries are everything outstanding on it
+// (deliveries are tracked in tag order, so no other outstanding delivery has a lower tag), one settle each
+// otherwise. Deliveries of a shared channel (i.e. from Get) are never all the channel's, whatever this consumer
+// counts, since other holders of the channel settle on it too. Expired deliveries are skipped and
+// reported with ErrDeliveryExpired.
+func (st *settleTracker) settleAll(
+	amqpChan *amqp.Channel,
+	deliveryTags []uint64,
+	outcome settleOutcome,
+	viaNext func(deliveryTag uint64) error,
+	direct func(deliveryTag uint64, multiple bool) error) error {
+
+	var expired error
+	claimed := make([]uint64, 0, len(deliveryTags))
+	highest := uint64(0)
+	for _, deliveryTag := range deliveryTags {
+		if !st.claim(amqpChan, deliveryTag) {
+			expired = ErrDeliveryExpired
+			continue
+		}
+
+		claimed = append(claimed, deliveryTag)
+		if deliveryTag > highest {
+			highest = deliveryTag
+		}
+
+		st.count(settleOutcomeNames[outcome])
+		if st.checkpoints != nil {
+			st.checkpoints.record(outcome, deliveryTag)
+		}
+	}
+
+	if st.next != nil {
+		for _, deliveryTag := range claimed {
+			st.settle(amqpChan, deliveryTag)
+
+			started := time.Now()
+			err := viaNext(deliveryTag)
+			st.trace(settleOutcomeNames[outcome], amqpChan, deliveryTag, false, started, err)
+			if err != nil {
+				return err
+			}
+		}
+
+		return expired
+	}
+
+	send := func(deliveryTag uint64, multiple bool) error {
+		started := time.Now()
+		err := direct(deliveryTag, multiple)
+		st.trace(settleOutcomeNames[outcome], amqpChan, deliveryTag, multiple, started, err)
+		return err
+	}
+
+	exclusive := st.isExclusive(amqpChan)
+
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	everything := exclusive && len(claimed) == len(st.unsettled[amqpChan])
+	for _, deliveryTag := range claimed {
+		st.decrement(amqpChan, deliveryTag)
+	}
+
+	if everything && len(claimed) > 1 {
+		if err := send(highest, true); err != nil {
+			return err
+		}
+
+		return expired
+	}
+
+	for _, deliveryTag := range claimed {
+		if err := send(deliveryTag, false); err != nil {
+			return err
+		}
+	}
+
+	return expired
+}
+
+// RequeueUnacked hands every unacked delivery of the consume back to the queue on an immediate stop.
+// The consume is cancelled first so nothing new arrives and decided batched acks are flushed. On a dedicated
+// channel (see pools.ChannelPool.GetDedicatedAckableChannel) a single nack (multiple, requeue) then covers
+// everything outstanding, on a shared one each of the consumer's deliveries is nacked by itself, so deliveries
+// others hold on the channel aren't reached. Messages still held by the application are requeued too, settling
+// them afterwards returns ErrDeliveryExpired without sending anything. Returns how many deliveries were requeued.
+func (con *Consumer) requeueUnacked(deliveryChan <-chan amqp.Delivery, amqpChan *amqp.Channel) int64 {
+
+	var drained []uint64
+	if con.ConsumerName != "" {
+		if err := amqpChan.Cancel(con.ConsumerName, false); err != nil {
+			con.handleError(err)
+		} else {
+			for delivery := range deliveryChan { // closed once the cancel is confirmed
+				drained = append(drained, delivery.DeliveryTag)
+			}
+		}
+	}
+
+	if con.ackBatcher != nil {
+		con.ackBatcher.Stop()
+	}
+
+	if con.replayGuard != nil {
+		con.replayGuard.Forget(amqpChan)
+	}
+
+	if con.dedup != nil {
+		con.dedup.Forget(amqpChan)
+	}
+
+	dedicated := con.settleTracker.isExclusive(amqpChan)
+	deliveryTags := append(con.settleTracker.markRequeued(amqpChan, dedicated), drained...)
+	defer con.settleTracker.forgetIDs(amqpChan)
+
+	if dedicated {
+		// a zero delivery tag with multiple covers every outstanding delivery on the channel
+		started := time.Now()
+		err := amqpChan.Nack(0, true, true)
+		con.settleTracker.trace("nack", amqpChan, 0, true, started, err)
+		if err != nil {
+			con.handleError(err)
+			return 0
+		}
+
+		return int64(len(deliveryTags))
+	}
+
+	requeued := int64(0)
+	for _, deliveryTag := range deliveryTags {
+		started := time.Now()
+		err := amqpChan.Nack(deliveryTag, false, true)
+		con.settleTracker.trace("nack", amqpChan, deliveryTag, false, started, err)
+		if err != nil {
+			con.handleError(err)
+			continue
+		}
+
+		requeued++
+	}
+
+	return requeued
+}
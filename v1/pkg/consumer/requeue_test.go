@@ -0,0 +1,89 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettleAllMultipleOnlyOnExclusiveChannel(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		exclusive bool
+		tracked   []uint64
+		settled   []uint64
+		sends     []ackSend
+	}{
+		{
+			name:      "everything outstanding on a dedicated channel",
+			exclusive: true,
+			tracked:   []uint64{1, 2, 3},
+			settled:   []uint64{1, 2, 3},
+			sends:     []ackSend{{deliveryTag: 3, multiple: true}},
+		},
+		{
+			name:    "everything this consumer counts on a shared channel",
+			tracked: []uint64{1, 2, 3},
+			settled: []uint64{1, 2, 3},
+			sends:   []ackSend{{deliveryTag: 1}, {deliveryTag: 2}, {deliveryTag: 3}},
+		},
+		{
+			name:      "some outstanding on a dedicated channel",
+			exclusive: true,
+			tracked:   []uint64{1, 2, 3},
+			settled:   []uint64{1, 3},
+			sends:     []ackSend{{deliveryTag: 1}, {deliveryTag: 3}},
+		},
+		{
+			name:      "a single delivery",
+			exclusive: true,
+			tracked:   []uint64{4},
+			settled:   []uint64{4},
+			sends:     []ackSend{{deliveryTag: 4}},
+		},
+	}
+
+	for _, test := range tests {
+		st := newSettleTracker()
+		amqpChan := &amqp.Channel{}
+		st.exclusive[amqpChan] = test.exclusive
+
+		for _, deliveryTag := range test.tracked {
+			st.track(amqpChan, deliveryTag)
+		}
+
+		var sends []ackSend
+		err := st.settleAll(amqpChan, test.settled, settledAck, nil, func(deliveryTag uint64, multiple bool) error {
+			sends = append(sends, ackSend{deliveryTag: deliveryTag, multiple: multiple})
+			return nil
+		})
+
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.sends, sends, test.name)
+		assert.Equal(t, int64(len(test.tracked)-len(test.settled)), st.outstanding(), test.name)
+	}
+}
+
+func TestMarkRequeuedOnlyRefusesOwnDeliveriesOnSharedChannel(t *testing.T) {
+	st := newSettleTracker()
+	shared := &amqp.Channel{}
+	dedicated := &amqp.Channel{}
+
+	st.track(shared, 1)
+	st.track(shared, 3)
+	st.track(dedicated, 1)
+
+	assert.ElementsMatch(t, []uint64{1, 3}, st.markRequeued(shared, false))
+	assert.ElementsMatch(t, []uint64{1}, st.markRequeued(dedicated, true))
+	assert.Equal(t, int64(0), st.outstanding())
+
+	assert.True(t, st.isRequeued(shared, 1))
+	assert.False(t, st.isRequeued(shared, 2)) // held by someone else on the channel
+	assert.True(t, st.isRequeued(dedicated, 2))
+
+	st.forgetRequeued()
+	assert.False(t, st.isRequeued(shared, 1))
+	assert.False(t, st.isRequeued(dedicated, 2))
+}
@@ -0,0 +1,115 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// ResultHeader marks a published result as "success" or "failure".
+	ResultHeader = "x-result"
+	// ResultErrorHeader carries the handler's error on a failure result.
+	ResultErrorHeader = "x-result-error"
+)
+
+// ResultPublisher publishes handler results, satisfied by *publisher.Publisher.
+type ResultPublisher interface {
+	PublishRaw(exchange, key string, mandatory, immediate bool, publishing amqp.Publishing) error
+}
+
+// ResultFunc handles a Message and returns the response to publish, a nil response publishes nothing.
+// The handler must not ack, nack, or reject the Message itself.
+type ResultFunc func(msg *models.Message) ([]byte, error)
+
+// ResultHandler wraps a ResultFunc for the consume-transform-publish pattern: a response is published to the
+// Success route, an error to the Failure route (the response, or the error text when there is none, as the body),
+// and only then is the Message acked. Responses carry the input's correlation id (its message id when it has none)
+// and the routing's CarryHeaders. When a response can't be published the Message is nacked for redelivery.
+// A failure without a Failure route rejects the Message (dead lettered when the queue has a DLX).
+func ResultHandler(publisher ResultPublisher, routing *models.ResultRouting, handler ResultFunc) MessageHandler {
+	return func(msg *models.Message) error {
+		response, err := handler(msg)
+
+		route := routing.Success
+		if err != nil {
+			route = routing.Failure
+			if route == nil {
+				if rejectErr := settleResult(msg, func() error { return msg.Reject(false) }); rejectErr != nil {
+					return fmt.Errorf("%w\r\n[reject error: %s]", err, rejectErr)
+				}
+
+				return err
+			}
+
+			if response == nil {
+				response = []byte(err.Error())
+			}
+		}
+
+		if route != nil && response != nil {
+			if publishErr := publisher.PublishRaw(route.Exchange, route.RoutingKey, false, false, resultPublishing(msg, routing, response, err)); publishErr != nil {
+				_ = settleResult(msg, func() error { return msg.Nack(true) })
+				return fmt.Errorf("can't publish the result: %w", publishErr)
+			}
+		}
+
+		if ackErr := settleResult(msg, msg.Acknowledge); ackErr != nil {
+			return ackErr
+		}
+
+		return err
+	}
+}
+
+// StartConsumingWithResults starts the Consumer in handler mode with a ResultHandler using the config's ResultRouting.
+func (con *Consumer) StartConsumingWithResults(publisher ResultPublisher, handler ResultFunc) error {
+	if con.resultRouting == nil {
+		return errors.New("can't route results without a result routing config")
+	}
+
+	if publisher == nil || handler == nil {
+		return errors.New("can't route results without a publisher and handler")
+	}
+
+	return con.StartConsumingWithHandler(ResultHandler(publisher, con.resultRouting, handler))
+}
+
+// SettleResult settles an ackable Message, auto acked ones have nothing to settle.
+func settleResult(msg *models.Message, settle func() error) error {
+	if !msg.IsAckable {
+		return nil
+	}
+
+	return settle()
+}
+
+func resultPublishing(msg *models.Message, routing *models.ResultRouting, response []byte, err error) amqp.Publishing {
+
+	headers := amqp.Table{}
+	for _, header := range routing.CarryHeaders {
+		if value, ok := msg.Headers[header]; ok {
+			headers[header] = value
+		}
+	}
+
+	headers[ResultHeader] = "success"
+	if err != nil {
+		headers[ResultHeader] = "failure"
+		headers[ResultErrorHeader] = err.Error()
+	}
+
+	correlationID := msg.CorrelationID
+	if correlationID == "" {
+		correlationID = msg.MessageID
+	}
+
+	return amqp.Publishing{
+		Headers:       headers,
+		CorrelationId: correlationID,
+		DeliveryMode:  amqp.Persistent,
+		Body:          response,
+	}
+}
@@ -0,0 +1,69 @@
+package consumer
+
+import (
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
+)
+
+const retirePollInterval = time.Duration(10) * time.Millisecond
+
+// ReleaseChannel gives back the channel a consume is done with. A shared channel goes straight back to the pool,
+// a dedicated one is retired once consuming stops (see retireChannels), since returning it closes it and the
+// deliveries still buffered or being handled couldn't be settled anymore.
+func (con *Consumer) releaseChannel(chanHost *pools.ChannelHost) {
+	if !chanHost.IsDedicated() {
+		con.channelPool.ReturnChannel(chanHost, false)
+		return
+	}
+
+	con.conLock.Lock()
+	con.retiring = append(con.retiring, chanHost)
+	con.conLock.Unlock()
+}
+
+// RetireChannels returns the released dedicated channels to the pool in the background, each once every delivery
+// on it is settled. Called after the tenants, the message group, and the batcher's final flush are done, so
+// nothing is left to settle on them but what the application still holds.
+func (con *Consumer) retireChannels() {
+	con.conLock.Lock()
+	retiring := con.retiring
+	con.retiring = nil
+	con.conLock.Unlock()
+
+	for _, chanHost := range retiring {
+		chanHost := chanHost
+		models.Go("consumer.retire", func() { con.retireChannel(chanHost) })
+	}
+}
+
+// RetireChannel waits for the channel's deliveries to be settled, or for the channel to close (the broker then
+// requeues what's left), before returning it.
+func (con *Consumer) retireChannel(chanHost *pools.ChannelHost) {
+	ticker := time.NewTicker(retirePollInterval)
+	defer ticker.Stop()
+
+RetireLoop:
+	for con.settleTracker.unsettledOn(chanHost.Channel) > 0 {
+		select {
+		case errorMessage := <-chanHost.CloseErrors():
+			if errorMessage != nil {
+				break RetireLoop
+			}
+		case <-ticker.C:
+		}
+	}
+
+	if con.replayGuard != nil {
+		con.replayGuard.Forget(chanHost.Channel)
+	}
+
+	if con.dedup != nil {
+		con.dedup.Forget(chanHost.Channel)
+	}
+
+	con.settleTracker.take(chanHost.Channel)
+	con.settleTracker.forgetIDs(chanHost.Channel)
+	con.channelPool.ReturnChannel(chanHost, false)
+}
@@ -0,0 +1,36 @@
+package consumer
+
+import (
+	"sync/atomic"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// PushOverwriting hands the Message to the buffer without waiting (the ring buffer strategy): when the buffer is
+// full the oldest buffered Message is taken out and nacked without requeue (dead lettered, or dropped) to make room.
+func (con *Consumer) pushOverwriting(msg *models.Message) {
+	for {
+		select {
+		case con.messages <- msg:
+			con.bufferAge.record()
+			return
+		default:
+		}
+
+		select {
+		case oldest := <-con.messages:
+			atomic.AddUint64(&con.overwritten, 1)
+			if oldest.IsAckable {
+				if err := oldest.Nack(false); err != nil {
+					con.handleError(err)
+				}
+			}
+		default: // the application read one in the meantime
+		}
+	}
+}
+
+// Overwritten returns how many buffered messages the ring buffer strategy dropped for fresher ones.
+func (con *Consumer) Overwritten() uint64 {
+	return atomic.LoadUint64(&con.overwritten)
+}
@@ -0,0 +1,69 @@
+package consumer
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+// Sampler copies a fraction of deliveries to a sink, used for capturing live traffic while debugging.
+type sampler struct {
+	rate        float64
+	sink        func(*models.Message)
+	samplerLock *sync.RWMutex
+}
+
+func newSampler() *sampler {
+	return &sampler{
+		samplerLock: &sync.RWMutex{},
+	}
+}
+
+func (s *sampler) set(rate float64, sink func(*models.Message)) {
+	s.samplerLock.Lock()
+	defer s.samplerLock.Unlock()
+
+	if rate > 1 {
+		rate = 1
+	}
+
+	s.rate = rate
+	s.sink = sink
+}
+
+// Sample hands a copy of the delivery from the queue to the sink (on its own goroutine) when the delivery is
+// sampled. The copy carries the delivery's metadata like a consumed Message but is not ackable, acknowledgement
+// stays with normal processing.
+func (s *sampler) sample(delivery *amqp.Delivery, queueName string) {
+	s.samplerLock.RLock()
+	rate := s.rate
+	sink := s.sink
+	s.samplerLock.RUnlock()
+
+	if sink == nil || rate <= 0 || rand.Float64() >= rate {
+		return
+	}
+
+	body := make([]byte, len(delivery.Body))
+	copy(body, delivery.Body)
+
+	msg := models.NewMessage(false, body, delivery.DeliveryTag, nil)
+	msg.MessageID = delivery.MessageId
+	msg.CorrelationID = delivery.CorrelationId
+	if delivery.Headers != nil { // the consumed Message shares the delivery's table, the sink gets its own
+		msg.Headers = make(amqp.Table, len(delivery.Headers))
+		for key, value := range delivery.Headers {
+			msg.Headers[key] = value
+		}
+	}
+	msg.Exchange = delivery.Exchange
+	msg.RoutingKey = delivery.RoutingKey
+	msg.Type = delivery.Type
+	msg.ContentType = delivery.ContentType
+	msg.ContentEncoding = delivery.ContentEncoding
+	msg.Priority = delivery.Priority
+	msg.Queue = queueName
+	models.TryGo("consumer.sampler", func() { sink(msg) })
+}
@@ -0,0 +1,58 @@
+package consumer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleCarriesMetadata(t *testing.T) {
+	sampled := make(chan *models.Message, 1)
+
+	s := newSampler()
+	s.set(1, func(msg *models.Message) { sampled <- msg })
+
+	delivery := &amqp.Delivery{
+		Body:            []byte("sampled"),
+		DeliveryTag:     7,
+		MessageId:       "message-1",
+		CorrelationId:   "correlation-1",
+		Headers:         amqp.Table{"tenant": "a"},
+		Exchange:        "TcrExchange",
+		RoutingKey:      "TcrRoutingKey",
+		Type:            "order.created",
+		ContentType:     "application/json",
+		ContentEncoding: "gzip",
+		Priority:        3,
+	}
+	s.sample(delivery, "TcrQueue")
+
+	var msg *models.Message
+	select {
+	case msg = <-sampled:
+	case <-time.After(time.Second):
+		t.Fatal("delivery wasn't sampled")
+	}
+
+	assert.Equal(t, []byte("sampled"), msg.Body)
+	assert.Equal(t, "message-1", msg.MessageID)
+	assert.Equal(t, "correlation-1", msg.CorrelationID)
+	assert.Equal(t, amqp.Table{"tenant": "a"}, msg.Headers)
+	assert.Equal(t, "TcrExchange", msg.Exchange)
+	assert.Equal(t, "TcrRoutingKey", msg.RoutingKey)
+	assert.Equal(t, "order.created", msg.Type)
+	assert.Equal(t, "application/json", msg.ContentType)
+	assert.Equal(t, "gzip", msg.ContentEncoding)
+	assert.Equal(t, uint8(3), msg.Priority)
+	assert.Equal(t, "TcrQueue", msg.Queue)
+	assert.False(t, msg.IsAckable)
+
+	// the sink's copies don't reach the delivery
+	msg.Body[0] = 'S'
+	msg.Headers["tenant"] = "b"
+	assert.Equal(t, []byte("sampled"), delivery.Body)
+	assert.Equal(t, "a", delivery.Headers["tenant"])
+}
@@ -0,0 +1,246 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+// ShadowDecision is what a handler decided for a Message in shadow mode (never sent to the server).
+type ShadowDecision int
+
+const (
+	// ShadowUnsettled means the handler returned without acking, nacking, or rejecting.
+	ShadowUnsettled ShadowDecision = iota
+	// ShadowAcked means the handler acked the Message.
+	ShadowAcked
+	// ShadowNacked means the handler nacked the Message.
+	ShadowNacked
+	// ShadowRejected means the handler rejected the Message.
+	ShadowRejected
+)
+
+var shadowDecisionNames = map[ShadowDecision]string{
+	ShadowUnsettled: "Unsettled",
+	ShadowAcked:     "Acked",
+	ShadowNacked:    "Nacked",
+	ShadowRejected:  "Rejected",
+}
+
+func (sd ShadowDecision) String() string {
+	if name, ok := shadowDecisionNames[sd]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("ShadowDecision(%d)", int(sd))
+}
+
+// ShadowResult is the outcome of handling one delivery in shadow mode.
+type ShadowResult struct {
+	DeliveryTag uint64
+	MessageID   string
+	Redelivered bool
+	Decision    ShadowDecision
+	Requeue     bool  // the requeue flag the handler nacked or rejected with
+	Error       error // returned by the handler (or its panic)
+	Latency     time.Duration
+	Time        time.Time
+}
+
+// ShadowStats totals the ShadowResults since the Consumer was created.
+type ShadowStats struct {
+	Handled      uint64
+	Errors       uint64
+	Acked        uint64
+	Nacked       uint64
+	Rejected     uint64
+	Unsettled    uint64
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+// AverageLatency is the mean handler latency.
+func (ss ShadowStats) AverageLatency() time.Duration {
+	if ss.Handled == 0 {
+		return 0
+	}
+
+	return ss.TotalLatency / time.Duration(ss.Handled)
+}
+
+type shadowMode struct {
+	requeueDelay time.Duration
+	results      chan *ShadowResult
+	stats        ShadowStats
+	statsLock    *sync.Mutex
+}
+
+func newShadowMode(requeueDelay time.Duration, resultBuffer uint32) *shadowMode {
+	return &shadowMode{
+		requeueDelay: requeueDelay,
+		results:      make(chan *ShadowResult, resultBuffer),
+		statsLock:    &sync.Mutex{},
+	}
+}
+
+func (sm *shadowMode) record(result *ShadowResult) {
+	sm.statsLock.Lock()
+	sm.stats.Handled++
+	if result.Error != nil {
+		sm.stats.Errors++
+	}
+
+	switch result.Decision {
+	case ShadowAcked:
+		sm.stats.Acked++
+	case ShadowNacked:
+		sm.stats.Nacked++
+	case ShadowRejected:
+		sm.stats.Rejected++
+	default:
+		sm.stats.Unsettled++
+	}
+
+	sm.stats.TotalLatency += result.Latency
+	if result.Latency > sm.stats.MaxLatency {
+		sm.stats.MaxLatency = result.Latency
+	}
+	sm.statsLock.Unlock()
+
+	select {
+	case sm.results <- result:
+	default:
+	}
+}
+
+// ShadowRecorder stands in for the channel as the Message's Acknowledger, recording the handler's decision.
+type shadowRecorder struct {
+	decision ShadowDecision
+	requeue  bool
+}
+
+func (sr *shadowRecorder) Ack(amqpChan *amqp.Channel, deliveryTag uint64) error {
+	sr.decision = ShadowAcked
+	return nil
+}
+
+func (sr *shadowRecorder) Nack(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	sr.decision, sr.requeue = ShadowNacked, requeue
+	return nil
+}
+
+func (sr *shadowRecorder) Reject(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error {
+	sr.decision, sr.requeue = ShadowRejected, requeue
+	return nil
+}
+
+// EnableShadowMode turns the Consumer into a canary: deliveries are handed to the handler as usual, but whatever
+// it decides is only recorded (see ShadowResults and ShadowStats) and every delivery is requeued after the delay,
+// so the real pipeline still gets every message. Needs handler mode on an ackable consumer, and can't be combined
+// with ack batching or replay protection (both settle messages with the server). Must be called before consuming starts.
+func (con *Consumer) EnableShadowMode(requeueDelay time.Duration) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't enable shadow mode on a started consumer")
+	}
+
+	if con.autoAck {
+		return errors.New("can't enable shadow mode on an auto ack consumer")
+	}
+
+	con.shadow = newShadowMode(requeueDelay, uint32(cap(con.errors)))
+
+	return nil
+}
+
+// ShadowResults yields the outcome of every delivery handled in shadow mode, results are dropped when nobody
+// is draining it.
+func (con *Consumer) ShadowResults() <-chan *ShadowResult {
+	if con.shadow == nil {
+		return nil
+	}
+
+	return con.shadow.results
+}
+
+// ShadowStats returns the shadow mode totals.
+func (con *Consumer) ShadowStats() ShadowStats {
+	if con.shadow == nil {
+		return ShadowStats{}
+	}
+
+	con.shadow.statsLock.Lock()
+	defer con.shadow.statsLock.Unlock()
+
+	return con.shadow.stats
+}
+
+// ValidateShadowMode checks shadow mode isn't combined with anything that settles with the server.
+func (con *Consumer) validateShadowMode(handler MessageHandler) error {
+	switch {
+	case handler == nil:
+		return errors.New("shadow mode needs a handler, use StartConsumingWithHandler")
+	case con.ackBatcher != nil:
+		return errors.New("can't use shadow mode with ack batching")
+	case con.replayGuard != nil:
+		return errors.New("can't use shadow mode with replay protection")
+	case con.dedup != nil:
+		return errors.New("can't use shadow mode with dedup")
+	}
+
+	return nil
+}
+
+// HandleShadowDelivery runs the handler against a Message whose decisions are only recorded, then requeues the delivery.
+func (con *Consumer) handleShadowDelivery(amqpChan *amqp.Channel, delivery *amqp.Delivery) {
+	msg := models.GetPooledMessage(true, con.deliveryBody(delivery.Body), delivery.DeliveryTag, amqpChan)
+	msg.MessageID = delivery.MessageId
+	msg.CorrelationID = delivery.CorrelationId
+	msg.Headers = delivery.Headers
+	msg.Exchange = delivery.Exchange
+	msg.RoutingKey = delivery.RoutingKey
+	msg.Type = delivery.Type
+	msg.ContentType = delivery.ContentType
+	msg.ContentEncoding = delivery.ContentEncoding
+	con.guardMessage(msg)
+
+	recorder := &shadowRecorder{}
+	msg.SetAcknowledger(recorder)
+
+	start := time.Now()
+	err := con.invokeHandler(msg, delivery)
+	latency := time.Since(start)
+
+	msg.CheckBody()
+	models.ReleaseMessage(msg)
+
+	con.shadow.record(&ShadowResult{
+		DeliveryTag: delivery.DeliveryTag,
+		MessageID:   delivery.MessageId,
+		Redelivered: delivery.Redelivered,
+		Decision:    recorder.decision,
+		Requeue:     recorder.requeue,
+		Error:       err,
+		Latency:     latency,
+		Time:        start,
+	})
+
+	requeue := func() {
+		if err := amqpChan.Nack(delivery.DeliveryTag, false, true); err != nil {
+			con.handleError(err)
+		}
+	}
+
+	if con.shadow.requeueDelay > 0 {
+		time.AfterFunc(con.shadow.requeueDelay, requeue)
+		return
+	}
+
+	requeue()
+}
@@ -0,0 +1,186 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+const defaultStandbyInterval = time.Duration(250) * time.Millisecond
+
+const (
+	standbyWaiting int32 = iota
+	standbyPromoted
+	standbyFailingOver // promoted by failover detection, until the broker accepts the exclusive consume
+)
+
+// Standby holds a started Consumer back from basic.consume until it's promoted, watching its queue meanwhile.
+type standby struct {
+	failover bool
+	interval time.Duration
+	state    int32
+	promote  chan struct{}
+}
+
+// EnableStandby starts the Consumer as a warm standby for active/passive deployments, must be called before
+// consuming starts. A standby Consumer is started and checks its queue (passively declared on a pooled channel)
+// every interval, but doesn't issue basic.consume until it's promoted: by Promote, or, with failover, as soon as
+// the queue has no consumers left. Failover needs an exclusive consume, so the broker refuses the consume of
+// every other standby racing for the queue (or a recovered active), a refused standby goes back to waiting.
+// Zero interval is 250ms. WaitUntilConsuming blocks until the standby is promoted and consuming.
+func (con *Consumer) EnableStandby(failover bool, interval time.Duration) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't enable standby on a started consumer")
+	}
+
+	if failover && !con.exclusive {
+		return errors.New("can't fail over without an exclusive consume, every standby could take over at once")
+	}
+
+	if interval <= 0 {
+		interval = defaultStandbyInterval
+	}
+
+	con.standby = &standby{
+		failover: failover,
+		interval: interval,
+		promote:  make(chan struct{}, 1),
+	}
+
+	return nil
+}
+
+// Promote takes the Consumer out of standby, it issues basic.consume right away (see EnableStandby).
+func (con *Consumer) Promote() error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.standby == nil {
+		return errors.New("can't promote a consumer without standby enabled")
+	}
+
+	if !con.started {
+		return errors.New("can't promote a stopped consumer")
+	}
+
+	select {
+	case con.standby.promote <- struct{}{}:
+	default: // already promoting
+	}
+
+	return nil
+}
+
+// InStandby returns true while a started standby Consumer hasn't been promoted.
+func (con *Consumer) InStandby() bool {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	return con.started && con.standby.waiting()
+}
+
+// Reset puts the standby back to waiting for a fresh start.
+func (sb *standby) reset() {
+	atomic.StoreInt32(&sb.state, standbyWaiting)
+
+	select {
+	case <-sb.promote:
+	default:
+	}
+}
+
+func (sb *standby) waiting() bool {
+	return sb != nil && atomic.LoadInt32(&sb.state) == standbyWaiting
+}
+
+// Consuming confirms a failover once the broker has accepted the consume.
+func (sb *standby) consuming() {
+	if sb != nil {
+		atomic.CompareAndSwapInt32(&sb.state, standbyFailingOver, standbyPromoted)
+	}
+}
+
+// Refused returns true, back to waiting, when the failover's exclusive consume lost the queue to another consumer.
+func (sb *standby) refused(err error) bool {
+	if sb == nil || atomic.LoadInt32(&sb.state) != standbyFailingOver {
+		return false
+	}
+
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) || (amqpErr.Code != amqp.AccessRefused && amqpErr.Code != amqp.ResourceLocked) {
+		return false
+	}
+
+	return atomic.CompareAndSwapInt32(&sb.state, standbyFailingOver, standbyWaiting)
+}
+
+// WaitForPromotion holds the consume loop in standby, returns false when the Consumer is stopped (or drained) first.
+func (con *Consumer) waitForPromotion() bool {
+
+	con.activity.set(ActivityStandby)
+
+	ticker := time.NewTicker(con.standby.interval)
+	defer ticker.Stop()
+
+	for {
+		if con.standby.failover && con.queueAbandoned() {
+			atomic.StoreInt32(&con.standby.state, standbyFailingOver)
+			con.emitEvent(models.NewEvent(
+				models.StandbyPromoted,
+				0,
+				0,
+				fmt.Sprintf("queue %s has no consumers, %s is taking over", con.QueueName, con.ConsumerName)))
+
+			return true
+		}
+
+		select {
+		case stop := <-con.consumeStop:
+			if stop {
+				return false
+			}
+		case <-con.standby.promote:
+			atomic.StoreInt32(&con.standby.state, standbyPromoted)
+			con.emitEvent(models.NewEvent(
+				models.StandbyPromoted,
+				0,
+				0,
+				fmt.Sprintf("%s promoted on queue %s", con.ConsumerName, con.QueueName)))
+
+			return true
+		case <-ticker.C:
+		}
+
+		if con.isDraining() {
+			return false
+		}
+	}
+}
+
+// QueueAbandoned passively declares the queue and returns true when nobody consumes it.
+// A failed check is reported on Errors() and treated as the queue still being consumed.
+func (con *Consumer) queueAbandoned() bool {
+
+	chanHost, err := con.channelPool.GetChannel()
+	if err != nil {
+		con.handleError(err)
+		return false
+	}
+
+	queue, err := chanHost.Channel.QueueDeclarePassive(con.QueueName, false, false, false, false, nil)
+	if err != nil {
+		con.handleErrorAndChannel(err, chanHost)
+		return false
+	}
+
+	con.channelPool.ReturnChannel(chanHost, false)
+
+	return queue.Consumers == 0
+}
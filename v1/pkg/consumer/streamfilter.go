@@ -0,0 +1,50 @@
+package consumer
+
+import (
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+// StreamFilter asks a stream (RabbitMQ 3.13+) to only deliver chunks containing the filter values.
+// Chunks can still hold other values, so deliveries are post-filtered on the x-stream-filter-value header.
+type streamFilter struct {
+	values          map[string]struct{}
+	matchUnfiltered bool
+}
+
+func newStreamFilter(values []string, matchUnfiltered bool) *streamFilter {
+	sf := &streamFilter{
+		values:          make(map[string]struct{}, len(values)),
+		matchUnfiltered: matchUnfiltered,
+	}
+
+	for _, value := range values {
+		sf.values[value] = struct{}{}
+	}
+
+	return sf
+}
+
+// Args are the basic.consume arguments requesting the filter from the stream.
+func (sf *streamFilter) args() amqp.Table {
+	values := make([]interface{}, 0, len(sf.values))
+	for value := range sf.values {
+		values = append(values, value)
+	}
+
+	return amqp.Table{
+		models.StreamFilterArg:          values,
+		models.StreamMatchUnfilteredArg: sf.matchUnfiltered,
+	}
+}
+
+// Matches checks the delivery's filter value, deliveries without one only match when matching unfiltered.
+func (sf *streamFilter) matches(delivery *amqp.Delivery) bool {
+	value, ok := delivery.Headers[models.StreamFilterValueHeader].(string)
+	if !ok {
+		return sf.matchUnfiltered
+	}
+
+	_, ok = sf.values[value]
+	return ok
+}
@@ -0,0 +1,76 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// Subscription records the outcome of a start's first basic.consume: success, or a broker refusal that retrying
+// won't fix. Only the first outcome counts.
+type subscription struct {
+	done chan struct{}
+	err  error
+	once *sync.Once
+}
+
+func newSubscription() *subscription {
+	return &subscription{
+		done: make(chan struct{}),
+		once: &sync.Once{},
+	}
+}
+
+func (sub *subscription) complete(err error) {
+	sub.once.Do(func() {
+		sub.err = err
+		close(sub.done)
+	})
+}
+
+// IsPermanentConsumeError reports broker refusals (i.e. queue not found, access refused) that keep failing on retry.
+func isPermanentConsumeError(err error) bool {
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) {
+		return false
+	}
+
+	switch amqpErr.Code {
+	case amqp.NotFound, amqp.AccessRefused, amqp.ResourceLocked, amqp.PreconditionFailed:
+		return true
+	}
+
+	return false
+}
+
+// WaitUntilConsuming blocks until the Consumer's first basic.consume since it was started succeeds, returning
+// nil, or until the broker refuses it (queue not found, access refused, exclusive queue locked), returning the
+// broker error. The Consumer keeps retrying in the background after a refusal, same as without waiting.
+// When the context ends first, the latest consume error (if any) is returned alongside the context error.
+func (con *Consumer) WaitUntilConsuming(ctx context.Context) error {
+	con.conLock.Lock()
+	sub := con.subscription
+	con.conLock.Unlock()
+
+	if sub == nil {
+		return errors.New("can't wait on a consumer that hasn't been started")
+	}
+
+	select {
+	case <-sub.done:
+		return sub.err
+	case <-ctx.Done():
+		con.conLock.Lock()
+		lastErr := con.lastConsumeErr
+		con.conLock.Unlock()
+
+		if lastErr != nil {
+			return fmt.Errorf("%w\r\n[last consume error: %s]", ctx.Err(), lastErr)
+		}
+
+		return ctx.Err()
+	}
+}
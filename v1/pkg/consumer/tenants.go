@@ -0,0 +1,346 @@
+package consumer
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+// TenantExtractor returns the tenant a delivery belongs to, deliveries without one share the "" tenant.
+type TenantExtractor func(delivery *amqp.Delivery) string
+
+// TenantHeaderExtractor reads the tenant from a string header.
+func TenantHeaderExtractor(header string) TenantExtractor {
+	return func(delivery *amqp.Delivery) string {
+		tenant, _ := delivery.Headers[header].(string)
+		return tenant
+	}
+}
+
+// TenantQuota limits a tenant's share of the handler workers.
+type TenantQuota struct {
+	MaxConcurrent int     // handlers running at once for the tenant, 0 is up to the worker count
+	Rate          float64 // handlers started per second for the tenant, 0 is unlimited
+	Burst         int     // handlers that can start at once under a Rate, defaults to 1
+}
+
+// TenantStats is a snapshot of one tenant's work.
+type TenantStats struct {
+	Tenant  string
+	Queued  int
+	Running int
+	Handled uint64
+}
+
+type tenantJob struct {
+	amqpChan  *amqp.Channel
+	delivery  *amqp.Delivery
+	isAckable bool
+}
+
+type tenantState struct {
+	name     string
+	quota    TenantQuota
+	queue    []*tenantJob
+	running  int
+	tokens   float64
+	refilled time.Time
+	handled  uint64
+}
+
+// Allowed refills the tenant's rate tokens and checks its quota, returning how long until a token is available
+// when it's only held back by its rate.
+func (ts *tenantState) allowed(now time.Time) (bool, time.Duration) {
+	if ts.quota.MaxConcurrent > 0 && ts.running >= ts.quota.MaxConcurrent {
+		return false, 0
+	}
+
+	if ts.quota.Rate <= 0 {
+		return true, 0
+	}
+
+	burst := float64(ts.quota.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	ts.tokens += now.Sub(ts.refilled).Seconds() * ts.quota.Rate
+	if ts.tokens > burst {
+		ts.tokens = burst
+	}
+	ts.refilled = now
+
+	if ts.tokens < 1 {
+		return false, time.Duration((1 - ts.tokens) / ts.quota.Rate * float64(time.Second))
+	}
+
+	return true, 0
+}
+
+// TenantScheduler runs handler-mode deliveries on a pool of workers, handing the next free worker to the next
+// tenant (round robin) that has work queued and is within its quota, so one tenant's backlog can't hold every worker.
+type tenantScheduler struct {
+	workers   int
+	extractor TenantExtractor
+	quota     TenantQuota
+	overrides map[string]TenantQuota
+	maxQueued int
+	tenants   map[string]*tenantState
+	ready     []*tenantState // tenants with queued work
+	next      int
+	queued    int
+	stopping  bool
+	timerSet  bool
+	wake      *sync.Cond
+	lock      *sync.Mutex
+	done      *sync.WaitGroup
+}
+
+func newTenantScheduler(workers int, extractor TenantExtractor, quota TenantQuota, maxQueued int) *tenantScheduler {
+	lock := &sync.Mutex{}
+
+	return &tenantScheduler{
+		workers:   workers,
+		extractor: extractor,
+		quota:     quota,
+		overrides: make(map[string]TenantQuota),
+		maxQueued: maxQueued,
+		tenants:   make(map[string]*tenantState),
+		wake:      sync.NewCond(lock),
+		lock:      lock,
+		done:      &sync.WaitGroup{},
+	}
+}
+
+func (ts *tenantScheduler) start(handle func(job *tenantJob)) {
+	ts.lock.Lock()
+	ts.stopping = false
+	ts.lock.Unlock()
+
+	ts.done.Add(ts.workers)
+	for i := 0; i < ts.workers; i++ {
+		models.Go("consumer.tenantworker", func() {
+			defer ts.done.Done()
+			ts.work(handle)
+		})
+	}
+}
+
+// Stop waits for the workers to finish. Queued deliveries are handled first unless discarded
+// (i.e. already requeued by an immediate stop).
+func (ts *tenantScheduler) stop(discard bool) {
+	ts.lock.Lock()
+	ts.stopping = true
+	if discard {
+		for _, state := range ts.ready {
+			state.queue = nil
+		}
+
+		ts.ready = nil
+		ts.queued = 0
+	}
+	ts.wake.Broadcast()
+	ts.lock.Unlock()
+
+	ts.done.Wait()
+}
+
+// Submit queues the delivery for its tenant, blocking while the scheduler is full.
+func (ts *tenantScheduler) submit(amqpChan *amqp.Channel, delivery *amqp.Delivery, isAckable bool) {
+	tenant := ts.extractor(delivery)
+
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	for ts.queued >= ts.maxQueued && !ts.stopping {
+		ts.wake.Wait()
+	}
+
+	state := ts.tenant(tenant)
+	if len(state.queue) == 0 {
+		ts.ready = append(ts.ready, state)
+	}
+
+	state.queue = append(state.queue, &tenantJob{amqpChan: amqpChan, delivery: delivery, isAckable: isAckable})
+	ts.queued++
+	ts.wake.Broadcast()
+}
+
+// Tenant gets (or creates) the tenant's state, must be called while holding the lock.
+func (ts *tenantScheduler) tenant(name string) *tenantState {
+	state, ok := ts.tenants[name]
+	if !ok {
+		quota := ts.quota
+		if override, ok := ts.overrides[name]; ok {
+			quota = override
+		}
+
+		state = &tenantState{name: name, quota: quota, tokens: float64(quota.Burst), refilled: time.Now()}
+		if state.tokens < 1 {
+			state.tokens = 1
+		}
+
+		ts.tenants[name] = state
+	}
+
+	return state
+}
+
+func (ts *tenantScheduler) setQuota(tenant string, quota TenantQuota) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	ts.overrides[tenant] = quota
+	if state, ok := ts.tenants[tenant]; ok {
+		state.quota = quota
+	}
+
+	ts.wake.Broadcast()
+}
+
+func (ts *tenantScheduler) work(handle func(job *tenantJob)) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	for {
+		if job, state := ts.pick(); job != nil {
+			ts.lock.Unlock()
+			handle(job)
+			ts.lock.Lock()
+
+			state.running--
+			state.handled++
+			ts.wake.Broadcast()
+			continue
+		}
+
+		if ts.stopping && ts.queued == 0 {
+			return
+		}
+
+		ts.wake.Wait()
+	}
+}
+
+// Pick takes the next job round robin from the tenants within their quota, must be called while holding the lock.
+// When every tenant with work is held back by its rate, a wake up is scheduled for the first token.
+func (ts *tenantScheduler) pick() (*tenantJob, *tenantState) {
+	now := time.Now()
+	wait := time.Duration(0)
+
+	for i := 0; i < len(ts.ready); i++ {
+		index := (ts.next + i) % len(ts.ready)
+		state := ts.ready[index]
+
+		ok, tokenWait := state.allowed(now)
+		if !ok {
+			if tokenWait > 0 && (wait == 0 || tokenWait < wait) {
+				wait = tokenWait
+			}
+			continue
+		}
+
+		job := state.queue[0]
+		state.queue[0] = nil
+		state.queue = state.queue[1:]
+		state.running++
+		if state.quota.Rate > 0 {
+			state.tokens--
+		}
+		ts.queued--
+
+		if len(state.queue) == 0 {
+			ts.ready = append(ts.ready[:index], ts.ready[index+1:]...)
+			ts.next = index
+		} else {
+			ts.next = index + 1
+		}
+
+		return job, state
+	}
+
+	if wait > 0 && !ts.timerSet {
+		ts.timerSet = true
+		time.AfterFunc(wait, func() {
+			ts.lock.Lock()
+			ts.timerSet = false
+			ts.wake.Broadcast()
+			ts.lock.Unlock()
+		})
+	}
+
+	return nil, nil
+}
+
+func (ts *tenantScheduler) stats() []TenantStats {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	stats := make([]TenantStats, 0, len(ts.tenants))
+	for _, state := range ts.tenants {
+		stats = append(stats, TenantStats{
+			Tenant:  state.name,
+			Queued:  len(state.queue),
+			Running: state.running,
+			Handled: state.handled,
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Tenant < stats[j].Tenant })
+
+	return stats
+}
+
+// EnableTenantIsolation runs the handler on a pool of workers shared fairly between tenants: each delivery's
+// tenant comes from the extractor and the next free worker goes to the next tenant, round robin, that is within
+// its quota. Up to MessageBuffer deliveries are queued for the workers before consuming waits for room.
+// Only applies in handler mode and must be called before consuming starts.
+func (con *Consumer) EnableTenantIsolation(workers int, extractor TenantExtractor, quota TenantQuota) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't enable tenant isolation on a started consumer")
+	}
+
+	if workers < 1 {
+		return errors.New("tenant isolation needs at least 1 worker")
+	}
+
+	if extractor == nil {
+		return errors.New("can't enable tenant isolation without a tenant extractor")
+	}
+
+	maxQueued := cap(con.messages)
+	if maxQueued < 1 {
+		maxQueued = 1
+	}
+
+	con.tenants = newTenantScheduler(workers, extractor, quota, maxQueued)
+
+	return nil
+}
+
+// SetTenantQuota overrides the default quota for one tenant, safe to call while consuming.
+func (con *Consumer) SetTenantQuota(tenant string, quota TenantQuota) error {
+	if con.tenants == nil {
+		return errors.New("can't set a tenant quota without tenant isolation")
+	}
+
+	con.tenants.setQuota(tenant, quota)
+
+	return nil
+}
+
+// TenantStats returns a snapshot of every tenant seen, ordered by tenant.
+func (con *Consumer) TenantStats() []TenantStats {
+	if con.tenants == nil {
+		return nil
+	}
+
+	return con.tenants.stats()
+}
@@ -0,0 +1,185 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+// ErrDeliveryExpired is returned when settling a Message that was already requeued, for nearing the consumer
+// timeout or by an immediate stop (see StopConsuming).
+var ErrDeliveryExpired = errors.New("delivery was already requeued, it can't be settled anymore")
+
+type deliveryKey struct {
+	amqpChan    *amqp.Channel
+	deliveryTag uint64
+}
+
+type watchedDelivery struct {
+	received time.Time
+	warned   bool
+}
+
+// TimeoutWatch keeps ackable deliveries clear of the broker's consumer timeout, which closes the whole channel
+// (PRECONDITION_FAILED) when any delivery on it stays unacked for too long. A delivery still unsettled
+// 2 margins before the timeout raises a ConsumerTimeoutNear event, 1 margin before it's requeued (nack) and
+// raises a DeliveryExpired event, and settling it afterwards returns ErrDeliveryExpired instead of touching the
+// channel, as the broker would close it for an unknown delivery tag.
+type timeoutWatch struct {
+	timeout   time.Duration
+	margin    time.Duration
+	delivered map[deliveryKey]*watchedDelivery
+	expired   map[deliveryKey]bool
+	lock      *sync.Mutex
+}
+
+func newTimeoutWatch(timeout time.Duration, margin time.Duration) *timeoutWatch {
+	if margin <= 0 {
+		margin = timeout / 10
+	}
+
+	return &timeoutWatch{
+		timeout:   timeout,
+		margin:    margin,
+		delivered: make(map[deliveryKey]*watchedDelivery),
+		expired:   make(map[deliveryKey]bool),
+		lock:      &sync.Mutex{},
+	}
+}
+
+func (tw *timeoutWatch) track(amqpChan *amqp.Channel, deliveryTag uint64) {
+	tw.lock.Lock()
+	tw.delivered[deliveryKey{amqpChan, deliveryTag}] = &watchedDelivery{received: time.Now()}
+	tw.lock.Unlock()
+}
+
+// Settle stops watching the delivery, returns false when it was already requeued as expired.
+func (tw *timeoutWatch) settle(amqpChan *amqp.Channel, deliveryTag uint64) bool {
+	key := deliveryKey{amqpChan, deliveryTag}
+
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	if tw.expired[key] {
+		delete(tw.expired, key)
+		return false
+	}
+
+	delete(tw.delivered, key)
+
+	return true
+}
+
+// Forget stops watching every delivery of a channel that closed.
+func (tw *timeoutWatch) forget(amqpChan *amqp.Channel) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	for key := range tw.delivered {
+		if key.amqpChan == amqpChan {
+			delete(tw.delivered, key)
+		}
+	}
+
+	for key := range tw.expired {
+		if key.amqpChan == amqpChan {
+			delete(tw.expired, key)
+		}
+	}
+}
+
+// Due returns the deliveries to warn about and the ones to requeue, the latter are marked expired.
+func (tw *timeoutWatch) due(now time.Time) (warn []deliveryKey, expire []deliveryKey) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	for key, delivery := range tw.delivered {
+		age := now.Sub(delivery.received)
+
+		if age >= tw.timeout-tw.margin {
+			delete(tw.delivered, key)
+			tw.expired[key] = true
+			expire = append(expire, key)
+		} else if age >= tw.timeout-2*tw.margin && !delivery.warned {
+			delivery.warned = true
+			warn = append(warn, key)
+		}
+	}
+
+	return warn, expire
+}
+
+// WatchTimeouts checks the unsettled deliveries until the Consumer stops.
+func (con *Consumer) watchTimeouts(tw *timeoutWatch, stopped <-chan struct{}) {
+	interval := tw.margin / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopped:
+			return
+		case now := <-ticker.C:
+			warn, expire := tw.due(now)
+
+			for _, key := range warn {
+				con.emitEvent(models.NewEvent(
+					models.ConsumerTimeoutNear,
+					0,
+					0,
+					fmt.Sprintf("%s has held delivery %d from %s unsettled for over %s of its %s consumer timeout", con.ConsumerName, key.deliveryTag, con.QueueName, tw.timeout-2*tw.margin, tw.timeout)))
+			}
+
+			for _, key := range expire {
+				if err := con.settleTracker.requeueExpired(key.amqpChan, key.deliveryTag); err != nil {
+					con.handleError(err)
+				}
+
+				con.emitEvent(models.NewEvent(
+					models.DeliveryExpired,
+					0,
+					0,
+					fmt.Sprintf("%s requeued delivery %d from %s, %s before its %s consumer timeout", con.ConsumerName, key.deliveryTag, con.QueueName, tw.margin, tw.timeout)))
+			}
+		}
+	}
+}
+
+// SetConsumerTimeout watches ackable deliveries against the broker's consumer timeout (its consumer_timeout
+// setting, 30 minutes by default, or the queue's x-consumer-timeout): a delivery still unsettled 2 margins
+// before the timeout raises a ConsumerTimeoutNear event and 1 margin before it's requeued so the broker doesn't
+// close the channel, settling it afterwards returns ErrDeliveryExpired. A margin of 0 is 10% of the timeout,
+// a timeout of 0 stops watching. Must be called before consuming starts.
+func (con *Consumer) SetConsumerTimeout(timeout time.Duration, margin time.Duration) error {
+	con.conLock.Lock()
+	defer con.conLock.Unlock()
+
+	if con.started {
+		return errors.New("can't set the consumer timeout on a started consumer")
+	}
+
+	if timeout <= 0 {
+		con.settleTracker.timeouts = nil
+		return nil
+	}
+
+	if con.autoAck {
+		return errors.New("can't watch the consumer timeout on an auto ack consumer")
+	}
+
+	if margin*2 >= timeout {
+		return errors.New("consumer timeout margin must be less than half the timeout")
+	}
+
+	con.settleTracker.timeouts = newTimeoutWatch(timeout, margin)
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// Memory keeps every metric in memory, i.e. for tests or to expose through an endpoint of your own.
+type Memory struct {
+	counters   map[string]float64
+	gauges     map[string]float64
+	histograms map[string][]float64
+	memoryLock *sync.Mutex
+}
+
+// NewMemory creates an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{
+		counters:   make(map[string]float64),
+		gauges:     make(map[string]float64),
+		histograms: make(map[string][]float64),
+		memoryLock: &sync.Mutex{},
+	}
+}
+
+// Counter adds the delta to the counter.
+func (m *Memory) Counter(name string, delta float64, labels models.Labels) {
+	m.memoryLock.Lock()
+	m.counters[Key(name, labels)] += delta
+	m.memoryLock.Unlock()
+}
+
+// Gauge sets the gauge.
+func (m *Memory) Gauge(name string, value float64, labels models.Labels) {
+	m.memoryLock.Lock()
+	m.gauges[Key(name, labels)] = value
+	m.memoryLock.Unlock()
+}
+
+// Histogram keeps the observation.
+func (m *Memory) Histogram(name string, value float64, labels models.Labels) {
+	m.memoryLock.Lock()
+	key := Key(name, labels)
+	m.histograms[key] = append(m.histograms[key], value)
+	m.memoryLock.Unlock()
+}
+
+// CounterValue returns the counter's total.
+func (m *Memory) CounterValue(name string, labels models.Labels) float64 {
+	m.memoryLock.Lock()
+	defer m.memoryLock.Unlock()
+
+	return m.counters[Key(name, labels)]
+}
+
+// GaugeValue returns the gauge's last value.
+func (m *Memory) GaugeValue(name string, labels models.Labels) float64 {
+	m.memoryLock.Lock()
+	defer m.memoryLock.Unlock()
+
+	return m.gauges[Key(name, labels)]
+}
+
+// Observations returns a copy of the histogram's observations.
+func (m *Memory) Observations(name string, labels models.Labels) []float64 {
+	m.memoryLock.Lock()
+	defer m.memoryLock.Unlock()
+
+	return append([]float64(nil), m.histograms[Key(name, labels)]...)
+}
+
+// Key identifies a metric and its labels as name{label="value",...}, with the labels sorted.
+func Key(name string, labels models.Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	builder.WriteString(name)
+	builder.WriteByte('{')
+	for i, label := range names {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+
+		builder.WriteString(label)
+		builder.WriteString(`="`)
+		builder.WriteString(labels[label])
+		builder.WriteByte('"')
+	}
+	builder.WriteByte('}')
+
+	return builder.String()
+}
@@ -0,0 +1,53 @@
+package metrics_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/metrics"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+func TestMemoryMetrics(t *testing.T) {
+	memory := metrics.NewMemory()
+	models.SetMetrics(memory)
+	defer models.SetMetrics(nil)
+
+	models.CountEvent(models.NewEvent(models.ConnectionLost, 1, 0, "test"))
+	models.CountEvent(models.NewEvent(models.ConnectionLost, 2, 0, "test"))
+	models.ObserveSince(models.MetricChannelWait, time.Now(), nil)
+
+	assert.Equal(t, float64(2), memory.CounterValue(models.MetricEvents, models.Labels{"type": "ConnectionLost"}))
+	assert.Equal(t, 1, len(memory.Observations(models.MetricChannelWait, nil)))
+	assert.Equal(t, `tcr_settles_total{outcome="ack",queue="q"}`, metrics.Key(models.MetricSettles, models.Labels{"queue": "q", "outcome": "ack"}))
+}
+
+func TestStatsD(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	statsd, err := metrics.NewStatsD(listener.LocalAddr().String(), "svc")
+	assert.NoError(t, err)
+	defer statsd.Close()
+
+	packet := make([]byte, 512)
+	read := func() string {
+		assert.NoError(t, listener.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := listener.ReadFrom(packet)
+		assert.NoError(t, err)
+		return string(packet[:n])
+	}
+
+	statsd.Counter(models.MetricSettles, 1, models.Labels{"queue": "q", "outcome": "ack"})
+	assert.Equal(t, "svc.tcr_settles_total:1|c|#outcome:ack,queue:q", read())
+
+	statsd.Gauge(models.MetricQueuedLetters, 42, nil)
+	assert.Equal(t, "svc.tcr_queued_letters:42|g", read())
+
+	statsd.Histogram(models.MetricChannelWait, 0.25, nil)
+	assert.Equal(t, "svc.tcr_channel_wait_seconds:0.25|h", read())
+}
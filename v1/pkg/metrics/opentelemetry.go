@@ -0,0 +1,95 @@
+//go:build otel
+// +build otel
+
+package metrics
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// OpenTelemetry records metrics as OpenTelemetry instruments, created on first use. Build with -tags otel
+// (and require go.opentelemetry.io/otel/metric), so the library itself doesn't depend on it.
+type OpenTelemetry struct {
+	meter      metric.Meter
+	counters   map[string]metric.Float64Counter
+	gauges     map[string]metric.Float64Gauge
+	histograms map[string]metric.Float64Histogram
+	otelLock   *sync.Mutex
+}
+
+// NewOpenTelemetry creates an OpenTelemetry recording through the meter.
+func NewOpenTelemetry(meter metric.Meter) *OpenTelemetry {
+	return &OpenTelemetry{
+		meter:      meter,
+		counters:   make(map[string]metric.Float64Counter),
+		gauges:     make(map[string]metric.Float64Gauge),
+		histograms: make(map[string]metric.Float64Histogram),
+		otelLock:   &sync.Mutex{},
+	}
+}
+
+// Counter adds the delta to the counter.
+func (ot *OpenTelemetry) Counter(name string, delta float64, labels models.Labels) {
+	ot.otelLock.Lock()
+	counter, ok := ot.counters[name]
+	if !ok {
+		var err error
+		if counter, err = ot.meter.Float64Counter(name); err != nil {
+			ot.otelLock.Unlock()
+			return
+		}
+		ot.counters[name] = counter
+	}
+	ot.otelLock.Unlock()
+
+	counter.Add(context.Background(), delta, metric.WithAttributes(attributes(labels)...))
+}
+
+// Gauge records the gauge's value.
+func (ot *OpenTelemetry) Gauge(name string, value float64, labels models.Labels) {
+	ot.otelLock.Lock()
+	gauge, ok := ot.gauges[name]
+	if !ok {
+		var err error
+		if gauge, err = ot.meter.Float64Gauge(name); err != nil {
+			ot.otelLock.Unlock()
+			return
+		}
+		ot.gauges[name] = gauge
+	}
+	ot.otelLock.Unlock()
+
+	gauge.Record(context.Background(), value, metric.WithAttributes(attributes(labels)...))
+}
+
+// Histogram records the value.
+func (ot *OpenTelemetry) Histogram(name string, value float64, labels models.Labels) {
+	ot.otelLock.Lock()
+	histogram, ok := ot.histograms[name]
+	if !ok {
+		var err error
+		if histogram, err = ot.meter.Float64Histogram(name); err != nil {
+			ot.otelLock.Unlock()
+			return
+		}
+		ot.histograms[name] = histogram
+	}
+	ot.otelLock.Unlock()
+
+	histogram.Record(context.Background(), value, metric.WithAttributes(attributes(labels)...))
+}
+
+func attributes(labels models.Labels) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(labels))
+	for label, value := range labels {
+		kvs = append(kvs, attribute.String(label, value))
+	}
+
+	return kvs
+}
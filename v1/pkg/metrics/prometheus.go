@@ -0,0 +1,124 @@
+//go:build prometheus
+// +build prometheus
+
+package metrics
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// Prometheus records metrics as Prometheus collectors, registered on first use. Build with -tags prometheus
+// (and require github.com/prometheus/client_golang), so the library itself doesn't depend on it.
+type Prometheus struct {
+	registerer     prometheus.Registerer
+	namespace      string
+	buckets        []float64
+	counters       map[string]*prometheus.CounterVec
+	gauges         map[string]*prometheus.GaugeVec
+	histograms     map[string]*prometheus.HistogramVec
+	prometheusLock *sync.Mutex
+}
+
+// NewPrometheus creates a Prometheus registering on the registerer (nil is the default registerer), with
+// the namespace prefixed to every metric name. Nil buckets are prometheus.DefBuckets.
+func NewPrometheus(registerer prometheus.Registerer, namespace string, buckets []float64) *Prometheus {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+
+	return &Prometheus{
+		registerer:     registerer,
+		namespace:      namespace,
+		buckets:        buckets,
+		counters:       make(map[string]*prometheus.CounterVec),
+		gauges:         make(map[string]*prometheus.GaugeVec),
+		histograms:     make(map[string]*prometheus.HistogramVec),
+		prometheusLock: &sync.Mutex{},
+	}
+}
+
+// Counter adds the delta to the counter.
+func (p *Prometheus) Counter(name string, delta float64, labels models.Labels) {
+	p.prometheusLock.Lock()
+	vec, ok := p.counters[name]
+	if !ok {
+		vec = prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: p.namespace, Name: name, Help: name}, labelNames(labels))
+		if registered, ok := p.register(vec).(*prometheus.CounterVec); ok {
+			vec = registered
+		}
+		p.counters[name] = vec
+	}
+	p.prometheusLock.Unlock()
+
+	if counter, err := vec.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		counter.Add(delta)
+	}
+}
+
+// Gauge sets the gauge.
+func (p *Prometheus) Gauge(name string, value float64, labels models.Labels) {
+	p.prometheusLock.Lock()
+	vec, ok := p.gauges[name]
+	if !ok {
+		vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: p.namespace, Name: name, Help: name}, labelNames(labels))
+		if registered, ok := p.register(vec).(*prometheus.GaugeVec); ok {
+			vec = registered
+		}
+		p.gauges[name] = vec
+	}
+	p.prometheusLock.Unlock()
+
+	if gauge, err := vec.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		gauge.Set(value)
+	}
+}
+
+// Histogram observes the value.
+func (p *Prometheus) Histogram(name string, value float64, labels models.Labels) {
+	p.prometheusLock.Lock()
+	vec, ok := p.histograms[name]
+	if !ok {
+		opts := prometheus.HistogramOpts{Namespace: p.namespace, Name: name, Help: name, Buckets: p.buckets}
+		vec = prometheus.NewHistogramVec(opts, labelNames(labels))
+		if registered, ok := p.register(vec).(*prometheus.HistogramVec); ok {
+			vec = registered
+		}
+		p.histograms[name] = vec
+	}
+	p.prometheusLock.Unlock()
+
+	if histogram, err := vec.GetMetricWith(prometheus.Labels(labels)); err == nil {
+		histogram.Observe(value)
+	}
+}
+
+// Register registers the collector, returning the one already registered under its name if there is one.
+// A collector the registerer refuses (i.e. its labels changed) still records, it just isn't exported.
+func (p *Prometheus) register(collector prometheus.Collector) prometheus.Collector {
+	if err := p.registerer.Register(collector); err != nil {
+		if registered, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return registered.ExistingCollector
+		}
+	}
+
+	return collector
+}
+
+func labelNames(labels models.Labels) []string {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+
+	return names
+}
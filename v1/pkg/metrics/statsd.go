@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// StatsD sends metrics over UDP in the statsd line format, with labels as DogStatsD tags (understood by Datadog,
+// Telegraf, and the statsd_exporter). Histograms are sent as "h". Sends are fire and forget, a lost packet or
+// an unreachable agent never slows the caller.
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsD creates a StatsD sending to the agent's address (i.e. "127.0.0.1:8125"), every metric name is
+// prefixed with the prefix and a dot when one is given.
+func NewStatsD(address string, prefix string) (*StatsD, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix != "" {
+		prefix += "."
+	}
+
+	return &StatsD{conn: conn, prefix: prefix}, nil
+}
+
+// Counter sends the delta as a count.
+func (sd *StatsD) Counter(name string, delta float64, labels models.Labels) {
+	sd.send(name, delta, "c", labels)
+}
+
+// Gauge sends the value as a gauge.
+func (sd *StatsD) Gauge(name string, value float64, labels models.Labels) {
+	sd.send(name, value, "g", labels)
+}
+
+// Histogram sends the value as a histogram.
+func (sd *StatsD) Histogram(name string, value float64, labels models.Labels) {
+	sd.send(name, value, "h", labels)
+}
+
+func (sd *StatsD) send(name string, value float64, kind string, labels models.Labels) {
+	_, _ = sd.conn.Write([]byte(sd.line(name, value, kind, labels)))
+}
+
+// Line formats a metric as prefix.name:value|kind|#label:value,...
+func (sd *StatsD) line(name string, value float64, kind string, labels models.Labels) string {
+	var builder strings.Builder
+	builder.WriteString(sd.prefix)
+	builder.WriteString(name)
+	builder.WriteByte(':')
+	builder.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	builder.WriteByte('|')
+	builder.WriteString(kind)
+
+	if len(labels) > 0 {
+		names := make([]string, 0, len(labels))
+		for label := range labels {
+			names = append(names, label)
+		}
+		sort.Strings(names)
+
+		builder.WriteString("|#")
+		for i, label := range names {
+			if i > 0 {
+				builder.WriteByte(',')
+			}
+
+			builder.WriteString(label)
+			builder.WriteByte(':')
+			builder.WriteString(labels[label])
+		}
+	}
+
+	return builder.String()
+}
+
+// Close closes the UDP socket.
+func (sd *StatsD) Close() error {
+	return sd.conn.Close()
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// AckOutcome is what a handler mode Consumer does with an ackable Message its handler left unsettled.
+type AckOutcome int
+
+const (
+	// AckOutcomeAck acks the Message.
+	AckOutcomeAck AckOutcome = iota
+	// AckOutcomeRequeue nacks the Message back onto the queue.
+	AckOutcomeRequeue
+	// AckOutcomeDeadLetter nacks the Message without requeueing it, dead lettered when the queue has a DLX.
+	AckOutcomeDeadLetter
+	// AckOutcomeRetry holds the Message for a delay and then nacks it back onto the queue.
+	AckOutcomeRetry
+)
+
+var ackOutcomeNames = map[AckOutcome]string{
+	AckOutcomeAck:        "ack",
+	AckOutcomeRequeue:    "requeue",
+	AckOutcomeDeadLetter: "deadletter",
+	AckOutcomeRetry:      "retry",
+}
+
+func (ao AckOutcome) String() string {
+	if name, ok := ackOutcomeNames[ao]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("AckOutcome(%d)", int(ao))
+}
+
+// ParseAckOutcome parses "ack", "requeue", "deadletter", or "retry".
+func ParseAckOutcome(name string) (AckOutcome, error) {
+	for outcome, outcomeName := range ackOutcomeNames {
+		if outcomeName == name {
+			return outcome, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown ack outcome %q, expected \"ack\", \"requeue\", \"deadletter\", or \"retry\"", name)
+}
+
+// AckPolicyConfig maps handler errors to ack outcomes for a handler mode Consumer, see consumer.AckPolicy.
+type AckPolicyConfig struct {
+	Rules   []*AckRuleConfig `json:"Rules"`             // evaluated in order, the first match wins
+	Default *AckRuleConfig   `json:"Default,omitempty"` // Outcome (and Delay) when no rule matches, defaults to requeue
+}
+
+// AckRuleConfig matches handler errors by their text or by the category a classifier puts them in.
+type AckRuleConfig struct {
+	Pattern  string `json:"Pattern,omitempty"`  // regular expression matched against the error text
+	Category string `json:"Category,omitempty"` // category returned by the policy's classifier
+	Outcome  string `json:"Outcome"`            // ack, requeue, deadletter, or retry
+	Delay    uint32 `json:"Delay"`              // ms a retry is held before it's requeued
+}
+
+func (apc *AckPolicyConfig) validate(field string, errs *ConfigErrors) {
+	for i, rule := range apc.Rules {
+		field := fmt.Sprintf("%s.Rules[%d]", field, i)
+		if rule == nil {
+			errs.add(field, "is nil")
+			continue
+		}
+
+		if (rule.Pattern == "") == (rule.Category == "") {
+			errs.add(field, "needs either a Pattern or a Category")
+		}
+
+		if rule.Pattern != "" {
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				errs.add(field+".Pattern", "can't be compiled: %s", err)
+			}
+		}
+
+		rule.validateOutcome(field, errs)
+	}
+
+	if apc.Default != nil {
+		apc.Default.validateOutcome(field+".Default", errs)
+	}
+}
+
+func (arc *AckRuleConfig) validateOutcome(field string, errs *ConfigErrors) {
+	if _, err := ParseAckOutcome(arc.Outcome); err != nil {
+		errs.add(field+".Outcome", "%s", err)
+	}
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"context"
+)
+
+// AnnotationKey names a Message annotation. Declare keys once (i.e. as package level constants) so the stage
+// setting an annotation and the stages reading it agree on the name.
+type AnnotationKey string
+
+// Annotate stores a value derived from the Message (i.e. the decoded payload, the tenant, auth claims) for later
+// stages and the handler, replacing any value under the same key. Safe for concurrent use.
+func (msg *Message) Annotate(key AnnotationKey, value interface{}) {
+	msg.annotationLock.Lock()
+	defer msg.annotationLock.Unlock()
+
+	if msg.annotations == nil {
+		msg.annotations = make(map[AnnotationKey]interface{})
+	}
+
+	msg.annotations[key] = value
+}
+
+// Annotation returns the value stored under the key, false when there is none.
+func (msg *Message) Annotation(key AnnotationKey) (interface{}, bool) {
+	msg.annotationLock.RLock()
+	defer msg.annotationLock.RUnlock()
+
+	value, ok := msg.annotations[key]
+	return value, ok
+}
+
+// AnnotationString returns the string stored under the key, false when there is none or it isn't a string.
+func (msg *Message) AnnotationString(key AnnotationKey) (string, bool) {
+	value, ok := msg.Annotation(key)
+	if !ok {
+		return "", false
+	}
+
+	str, ok := value.(string)
+	return str, ok
+}
+
+// RemoveAnnotation removes the value stored under the key.
+func (msg *Message) RemoveAnnotation(key AnnotationKey) {
+	msg.annotationLock.Lock()
+	defer msg.annotationLock.Unlock()
+
+	delete(msg.annotations, key)
+}
+
+// Annotations returns a copy of every annotation.
+func (msg *Message) Annotations() map[AnnotationKey]interface{} {
+	msg.annotationLock.RLock()
+	defer msg.annotationLock.RUnlock()
+
+	annotations := make(map[AnnotationKey]interface{}, len(msg.annotations))
+	for key, value := range msg.annotations {
+		annotations[key] = value
+	}
+
+	return annotations
+}
+
+// Context returns the context attached to the Message, context.Background() when none was.
+func (msg *Message) Context() context.Context {
+	msg.annotationLock.RLock()
+	defer msg.annotationLock.RUnlock()
+
+	if msg.ctx == nil {
+		return context.Background()
+	}
+
+	return msg.ctx
+}
+
+// SetContext attaches a context (i.e. carrying a trace span or deadline) for later stages and the handler.
+func (msg *Message) SetContext(ctx context.Context) {
+	msg.annotationLock.Lock()
+	defer msg.annotationLock.Unlock()
+
+	msg.ctx = ctx
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// AuditAction is what an AuditRecord records.
+type AuditAction string
+
+// Actions the library records to the Auditor (see SetAuditor).
+const (
+	AuditPublished        AuditAction = "Published"        // a letter was sent without a publisher confirm
+	AuditPublishConfirmed AuditAction = "PublishConfirmed" // the server confirmed a letter (see ExchangeDefaults.RequireConfirm)
+	AuditPublishFailed    AuditAction = "PublishFailed"    // a letter failed every attempt
+	AuditConsumed         AuditAction = "Consumed"         // a delivery was received
+	AuditAcked            AuditAction = "Acked"
+	AuditNacked           AuditAction = "Nacked"
+	AuditRejected         AuditAction = "Rejected"
+	AuditTopologyChanged  AuditAction = "TopologyChanged" // an exchange, queue, binding, policy, or parameter was declared, changed, or deleted
+	AuditConnectionCycled AuditAction = "ConnectionCycled"
+)
+
+// AuditRecord is one entry of the audit trail, fields that don't apply to the Action are left empty.
+type AuditRecord struct {
+	Time          time.Time   `json:"Time"`
+	Action        AuditAction `json:"Action"`
+	Exchange      string      `json:"Exchange,omitempty"`
+	RoutingKey    string      `json:"RoutingKey,omitempty"`
+	Queue         string      `json:"Queue,omitempty"`
+	MessageID     string      `json:"MessageID,omitempty"`
+	CorrelationID string      `json:"CorrelationID,omitempty"`
+	LetterID      uint64      `json:"LetterID,omitempty"`
+	DeliveryTag   uint64      `json:"DeliveryTag,omitempty"`
+	Requeued      bool        `json:"Requeued,omitempty"`
+	ConnectionID  uint64      `json:"ConnectionID,omitempty"`
+	Detail        string      `json:"Detail,omitempty"`
+}
+
+// Auditor receives the library's audit records, see the audit package for a batching log over an append-only
+// sink. Calls come from publish and consume paths.
+type Auditor interface {
+	Record(record *AuditRecord)
+}
+
+type auditorHolder struct {
+	auditor Auditor
+}
+
+var auditor atomic.Value
+
+// SetAuditor sets where every Publisher, Consumer, Topologer, and ConnectionPool records its audit trail, nil
+// turns auditing off.
+func SetAuditor(a Auditor) {
+	auditor.Store(&auditorHolder{auditor: a})
+}
+
+// GetAuditor returns the Auditor set with SetAuditor, or nil.
+func GetAuditor() Auditor {
+	if holder, ok := auditor.Load().(*auditorHolder); ok {
+		return holder.auditor
+	}
+
+	return nil
+}
+
+// Auditing returns true when an Auditor is set, check it before building a record on a hot path.
+func Auditing() bool {
+	return GetAuditor() != nil
+}
+
+// Audit stamps the record with the current time and hands it to the Auditor, if any.
+func Audit(record *AuditRecord) {
+	a := GetAuditor()
+	if a == nil {
+		return
+	}
+
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+
+	a.Record(record)
+}
+
+// AuditEvent records the connection events (lost, restored, recycled) that cycle a pooled connection.
+func AuditEvent(event *Event) {
+	switch event.Type {
+	case ConnectionLost, ConnectionRestored, ConnectionRecycled, ConnectionStale:
+	default:
+		return
+	}
+
+	Audit(&AuditRecord{
+		Time:         event.Time.UTC(),
+		Action:       AuditConnectionCycled,
+		ConnectionID: event.ConnectionID,
+		Detail:       event.Type.String() + ": " + event.Reason,
+	})
+}
@@ -0,0 +1,90 @@
+package models
+
+import "github.com/streadway/amqp"
+
+// BatchAcknowledger is an Acknowledger that settles many of a channel's deliveries at once, i.e. with the
+// multiple flag. AckMessages and NackMessages use it when a Message's Acknowledger implements it.
+type BatchAcknowledger interface {
+	Acknowledger
+	AckAll(amqpChan *amqp.Channel, deliveryTags []uint64) error
+	NackAll(amqpChan *amqp.Channel, deliveryTags []uint64, requeue bool) error
+}
+
+type batchKey struct {
+	acker    BatchAcknowledger
+	amqpChan *amqp.Channel
+}
+
+// AckMessages acks the ackable Messages not settled yet, each channel's at once when their Acknowledger is a
+// BatchAcknowledger. Returns the first error, the rest are still settled.
+func AckMessages(msgs []*Message) error {
+	return settleMessages(msgs, AuditAcked, false)
+}
+
+// NackMessages nacks the ackable Messages not settled yet, each channel's at once when their Acknowledger is a
+// BatchAcknowledger. Returns the first error, the rest are still settled.
+func NackMessages(msgs []*Message, requeue bool) error {
+	return settleMessages(msgs, AuditNacked, requeue)
+}
+
+func settleMessages(msgs []*Message, action AuditAction, requeue bool) error {
+
+	var firstErr error
+	keep := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	batches := make(map[batchKey][]*Message)
+	keys := make([]batchKey, 0, 1)
+	for _, msg := range msgs {
+		if !msg.IsAckable || msg.settled {
+			continue
+		}
+
+		acker, ok := msg.acker.(BatchAcknowledger)
+		if !ok || msg.amqpChan == nil {
+			if action == AuditAcked {
+				keep(msg.Acknowledge())
+			} else {
+				keep(msg.Nack(requeue))
+			}
+			continue
+		}
+
+		key := batchKey{acker: acker, amqpChan: msg.amqpChan}
+		if _, ok := batches[key]; !ok {
+			keys = append(keys, key)
+		}
+		batches[key] = append(batches[key], msg)
+	}
+
+	for _, key := range keys {
+		batch := batches[key]
+		tags := make([]uint64, len(batch))
+		for i, msg := range batch {
+			tags[i] = msg.deliveryTag
+			msg.settled = true
+			msg.CheckBody()
+		}
+
+		var err error
+		if action == AuditAcked {
+			err = key.acker.AckAll(key.amqpChan, tags)
+		} else {
+			err = key.acker.NackAll(key.amqpChan, tags, requeue)
+		}
+
+		if err != nil {
+			keep(err)
+			continue
+		}
+
+		for _, msg := range batch {
+			msg.audit(action, requeue)
+		}
+	}
+
+	return firstErr
+}
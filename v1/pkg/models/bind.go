@@ -0,0 +1,289 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BindTag is the struct tag Message.Bind reads: `amqp:"header=x-tenant-id"`, `amqp:"header=x-tenant-id,required"`,
+// or `amqp:"property=correlation_id"`.
+const BindTag = "amqp"
+
+// The properties a field can be bound to.
+const (
+	BindPropertyMessageID     = "message_id"
+	BindPropertyCorrelationID = "correlation_id"
+	BindPropertyType          = "type"
+	BindPropertyPriority      = "priority"
+	BindPropertyExchange      = "exchange"
+	BindPropertyRoutingKey    = "routing_key"
+	BindPropertyQueue         = "queue"
+)
+
+var (
+	// ErrBindFailed is matched (errors.Is) by every BindError, i.e. to reject unbindable messages in an AckPolicy.
+	ErrBindFailed = errors.New("can't bind message")
+	// ErrRequiredHeader is a BindError's cause when a required header is missing.
+	ErrRequiredHeader = errors.New("required header is missing")
+)
+
+// BindError is why Message.Bind couldn't populate a field.
+type BindError struct {
+	Field  string // the struct field
+	Source string // the tag, i.e. header=x-tenant-id, or body
+	Err    error
+}
+
+func (be *BindError) Error() string {
+	return fmt.Sprintf("can't bind %s from %s: %s", be.Field, be.Source, be.Err)
+}
+
+// Unwrap returns the cause.
+func (be *BindError) Unwrap() error {
+	return be.Err
+}
+
+// Is matches ErrBindFailed.
+func (be *BindError) Is(target error) bool {
+	return target == ErrBindFailed
+}
+
+type fieldBinding struct {
+	index    []int
+	name     string
+	source   string
+	header   string
+	property string
+	required bool
+}
+
+var bindings = &sync.Map{} // reflect.Type to []*fieldBinding
+
+// Bind populates the struct target points to: the JSON body (when there is one) first, then the fields tagged
+// with a header or property (see BindTag), overriding the body. Header values are converted to the field's type,
+// numbers between kinds and strings parsed into numbers and bools, a missing header leaves its field as is
+// (a pointer field nil) unless it's required. Fails with a *BindError.
+func (msg *Message) Bind(target interface{}) error {
+
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return &BindError{Field: fmt.Sprintf("%T", target), Source: "target", Err: errors.New("not a pointer to a struct")}
+	}
+
+	fields, err := structBindings(value.Elem().Type())
+	if err != nil {
+		return err
+	}
+
+	if len(msg.Body) > 0 {
+		if err = json.Unmarshal(msg.Body, target); err != nil {
+			return &BindError{Field: value.Elem().Type().Name(), Source: "body", Err: err}
+		}
+	}
+
+	for _, field := range fields {
+		source, found := msg.bindSource(field)
+		if !found {
+			if field.required {
+				return &BindError{Field: field.name, Source: field.source, Err: ErrRequiredHeader}
+			}
+			continue
+		}
+
+		if err = setField(value.Elem().FieldByIndex(field.index), source); err != nil {
+			return &BindError{Field: field.name, Source: field.source, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// CheckBindings returns an error when the tags of the struct target points to can't be bound.
+func CheckBindings(target interface{}) error {
+
+	kind := reflect.TypeOf(target)
+	if kind == nil || kind.Kind() != reflect.Ptr || kind.Elem().Kind() != reflect.Struct {
+		return &BindError{Field: fmt.Sprintf("%T", target), Source: "target", Err: errors.New("not a pointer to a struct")}
+	}
+
+	_, err := structBindings(kind.Elem())
+	return err
+}
+
+// BindSource returns the header or property the field is bound to, false when the message doesn't have it.
+func (msg *Message) bindSource(field *fieldBinding) (interface{}, bool) {
+
+	if field.header != "" {
+		value, ok := msg.Headers[field.header]
+		return value, ok && value != nil
+	}
+
+	var value interface{}
+	switch field.property {
+	case BindPropertyMessageID:
+		value = msg.MessageID
+	case BindPropertyCorrelationID:
+		value = msg.CorrelationID
+	case BindPropertyType:
+		value = msg.Type
+	case BindPropertyPriority:
+		return msg.Priority, msg.Priority != 0
+	case BindPropertyExchange:
+		value = msg.Exchange
+	case BindPropertyRoutingKey:
+		value = msg.RoutingKey
+	case BindPropertyQueue:
+		value = msg.Queue
+	}
+
+	return value, value != ""
+}
+
+// StructBindings parses (once per type) the tagged fields of the struct type.
+func structBindings(structType reflect.Type) ([]*fieldBinding, error) {
+
+	if cached, ok := bindings.Load(structType); ok {
+		return cached.([]*fieldBinding), nil
+	}
+
+	fields := make([]*fieldBinding, 0)
+	for i := 0; i < structType.NumField(); i++ {
+		structField := structType.Field(i)
+		tag, ok := structField.Tag.Lookup(BindTag)
+		if !ok || tag == "" || tag == "-" {
+			continue
+		}
+
+		field, err := parseBindTag(structField, tag)
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, field)
+	}
+
+	bindings.Store(structType, fields)
+	return fields, nil
+}
+
+// ParseBindTag parses a field's tag, i.e. header=x-tenant-id,required.
+func parseBindTag(structField reflect.StructField, tag string) (*fieldBinding, error) {
+
+	field := &fieldBinding{index: structField.Index, name: structField.Name}
+	parts := strings.Split(tag, ",")
+	field.source = parts[0]
+
+	if structField.PkgPath != "" {
+		return nil, &BindError{Field: field.name, Source: field.source, Err: errors.New("field is unexported")}
+	}
+
+	for _, option := range parts[1:] {
+		if option != "required" {
+			return nil, &BindError{Field: field.name, Source: field.source, Err: fmt.Errorf("unknown option %q", option)}
+		}
+		field.required = true
+	}
+
+	keyValue := strings.SplitN(parts[0], "=", 2)
+	if len(keyValue) != 2 || keyValue[1] == "" {
+		return nil, &BindError{Field: field.name, Source: field.source, Err: errors.New("tag isn't header=name or property=name")}
+	}
+
+	switch keyValue[0] {
+	case "header":
+		field.header = keyValue[1]
+	case "property":
+		switch keyValue[1] {
+		case BindPropertyMessageID, BindPropertyCorrelationID, BindPropertyType, BindPropertyPriority,
+			BindPropertyExchange, BindPropertyRoutingKey, BindPropertyQueue:
+			field.property = keyValue[1]
+		default:
+			return nil, &BindError{Field: field.name, Source: field.source, Err: fmt.Errorf("unknown property %q", keyValue[1])}
+		}
+	default:
+		return nil, &BindError{Field: field.name, Source: field.source, Err: errors.New("tag isn't header=name or property=name")}
+	}
+
+	return field, nil
+}
+
+// SetField sets the field to the source value, converted to the field's type.
+func setField(field reflect.Value, source interface{}) error {
+
+	if field.Kind() == reflect.Ptr {
+		value := reflect.New(field.Type().Elem())
+		if err := setField(value.Elem(), source); err != nil {
+			return err
+		}
+
+		field.Set(value)
+		return nil
+	}
+
+	value := reflect.ValueOf(source)
+	if value.Type().AssignableTo(field.Type()) {
+		field.Set(value)
+		return nil
+	}
+
+	if bytes, ok := source.([]byte); ok {
+		source, value = string(bytes), reflect.ValueOf(string(bytes))
+	}
+
+	text, isText := source.(string)
+	switch field.Kind() {
+	case reflect.String:
+		if isText {
+			field.SetString(text)
+			return nil
+		}
+	case reflect.Bool:
+		if isText {
+			parsed, err := strconv.ParseBool(text)
+			if err != nil {
+				return err
+			}
+
+			field.SetBool(parsed)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+
+		if isText {
+			parsed, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return err
+			}
+
+			value = reflect.ValueOf(parsed)
+		}
+
+		if isNumber(value.Kind()) {
+			converted := value.Convert(field.Type())
+			negative := value.Kind() <= reflect.Int64 && value.Int() < 0 || value.Kind() >= reflect.Float32 && value.Float() < 0
+			if converted.Convert(value.Type()).Interface() != value.Interface() || negative && !isSigned(field.Kind()) {
+				return fmt.Errorf("%v doesn't fit a %s", source, field.Type())
+			}
+
+			field.Set(converted)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("can't convert %T to %s", source, field.Type())
+}
+
+func isNumber(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
+
+func isSigned(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Int64 || kind == reflect.Float32 || kind == reflect.Float64
+}
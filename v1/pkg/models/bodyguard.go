@@ -0,0 +1,39 @@
+package models
+
+import (
+	"hash/crc32"
+)
+
+// CopyBody returns a copy of the body, so a handler changing its Message's Body can't change the delivery the
+// library still holds (recordings, crash reports, and the like).
+func CopyBody(body []byte) []byte {
+	if body == nil {
+		return nil
+	}
+
+	copied := make([]byte, len(body))
+	copy(copied, body)
+
+	return copied
+}
+
+// GuardBody checksums the Body as it is now, onMutated is called (once) when it has changed by the time the
+// Message is settled or CheckBody is called, to catch handlers writing into a body they should only read.
+func (msg *Message) GuardBody(onMutated func(msg *Message)) {
+	msg.bodySum = crc32.ChecksumIEEE(msg.Body)
+	msg.onMutated = onMutated
+}
+
+// CheckBody returns false, and calls the GuardBody callback, when the Body changed since it was guarded.
+// An unguarded Message is always intact.
+func (msg *Message) CheckBody() bool {
+	if msg.onMutated == nil || crc32.ChecksumIEEE(msg.Body) == msg.bodySum {
+		return true
+	}
+
+	onMutated := msg.onMutated
+	msg.onMutated = nil
+	onMutated(msg)
+
+	return false
+}
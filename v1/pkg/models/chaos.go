@@ -0,0 +1,141 @@
+package models
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChaosFault is a fault the chaos layer injects.
+type ChaosFault int
+
+const (
+	// ChaosCloseChannel closes a channel as a ChannelPool hands it out.
+	ChaosCloseChannel ChaosFault = iota
+	// ChaosForceReconnect closes a connection as a ConnectionPool hands it out, taking its channels with it.
+	ChaosForceReconnect
+	// ChaosDelayAck holds a Consumer's ack before sending it.
+	ChaosDelayAck
+	// ChaosDropConfirm discards a publisher confirm, so the publish times out waiting for it.
+	ChaosDropConfirm
+)
+
+var chaosFaultNames = map[ChaosFault]string{
+	ChaosCloseChannel:   "CloseChannel",
+	ChaosForceReconnect: "ForceReconnect",
+	ChaosDelayAck:       "DelayAck",
+	ChaosDropConfirm:    "DropConfirm",
+}
+
+func (cf ChaosFault) String() string {
+	return chaosFaultNames[cf]
+}
+
+// ChaosStats count the faults injected so far.
+type ChaosStats struct {
+	ChannelsClosed  uint64
+	Reconnects      uint64
+	AcksDelayed     uint64
+	ConfirmsDropped uint64
+}
+
+// Chaos injects faults at the configured probabilities, to exercise an application's handling of the library's
+// recovery paths (i.e. in staging game days) without touching the broker. Disabled unless set with SetChaos,
+// a nil Chaos injects nothing.
+type Chaos struct {
+	config    ChaosConfig
+	random    *rand.Rand
+	injected  [4]uint64
+	chaosLock *sync.Mutex
+}
+
+var chaos atomic.Value
+
+// NewChaos creates a Chaos from the config.
+func NewChaos(config *ChaosConfig) *Chaos {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &Chaos{
+		config:    *config,
+		random:    rand.New(rand.NewSource(seed)),
+		chaosLock: &sync.Mutex{},
+	}
+}
+
+// SetChaos turns fault injection on for every pool, Consumer, and Publisher, nil turns it off.
+func SetChaos(c *Chaos) {
+	chaos.Store(c)
+}
+
+// GetChaos returns the Chaos set with SetChaos, nil when fault injection is off.
+func GetChaos() *Chaos {
+	c, _ := chaos.Load().(*Chaos)
+	return c
+}
+
+// Inject rolls for the fault, returning true (and counting it) when it should be injected.
+func (c *Chaos) Inject(fault ChaosFault) bool {
+	if c == nil {
+		return false
+	}
+
+	var probability float64
+	switch fault {
+	case ChaosCloseChannel:
+		probability = c.config.CloseChannel
+	case ChaosForceReconnect:
+		probability = c.config.ForceReconnect
+	case ChaosDelayAck:
+		probability = c.config.DelayAck
+	case ChaosDropConfirm:
+		probability = c.config.DropConfirm
+	default:
+		return false
+	}
+
+	if probability <= 0 {
+		return false
+	}
+
+	c.chaosLock.Lock()
+	roll := c.random.Float64()
+	c.chaosLock.Unlock()
+
+	if roll >= probability {
+		return false
+	}
+
+	atomic.AddUint64(&c.injected[fault], 1)
+
+	return true
+}
+
+// AckDelay rolls for ChaosDelayAck, returning how long to hold the ack (up to the configured AckDelay) or 0.
+func (c *Chaos) AckDelay() time.Duration {
+	if !c.Inject(ChaosDelayAck) || c.config.AckDelay == 0 {
+		return 0
+	}
+
+	c.chaosLock.Lock()
+	defer c.chaosLock.Unlock()
+
+	return time.Duration(c.random.Int63n(int64(c.config.AckDelay))+1) * time.Millisecond
+}
+
+// Stats returns how many faults were injected.
+func (c *Chaos) Stats() ChaosStats {
+	if c == nil {
+		return ChaosStats{}
+	}
+
+	return ChaosStats{
+		ChannelsClosed:  atomic.LoadUint64(&c.injected[ChaosCloseChannel]),
+		Reconnects:      atomic.LoadUint64(&c.injected[ChaosForceReconnect]),
+		AcksDelayed:     atomic.LoadUint64(&c.injected[ChaosDelayAck]),
+		ConfirmsDropped: atomic.LoadUint64(&c.injected[ChaosDropConfirm]),
+	}
+}
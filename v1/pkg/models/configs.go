@@ -8,11 +8,46 @@ type RabbitSeasoning struct {
 	PoolConfig        *PoolConfig                `json:"PoolConfig"`
 	ConsumerConfigs   map[string]*ConsumerConfig `json:"ConsumerConfigs"`
 	PublisherConfig   *PublisherConfig           `json:"PublisherConfig"`
+	ManagementConfig  *ManagementConfig          `json:"ManagementConfig"`
+	NamingPolicy      *NamingPolicyConfig        `json:"NamingPolicy,omitempty"` // restricts the exchange and queue names the Topologer declares and the Publisher publishes to
+	ChaosConfig       *ChaosConfig               `json:"ChaosConfig,omitempty"`  // fault injection for resilience testing, never enable in production
+	Targets           map[string]*TargetConfig   `json:"Targets,omitempty"`      // other brokers (clusters or vhosts) consumers and publishers are bound to by name
+}
+
+// TargetConfig is a broker the service talks to alongside the PoolConfig's, i.e. a second cluster or vhost.
+type TargetConfig struct {
+	PoolConfig      *PoolConfig      `json:"PoolConfig"`
+	PublisherConfig *PublisherConfig `json:"PublisherConfig,omitempty"` // the target's Publisher, defaults to the PublisherConfig without its SpoolDir
+}
+
+// ChaosConfig sets the probabilities (0 to 1) of each injected fault (see models.Chaos).
+type ChaosConfig struct {
+	Enabled        bool    `json:"Enabled"`
+	Seed           int64   `json:"Seed"`           // seeds the rolls for a repeatable run, 0 seeds from the clock
+	CloseChannel   float64 `json:"CloseChannel"`   // chance a channel is closed as a ChannelPool hands it out
+	ForceReconnect float64 `json:"ForceReconnect"` // chance a connection is closed as a ConnectionPool hands it out
+	DelayAck       float64 `json:"DelayAck"`       // chance a Consumer's ack is held before it's sent
+	AckDelay       uint32  `json:"AckDelay"`       // most ms an ack is held
+	DropConfirm    float64 `json:"DropConfirm"`    // chance a publisher confirm is discarded, the publish times out
 }
 
 // ServiceConfig represents settings for creating RabbitServices.
 type ServiceConfig struct {
-	ErrorBuffer uint16 `json:"ErrorBuffer"`
+	ErrorBuffer            uint16              `json:"ErrorBuffer"`
+	GoroutineLimit         int64               `json:"GoroutineLimit"`                 // caps the library's best effort goroutines (error sends), 0 is unlimited
+	GoroutineWarnThreshold int64               `json:"GoroutineWarnThreshold"`         // live goroutines of one kind before a warning is logged, 0 disables
+	ErrorDelivery          string              `json:"ErrorDelivery,omitempty"`        // "immediate" (default) drops errors that find an Errors() buffer full, "deferred" waits for room on a best effort goroutine
+	DeployTimeout          uint32              `json:"DeployTimeout"`                  // deadline of a graceful deploy in ms, 0 is 25 seconds (inside Kubernetes' default grace period)
+	ShutdownDependencies   map[string][]string `json:"ShutdownDependencies,omitempty"` // components (consumers by name, "Publisher") to stop only after the keyed one has stopped
+	HandleSignals          bool                `json:"HandleSignals"`                  // shut down gracefully on the ShutdownSignals, see RabbitService.HandleShutdownSignals
+	ShutdownSignals        []string            `json:"ShutdownSignals,omitempty"`      // SIGTERM, SIGINT, SIGHUP, or SIGQUIT, none is SIGTERM and SIGINT
+	ShutdownGracePeriod    uint32              `json:"ShutdownGracePeriod"`            // ms a signalled shutdown may take, 0 is the DeployTimeout
+	LogUnreadErrors        bool                `json:"LogUnreadErrors"`                // log the CentralErr errors the application leaves unread, see models.DrainErrors
+	ErrorLogLimit          uint32              `json:"ErrorLogLimit"`                  // unread errors logged a second, the rest are counted, 0 is 10
+	Trace                  bool                `json:"Trace"`                          // log every AMQP operation with its timing through the Logger, toggle at runtime with models.SetTracing
+	QueueLocality          bool                `json:"QueueLocality"`                  // consumers (and PublisherFor) connect to the node hosting their queue, requires the ManagementConfig
+	LocalityRefresh        uint32              `json:"LocalityRefresh"`                // ms a located queue's node is trusted before asking the management api again, 0 is 30 seconds
+	NodeAddresses          map[string]string   `json:"NodeAddresses,omitempty"`        // AMQP host:port by node name (i.e. rabbit@node-1), for node names that don't resolve
 }
 
 // PoolConfig represents settings for creating/configuring pools.
@@ -28,20 +63,42 @@ type ChannelPoolConfig struct {
 	MaxChannelCount      uint64 `json:"MaxChannelCount"`
 	MaxAckChannelCount   uint64 `json:"MaxAckChannelCount"`
 	AckNoWait            bool   `json:"AckNoWait"`
-	GlobalQosCount       int    `json:"GlobalQosCount"` // Leave at 0 if you want to ignore them.
+	GlobalQosCount       int    `json:"GlobalQosCount"`  // Leave at 0 if you want to ignore them.
+	MaxChannelAge        uint32 `json:"MaxChannelAge"`   // ms before a non-ackable channel is closed and replaced on checkout, 0 keeps channels forever
+	RecycleInterval      uint32 `json:"RecycleInterval"` // ms between channel recycles, defaults to MaxChannelAge / MaxChannelCount
 }
 
 // ConnectionPoolConfig represents settings for creating connection pools.
 type ConnectionPoolConfig struct {
-	ConnectionName       string     `json:"ConnectionName"`
-	URI                  string     `json:"URI"`
-	Heartbeat            uint32     `json:"Heartbeat"`
-	ConnectionTimeout    uint32     `json:"ConnectionTimeout"`
-	ErrorBuffer          uint16     `json:"ErrorBuffer"`
-	SleepOnErrorInterval uint32     `json:"SleepOnErrorInterval"` // sleep length on errors
-	EnableTLS            bool       `json:"EnableTLS"`            // Use TLSConfig to create connections with AMQPS uri.
-	MaxConnectionCount   uint64     `json:"MaxConnectionCount"`   // number of connections to create in the pool
-	TLSConfig            *TLSConfig `json:"TLSConfig"`            // TLS settings for connection with AMQPS.
+	ConnectionName         string     `json:"ConnectionName"`
+	URI                    string     `json:"URI"`
+	Heartbeat              uint32     `json:"Heartbeat"`
+	ConnectionTimeout      uint32     `json:"ConnectionTimeout"`
+	ErrorBuffer            uint16     `json:"ErrorBuffer"`
+	EventBuffer            uint16     `json:"EventBuffer"`            // defaults to ErrorBuffer, events are dropped when full
+	SleepOnErrorInterval   uint32     `json:"SleepOnErrorInterval"`   // sleep length on errors
+	EnableTLS              bool       `json:"EnableTLS"`              // Use TLSConfig to create connections with AMQPS uri.
+	MaxConnectionCount     uint64     `json:"MaxConnectionCount"`     // number of connections to create in the pool
+	MinConnectionCount     uint64     `json:"MinConnectionCount"`     // healthy connections needed to initialize degraded, 0 requires all of them
+	RestoreInterval        uint32     `json:"RestoreInterval"`        // ms between attempts to restore missing connections, defaults to SleepOnErrorInterval
+	WatchdogInterval       uint32     `json:"WatchdogInterval"`       // ms between probes of every connection, 0 disables the watchdog
+	WatchdogTimeout        uint32     `json:"WatchdogTimeout"`        // ms a probe has before the connection is replaced as stale, defaults to WatchdogInterval
+	MaxConnectionAge       uint32     `json:"MaxConnectionAge"`       // ms before a connection is closed and replaced, 0 keeps connections forever
+	RecycleInterval        uint32     `json:"RecycleInterval"`        // ms between connection recycles, defaults to MaxConnectionAge / MaxConnectionCount
+	RotateAddresses        bool       `json:"RotateAddresses"`        // re-resolve the broker host on every dial and rotate through its A/AAAA records
+	PreferHealthyAddresses bool       `json:"PreferHealthyAddresses"` // with RotateAddresses, try addresses that last connected first
+	TCPKeepAlive           int32      `json:"TCPKeepAlive"`           // ms between TCP keepalive probes of an idle connection, 0 uses Go's default (15s), -1 disables them
+	LocalAddress           string     `json:"LocalAddress"`           // source IP (or IP:port) connections are dialed from, empty lets the OS pick
+	TLSConfig              *TLSConfig `json:"TLSConfig"`              // TLS settings for connection with AMQPS.
+}
+
+// ManagementConfig represents settings for reaching the RabbitMQ management HTTP API.
+type ManagementConfig struct {
+	URI      string `json:"URI"` // i.e. http://localhost:15672
+	Username string `json:"Username"`
+	Password string `json:"Password"`
+	VHost    string `json:"VHost"`   // default vhost when one isn't provided, defaults to "/"
+	Timeout  uint32 `json:"Timeout"` // request timeout in seconds
 }
 
 // TLSConfig represents settings for configuring TLS.
@@ -49,40 +106,146 @@ type TLSConfig struct {
 	PEMCertLocation   string `json:"PEMCertLocation"`
 	LocalCertLocation string `json:"LocalCertLocation"`
 	CertServerName    string `json:"CertServerName"`
+	SessionCacheSize  int    `json:"SessionCacheSize"` // TLS sessions cached to resume on redials, 0 does a full handshake every dial
 }
 
 // ConsumerConfig represents settings for configuring a consumer with ease.
 type ConsumerConfig struct {
-	Enabled              bool                   `json:"Enabled"`
-	QueueName            string                 `json:"QueueName"`
-	ConsumerName         string                 `json:"ConsumerName"`
-	AutoAck              bool                   `json:"AutoAck"`
-	Exclusive            bool                   `json:"Exclusive"`
-	NoWait               bool                   `json:"NoWait"`
-	Args                 map[string]interface{} `json:"Args"`
-	QosCountOverride     int                    `json:"QosCountOverride"` // if zero ignored
-	MessageBuffer        uint32                 `json:"MessageBuffer"`
-	ErrorBuffer          uint32                 `json:"ErrorBuffer"`
-	SleepOnErrorInterval uint32                 `json:"SleepOnErrorInterval"` // sleep on error
-	SleepOnIdleInterval  uint32                 `json:"SleepOnIdleInterval"`  // sleep on idle
+	Enabled                bool                   `json:"Enabled"`
+	QueueName              string                 `json:"QueueName"`
+	ConsumerName           string                 `json:"ConsumerName"`
+	AutoAck                bool                   `json:"AutoAck"`
+	Exclusive              bool                   `json:"Exclusive"`
+	NoWait                 bool                   `json:"NoWait"`
+	Args                   map[string]interface{} `json:"Args"`
+	QosCountOverride       int                    `json:"QosCountOverride"` // if zero ignored
+	MessageBuffer          uint32                 `json:"MessageBuffer"`
+	ErrorBuffer            uint32                 `json:"ErrorBuffer"`
+	SleepOnErrorInterval   uint32                 `json:"SleepOnErrorInterval"`             // sleep on error
+	SleepOnIdleInterval    uint32                 `json:"SleepOnIdleInterval"`              // sleep on idle
+	AckBatchSize           uint32                 `json:"AckBatchSize"`                     // acks flushed as one multiple ack, ignored below 2
+	AckBatchInterval       uint32                 `json:"AckBatchInterval"`                 // ms between timed flushes
+	AckBatchMaxAge         uint32                 `json:"AckBatchMaxAge"`                   // ms an ack may wait before being sent on its own
+	ReplayWindow           uint32                 `json:"ReplayWindow"`                     // ms acked message-ids are remembered for, 0 disables replay protection
+	ReplayExpectedCount    uint32                 `json:"ReplayExpectedCount"`              // messages expected per window
+	ReplayFalsePositive    float64                `json:"ReplayFalsePositive"`              // chance a unique message is dropped as a duplicate (i.e. 0.001)
+	StreamFilters          []string               `json:"StreamFilters"`                    // stream filter values to deliver (stream queues only)
+	StreamMatchUnfiltered  bool                   `json:"StreamMatchUnfiltered"`            // also deliver stream messages without a filter value
+	ShadowMode             bool                   `json:"ShadowMode"`                       // handle deliveries but only record the decisions, every delivery is requeued
+	ShadowRequeueDelay     uint32                 `json:"ShadowRequeueDelay"`               // ms to hold a shadow delivery before requeueing it
+	CheckpointEvery        uint32                 `json:"CheckpointEvery"`                  // acked messages between progress checkpoints, 0 disables
+	CheckpointInterval     uint32                 `json:"CheckpointInterval"`               // ms between progress checkpoints, 0 disables
+	ResultRouting          *ResultRouting         `json:"ResultRouting,omitempty"`          // where handler results are published, see Consumer.StartConsumingWithResults
+	AcceptContentTypes     []string               `json:"AcceptContentTypes,omitempty"`     // i.e. application/json, text/*, "" for none, everything else is rejected
+	AcceptContentEncodings []string               `json:"AcceptContentEncodings,omitempty"` // i.e. gzip, identity, everything else is rejected
+	AckPolicy              *AckPolicyConfig       `json:"AckPolicy,omitempty"`              // settles what the handler leaves unsettled, by the handler's error
+	BufferStrategy         string                 `json:"BufferStrategy,omitempty"`         // "block" (default) waits for room in the message buffer, "ring" overwrites the oldest buffered message
+	ConsumerTimeout        uint32                 `json:"ConsumerTimeout"`                  // ms of the broker's consumer_timeout (30 minutes by default) to keep unsettled deliveries clear of, 0 disables
+	ConsumerTimeoutMargin  uint32                 `json:"ConsumerTimeoutMargin"`            // ms before the ConsumerTimeout an unsettled delivery is requeued, 0 is 10% of it
+	Handler                string                 `json:"Handler,omitempty"`                // registered handler name ProvisionConsumers starts this consumer with, defaults to its ConsumerConfigs key
+	Queue                  *Queue                 `json:"Queue,omitempty"`                  // declared by ProvisionConsumers, without one the queue must already exist
+	DedupTTL               uint32                 `json:"DedupTTL"`                         // ms a processed message's key is remembered for exact dedup (in memory, see Consumer.EnableDedup), 0 disables
+	DedupClaimTTL          uint32                 `json:"DedupClaimTTL"`                    // ms a delivery being processed holds its key, 0 is 30 seconds
+	DedupHeader            string                 `json:"DedupHeader,omitempty"`            // header holding the dedup key, empty uses the MessageId
+	Standby                bool                   `json:"Standby"`                          // start as a warm standby, consuming only once promoted (see Consumer.EnableStandby)
+	StandbyFailover        bool                   `json:"StandbyFailover"`                  // promote the standby when its queue has no consumers left, requires Exclusive
+	StandbyInterval        uint32                 `json:"StandbyInterval"`                  // ms between a standby's queue checks, 0 is 250
+	BufferAgeWarning       uint32                 `json:"BufferAgeWarning"`                 // ms a message may sit in the buffer unread before a BufferAging event, 0 disables
+	ImmutableBody          bool                   `json:"ImmutableBody"`                    // hand each Message a copy of the delivery's body, so a handler writing into it can't corrupt what the library still reads
+	GuardBody              bool                   `json:"GuardBody"`                        // checksum each body on delivery and raise a BodyMutated event (and error) when it changed by the time the Message is settled
+	RejectPoisonPanics     bool                   `json:"RejectPoisonPanics"`               // reject (without requeue) a redelivered message the handler panics on, instead of requeueing it again
+	Target                 string                 `json:"Target,omitempty"`                 // the Targets entry whose broker the consumer consumes from, empty for the PoolConfig's
+}
+
+// Strategies for a full Consumer message buffer.
+const (
+	BufferStrategyBlock = "block" // wait for the application to read a message
+	BufferStrategyRing  = "ring"  // take out the oldest message, nacking it without requeue, for freshest data over completeness
+)
+
+// ResultRouting is where a result handler's responses are published, a nil route publishes nothing.
+type ResultRouting struct {
+	Success      *ResultRoute `json:"Success,omitempty"`
+	Failure      *ResultRoute `json:"Failure,omitempty"`
+	CarryHeaders []string     `json:"CarryHeaders,omitempty"` // input headers copied onto the response, the correlation id always is
+}
+
+// ResultRoute is an exchange and routing key to publish results to.
+type ResultRoute struct {
+	Exchange   string `json:"Exchange"`
+	RoutingKey string `json:"RoutingKey"`
 }
 
 // PublisherConfig represents settings for configuring global settings for all Publishers with ease.
 type PublisherConfig struct {
-	SleepOnIdleInterval      uint32 `json:"SleepOnIdleInterval"`
-	SleepOnQueueFullInterval uint32 `json:"SleepOnQueueFullInterval"`
-	SleepOnErrorInterval     uint32 `json:"SleepOnErrorInterval"`
-	LetterBuffer             uint64 `json:"LetterBuffer"`
-	MaxOverBuffer            uint64 `json:"MaxOverBuffer"`
-	NotificationBuffer       uint32 `json:"NotificationBuffer"`
+	SleepOnIdleInterval      uint32                        `json:"SleepOnIdleInterval"`
+	SleepOnQueueFullInterval uint32                        `json:"SleepOnQueueFullInterval"`
+	SleepOnErrorInterval     uint32                        `json:"SleepOnErrorInterval"`
+	LetterBuffer             uint64                        `json:"LetterBuffer"`
+	MaxOverBuffer            uint64                        `json:"MaxOverBuffer"`
+	NotificationBuffer       uint32                        `json:"NotificationBuffer"`
+	FailureBuffer            uint32                        `json:"FailureBuffer"`       // defaults to NotificationBuffer
+	RawRetryCount            uint32                        `json:"RawRetryCount"`       // retries for PublishRaw, zero tries once
+	ReceiptDedupWindow       uint32                        `json:"ReceiptDedupWindow"`  // ms a MessageID's success receipt suppresses repeats, 0 disables
+	ConfirmTimeout           uint32                        `json:"ConfirmTimeout"`      // ms to wait for a publisher confirm, defaults to 5000
+	ExchangeDefaults         map[string]*ExchangeDefaults  `json:"ExchangeDefaults"`    // keyed by exchange name, "" is the default exchange
+	VerifyTTLDeadLetter      bool                          `json:"VerifyTTLDeadLetter"` // PublishWithTTL checks the target queues have a dead letter exchange, requires the ManagementConfig
+	SpoolDir                 string                        `json:"SpoolDir"`            // queued letters overflowing the LetterBuffer spill to disk here, empty disables
+	SpoolMaxBytes            uint64                        `json:"SpoolMaxBytes"`       // disk the spool may use before QueueLetter blocks, 0 is unlimited
+	SpoolSegmentSize         uint64                        `json:"SpoolSegmentSize"`    // bytes a spool file rolls over at, defaults to 16 MiB
+	PublishPolicies          map[string]*PublishPolicy     `json:"PublishPolicies"`     // keyed by exchange name, "" is the default exchange, "*" covers exchanges without their own
+	InheritHeaders           []string                      `json:"InheritHeaders"`      // headers (i.e. x-tenant-id) a publish with context inherits from the consumed message
+	Fallbacks                map[string][]*PublishFallback `json:"Fallbacks"`           // keyed by exchange name, tried in order when a publish to it fails
+	Schedules                []*ScheduledPublish           `json:"Schedules"`           // published on a schedule by the RabbitService's Scheduler, see publisher.Scheduler
+}
+
+// ExchangeDefaults are publishing options applied to every letter sent to an exchange (see Publisher.SetExchangeDefaults).
+type ExchangeDefaults struct {
+	DeliveryMode   uint8                  `json:"DeliveryMode"`   // used when the letter's is 0, 2 is persistent
+	Mandatory      bool                   `json:"Mandatory"`      // letters are always mandatory when set
+	ContentType    string                 `json:"ContentType"`    // used when the letter's is empty
+	Headers        map[string]interface{} `json:"Headers"`        // merged under the letter's headers, the letter's win
+	RequireConfirm bool                   `json:"RequireConfirm"` // wait for the server to confirm every publish
+}
+
+// PublishPolicy is what every letter sent to an exchange must satisfy before it's published (see Publisher.SetPublishPolicy).
+type PublishPolicy struct {
+	MaxBodySize     uint32   `json:"MaxBodySize"`     // bytes, 0 is unlimited
+	ContentTypes    []string `json:"ContentTypes"`    // allowed media types (i.e. application/json or text/*), empty allows any
+	RequiredHeaders []string `json:"RequiredHeaders"` // headers every letter must carry
+}
+
+// PublishFallback is where a letter goes when its publish failed (see Publisher.SetFallbacks): another exchange,
+// or the fallback sink (i.e. a local spool, see Publisher.SetFallbackSink).
+type PublishFallback struct {
+	Exchange   string `json:"Exchange"`
+	RoutingKey string `json:"RoutingKey"` // empty keeps the letter's
+	Sink       bool   `json:"Sink"`       // hand the letter to the fallback sink instead of an exchange
+}
+
+// ScheduledPublish is a letter published on a schedule (see publisher.Scheduler), i.e. a heartbeat.
+type ScheduledPublish struct {
+	Name         string                 `json:"Name"`
+	Schedule     string                 `json:"Schedule"` // cron (minute hour day month weekday), @hourly, @daily, or @every 30s
+	Jitter       uint32                 `json:"Jitter"`   // up to this many ms of random delay on every run
+	Exchange     string                 `json:"Exchange"`
+	RoutingKey   string                 `json:"RoutingKey"`
+	ContentType  string                 `json:"ContentType"`
+	Headers      map[string]interface{} `json:"Headers"`
+	DeliveryMode uint8                  `json:"DeliveryMode"`
+	RetryCount   uint32                 `json:"RetryCount"`
+	Body         string                 `json:"Body"`
 }
 
 // TopologyConfig allows you to build simple toplogies from a JSON file.
 type TopologyConfig struct {
-	Exchanges        []*Exchange        `json:"Exchanges"`
-	Queues           []*Queue           `json:"Queues"`
-	QueueBindings    []*QueueBinding    `json:"QueueBindings"`
-	ExchangeBindings []*ExchangeBinding `json:"ExchangeBindings"`
+	Exchanges               []*Exchange               `json:"Exchanges"`
+	Queues                  []*Queue                  `json:"Queues"`
+	QueueBindings           []*QueueBinding           `json:"QueueBindings"`
+	ExchangeBindings        []*ExchangeBinding        `json:"ExchangeBindings"`
+	ConsistentHashExchanges []*ConsistentHashExchange `json:"ConsistentHashExchanges"` // requires the consistent hash plugin
+	Policies                []*Policy                 `json:"Policies"`                // requires the management API
+	Parameters              []*Parameter              `json:"Parameters"`              // requires the management API
 }
 
 // CompressionConfig allows you to configuration symmetric key encryption based on options
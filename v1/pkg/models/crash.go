@@ -0,0 +1,53 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Crash is a handler panic with the stack and the delivery that caused it, for postmortems.
+type Crash struct {
+	Panic         interface{}
+	Stack         []byte
+	ConsumerName  string
+	QueueName     string
+	DeliveryTag   uint64
+	Exchange      string
+	RoutingKey    string
+	MessageID     string
+	CorrelationID string
+	ContentType   string
+	Headers       amqp.Table
+	Redelivered   bool
+	Body          []byte
+	Requeued      bool // the delivery was nacked back onto the queue
+	Time          time.Time
+}
+
+// NewCrash creates a new Crash from the recovered panic value and the delivery being handled.
+func NewCrash(panicValue interface{}, stack []byte, consumerName string, queueName string, delivery *amqp.Delivery) *Crash {
+	return &Crash{
+		Panic:         panicValue,
+		Stack:         stack,
+		ConsumerName:  consumerName,
+		QueueName:     queueName,
+		DeliveryTag:   delivery.DeliveryTag,
+		Exchange:      delivery.Exchange,
+		RoutingKey:    delivery.RoutingKey,
+		MessageID:     delivery.MessageId,
+		CorrelationID: delivery.CorrelationId,
+		ContentType:   delivery.ContentType,
+		Headers:       delivery.Headers,
+		Redelivered:   delivery.Redelivered,
+		Body:          delivery.Body,
+		Time:          time.Now(),
+	}
+}
+
+// ToString allows you to quickly log the Crash struct as a string.
+func (crash *Crash) ToString() string {
+	return fmt.Sprintf("[%s] handler panicked on DeliveryTag: %d MessageID: %s Requeued: %t\r\nPanic: %v\r\n%s\r\n",
+		crash.ConsumerName, crash.DeliveryTag, crash.MessageID, crash.Requeued, crash.Panic, crash.Stack)
+}
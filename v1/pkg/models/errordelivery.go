@@ -0,0 +1,49 @@
+package models
+
+import (
+	"sync/atomic"
+)
+
+// ErrorDelivery is how the library hands errors to the Errors() channels.
+type ErrorDelivery int32
+
+const (
+	// ErrorDeliveryImmediate sends without waiting, an error that finds the buffer full is dropped and counted.
+	ErrorDeliveryImmediate ErrorDelivery = iota
+	// ErrorDeliveryDeferred waits for room on a best effort goroutine, bounded by the goroutine limit
+	// (see SetGoroutineLimit), an error refused a goroutine is dropped and counted.
+	ErrorDeliveryDeferred
+)
+
+// Names of the error delivery modes in a ServiceConfig.
+const (
+	ErrorDeliveryImmediateName = "immediate"
+	ErrorDeliveryDeferredName  = "deferred"
+)
+
+var errorDelivery int32
+
+// SetErrorDelivery sets how errors are handed to every Errors() channel, ErrorDeliveryImmediate is the default.
+func SetErrorDelivery(delivery ErrorDelivery) {
+	atomic.StoreInt32(&errorDelivery, int32(delivery))
+}
+
+// GetErrorDelivery returns how errors are handed to the Errors() channels.
+func GetErrorDelivery() ErrorDelivery {
+	return ErrorDelivery(atomic.LoadInt32(&errorDelivery))
+}
+
+// SendError hands the error to the channel per the error delivery mode, returning false when it was dropped.
+// The name is the deferred goroutine's name, i.e. "consumer.errors".
+func SendError(name string, errors chan<- error, err error) bool {
+	if GetErrorDelivery() == ErrorDeliveryDeferred {
+		return TryGo(name, func() { errors <- err })
+	}
+
+	select {
+	case errors <- err:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultErrorLogLimit = 10
+	errorDrainInterval   = time.Duration(100) * time.Millisecond
+	errorDrainWindow     = time.Second
+)
+
+// ErrorDrain logs the errors an application leaves unread in an Errors() channel, so they're seen instead of
+// filling the buffer and being dropped (or piling up goroutines with deferred error delivery, see
+// SetErrorDelivery). It steps aside while the application keeps up: errors are only taken once the channel is
+// half full, and then only until it's below half again. Logging (see SetLogger) is rate limited, the errors over
+// the limit are counted and summarized once a second.
+type ErrorDrain struct {
+	source     string
+	errors     <-chan error
+	limit      uint64
+	logged     uint64
+	suppressed uint64
+	stop       chan struct{}
+	done       chan struct{}
+	stopOnce   *sync.Once
+}
+
+// DrainErrors starts draining the errors channel (i.e. a Consumer's or ChannelPool's Errors()) to the Logger,
+// logging up to limit errors a second (0 is 10). The source prefixes every line. Drains until Stop or the
+// channel is closed.
+func DrainErrors(source string, errors <-chan error, limit uint32) *ErrorDrain {
+	if limit == 0 {
+		limit = defaultErrorLogLimit
+	}
+
+	ed := &ErrorDrain{
+		source:   source,
+		errors:   errors,
+		limit:    uint64(limit),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		stopOnce: &sync.Once{},
+	}
+
+	Go("models.errordrain", ed.run)
+
+	return ed
+}
+
+// Stop stops draining, summarizing the errors suppressed since the last summary.
+func (ed *ErrorDrain) Stop() {
+	ed.stopOnce.Do(func() { close(ed.stop) })
+	<-ed.done
+}
+
+// Logged returns how many errors were logged.
+func (ed *ErrorDrain) Logged() uint64 {
+	return atomic.LoadUint64(&ed.logged)
+}
+
+// Suppressed returns how many errors were drained over the rate limit and only counted.
+func (ed *ErrorDrain) Suppressed() uint64 {
+	return atomic.LoadUint64(&ed.suppressed)
+}
+
+func (ed *ErrorDrain) run() {
+	defer close(ed.done)
+
+	ticker := time.NewTicker(errorDrainInterval)
+	defer ticker.Stop()
+
+	windowStart := time.Now()
+	var windowLogged, windowSuppressed uint64
+
+	summarize := func() {
+		if windowSuppressed > 0 {
+			GetLogger().Printf("%s: %d more errors suppressed", ed.source, windowSuppressed)
+		}
+
+		windowStart, windowLogged, windowSuppressed = time.Now(), 0, 0
+	}
+	defer summarize()
+
+	for {
+		select {
+		case <-ed.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if time.Since(windowStart) >= errorDrainWindow {
+			summarize()
+		}
+
+		for ed.unread() {
+			var err error
+			var ok bool
+			select {
+			case err, ok = <-ed.errors:
+				if !ok {
+					return
+				}
+			default:
+			}
+
+			if err == nil {
+				break // read by the application meanwhile
+			}
+
+			if windowLogged < ed.limit {
+				GetLogger().Printf("%s: %s", ed.source, err)
+				windowLogged++
+				atomic.AddUint64(&ed.logged, 1)
+			} else {
+				windowSuppressed++
+				atomic.AddUint64(&ed.suppressed, 1)
+			}
+		}
+	}
+}
+
+// Unread returns true while the channel is at least half full, an unbuffered channel is always drained.
+func (ed *ErrorDrain) unread() bool {
+	capacity := cap(ed.errors)
+	return capacity == 0 || len(ed.errors)*2 >= capacity
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType identifies what happened in a pool Event.
+type EventType int
+
+const (
+	// ConnectionLost is raised when the server or network closes a pooled connection.
+	ConnectionLost EventType = iota
+	// ConnectionRestored is raised when a dead or flagged connection has been replaced.
+	ConnectionRestored
+	// ChannelReplaced is raised when a dead or flagged channel has been replaced.
+	ChannelReplaced
+	// Blocked is raised when the server blocks a connection (i.e. memory or disk alarms).
+	Blocked
+	// Unblocked is raised when the server lifts a connection block.
+	Unblocked
+	// PoolDegraded is raised when a pool initializes with some of its connections missing.
+	PoolDegraded
+	// PoolRestored is raised once every missing connection of a degraded pool has been restored.
+	PoolRestored
+	// PoolResizing is raised for each host added or removed while a pool resizes.
+	PoolResizing
+	// PoolResized is raised once a pool has reached the size it was resized to.
+	PoolResized
+	// ConnectionStale is raised when the watchdog flags a connection that stopped responding.
+	ConnectionStale
+	// DeadLetterMissing is raised by a Publisher when messages with a TTL go to a queue without a dead letter exchange.
+	DeadLetterMissing
+	// UnsupportedFormat is raised by a Consumer when it rejects a delivery in a content type or encoding it doesn't accept.
+	UnsupportedFormat
+	// ConnectionRecycled is raised when a connection past its MaxConnectionAge is closed to be replaced.
+	ConnectionRecycled
+	// ChannelRecycled is raised when a channel past its MaxChannelAge has been closed and replaced.
+	ChannelRecycled
+	// ConsumerTimeoutNear is raised by a Consumer when a delivery has gone unsettled for most of its consumer timeout.
+	ConsumerTimeoutNear
+	// DeliveryExpired is raised by a Consumer when it requeues a delivery that was about to hit its consumer timeout.
+	DeliveryExpired
+	// DuplicateDropped is raised by a Consumer when dedup drops a delivery whose key was already processed.
+	DuplicateDropped
+	// ChaosInjected is raised when the chaos layer (see models.SetChaos) injects a fault.
+	ChaosInjected
+	// StandbyPromoted is raised by a standby Consumer when it's promoted, or takes over a queue without consumers.
+	StandbyPromoted
+	// FallbackUsed is raised by a Publisher when a letter whose publish failed went to one of its exchange's fallbacks.
+	FallbackUsed
+	// BufferAging is raised by a Consumer when a Message has sat in its buffer unread for longer than the warning threshold.
+	BufferAging
+	// PoolDraining is raised when a pool starts draining, it hands nothing out while waiting for its leases to return.
+	PoolDraining
+	// PoolDrained is raised once a draining pool has been shut down.
+	PoolDrained
+	// BodyMutated is raised by a Consumer guarding bodies when a handler changed a Message's body.
+	BodyMutated
+)
+
+var eventTypeNames = map[EventType]string{
+	ConnectionLost:      "ConnectionLost",
+	ConnectionRestored:  "ConnectionRestored",
+	ChannelReplaced:     "ChannelReplaced",
+	Blocked:             "Blocked",
+	Unblocked:           "Unblocked",
+	PoolDegraded:        "PoolDegraded",
+	PoolRestored:        "PoolRestored",
+	PoolResizing:        "PoolResizing",
+	PoolResized:         "PoolResized",
+	ConnectionStale:     "ConnectionStale",
+	DeadLetterMissing:   "DeadLetterMissing",
+	UnsupportedFormat:   "UnsupportedFormat",
+	ConnectionRecycled:  "ConnectionRecycled",
+	ChannelRecycled:     "ChannelRecycled",
+	ConsumerTimeoutNear: "ConsumerTimeoutNear",
+	DeliveryExpired:     "DeliveryExpired",
+	DuplicateDropped:    "DuplicateDropped",
+	ChaosInjected:       "ChaosInjected",
+	StandbyPromoted:     "StandbyPromoted",
+	FallbackUsed:        "FallbackUsed",
+	BufferAging:         "BufferAging",
+	PoolDraining:        "PoolDraining",
+	PoolDrained:         "PoolDrained",
+	BodyMutated:         "BodyMutated",
+}
+
+func (et EventType) String() string {
+	if name, ok := eventTypeNames[et]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("EventType(%d)", int(et))
+}
+
+// Event is a typed notification of a change in a pool's connections or channels, or a Publisher or Consumer warning.
+type Event struct {
+	Type         EventType
+	ConnectionID uint64
+	ChannelID    uint64
+	Reason       string
+	Time         time.Time
+}
+
+// NewEvent creates a new Event stamped with the current time.
+func NewEvent(eventType EventType, connectionID uint64, channelID uint64, reason string) *Event {
+	return &Event{
+		Type:         eventType,
+		ConnectionID: connectionID,
+		ChannelID:    channelID,
+		Reason:       reason,
+		Time:         time.Now(),
+	}
+}
+
+// ToString allows you to quickly log the Event struct as a string.
+func (ev *Event) ToString() string {
+	return fmt.Sprintf("[%s] ConnectionID: %d ChannelID: %d Reason: %s\r\n", ev.Type, ev.ConnectionID, ev.ChannelID, ev.Reason)
+}
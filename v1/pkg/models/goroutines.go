@@ -0,0 +1,129 @@
+package models
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// GoroutineStats is a snapshot of the goroutines the library started and hasn't seen finish yet.
+type GoroutineStats struct {
+	Live    int64
+	Limit   int64            // 0 is unlimited
+	Refused uint64           // best effort goroutines (i.e. error sends) not started because of the limit
+	ByName  map[string]int64 // live goroutines by name, i.e. "consumer.errors"
+}
+
+// Supervisor starts and counts every goroutine the library owns. Long running goroutines (consume loops, monitors)
+// always start, best effort ones (error and notification sends) are refused once the limit is reached, dropping
+// what they would have sent. Sends pile up when nobody drains Errors(), the warning fires once per name when its
+// live count reaches the threshold (and again after it falls back below).
+type supervisor struct {
+	live           int64
+	limit          int64
+	refused        uint64
+	byName         map[string]int64
+	warnThreshold  int64
+	warn           func(name string, live int64)
+	warned         map[string]bool
+	supervisorLock *sync.Mutex
+}
+
+var goroutines = &supervisor{
+	byName:         make(map[string]int64),
+	warned:         make(map[string]bool),
+	supervisorLock: &sync.Mutex{},
+}
+
+// Go starts a library goroutine, it always starts regardless of the limit.
+func Go(name string, fn func()) {
+	goroutines.start(name)
+	go goroutines.run(name, fn)
+}
+
+// TryGo starts a best effort library goroutine unless the limit has been reached, returning false when refused.
+func TryGo(name string, fn func()) bool {
+	if limit := atomic.LoadInt64(&goroutines.limit); limit > 0 && atomic.LoadInt64(&goroutines.live) >= limit {
+		atomic.AddUint64(&goroutines.refused, 1)
+		return false
+	}
+
+	goroutines.start(name)
+	go goroutines.run(name, fn)
+
+	return true
+}
+
+// SetGoroutineLimit caps the live library goroutines for best effort work, 0 removes the cap.
+func SetGoroutineLimit(limit int64) {
+	atomic.StoreInt64(&goroutines.limit, limit)
+}
+
+// SetGoroutineWarning calls warn when the live goroutines of a single name reach the threshold,
+// a zero threshold or nil warn turns the warning off. Warn is called on the goroutine being started.
+func SetGoroutineWarning(threshold int64, warn func(name string, live int64)) {
+	goroutines.supervisorLock.Lock()
+	defer goroutines.supervisorLock.Unlock()
+
+	goroutines.warnThreshold = threshold
+	goroutines.warn = warn
+	goroutines.warned = make(map[string]bool)
+}
+
+// Goroutines returns a snapshot of the library's live goroutines.
+func Goroutines() GoroutineStats {
+	goroutines.supervisorLock.Lock()
+	defer goroutines.supervisorLock.Unlock()
+
+	byName := make(map[string]int64, len(goroutines.byName))
+	for name, live := range goroutines.byName {
+		byName[name] = live
+	}
+
+	return GoroutineStats{
+		Live:    atomic.LoadInt64(&goroutines.live),
+		Limit:   atomic.LoadInt64(&goroutines.limit),
+		Refused: atomic.LoadUint64(&goroutines.refused),
+		ByName:  byName,
+	}
+}
+
+func (s *supervisor) start(name string) {
+	atomic.AddInt64(&s.live, 1)
+
+	s.supervisorLock.Lock()
+	s.byName[name]++
+	live := s.byName[name]
+
+	var warn func(string, int64)
+	if s.warn != nil && s.warnThreshold > 0 && live >= s.warnThreshold && !s.warned[name] {
+		s.warned[name] = true
+		warn = s.warn
+	}
+	s.supervisorLock.Unlock()
+
+	if warn != nil {
+		warn(name, live)
+	}
+}
+
+func (s *supervisor) run(name string, fn func()) {
+	defer s.finish(name)
+
+	fn()
+}
+
+func (s *supervisor) finish(name string) {
+	atomic.AddInt64(&s.live, -1)
+
+	s.supervisorLock.Lock()
+	defer s.supervisorLock.Unlock()
+
+	s.byName[name]--
+	if s.byName[name] <= 0 {
+		delete(s.byName, name)
+	}
+
+	if s.byName[name] < s.warnThreshold {
+		delete(s.warned, name)
+	}
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// DeadlineHeader carries a message's deadline, in Unix milliseconds, from hop to hop (see Message.InheritContext).
+const DeadlineHeader = "x-deadline"
+
+type inheritedKey struct{}
+
+// Inherited is what a consumed Message passes on to the messages published while handling it: its priority,
+// correlation id, deadline, and headers (the Publisher picks the ones in its InheritHeaders).
+type Inherited struct {
+	Priority      uint8
+	CorrelationID string
+	Deadline      time.Time // zero without one
+	Headers       map[string]interface{}
+}
+
+// InheritContext returns the Message's context (see Context) carrying what it passes on to follow-up publishes,
+// hand it to Publisher.PublishWithContext or QueueLetterWithContext. The attributes are copied, the context
+// stays valid after a pooled Message is recycled.
+func (msg *Message) InheritContext() context.Context {
+
+	inherited := &Inherited{
+		Priority:      msg.Priority,
+		CorrelationID: msg.CorrelationID,
+		Headers:       make(map[string]interface{}, len(msg.Headers)),
+	}
+
+	for key, value := range msg.Headers {
+		inherited.Headers[key] = value
+	}
+
+	if deadline, ok := HeaderDeadline(msg.Headers); ok {
+		inherited.Deadline = deadline
+	}
+
+	return context.WithValue(msg.Context(), inheritedKey{}, inherited)
+}
+
+// InheritedFrom returns what the context carries from a consumed Message, false when it carries nothing.
+func InheritedFrom(ctx context.Context) (*Inherited, bool) {
+	inherited, ok := ctx.Value(inheritedKey{}).(*Inherited)
+	return inherited, ok
+}
+
+// HeaderDeadline reads the DeadlineHeader, false when it's missing or isn't a number of milliseconds.
+func HeaderDeadline(headers map[string]interface{}) (time.Time, bool) {
+
+	var millis int64
+	switch value := headers[DeadlineHeader].(type) {
+	case int64:
+		millis = value
+	case int32:
+		millis = int64(value)
+	case int:
+		millis = int64(value)
+	case string:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		millis = parsed
+	default:
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, millis*int64(time.Millisecond)), true
+}
@@ -1,26 +1,44 @@
 package models
 
+import "github.com/streadway/amqp"
+
+const (
+	// StreamFilterValueHeader carries a message's stream filter value (RabbitMQ 3.13+ streams).
+	StreamFilterValueHeader = "x-stream-filter-value"
+	// StreamFilterArg is the stream consumer argument listing the filter values to deliver.
+	StreamFilterArg = "x-stream-filter"
+	// StreamMatchUnfilteredArg is the stream consumer argument to also deliver messages without a filter value.
+	StreamMatchUnfilteredArg = "x-stream-match-unfiltered"
+)
+
 // Letter contains the message body and address of where things are going.
 type Letter struct {
 	LetterID   uint64
 	RetryCount uint32
 	Body       []byte
 	Envelope   *Envelope
+	Publishing *amqp.Publishing // published as is when set, the Envelope only supplies the address (see Publisher.PublishRaw)
 }
 
 // Envelope contains all the address details of where a letter is going.
 type Envelope struct {
-	Exchange     string
-	RoutingKey   string
-	ContentType  string
-	Mandatory    bool
-	Immediate    bool
-	Headers      map[string]interface{}
-	DeliveryMode uint8
+	Exchange          string
+	RoutingKey        string
+	ContentType       string
+	Mandatory         bool
+	Immediate         bool
+	Headers           map[string]interface{}
+	DeliveryMode      uint8
+	MessageID         string
+	CorrelationID     string
+	StreamFilterValue string // sent as the x-stream-filter-value header when publishing to a stream
+	Expiration        string // per-message TTL in ms, see Publisher.PublishWithTTL
+	Priority          uint8  // 0 to 9 on priority queues, 0 is unset
 }
 
 // ModdedLetter is a letter with a modified body and indicators of what was done to it.
 type ModdedLetter struct {
+	Version        int         `json:"Version,omitempty"` // wire format version (see utils.EnvelopeVersion), 0 predates versioning
 	LetterID       uint64      `json:"LetterID"`
 	Body           *ModdedBody `json:"Body"`
 	LetterMetadata string      `json:"LetterMetadata"`
@@ -32,6 +50,9 @@ type ModdedBody struct {
 	EType       string `json:"EncryptionType,omitempty"`
 	Compressed  bool   `json:"Compressed"`
 	CType       string `json:"CompressionType,omitempty"`
+	Signed      bool   `json:"Signed,omitempty"`
+	SType       string `json:"SignatureType,omitempty"`
+	Signature   []byte `json:"Signature,omitempty"`
 	UTCDateTime string `json:"UTCDateTime"`
 	Data        []byte `json:"Data"`
 }
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// Logger receives what the library logs, *log.Logger satisfies it, adapt any other logging library to it.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// NoopLogger discards everything.
+type NoopLogger struct{}
+
+// Printf does nothing.
+func (NoopLogger) Printf(format string, args ...interface{}) {}
+
+type loggerHolder struct {
+	logger Logger
+}
+
+var logger atomic.Value
+
+var defaultLogger = log.New(os.Stderr, "turbocookedrabbit: ", log.LstdFlags)
+
+// SetLogger sets where the library logs, nil discards it.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = NoopLogger{}
+	}
+
+	logger.Store(&loggerHolder{logger: l})
+}
+
+// GetLogger returns the Logger set with SetLogger, a standard library logger on stderr when none was.
+func GetLogger() Logger {
+	if holder, ok := logger.Load().(*loggerHolder); ok {
+		return holder.logger
+	}
+
+	return defaultLogger
+}
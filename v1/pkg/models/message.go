@@ -1,8 +1,10 @@
 package models
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/streadway/amqp"
@@ -14,6 +16,7 @@ type Notification struct {
 	FailedLetter *Letter
 	Success      bool
 	Error        error
+	RetryCount   uint32 // attempts made before this one
 }
 
 // ToString allows you to quickly log the Notification struct as a string.
@@ -25,12 +28,49 @@ func (not *Notification) ToString() string {
 	return fmt.Sprintf("[LetterID: %d] - Failed.\r\nError: %s\r\n", not.LetterID, not.Error.Error())
 }
 
+// Failure is a Letter that could not be published after every attempt, kept whole so it can be persisted or re-routed.
+type Failure struct {
+	Letter   *Letter
+	Error    error // the last error seen
+	Attempts uint32
+	Time     time.Time
+}
+
+// ToString allows you to quickly log the Failure struct as a string.
+func (fail *Failure) ToString() string {
+	return fmt.Sprintf("[LetterID: %d] - Failed after %d attempt(s).\r\nError: %s\r\n", fail.Letter.LetterID, fail.Attempts, fail.Error.Error())
+}
+
+// Acknowledger receives a Message's ack decisions in place of its channel (i.e. to batch them).
+type Acknowledger interface {
+	Ack(amqpChan *amqp.Channel, deliveryTag uint64) error
+	Nack(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error
+	Reject(amqpChan *amqp.Channel, deliveryTag uint64, requeue bool) error
+}
+
 // Message allow for you to acknowledge, after processing the payload, by its RabbitMQ tag and Channel pointer.
 type Message struct {
-	IsAckable   bool
-	Body        []byte
-	deliveryTag uint64
-	amqpChan    *amqp.Channel
+	IsAckable       bool
+	Body            []byte
+	MessageID       string     // the publisher's message-id property, if any
+	CorrelationID   string     // the publisher's correlation-id property, if any
+	Headers         amqp.Table // the delivery's headers, if any
+	Exchange        string     // the exchange the message was published to
+	RoutingKey      string     // the routing key the message was published with
+	Type            string     // the publisher's type property, if any
+	ContentType     string     // the publisher's content-type property, if any
+	ContentEncoding string     // the publisher's content-encoding property, if any
+	Priority        uint8      // the publisher's priority property, 0 when unset
+	Queue           string     // the queue a Consumer received it from, its settles are audited (see SetAuditor)
+	deliveryTag     uint64
+	amqpChan        *amqp.Channel
+	acker           Acknowledger
+	settled         bool
+	bodySum         uint32         // the Body's checksum when guarded (see GuardBody)
+	onMutated       func(*Message) // nil when unguarded
+	annotations     map[AnnotationKey]interface{}
+	ctx             context.Context
+	annotationLock  *sync.RWMutex
 }
 
 // NewMessage creates a new Message.
@@ -41,11 +81,75 @@ func NewMessage(
 	amqpChan *amqp.Channel) *Message {
 
 	return &Message{
-		IsAckable:   isAckable,
-		Body:        body,
-		deliveryTag: deliveryTag,
-		amqpChan:    amqpChan,
+		IsAckable:      isAckable,
+		Body:           body,
+		deliveryTag:    deliveryTag,
+		amqpChan:       amqpChan,
+		annotationLock: &sync.RWMutex{},
+	}
+}
+
+var messagePool = sync.Pool{
+	New: func() interface{} {
+		return &Message{annotationLock: &sync.RWMutex{}}
+	},
+}
+
+// GetPooledMessage gets a Message from the internal sync.Pool and populates it.
+// The Message must be handed back with ReleaseMessage once it (and its Body) is no longer referenced.
+func GetPooledMessage(
+	isAckable bool,
+	body []byte,
+	deliveryTag uint64,
+	amqpChan *amqp.Channel) *Message {
+
+	msg := messagePool.Get().(*Message)
+	msg.IsAckable = isAckable
+	msg.Body = body
+	msg.deliveryTag = deliveryTag
+	msg.amqpChan = amqpChan
+
+	return msg
+}
+
+// ReleaseMessage clears the Message and returns it to the internal sync.Pool.
+func ReleaseMessage(msg *Message) {
+	if msg == nil {
+		return
 	}
+
+	msg.IsAckable = false
+	msg.Body = nil
+	msg.MessageID = ""
+	msg.CorrelationID = ""
+	msg.Headers = nil
+	msg.Exchange = ""
+	msg.RoutingKey = ""
+	msg.Type = ""
+	msg.ContentType = ""
+	msg.ContentEncoding = ""
+	msg.Priority = 0
+	msg.Queue = ""
+	msg.deliveryTag = 0
+	msg.amqpChan = nil
+	msg.acker = nil
+	msg.settled = false
+	msg.bodySum = 0
+	msg.onMutated = nil
+	msg.annotations = nil
+	msg.ctx = nil
+
+	messagePool.Put(msg)
+}
+
+// SetAcknowledger routes Acknowledge, Nack, and Reject through the Acknowledger instead of the channel.
+func (msg *Message) SetAcknowledger(acker Acknowledger) {
+	msg.acker = acker
+}
+
+// Settled returns true once the Message has been acknowledged, nacked, or rejected.
+func (msg *Message) Settled() bool {
+	return msg.settled
 }
 
 // Acknowledge allows for you to acknowledge message on the original channel it was received.
@@ -56,11 +160,25 @@ func (msg *Message) Acknowledge() error {
 		return errors.New("can't acknowledge, not an ackable message")
 	}
 
-	if msg.amqpChan == nil {
+	if msg.amqpChan == nil && msg.acker == nil {
 		return errors.New("can't acknowledge, internal channel is nil")
 	}
 
-	return msg.amqpChan.Ack(msg.deliveryTag, false)
+	msg.settled = true
+	msg.CheckBody()
+
+	var err error
+	if msg.acker != nil {
+		err = msg.acker.Ack(msg.amqpChan, msg.deliveryTag)
+	} else {
+		err = msg.amqpChan.Ack(msg.deliveryTag, false)
+	}
+
+	if err == nil {
+		msg.audit(AuditAcked, false)
+	}
+
+	return err
 }
 
 // Nack allows for you to negative acknowledge message on the original channel it was received.
@@ -70,11 +188,25 @@ func (msg *Message) Nack(requeue bool) error {
 		return errors.New("can't nack, not an ackable message")
 	}
 
-	if msg.amqpChan == nil {
+	if msg.amqpChan == nil && msg.acker == nil {
 		return errors.New("can't nack, internal channel is nil")
 	}
 
-	return msg.amqpChan.Nack(msg.deliveryTag, false, requeue)
+	msg.settled = true
+	msg.CheckBody()
+
+	var err error
+	if msg.acker != nil {
+		err = msg.acker.Nack(msg.amqpChan, msg.deliveryTag, requeue)
+	} else {
+		err = msg.amqpChan.Nack(msg.deliveryTag, false, requeue)
+	}
+
+	if err == nil {
+		msg.audit(AuditNacked, requeue)
+	}
+
+	return err
 }
 
 // Reject allows for you to reject on the original channel it was received.
@@ -84,11 +216,43 @@ func (msg *Message) Reject(requeue bool) error {
 		return errors.New("can't reject, not an ackable message")
 	}
 
-	if msg.amqpChan == nil {
+	if msg.amqpChan == nil && msg.acker == nil {
 		return errors.New("can't reject, internal channel is nil")
 	}
 
-	return msg.amqpChan.Reject(msg.deliveryTag, requeue)
+	msg.settled = true
+	msg.CheckBody()
+
+	var err error
+	if msg.acker != nil {
+		err = msg.acker.Reject(msg.amqpChan, msg.deliveryTag, requeue)
+	} else {
+		err = msg.amqpChan.Reject(msg.deliveryTag, requeue)
+	}
+
+	if err == nil {
+		msg.audit(AuditRejected, requeue)
+	}
+
+	return err
+}
+
+// Audit records the Message's settle when it was consumed from a queue.
+func (msg *Message) audit(action AuditAction, requeued bool) {
+	if msg.Queue == "" || !Auditing() {
+		return
+	}
+
+	Audit(&AuditRecord{
+		Action:        action,
+		Exchange:      msg.Exchange,
+		RoutingKey:    msg.RoutingKey,
+		Queue:         msg.Queue,
+		MessageID:     msg.MessageID,
+		CorrelationID: msg.CorrelationID,
+		DeliveryTag:   msg.deliveryTag,
+		Requeued:      requeued,
+	})
 }
 
 // ErrorMessage allow for you to replay a message that was returned.
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Names of the metrics the library records (see SetMetrics), with the labels each one carries.
+const (
+	MetricPublishes          = "tcr_publishes_total"               // counter of publish attempts, labels: exchange, result (success, failure)
+	MetricQueuedLetters      = "tcr_queued_letters"                // gauge, letters queued for AutoPublish
+	MetricSpooledLetters     = "tcr_spooled_letters"               // gauge, letters spooled to disk
+	MetricDeliveries         = "tcr_deliveries_total"              // counter, labels: queue
+	MetricSettles            = "tcr_settles_total"                 // counter, labels: queue, outcome (ack, nack, reject)
+	MetricHandlerSeconds     = "tcr_handler_seconds"               // histogram, labels: queue, result (success, failure)
+	MetricChannelWait        = "tcr_channel_wait_seconds"          // histogram, time to get a channel from a ChannelPool
+	MetricEvents             = "tcr_events_total"                  // counter, labels: type (see EventType)
+	MetricDroppedErrors      = "tcr_dropped_errors_total"          // counter, labels: source (consumer, channelpool, connectionpool)
+	MetricBufferSeconds      = "tcr_buffer_seconds"                // histogram, time a Message sat in a Consumer's buffer before it was read, labels: queue
+	MetricBufferAgeHighWater = "tcr_buffer_age_high_water_seconds" // gauge, the longest a Message sat in a Consumer's buffer in the last 100ms, labels: queue
+)
+
+// Labels are a metric's dimensions. A metric is always recorded with the same label names.
+type Labels map[string]string
+
+// Metrics receives the library's instrumentation, adapt it to any metrics system (see the metrics package
+// for Prometheus, OpenTelemetry, and statsd). Calls come from hot paths and must not block.
+type Metrics interface {
+	// Counter adds the delta to a monotonically increasing counter.
+	Counter(name string, delta float64, labels Labels)
+	// Gauge sets a value that goes up and down.
+	Gauge(name string, value float64, labels Labels)
+	// Histogram observes a value into a distribution, durations are in seconds.
+	Histogram(name string, value float64, labels Labels)
+}
+
+// NoopMetrics discards everything, it's used until SetMetrics is called.
+type NoopMetrics struct{}
+
+// Counter does nothing.
+func (NoopMetrics) Counter(name string, delta float64, labels Labels) {}
+
+// Gauge does nothing.
+func (NoopMetrics) Gauge(name string, value float64, labels Labels) {}
+
+// Histogram does nothing.
+func (NoopMetrics) Histogram(name string, value float64, labels Labels) {}
+
+type metricsHolder struct {
+	metrics Metrics
+}
+
+var metrics atomic.Value
+
+// SetMetrics sets where every pool, Consumer, and Publisher records metrics, nil discards them.
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = NoopMetrics{}
+	}
+
+	metrics.Store(&metricsHolder{metrics: m})
+}
+
+// GetMetrics returns the Metrics set with SetMetrics, NoopMetrics when none was.
+func GetMetrics() Metrics {
+	if holder, ok := metrics.Load().(*metricsHolder); ok {
+		return holder.metrics
+	}
+
+	return NoopMetrics{}
+}
+
+// ObserveSince records the seconds since start to a histogram.
+func ObserveSince(name string, start time.Time, labels Labels) {
+	GetMetrics().Histogram(name, time.Since(start).Seconds(), labels)
+}
+
+// CountEvent counts the event by type.
+func CountEvent(event *Event) {
+	GetMetrics().Counter(MetricEvents, 1, Labels{"type": event.Type.String()})
+}
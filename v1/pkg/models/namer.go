@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Namer generates every name the library makes up: connection names, consumer tags, and temporary
+// (i.e. test fixture) names, so deployments can encode pod, region, version, etc. consistently.
+// Set it once at startup with SetNamer, before creating pools or services.
+type Namer interface {
+	// ConnectionName names a pooled connection from the configured ConnectionName and the connection's ID.
+	ConnectionName(base string, connectionID uint64) string
+	// ConsumerTag names a consumer from its configured ConsumerName and the queue it consumes.
+	ConsumerTag(base string, queueName string) string
+	// TemporaryName returns a unique name (or prefix) for short lived entities.
+	TemporaryName(base string) string
+}
+
+var (
+	namer       Namer = &DefaultNamer{}
+	namerLock         = &sync.RWMutex{}
+	temporaryID uint64
+)
+
+// SetNamer replaces the Namer used everywhere, nil restores the DefaultNamer.
+func SetNamer(newNamer Namer) {
+	namerLock.Lock()
+	defer namerLock.Unlock()
+
+	if newNamer == nil {
+		newNamer = &DefaultNamer{}
+	}
+
+	namer = newNamer
+}
+
+// GetNamer returns the Namer in use.
+func GetNamer() Namer {
+	namerLock.RLock()
+	defer namerLock.RUnlock()
+
+	return namer
+}
+
+// DefaultNamer generates the names the library has always used.
+type DefaultNamer struct{}
+
+// ConnectionName returns base-id.
+func (dn *DefaultNamer) ConnectionName(base string, connectionID uint64) string {
+	return base + "-" + strconv.FormatUint(connectionID, 10)
+}
+
+// ConsumerTag returns hostname-base, or base when the hostname isn't available.
+func (dn *DefaultNamer) ConsumerTag(base string, queueName string) string {
+	hostName, err := os.Hostname()
+	if err != nil {
+		return base
+	}
+
+	return hostName + "-" + base
+}
+
+// TemporaryName returns base followed by the pid, time, and a counter, i.e. tcr-123-16a0b4c5d6e7f890-1.
+func (dn *DefaultNamer) TemporaryName(base string) string {
+	return fmt.Sprintf("%s-%d-%x-%d", base, os.Getpid(), time.Now().UnixNano(), atomic.AddUint64(&temporaryID, 1))
+}
+
+// StructuredNamer prefixes every name with the non-empty fields in a fixed order (Service, Region, Pod, Version),
+// joined by the Separator (defaults to "."), i.e. orders.eu-west-1.orders-7d9f.v2.TurboCookedRabbit-0.
+type StructuredNamer struct {
+	Service   string
+	Region    string
+	Pod       string // i.e. the pod or host name
+	Version   string
+	Separator string
+}
+
+func (sn *StructuredNamer) name(base string) string {
+	separator := sn.Separator
+	if separator == "" {
+		separator = "."
+	}
+
+	parts := make([]string, 0, 5)
+	for _, part := range []string{sn.Service, sn.Region, sn.Pod, sn.Version, base} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, separator)
+}
+
+// ConnectionName returns the structured base-id.
+func (sn *StructuredNamer) ConnectionName(base string, connectionID uint64) string {
+	return sn.name(base + "-" + strconv.FormatUint(connectionID, 10))
+}
+
+// ConsumerTag returns the structured base, or the structured queue name when base is empty.
+func (sn *StructuredNamer) ConsumerTag(base string, queueName string) string {
+	if base == "" {
+		base = queueName
+	}
+
+	return sn.name(base)
+}
+
+// TemporaryName returns the structured base followed by the time and a counter.
+func (sn *StructuredNamer) TemporaryName(base string) string {
+	return sn.name(fmt.Sprintf("%s-%x-%d", base, time.Now().UnixNano(), atomic.AddUint64(&temporaryID, 1)))
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NamingRule is what a name must look like, it passes when it has one of the Prefixes (if any) and matches
+// the Pattern (if any).
+type NamingRule struct {
+	Prefixes []string `json:"Prefixes,omitempty"`
+	Pattern  string   `json:"Pattern,omitempty"` // regex, i.e. ^orders\.[a-z0-9.-]+$
+}
+
+// NamingPolicyConfig holds the naming rules per entity type, a nil rule allows any name.
+type NamingPolicyConfig struct {
+	Exchanges *NamingRule `json:"Exchanges,omitempty"`
+	Queues    *NamingRule `json:"Queues,omitempty"`
+	WarnOnly  bool        `json:"WarnOnly"` // report violations (see NamingPolicy.OnViolation) without rejecting them
+}
+
+// NamingViolation is a name that breaks the NamingPolicy.
+type NamingViolation struct {
+	Kind   string // "exchange" or "queue"
+	Name   string
+	Reason string
+}
+
+func (nv *NamingViolation) Error() string {
+	return fmt.Sprintf("%s name %q violates the naming policy: %s", nv.Kind, nv.Name, nv.Reason)
+}
+
+type namingRule struct {
+	prefixes []string
+	pattern  *regexp.Regexp
+}
+
+// NamingPolicy keeps declared (and published to) exchanges and queues inside a namespace on a shared broker.
+// The default exchange, server named queues, and amq.* names are always allowed. A nil NamingPolicy allows everything.
+type NamingPolicy struct {
+	exchanges   *namingRule
+	queues      *namingRule
+	warnOnly    bool
+	onViolation func(*NamingViolation)
+}
+
+// NewNamingPolicy compiles the NamingPolicyConfig.
+func NewNamingPolicy(config *NamingPolicyConfig) (*NamingPolicy, error) {
+
+	exchanges, err := compileNamingRule(config.Exchanges)
+	if err != nil {
+		return nil, fmt.Errorf("exchange naming rule: %w", err)
+	}
+
+	queues, err := compileNamingRule(config.Queues)
+	if err != nil {
+		return nil, fmt.Errorf("queue naming rule: %w", err)
+	}
+
+	return &NamingPolicy{
+		exchanges: exchanges,
+		queues:    queues,
+		warnOnly:  config.WarnOnly,
+	}, nil
+}
+
+func compileNamingRule(rule *NamingRule) (*namingRule, error) {
+	if rule == nil {
+		return nil, nil
+	}
+
+	compiled := &namingRule{prefixes: rule.Prefixes}
+	if rule.Pattern != "" {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		compiled.pattern = pattern
+	}
+
+	return compiled, nil
+}
+
+// OnViolation sets a callback for every violation (rejected or not), i.e. for logging in WarnOnly mode.
+// Must be set before the NamingPolicy is in use.
+func (np *NamingPolicy) OnViolation(onViolation func(*NamingViolation)) {
+	np.onViolation = onViolation
+}
+
+// CheckExchange returns a *NamingViolation when the exchange name breaks the policy, nil when WarnOnly.
+func (np *NamingPolicy) CheckExchange(name string) error {
+	if np == nil {
+		return nil
+	}
+
+	return np.check("exchange", np.exchanges, name)
+}
+
+// CheckQueue returns a *NamingViolation when the queue name breaks the policy, nil when WarnOnly.
+func (np *NamingPolicy) CheckQueue(name string) error {
+	if np == nil {
+		return nil
+	}
+
+	return np.check("queue", np.queues, name)
+}
+
+func (np *NamingPolicy) check(kind string, rule *namingRule, name string) error {
+	if rule == nil || name == "" || strings.HasPrefix(name, "amq.") {
+		return nil
+	}
+
+	reason := rule.violation(name)
+	if reason == "" {
+		return nil
+	}
+
+	violation := &NamingViolation{Kind: kind, Name: name, Reason: reason}
+	if np.onViolation != nil {
+		np.onViolation(violation)
+	}
+
+	if np.warnOnly {
+		return nil
+	}
+
+	return violation
+}
+
+// Violation returns why the name breaks the rule, or empty.
+func (nr *namingRule) violation(name string) string {
+	if len(nr.prefixes) > 0 {
+		prefixed := false
+		for _, prefix := range nr.prefixes {
+			if strings.HasPrefix(name, prefix) {
+				prefixed = true
+				break
+			}
+		}
+
+		if !prefixed {
+			return fmt.Sprintf("must start with one of %s", strings.Join(nr.prefixes, ", "))
+		}
+	}
+
+	if nr.pattern != nil && !nr.pattern.MatchString(name) {
+		return fmt.Sprintf("must match %s", nr.pattern)
+	}
+
+	return ""
+}
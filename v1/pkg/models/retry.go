@@ -0,0 +1,61 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// RetryState is a snapshot of something retrying after failures, i.e. to answer "when will it try again?".
+type RetryState struct {
+	Retrying      bool   // true from the first failure until the next success
+	Attempts      uint64 // consecutive failed attempts
+	LastError     error  // the most recent failure, kept after recovering
+	LastErrorTime time.Time
+	NextRetry     time.Time // when the next attempt is due, zero when not retrying
+	LastSuccess   time.Time
+}
+
+// RetryTracker records attempts and failures so their RetryState can be read from other goroutines.
+type RetryTracker struct {
+	state     RetryState
+	retryLock *sync.Mutex
+}
+
+// NewRetryTracker creates a new RetryTracker.
+func NewRetryTracker() *RetryTracker {
+	return &RetryTracker{
+		retryLock: &sync.Mutex{},
+	}
+}
+
+// Failed records a failed attempt, the next attempt is due after the backoff.
+func (rt *RetryTracker) Failed(err error, backoff time.Duration) {
+	rt.retryLock.Lock()
+	defer rt.retryLock.Unlock()
+
+	now := time.Now()
+	rt.state.Retrying = true
+	rt.state.Attempts++
+	rt.state.LastError = err
+	rt.state.LastErrorTime = now
+	rt.state.NextRetry = now.Add(backoff)
+}
+
+// Succeeded records a successful attempt, ending the retrying.
+func (rt *RetryTracker) Succeeded() {
+	rt.retryLock.Lock()
+	defer rt.retryLock.Unlock()
+
+	rt.state.Retrying = false
+	rt.state.Attempts = 0
+	rt.state.NextRetry = time.Time{}
+	rt.state.LastSuccess = time.Now()
+}
+
+// State returns a snapshot of the RetryState.
+func (rt *RetryTracker) State() RetryState {
+	rt.retryLock.Lock()
+	defer rt.retryLock.Unlock()
+
+	return rt.state
+}
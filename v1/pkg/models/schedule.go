@@ -0,0 +1,190 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule yields the times a scheduled publish runs at.
+type Schedule interface {
+	// Next returns the first run after the time.
+	Next(after time.Time) time.Time
+}
+
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (es *everySchedule) Next(after time.Time) time.Time {
+	return after.Add(es.interval)
+}
+
+// CronSchedule is a five field cron expression: minute, hour, day of month, month, and day of week, each a bit set.
+type cronSchedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	anyDay   bool // day of month is *
+	anyWeek  bool // day of week is *
+}
+
+var scheduleShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSchedule parses a cron expression (minute hour day-of-month month day-of-week, with *, lists, ranges,
+// and steps, i.e. */15 9-17 * * 1-5), a shorthand (@hourly, @daily, @weekly, @monthly, @yearly), or a fixed
+// interval (@every 30s). Cron times are in the local time zone of the time handed to Next.
+func ParseSchedule(spec string) (Schedule, error) {
+
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("can't parse schedule %q: %w", spec, err)
+		}
+
+		if interval < time.Second {
+			return nil, fmt.Errorf("can't parse schedule %q: intervals under 1s aren't supported", spec)
+		}
+
+		return &everySchedule{interval: interval}, nil
+	}
+
+	if expanded, ok := scheduleShorthands[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("can't parse schedule %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	cron := &cronSchedule{anyDay: fields[2] == "*", anyWeek: fields[4] == "*"}
+	bounds := []struct {
+		set      *uint64
+		min, max int
+	}{
+		{&cron.minutes, 0, 59},
+		{&cron.hours, 0, 23},
+		{&cron.days, 1, 31},
+		{&cron.months, 1, 12},
+		{&cron.weekdays, 0, 7},
+	}
+
+	for i, bound := range bounds {
+		set, err := parseCronField(fields[i], bound.min, bound.max)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse schedule %q: %w", spec, err)
+		}
+
+		*bound.set = set
+	}
+
+	if cron.weekdays&(1<<7) != 0 { // 7 is Sunday too
+		cron.weekdays |= 1
+	}
+
+	return cron, nil
+}
+
+// ParseCronField parses one field into a bit set, i.e. 1-5, */15, or 0,30.
+func parseCronField(field string, min, max int) (uint64, error) {
+
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			parsed, err := strconv.Atoi(part[slash+1:])
+			if err != nil || parsed <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+
+			step, part = parsed, part[:slash]
+		}
+
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value in %q", field)
+			}
+
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad value in %q", field)
+				}
+			} else if step > 1 {
+				high = max // 5/15 runs from 5 to the end
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+
+	if set == 0 {
+		return 0, errors.New("empty field")
+	}
+
+	return set, nil
+}
+
+func (cs *cronSchedule) Next(after time.Time) time.Time {
+
+	next := after.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(5, 0, 0) // an impossible date (i.e. February 30th) never matches
+
+	for next.Before(limit) {
+		switch {
+		case cs.months&(1<<uint(next.Month())) == 0:
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+		case !cs.dayMatches(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+		case cs.hours&(1<<uint(next.Hour())) == 0:
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+		case cs.minutes&(1<<uint(next.Minute())) == 0:
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+
+	return time.Time{}
+}
+
+// DayMatches applies cron's day rule: with both day fields restricted, either one matching is enough.
+func (cs *cronSchedule) dayMatches(day time.Time) bool {
+	inMonth := cs.days&(1<<uint(day.Day())) != 0
+	inWeek := cs.weekdays&(1<<uint(day.Weekday())) != 0
+
+	switch {
+	case cs.anyDay && cs.anyWeek:
+		return true
+	case cs.anyDay:
+		return inWeek
+	case cs.anyWeek:
+		return inMonth
+	}
+
+	return inMonth || inWeek
+}
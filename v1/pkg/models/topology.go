@@ -1,6 +1,24 @@
 package models
 
-import "github.com/streadway/amqp"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// ConsistentHashExchangeType is the exchange type provided by the rabbitmq_consistent_hash_exchange plugin.
+	ConsistentHashExchangeType = "x-consistent-hash"
+
+	// HashPropertyMessageID hashes a ConsistentHashExchange on the message_id property.
+	HashPropertyMessageID = "message_id"
+	// HashPropertyCorrelationID hashes a ConsistentHashExchange on the correlation_id property.
+	HashPropertyCorrelationID = "correlation_id"
+
+	// QueueExpiresArg is the queue argument for how long (ms) a queue can go unused before the server deletes it.
+	QueueExpiresArg = "x-expires"
+)
 
 // Exchange allows for you to create Exchange topology.
 type Exchange struct {
@@ -22,7 +40,24 @@ type Queue struct {
 	AutoDelete     bool       `json:"AutoDelete"`
 	Exclusive      bool       `json:"Exclusive"`
 	NoWait         bool       `json:"NoWait"`
-	Args           amqp.Table `json:"Args,omitempty"` // map[string]interface()
+	Expires        uint32     `json:"Expires,omitempty"` // ms unused (no consumers, gets, or redeclares) before the server deletes the queue, sent as x-expires
+	Args           amqp.Table `json:"Args,omitempty"`    // map[string]interface()
+}
+
+// Arguments returns the queue's declare arguments, the Args with the typed fields (Expires) applied over them.
+func (q *Queue) Arguments() amqp.Table {
+	if q.Expires == 0 {
+		return q.Args
+	}
+
+	args := make(amqp.Table, len(q.Args)+1)
+	for key, value := range q.Args {
+		args[key] = value
+	}
+
+	args[QueueExpiresArg] = int64(q.Expires)
+
+	return args
 }
 
 // QueueBinding allows for you to create Bindings between a Queue and Exchange.
@@ -42,3 +77,224 @@ type ExchangeBinding struct {
 	NoWait             bool       `json:"NoWait"`
 	Args               amqp.Table `json:"Args,omitempty"` // map[string]interface()
 }
+
+// ConsistentHashExchange allows for you to create an x-consistent-hash Exchange (plugin) with weighted Queue bindings.
+// Messages are hashed on the routing key unless HashHeader or HashProperty is set.
+type ConsistentHashExchange struct {
+	Name         string             `json:"Name"`
+	Durable      bool               `json:"Durable"`
+	AutoDelete   bool               `json:"AutoDelete"`
+	HashHeader   string             `json:"HashHeader,omitempty"`   // hash on this header's value
+	HashProperty string             `json:"HashProperty,omitempty"` // hash on this property: "message_id" or "correlation_id"
+	Bindings     []*WeightedBinding `json:"Bindings"`
+}
+
+// Validate checks for the misconfigurations that skew the hash space (duplicate or zero weight bindings).
+func (che *ConsistentHashExchange) Validate() error {
+
+	if che.Name == "" {
+		return errors.New("consistent hash exchange name can't be empty")
+	}
+
+	if che.HashHeader != "" && che.HashProperty != "" {
+		return fmt.Errorf("consistent hash exchange %s can't hash on both a header and a property", che.Name)
+	}
+
+	if che.HashProperty != "" && che.HashProperty != HashPropertyMessageID && che.HashProperty != HashPropertyCorrelationID {
+		return fmt.Errorf("consistent hash exchange %s has an unsupported hash property: %s", che.Name, che.HashProperty)
+	}
+
+	if len(che.Bindings) == 0 {
+		return fmt.Errorf("consistent hash exchange %s has no bindings", che.Name)
+	}
+
+	bound := make(map[string]bool)
+	for _, binding := range che.Bindings {
+		if binding.QueueName == "" {
+			return fmt.Errorf("consistent hash exchange %s has a binding without a queue name", che.Name)
+		}
+
+		if binding.Weight == 0 {
+			return fmt.Errorf("consistent hash exchange %s binding to %s can't have a weight of 0", che.Name, binding.QueueName)
+		}
+
+		if bound[binding.QueueName] {
+			return fmt.Errorf("consistent hash exchange %s binds %s more than once", che.Name, binding.QueueName)
+		}
+
+		bound[binding.QueueName] = true
+	}
+
+	return nil
+}
+
+// Args builds the exchange arguments selecting what gets hashed.
+func (che *ConsistentHashExchange) Args() amqp.Table {
+	args := amqp.Table{}
+
+	if che.HashHeader != "" {
+		args["hash-header"] = che.HashHeader
+	}
+
+	if che.HashProperty != "" {
+		args["hash-property"] = che.HashProperty
+	}
+
+	return args
+}
+
+// WeightedBinding binds a Queue to a ConsistentHashExchange, a Queue receives a share of the hash space proportional to its Weight.
+type WeightedBinding struct {
+	QueueName string `json:"QueueName"`
+	Weight    uint32 `json:"Weight"`
+}
+
+// Policy allows for you to create RabbitMQ policies through the management API.
+type Policy struct {
+	Name       string            `json:"Name"`
+	VHost      string            `json:"VHost,omitempty"` // defaults to the management config VHost
+	Pattern    string            `json:"Pattern"`         // regex matched against queue/exchange names
+	ApplyTo    string            `json:"ApplyTo"`         // "queues", "exchanges", "all"
+	Priority   int               `json:"Priority"`
+	Definition *PolicyDefinition `json:"Definition"`
+}
+
+// PolicyDefinition holds the typed policy keys, Args covers any key not typed here.
+type PolicyDefinition struct {
+	HAMode                string                 `json:"HAMode,omitempty"` // "all", "exactly", "nodes"
+	HAParams              interface{}            `json:"HAParams,omitempty"`
+	HASyncMode            string                 `json:"HASyncMode,omitempty"` // "manual", "automatic"
+	MessageTTL            uint32                 `json:"MessageTTL,omitempty"` // ms
+	Expires               uint32                 `json:"Expires,omitempty"`    // ms
+	MaxLength             uint32                 `json:"MaxLength,omitempty"`
+	MaxLengthBytes        uint64                 `json:"MaxLengthBytes,omitempty"`
+	Overflow              string                 `json:"Overflow,omitempty"` // "drop-head", "reject-publish", "reject-publish-dlx"
+	DeadLetterExchange    string                 `json:"DeadLetterExchange,omitempty"`
+	DeadLetterRoutingKey  string                 `json:"DeadLetterRoutingKey,omitempty"`
+	FederationUpstream    string                 `json:"FederationUpstream,omitempty"`
+	FederationUpstreamSet string                 `json:"FederationUpstreamSet,omitempty"`
+	Args                  map[string]interface{} `json:"Args,omitempty"`
+}
+
+// ToTable converts the PolicyDefinition into the key/values RabbitMQ expects.
+func (pd *PolicyDefinition) ToTable() map[string]interface{} {
+	table := make(map[string]interface{})
+	for key, value := range pd.Args {
+		table[key] = value
+	}
+
+	if pd.HAMode != "" {
+		table["ha-mode"] = pd.HAMode
+	}
+
+	if pd.HAParams != nil {
+		table["ha-params"] = pd.HAParams
+	}
+
+	if pd.HASyncMode != "" {
+		table["ha-sync-mode"] = pd.HASyncMode
+	}
+
+	if pd.MessageTTL > 0 {
+		table["message-ttl"] = pd.MessageTTL
+	}
+
+	if pd.Expires > 0 {
+		table["expires"] = pd.Expires
+	}
+
+	if pd.MaxLength > 0 {
+		table["max-length"] = pd.MaxLength
+	}
+
+	if pd.MaxLengthBytes > 0 {
+		table["max-length-bytes"] = pd.MaxLengthBytes
+	}
+
+	if pd.Overflow != "" {
+		table["overflow"] = pd.Overflow
+	}
+
+	if pd.DeadLetterExchange != "" {
+		table["dead-letter-exchange"] = pd.DeadLetterExchange
+	}
+
+	if pd.DeadLetterRoutingKey != "" {
+		table["dead-letter-routing-key"] = pd.DeadLetterRoutingKey
+	}
+
+	if pd.FederationUpstream != "" {
+		table["federation-upstream"] = pd.FederationUpstream
+	}
+
+	if pd.FederationUpstreamSet != "" {
+		table["federation-upstream-set"] = pd.FederationUpstreamSet
+	}
+
+	return table
+}
+
+// Parameter allows for you to create RabbitMQ runtime parameters (i.e. federation upstreams, shovels) through the management API.
+type Parameter struct {
+	Component string                 `json:"Component"` // i.e. "federation-upstream", "shovel"
+	VHost     string                 `json:"VHost,omitempty"`
+	Name      string                 `json:"Name"`
+	Value     map[string]interface{} `json:"Value"`
+}
+
+// QueueInfo is a queue's state as reported by the management API.
+type QueueInfo struct {
+	Name                   string `json:"name"`
+	VHost                  string `json:"vhost"`
+	Type                   string `json:"type"` // "classic", "quorum", "stream"
+	Durable                bool   `json:"durable"`
+	Messages               int64  `json:"messages"`
+	MessagesReady          int64  `json:"messages_ready"`
+	MessagesUnacknowledged int64  `json:"messages_unacknowledged"`
+	Consumers              int64  `json:"consumers"`
+	Node                   string `json:"node"`             // the classic queue's master node
+	Leader                 string `json:"leader,omitempty"` // the quorum queue's or stream's leader node
+
+	Arguments                 map[string]interface{} `json:"arguments,omitempty"`
+	EffectivePolicyDefinition map[string]interface{} `json:"effective_policy_definition,omitempty"`
+}
+
+// DeadLetterExchange returns the queue's dead letter exchange from its arguments or effective policy.
+func (qi *QueueInfo) DeadLetterExchange() (string, bool) {
+	if dlx, ok := qi.Arguments["x-dead-letter-exchange"].(string); ok {
+		return dlx, true
+	}
+
+	if dlx, ok := qi.EffectivePolicyDefinition["dead-letter-exchange"].(string); ok {
+		return dlx, true
+	}
+
+	return "", false
+}
+
+// HostNode returns the cluster node hosting the queue, its leader for quorum queues and streams, its master
+// otherwise.
+func (qi *QueueInfo) HostNode() string {
+	if qi.Leader != "" {
+		return qi.Leader
+	}
+
+	return qi.Node
+}
+
+// ListenerInfo is a node's protocol listener as reported by the management API.
+type ListenerInfo struct {
+	Node      string `json:"node"`
+	Protocol  string `json:"protocol"`   // "amqp", "amqp/ssl", "http", ...
+	IPAddress string `json:"ip_address"` // "::" or "0.0.0.0" when listening on every interface
+	Port      int    `json:"port"`
+}
+
+// BindingInfo is a binding as reported by the management API.
+type BindingInfo struct {
+	Source          string `json:"source"`
+	VHost           string `json:"vhost"`
+	Destination     string `json:"destination"`
+	DestinationType string `json:"destination_type"` // "queue", "exchange"
+	RoutingKey      string `json:"routing_key"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var tracing int32
+
+// SetTracing turns developer tracing on or off at runtime: every AMQP operation the library performs (declares,
+// binds, consumes, publishes, acks, qos, channel opens and closes) is logged through the Logger with how long it
+// took and its connection, channel, and delivery identifiers. It's verbose, leave it off in production.
+func SetTracing(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&tracing, 1)
+	} else {
+		atomic.StoreInt32(&tracing, 0)
+	}
+}
+
+// Tracing returns whether SetTracing turned tracing on, check it before building an expensive Trace detail.
+func Tracing() bool {
+	return atomic.LoadInt32(&tracing) == 1
+}
+
+// Trace logs an AMQP operation started at started when tracing, the detail identifies what it operated on
+// (formatted with args) and a failed operation's error is logged with it.
+func Trace(operation string, connectionID, channelID uint64, started time.Time, err error, detail string, args ...interface{}) {
+	if !Tracing() {
+		return
+	}
+
+	outcome := "ok"
+	if err != nil {
+		outcome = "failed: " + err.Error()
+	}
+
+	GetLogger().Printf(
+		"trace %s [connection: %d, channel: %d] %s took %s %s",
+		operation,
+		connectionID,
+		channelID,
+		fmt.Sprintf(detail, args...),
+		time.Since(started),
+		outcome)
+}
@@ -0,0 +1,493 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ConfigError is a single configuration violation, Field is the path to the offending value.
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (ce *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", ce.Field, ce.Reason)
+}
+
+// ConfigErrors is every violation found while validating a configuration.
+type ConfigErrors []*ConfigError
+
+func (ce ConfigErrors) Error() string {
+	reasons := make([]string, len(ce))
+	for i, err := range ce {
+		reasons[i] = err.Error()
+	}
+
+	return fmt.Sprintf("invalid configuration (%d violations):\r\n%s", len(ce), strings.Join(reasons, "\r\n"))
+}
+
+func (ce *ConfigErrors) add(field string, reason string, args ...interface{}) {
+	*ce = append(*ce, &ConfigError{Field: field, Reason: fmt.Sprintf(reason, args...)})
+}
+
+// Validate checks the whole RabbitSeasoning and returns every violation at once as ConfigErrors, or nil.
+func (rs *RabbitSeasoning) Validate() error {
+	var errs ConfigErrors
+
+	if rs.ServiceConfig != nil {
+		if rs.ServiceConfig.ErrorBuffer == 0 {
+			errs.add("ServiceConfig.ErrorBuffer", "can't be 0")
+		}
+
+		switch rs.ServiceConfig.ErrorDelivery {
+		case "", ErrorDeliveryImmediateName, ErrorDeliveryDeferredName:
+		default:
+			errs.add("ServiceConfig.ErrorDelivery", "must be %s or %s", ErrorDeliveryImmediateName, ErrorDeliveryDeferredName)
+		}
+
+		for name, dependsOn := range rs.ServiceConfig.ShutdownDependencies {
+			for _, dependency := range dependsOn {
+				if dependency == name {
+					errs.add("ServiceConfig.ShutdownDependencies."+name, "can't depend on itself")
+				}
+			}
+		}
+
+		for i, name := range rs.ServiceConfig.ShutdownSignals {
+			switch name {
+			case "SIGTERM", "SIGINT", "SIGHUP", "SIGQUIT":
+			default:
+				errs.add(fmt.Sprintf("ServiceConfig.ShutdownSignals[%d]", i), "must be SIGTERM, SIGINT, SIGHUP, or SIGQUIT, not %s", name)
+			}
+		}
+
+		if rs.ServiceConfig.QueueLocality && rs.ManagementConfig == nil {
+			errs.add("ServiceConfig.QueueLocality", "requires a ManagementConfig")
+		}
+
+		for node, address := range rs.ServiceConfig.NodeAddresses {
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				errs.add("ServiceConfig.NodeAddresses", "%s isn't a host:port address for node %s", address, node)
+			}
+		}
+	}
+
+	if rs.PoolConfig == nil {
+		errs.add("PoolConfig", "is required")
+	} else {
+		rs.PoolConfig.validate("PoolConfig", &errs)
+		rs.validatePoolSizing("PoolConfig", rs.PoolConfig, "", &errs)
+	}
+
+	targets := make([]string, 0, len(rs.Targets))
+	for name := range rs.Targets {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+
+	for _, name := range targets {
+		field := fmt.Sprintf("Targets[%s]", name)
+		switch target := rs.Targets[name]; {
+		case name == "":
+			errs.add(field, "needs a name")
+		case target == nil:
+			errs.add(field, "is nil")
+		case target.PoolConfig == nil:
+			errs.add(field+".PoolConfig", "is required")
+		default:
+			target.PoolConfig.validate(field+".PoolConfig", &errs)
+			rs.validatePoolSizing(field+".PoolConfig", target.PoolConfig, name, &errs)
+		}
+	}
+
+	names := make([]string, 0, len(rs.ConsumerConfigs))
+	for name := range rs.ConsumerConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names) // stable output
+
+	for _, name := range names {
+		field := fmt.Sprintf("ConsumerConfigs[%s]", name)
+		if rs.ConsumerConfigs[name] == nil {
+			errs.add(field, "is nil")
+			continue
+		}
+
+		rs.ConsumerConfigs[name].validate(field, &errs)
+
+		if target := rs.ConsumerConfigs[name].Target; target != "" && rs.Targets[target] == nil {
+			errs.add(field+".Target", "%s isn't one of the Targets", target)
+		}
+	}
+
+	if rs.PublisherConfig != nil {
+		exchanges := make([]string, 0, len(rs.PublisherConfig.ExchangeDefaults))
+		for exchange := range rs.PublisherConfig.ExchangeDefaults {
+			exchanges = append(exchanges, exchange)
+		}
+		sort.Strings(exchanges)
+
+		for _, exchange := range exchanges {
+			if defaults := rs.PublisherConfig.ExchangeDefaults[exchange]; defaults != nil && defaults.DeliveryMode > 2 {
+				errs.add(
+					fmt.Sprintf("PublisherConfig.ExchangeDefaults[%s].DeliveryMode", exchange),
+					"must be 0 (unset), 1 (transient), or 2 (persistent)")
+			}
+		}
+
+		exchanges = exchanges[:0]
+		for exchange := range rs.PublisherConfig.PublishPolicies {
+			exchanges = append(exchanges, exchange)
+		}
+		sort.Strings(exchanges)
+
+		for _, exchange := range exchanges {
+			rs.PublisherConfig.PublishPolicies[exchange].validate(fmt.Sprintf("PublisherConfig.PublishPolicies[%s]", exchange), &errs)
+		}
+
+		exchanges = exchanges[:0]
+		for exchange := range rs.PublisherConfig.Fallbacks {
+			exchanges = append(exchanges, exchange)
+		}
+		sort.Strings(exchanges)
+
+		for _, exchange := range exchanges {
+			for i, fallback := range rs.PublisherConfig.Fallbacks[exchange] {
+				fallback.validate(fmt.Sprintf("PublisherConfig.Fallbacks[%s][%d]", exchange, i), exchange, &errs)
+			}
+		}
+
+		scheduled := make(map[string]bool, len(rs.PublisherConfig.Schedules))
+		for i, schedule := range rs.PublisherConfig.Schedules {
+			field := fmt.Sprintf("PublisherConfig.Schedules[%d]", i)
+			switch {
+			case schedule == nil:
+				errs.add(field, "can't be null")
+				continue
+			case schedule.Name == "":
+				errs.add(field+".Name", "is required")
+			case scheduled[schedule.Name]:
+				errs.add(field+".Name", "%s is already scheduled", schedule.Name)
+			}
+			scheduled[schedule.Name] = true
+
+			if _, err := ParseSchedule(schedule.Schedule); err != nil {
+				errs.add(field+".Schedule", "%s", err)
+			}
+		}
+
+		if rs.PublisherConfig.VerifyTTLDeadLetter && rs.ManagementConfig == nil {
+			errs.add("PublisherConfig.VerifyTTLDeadLetter", "requires a ManagementConfig")
+		}
+
+		if rs.PublisherConfig.SpoolDir != "" && rs.PublisherConfig.LetterBuffer == 0 {
+			errs.add("PublisherConfig.SpoolDir", "requires a LetterBuffer to overflow from")
+		}
+
+		if rs.PublisherConfig.SpoolMaxBytes != 0 && rs.PublisherConfig.SpoolMaxBytes < rs.PublisherConfig.SpoolSegmentSize {
+			errs.add("PublisherConfig.SpoolMaxBytes", "%d is smaller than the SpoolSegmentSize", rs.PublisherConfig.SpoolMaxBytes)
+		}
+	}
+
+	if rs.EncryptionConfig != nil && rs.EncryptionConfig.Enabled {
+		if rs.EncryptionConfig.Type != "" && rs.EncryptionConfig.Type != "aes" {
+			errs.add("EncryptionConfig.Type", "unsupported encryption type %q, expected \"aes\"", rs.EncryptionConfig.Type)
+		}
+
+		if n := len(rs.EncryptionConfig.Hashkey); n != 0 && n != 16 && n != 24 && n != 32 {
+			errs.add("EncryptionConfig.Hashkey", "must be 16, 24, or 32 bytes for aes, got %d", n)
+		}
+	}
+
+	if rs.CompressionConfig != nil && rs.CompressionConfig.Enabled {
+		if rs.CompressionConfig.Type != "" && rs.CompressionConfig.Type != "gzip" && rs.CompressionConfig.Type != "zstd" {
+			errs.add("CompressionConfig.Type", "unsupported compression type %q, expected \"gzip\" or \"zstd\"", rs.CompressionConfig.Type)
+		}
+	}
+
+	if rs.NamingPolicy != nil {
+		rs.NamingPolicy.Exchanges.validate("NamingPolicy.Exchanges", &errs)
+		rs.NamingPolicy.Queues.validate("NamingPolicy.Queues", &errs)
+	}
+
+	if rs.ChaosConfig != nil && rs.ChaosConfig.Enabled {
+		probabilities := map[string]float64{
+			"CloseChannel":   rs.ChaosConfig.CloseChannel,
+			"ForceReconnect": rs.ChaosConfig.ForceReconnect,
+			"DelayAck":       rs.ChaosConfig.DelayAck,
+			"DropConfirm":    rs.ChaosConfig.DropConfirm,
+		}
+
+		for _, name := range []string{"CloseChannel", "ForceReconnect", "DelayAck", "DropConfirm"} {
+			if probability := probabilities[name]; probability < 0 || probability > 1 {
+				errs.add("ChaosConfig."+name, "must be between 0 and 1")
+			}
+		}
+
+		if rs.ChaosConfig.DelayAck > 0 && rs.ChaosConfig.AckDelay == 0 {
+			errs.add("ChaosConfig.AckDelay", "is required to delay acks")
+		}
+	}
+
+	if rs.ManagementConfig != nil {
+		if u, err := url.Parse(rs.ManagementConfig.URI); err != nil {
+			errs.add("ManagementConfig.URI", "can't be parsed: %s", err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add("ManagementConfig.URI", "must be an http(s)://host[:port] address")
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+func (pc *PoolConfig) validate(field string, errs *ConfigErrors) {
+
+	if cpc := pc.ConnectionPoolConfig; cpc == nil {
+		errs.add(field+".ConnectionPoolConfig", "is required")
+	} else {
+		field := field + ".ConnectionPoolConfig"
+
+		if u, err := url.Parse(cpc.URI); err != nil {
+			errs.add(field+".URI", "can't be parsed: %s", err)
+		} else if u.Scheme != "amqp" && u.Scheme != "amqps" {
+			errs.add(field+".URI", "scheme must be amqp or amqps, got %q", u.Scheme)
+		} else {
+			if u.Host == "" {
+				errs.add(field+".URI", "is missing a host")
+			}
+
+			if cpc.EnableTLS && u.Scheme != "amqps" {
+				errs.add(field+".URI", "must use amqps when EnableTLS is set")
+			}
+		}
+
+		if cpc.Heartbeat == 0 {
+			errs.add(field+".Heartbeat", "can't be 0")
+		}
+
+		if cpc.ConnectionTimeout == 0 {
+			errs.add(field+".ConnectionTimeout", "can't be 0")
+		}
+
+		if cpc.MaxConnectionCount == 0 {
+			errs.add(field+".MaxConnectionCount", "can't be 0")
+		}
+
+		if cpc.ErrorBuffer == 0 {
+			errs.add(field+".ErrorBuffer", "can't be 0")
+		}
+
+		if cpc.MinConnectionCount > cpc.MaxConnectionCount {
+			errs.add(field+".MinConnectionCount", "can't be greater than MaxConnectionCount")
+		}
+
+		if cpc.TCPKeepAlive < -1 {
+			errs.add(field+".TCPKeepAlive", "must be -1 (disabled), 0 (default), or a positive interval, got %d", cpc.TCPKeepAlive)
+		}
+
+		if cpc.LocalAddress != "" && net.ParseIP(cpc.LocalAddress) == nil {
+			if _, _, err := net.SplitHostPort(cpc.LocalAddress); err != nil {
+				errs.add(field+".LocalAddress", "must be an IP or IP:port, got %q", cpc.LocalAddress)
+			}
+		}
+
+		if cpc.EnableTLS {
+			if cpc.TLSConfig == nil {
+				errs.add(field+".TLSConfig", "is required when EnableTLS is set")
+			} else {
+				if cpc.TLSConfig.PEMCertLocation == "" {
+					errs.add(field+".TLSConfig.PEMCertLocation", "is required when EnableTLS is set")
+				}
+
+				if cpc.TLSConfig.LocalCertLocation == "" {
+					errs.add(field+".TLSConfig.LocalCertLocation", "is required when EnableTLS is set")
+				}
+
+				if cpc.TLSConfig.SessionCacheSize < 0 {
+					errs.add(field+".TLSConfig.SessionCacheSize", "can't be negative")
+				}
+			}
+		}
+	}
+
+	if cpc := pc.ChannelPoolConfig; cpc == nil {
+		errs.add(field+".ChannelPoolConfig", "is required")
+	} else {
+		field := field + ".ChannelPoolConfig"
+
+		if cpc.MaxChannelCount == 0 {
+			errs.add(field+".MaxChannelCount", "can't be 0")
+		}
+
+		if cpc.MaxAckChannelCount == 0 {
+			errs.add(field+".MaxAckChannelCount", "can't be 0")
+		}
+
+		if cpc.GlobalQosCount < 0 {
+			errs.add(field+".GlobalQosCount", "can't be negative")
+		}
+	}
+}
+
+// ValidatePoolSizing makes sure the auto ack consumers (which hold a channel for as long as they consume)
+// and the publisher all fit in the channel pool, otherwise the last in line waits forever for a channel.
+// The target is the Targets entry the pool belongs to, empty for the PoolConfig.
+func (rs *RabbitSeasoning) validatePoolSizing(field string, pool *PoolConfig, target string, errs *ConfigErrors) {
+	if pool.ChannelPoolConfig == nil {
+		return
+	}
+
+	needed := uint64(0)
+	for _, config := range rs.ConsumerConfigs {
+		if config != nil && config.Enabled && config.AutoAck && config.Target == target {
+			needed++
+		}
+	}
+
+	if rs.PublisherConfig != nil {
+		needed++
+	}
+
+	if max := pool.ChannelPoolConfig.MaxChannelCount; max != 0 && max < needed {
+		errs.add(
+			field+".ChannelPoolConfig.MaxChannelCount",
+			"%d is too small for the enabled auto ack consumers and publisher, needs at least %d", max, needed)
+	}
+}
+
+func (cc *ConsumerConfig) validate(field string, errs *ConfigErrors) {
+
+	if cc.QueueName == "" {
+		errs.add(field+".QueueName", "is required")
+	}
+
+	if cc.MessageBuffer == 0 {
+		errs.add(field+".MessageBuffer", "can't be 0")
+	}
+
+	if cc.ErrorBuffer == 0 {
+		errs.add(field+".ErrorBuffer", "can't be 0")
+	}
+
+	if cc.Queue != nil {
+		if cc.Queue.Name != "" && cc.Queue.Name != cc.QueueName {
+			errs.add(field+".Queue.Name", "%q doesn't match the QueueName %q", cc.Queue.Name, cc.QueueName)
+		}
+
+		if cc.Queue.PassiveDeclare {
+			errs.add(field+".Queue.PassiveDeclare", "leave the Queue out to only check the queue exists")
+		}
+	}
+
+	if cc.QosCountOverride < 0 {
+		errs.add(field+".QosCountOverride", "can't be negative")
+	}
+
+	if cc.AutoAck && cc.AckBatchSize > 1 {
+		errs.add(field+".AckBatchSize", "can't batch acks on an AutoAck consumer")
+	}
+
+	if cc.ReplayWindow > 0 && (cc.ReplayFalsePositive < 0 || cc.ReplayFalsePositive >= 1) {
+		errs.add(field+".ReplayFalsePositive", "must be between 0 and 1")
+	}
+
+	if cc.StreamMatchUnfiltered && len(cc.StreamFilters) == 0 {
+		errs.add(field+".StreamMatchUnfiltered", "requires StreamFilters")
+	}
+
+	if cc.ShadowMode {
+		if cc.AutoAck {
+			errs.add(field+".ShadowMode", "can't shadow on an AutoAck consumer")
+		}
+
+		if cc.AckBatchSize > 1 || cc.ReplayWindow > 0 || cc.DedupTTL > 0 {
+			errs.add(field+".ShadowMode", "can't be combined with ack batching, replay protection, or dedup")
+		}
+	}
+
+	if cc.DedupTTL == 0 && (cc.DedupClaimTTL > 0 || cc.DedupHeader != "") {
+		errs.add(field+".DedupTTL", "is required to dedup")
+	}
+
+	if cc.StandbyFailover {
+		if !cc.Standby {
+			errs.add(field+".StandbyFailover", "requires Standby")
+		}
+
+		if !cc.Exclusive {
+			errs.add(field+".StandbyFailover", "requires an Exclusive consume, every standby could take over at once")
+		}
+	}
+
+	if cc.AckPolicy != nil {
+		if cc.AutoAck {
+			errs.add(field+".AckPolicy", "can't settle messages on an AutoAck consumer")
+		}
+
+		cc.AckPolicy.validate(field+".AckPolicy", errs)
+	}
+
+	if cc.ConsumerTimeout > 0 {
+		if cc.AutoAck {
+			errs.add(field+".ConsumerTimeout", "can't watch the consumer timeout on an AutoAck consumer")
+		}
+
+		if uint64(cc.ConsumerTimeoutMargin)*2 >= uint64(cc.ConsumerTimeout) {
+			errs.add(field+".ConsumerTimeoutMargin", "must be less than half the ConsumerTimeout")
+		}
+	}
+
+	switch cc.BufferStrategy {
+	case "", BufferStrategyBlock, BufferStrategyRing:
+	default:
+		errs.add(field+".BufferStrategy", "must be %s or %s", BufferStrategyBlock, BufferStrategyRing)
+	}
+}
+
+func (nr *NamingRule) validate(field string, errs *ConfigErrors) {
+	if nr == nil || nr.Pattern == "" {
+		return
+	}
+
+	if _, err := regexp.Compile(nr.Pattern); err != nil {
+		errs.add(field+".Pattern", "can't be compiled: %s", err)
+	}
+}
+
+func (pf *PublishFallback) validate(field, exchange string, errs *ConfigErrors) {
+	switch {
+	case pf == nil:
+		errs.add(field, "can't be null")
+	case pf.Sink && (pf.Exchange != "" || pf.RoutingKey != ""):
+		errs.add(field, "can't have an exchange or routing key with Sink set")
+	case !pf.Sink && pf.Exchange == exchange && pf.RoutingKey == "":
+		errs.add(field+".Exchange", "can't fall back to the exchange it falls back from")
+	}
+}
+
+func (pp *PublishPolicy) validate(field string, errs *ConfigErrors) {
+	if pp == nil {
+		return
+	}
+
+	for _, contentType := range pp.ContentTypes {
+		if !strings.Contains(contentType, "/") {
+			errs.add(field+".ContentTypes", "%q isn't a media type (type/subtype)", contentType)
+		}
+	}
+
+	for _, header := range pp.RequiredHeaders {
+		if header == "" {
+			errs.add(field+".RequiredHeaders", "can't have an empty header name")
+		}
+	}
+}
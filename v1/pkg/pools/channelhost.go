@@ -2,6 +2,7 @@ package pools
 
 import (
 	"errors"
+	"time"
 
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
 	"github.com/streadway/amqp"
@@ -17,6 +18,8 @@ type ChannelHost struct {
 	ReturnMessages chan *models.ReturnMessage
 	closeErrors    chan *amqp.Error
 	returnMessages chan amqp.Return
+	created        time.Time
+	dedicatedOn    *ConnectionHost // the connection of a dedicated channel (see GetDedicatedAckableChannel), nil when pooled
 }
 
 // NewChannelHost creates a simple ConnectionHost wrapper for management by end-user developer.
@@ -30,7 +33,9 @@ func NewChannelHost(
 		return nil, errors.New("can't open a channel - connection is already closed")
 	}
 
+	started := time.Now()
 	amqpChan, err := amqpConn.Channel()
+	models.Trace("channel.open", connectionID, channelID, started, err, "ackable: %t", ackable)
 	if err != nil {
 		return nil, err
 	}
@@ -44,6 +49,7 @@ func NewChannelHost(
 		ReturnMessages: make(chan *models.ReturnMessage, 1),
 		closeErrors:    make(chan *amqp.Error, 1),
 		returnMessages: make(chan amqp.Return, 1),
+		created:        time.Now(),
 	}
 
 	channelHost.Channel.NotifyClose(channelHost.closeErrors)
@@ -52,6 +58,19 @@ func NewChannelHost(
 	return channelHost, nil
 }
 
+// Age is how long ago the channel was opened.
+func (ch *ChannelHost) Age() time.Duration {
+	return time.Since(ch.created)
+}
+
+// Close closes the channel.
+func (ch *ChannelHost) Close() error {
+	started := time.Now()
+	err := ch.Channel.Close()
+	models.Trace("channel.close", ch.ConnectionID, ch.ChannelID, started, err, "age: %s", ch.Age())
+	return err
+}
+
 // CloseErrors allow you to listen for amqp.Error messages.
 func (ch *ChannelHost) CloseErrors() <-chan *models.ErrorMessage {
 	select {
@@ -83,3 +102,8 @@ func (ch *ChannelHost) Returns() <-chan *models.ReturnMessage {
 func (ch *ChannelHost) IsAckable() bool {
 	return ch.ackable
 }
+
+// IsDedicated determines if this host's channel belongs to whoever holds it alone (see GetDedicatedAckableChannel).
+func (ch *ChannelHost) IsDedicated() bool {
+	return ch.dedicatedOn != nil
+}
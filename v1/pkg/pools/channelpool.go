@@ -1,6 +1,7 @@
 package pools
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"sync/atomic"
@@ -31,6 +32,12 @@ type ChannelPool struct {
 	sleepOnErrorInterval time.Duration
 	globalQosCount       int
 	ackNoWait            bool
+	pendingRemovals      int64
+	maxChannelAge        time.Duration
+	recycleInterval      time.Duration
+	lastRecycle          int64 // unix nanoseconds of the last channel recycle
+	droppedErrors        uint64
+	drain                *drainer
 }
 
 // NewChannelPool creates hosting structure for the ChannelPool.
@@ -51,6 +58,8 @@ func NewChannelPool(
 		}
 	}
 
+	maxChannelAge := time.Duration(config.ChannelPoolConfig.MaxChannelAge) * time.Millisecond
+
 	cp := &ChannelPool{
 		Config:               *config,
 		connectionPool:       connPool,
@@ -63,8 +72,12 @@ func NewChannelPool(
 		poolRWLock:           &sync.RWMutex{},
 		flaggedChannels:      make(map[uint64]bool),
 		sleepOnErrorInterval: time.Duration(config.ChannelPoolConfig.SleepOnErrorInterval) * time.Millisecond,
+		drain:                &drainer{},
 		globalQosCount:       config.ChannelPoolConfig.GlobalQosCount,
 		ackNoWait:            config.ChannelPoolConfig.AckNoWait,
+		maxChannelAge:        maxChannelAge,
+		recycleInterval: recycleInterval(
+			config.ChannelPoolConfig.RecycleInterval, maxChannelAge, config.ChannelPoolConfig.MaxChannelCount),
 	}
 
 	if initializeNow {
@@ -79,70 +92,86 @@ func NewChannelPool(
 // Initialize creates the ConnectionPool based on the config details.
 // Blocks on network/communication issues unless overridden by config.
 func (cp *ChannelPool) Initialize() error {
+	return cp.InitializeWithContext(context.Background())
+}
+
+// InitializeWithContext creates the ChannelPool (and ConnectionPool if needed), giving up between
+// connection/channel creations once the context is done.
+func (cp *ChannelPool) InitializeWithContext(ctx context.Context) error {
 	cp.poolLock.Lock()
 	defer cp.poolLock.Unlock()
 
+	cp.drain.reset()
+
 	if !cp.connectionPool.Initialized {
-		if err := cp.connectionPool.Initialize(); err != nil {
+		if err := cp.connectionPool.InitializeWithContext(ctx); err != nil {
 			return err
 		}
 	}
 
 	if !cp.Initialized {
-		ok := cp.initialize()
-		if ok {
-			cp.Initialized = true
-		} else {
-			return errors.New("errors occurred creating channels")
+		if err := cp.initialize(ctx); err != nil {
+			return err
 		}
+
+		cp.Initialized = true
 	}
 
 	return nil
 }
 
-func (cp *ChannelPool) initialize() bool {
+func (cp *ChannelPool) initialize(ctx context.Context) error {
 
 	// Create Channel queue.
 	for i := uint64(0); i < cp.maxChannels; i++ {
 
-		channelHost, err := cp.createChannelHost(cp.channelID, false)
+		channelHost, err := cp.createChannelHost(ctx, cp.channelID, false)
 		if err != nil {
 			cp.channelID = 0
 			cp.channels = queue.New(int64(cp.Config.ChannelPoolConfig.MaxChannelCount))
-			return false
+			return cp.initializeError(ctx)
 		}
 
 		cp.channelID++
 		if err = cp.channels.Put(channelHost); err != nil {
 			cp.channelID = 0
 			cp.channels = queue.New(int64(cp.Config.ChannelPoolConfig.MaxChannelCount))
-			return false
+			return cp.initializeError(ctx)
 		}
 	}
 
 	// Create AckChannel queue.
 	for i := uint64(0); i < cp.maxAckChannels; i++ {
 
-		channelHost, err := cp.createChannelHost(cp.channelID, true)
+		channelHost, err := cp.createChannelHost(ctx, cp.channelID, true)
 		if err != nil {
 			cp.channelID = 0
 			cp.channels = queue.New(int64(cp.Config.ChannelPoolConfig.MaxAckChannelCount))
-			return false
+			return cp.initializeError(ctx)
 		}
 
 		cp.channelID++
 		if err = cp.ackChannels.Put(channelHost); err != nil {
 			cp.channelID = 0
 			cp.channels = queue.New(int64(cp.Config.ChannelPoolConfig.MaxAckChannelCount))
-			return false
+			return cp.initializeError(ctx)
 		}
 	}
 
-	return true
+	return nil
+}
+
+// InitializeError prefers the context error, when there is one, over the generic channel creation error.
+func (cp *ChannelPool) initializeError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return errors.New("errors occurred creating channels")
 }
 
 // CreateChannelHost creates the Channel (backed by a Connection) with RabbitMQ server.
-func (cp *ChannelPool) createChannelHost(channelID uint64, ackable bool) (*ChannelHost, error) {
+func (cp *ChannelPool) createChannelHost(ctx context.Context, channelID uint64, ackable bool) (*ChannelHost, error) {
 
 	getConnectionCounter := 0
 GetNewConnection:
@@ -150,7 +179,7 @@ GetNewConnection:
 		return nil, errors.New("-1")
 	}
 
-	connHost, err := cp.connectionPool.GetConnection()
+	connHost, err := cp.connectionPool.GetConnectionWithContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -175,7 +204,10 @@ GetNewConnection:
 	}
 
 	if cp.globalQosCount > 0 {
-		if err = channelHost.Channel.Qos(cp.globalQosCount, 0, true); err != nil {
+		started := time.Now()
+		err = channelHost.Channel.Qos(cp.globalQosCount, 0, true)
+		models.Trace("qos", connHost.ConnectionID, channelID, started, err, "prefetch: %d, global: true", cp.globalQosCount)
+		if err != nil {
 			cp.handleError(err)
 		}
 	}
@@ -192,7 +224,10 @@ GetNewConnection:
 }
 
 func (cp *ChannelPool) handleError(err error) {
-	go func() { cp.errors <- err }()
+	if !models.SendError("channelpool.errors", cp.errors, err) {
+		atomic.AddUint64(&cp.droppedErrors, 1)
+		models.GetMetrics().Counter(models.MetricDroppedErrors, 1, models.Labels{"source": "channelpool"})
+	}
 }
 
 // Errors yields all the internal err chan for managing the ChannelPool.
@@ -200,32 +235,63 @@ func (cp *ChannelPool) Errors() <-chan error {
 	return cp.errors
 }
 
+// DroppedErrors returns how many errors were dropped instead of sent to Errors() (see models.SetErrorDelivery).
+func (cp *ChannelPool) DroppedErrors() uint64 {
+	return atomic.LoadUint64(&cp.droppedErrors)
+}
+
+// Events yields typed channel and connection lifecycle events, shared with the underlying ConnectionPool.
+// Events are dropped, not queued, when the buffer is full.
+func (cp *ChannelPool) Events() <-chan *models.Event {
+	return cp.connectionPool.Events()
+}
+
 // GetChannel gets a channel based on whats ChannelPool queue (blocking under bad network conditions).
 // Outages/transient network outages block until success connecting.
 // Uses the SleepOnErrorInterval to pause between retries.
 func (cp *ChannelPool) GetChannel() (*ChannelHost, error) {
+	return cp.GetChannelWithContext(context.Background())
+}
+
+// GetChannelWithContext gets a channel like GetChannel but stops waiting on the queue, or on replacing
+// a dead channel, once the context is done.
+func (cp *ChannelPool) GetChannelWithContext(ctx context.Context) (*ChannelHost, error) {
+	defer models.ObserveSince(models.MetricChannelWait, time.Now(), nil)
+
+	if !cp.drain.enter() {
+		return nil, ErrDraining
+	}
+	defer cp.drain.leave()
+
 	if atomic.LoadInt32(&cp.channelLock) > 0 {
 		return nil, errors.New("can't get channel - channel pool has been shutdown")
 	}
 
 	if !cp.Initialized {
-		time.Sleep(cp.sleepOnErrorInterval)
+		if err := sleepWithContext(ctx, cp.sleepOnErrorInterval); err != nil {
+			return nil, err
+		}
 		return nil, errors.New("can't get channel - channel pool has not been initialized")
 	}
 
 	// Pull from the queue.
 	// Pauses here if the queue is empty.
 DequeueChannel:
-	structs, err := cp.channels.Get(1)
+	item, err := dequeue(ctx, cp.channels)
 	if err != nil {
 		return nil, err
 	}
 
-	channelHost, ok := structs[0].(*ChannelHost)
+	channelHost, ok := item.(*ChannelHost)
 	if !ok {
 		return nil, errors.New("invalid struct type found in ChannelPool queue")
 	}
 
+	if cp.drain.isDraining() { // started while waiting on the queue
+		cp.returnChannel(channelHost, false)
+		return nil, ErrDraining
+	}
+
 	healthy := true
 	select {
 	case <-channelHost.CloseErrors():
@@ -236,22 +302,24 @@ DequeueChannel:
 
 	// Between these two states we do our best to determine that a channel is dead in the various
 	// lifecycles.
-	if cp.IsChannelFlagged(channelHost.ChannelID) || !healthy {
+	// A flagged connection (i.e. found stale by the watchdog) takes its channels with it.
+	if cp.IsChannelFlagged(channelHost.ChannelID) || !healthy || cp.connectionPool.IsConnectionFlagged(channelHost.ConnectionID) {
 
 		replacementChannelID := channelHost.ChannelID
 		var newChannelHost *ChannelHost
 
-		// Do not leave without a good ChannelHost.
+		// Do not leave without a good ChannelHost (or a done context).
 		for newChannelHost == nil {
 
-			if cp.sleepOnErrorInterval > 0 {
-				time.Sleep(cp.sleepOnErrorInterval)
+			if err = sleepWithContext(ctx, cp.sleepOnErrorInterval); err != nil {
+				cp.returnChannel(channelHost, true) // still flagged, the next caller will replace it
+				return nil, err
 			}
 
-			newChannelHost, err = cp.createChannelHost(replacementChannelID, false)
+			newChannelHost, err = cp.createChannelHost(ctx, replacementChannelID, false)
 			if err != nil {
 				if err.Error() == "-1" { // A control error of "-1" indicates we are at max channels for 3 separate connections. Try with a new channel.
-					cp.ReturnChannel(channelHost, true) // return the bad channel since we don't want to lose our pool overtime
+					cp.returnChannel(channelHost, true) // return the bad channel since we don't want to lose our pool overtime
 					goto DequeueChannel
 				}
 				continue
@@ -261,20 +329,47 @@ DequeueChannel:
 		}
 
 		cp.UnflagChannel(replacementChannelID)
+		cp.connectionPool.emitEvent(models.NewEvent(models.ChannelReplaced, channelHost.ConnectionID, replacementChannelID, "channel replaced"))
+	} else if cp.shouldRecycle(channelHost) {
+		channelHost = cp.recycleChannel(ctx, channelHost)
 	}
 
+	if models.GetChaos().Inject(models.ChaosCloseChannel) {
+		_ = channelHost.Close()
+		cp.connectionPool.emitEvent(models.NewEvent(models.ChaosInjected, channelHost.ConnectionID, channelHost.ChannelID, models.ChaosCloseChannel.String()))
+	}
+
+	cp.drain.lease()
 	return channelHost, nil
 }
 
 // ReturnChannel puts the connection back in the queue.
 // Developer has to manually return the Channel and helps maintain a Round Robin on Channels and their resources.
-// Optional parameter allows you to flag a Channel as dead.
+// Optional parameter allows you to flag a Channel as dead. A dedicated channel is closed instead.
 func (cp *ChannelPool) ReturnChannel(chanHost *ChannelHost, flagChannel bool) {
+	if !chanHost.IsAckable() || chanHost.IsDedicated() { // a shared ackable channel was never leased
+		cp.drain.release()
+	}
+
+	if chanHost.IsDedicated() {
+		cp.closeDedicated(chanHost)
+		return
+	}
+
+	cp.returnChannel(chanHost, flagChannel)
+}
+
+// ReturnChannel puts a channel that wasn't handed out (no lease to release) back in its queue.
+func (cp *ChannelPool) returnChannel(chanHost *ChannelHost, flagChannel bool) {
 	if chanHost.IsAckable() {
 		if err := cp.ackChannels.Put(chanHost); err != nil {
 			cp.handleError(err)
 		}
 	} else {
+		if cp.removeIfPending(chanHost) {
+			return // removed by a shrinking Resize
+		}
+
 		if err := cp.channels.Put(chanHost); err != nil {
 			cp.handleError(err)
 		}
@@ -287,27 +382,45 @@ func (cp *ChannelPool) ReturnChannel(chanHost *ChannelHost, flagChannel bool) {
 
 // GetAckableChannel gets an ackable channel based on whats available in AckChannelPool queue.
 func (cp *ChannelPool) GetAckableChannel() (*ChannelHost, error) {
+	return cp.GetAckableChannelWithContext(context.Background())
+}
+
+// GetAckableChannelWithContext gets an ackable channel like GetAckableChannel but stops waiting on the
+// queue, or on replacing a dead channel, once the context is done.
+func (cp *ChannelPool) GetAckableChannelWithContext(ctx context.Context) (*ChannelHost, error) {
+	if !cp.drain.enter() {
+		return nil, ErrDraining
+	}
+	defer cp.drain.leave()
+
 	if atomic.LoadInt32(&cp.channelLock) > 0 {
 		return nil, errors.New("can't get channel - channel pool has been shutdown")
 	}
 
 	if !cp.Initialized {
-		time.Sleep(cp.sleepOnErrorInterval)
+		if err := sleepWithContext(ctx, cp.sleepOnErrorInterval); err != nil {
+			return nil, err
+		}
 		return nil, errors.New("can't get channel - channel pool has not been initialized")
 	}
 
 	// Pull from the queue.
 	// Pauses here if the queue is empty.
-	structs, err := cp.ackChannels.Get(1)
+	item, err := dequeue(ctx, cp.ackChannels)
 	if err != nil {
 		return nil, err
 	}
 
-	channelHost, ok := structs[0].(*ChannelHost)
+	channelHost, ok := item.(*ChannelHost)
 	if !ok {
 		return nil, errors.New("invalid struct type found in ChannelPool queue")
 	}
 
+	if cp.drain.isDraining() { // started while waiting on the queue
+		cp.returnChannel(channelHost, false)
+		return nil, ErrDraining
+	}
+
 	notifiedClosed := false
 	select {
 	case <-channelHost.CloseErrors():
@@ -318,29 +431,34 @@ func (cp *ChannelPool) GetAckableChannel() (*ChannelHost, error) {
 
 	// Between these two states we do our best to determine that a channel is dead in the various
 	// lifecycles.
-	if notifiedClosed || cp.IsChannelFlagged(channelHost.ChannelID) {
+	if notifiedClosed || cp.IsChannelFlagged(channelHost.ChannelID) || cp.connectionPool.IsConnectionFlagged(channelHost.ConnectionID) {
 
 		cp.connectionPool.FlagConnection(channelHost.ConnectionID)
 
+		deadChannelHost := channelHost
 		replacementChannelID := channelHost.ChannelID
 		channelHost = nil
 
 		for channelHost == nil {
 
-			channelHost, err = cp.createChannelHost(replacementChannelID, true)
+			channelHost, err = cp.createChannelHost(ctx, replacementChannelID, true)
 			if err != nil {
-				if cp.sleepOnErrorInterval > 0 {
-					time.Sleep(cp.sleepOnErrorInterval)
+				channelHost = nil
+				if err = sleepWithContext(ctx, cp.sleepOnErrorInterval); err != nil {
+					cp.returnChannel(deadChannelHost, true) // still flagged, the next caller will replace it
+					return nil, err
 				}
 				continue
 			}
 		}
 
 		cp.UnflagChannel(replacementChannelID)
+		cp.connectionPool.emitEvent(models.NewEvent(models.ChannelReplaced, channelHost.ConnectionID, replacementChannelID, "ackable channel replaced"))
 	}
 
 	// Puts the connection back in the queue while also returning a pointer to the caller.
 	// This creates a Round Robin on Connections and their resources.
+	// Still in the queue and shared, the channel isn't a lease for Drain to wait on.
 	if err := cp.ackChannels.Put(channelHost); err != nil {
 		cp.handleError(err)
 	}
@@ -385,6 +503,11 @@ func (cp *ChannelPool) IsChannelFlagged(channelID uint64) bool {
 
 // Shutdown closes all channels and all connections.
 func (cp *ChannelPool) Shutdown() {
+	cp.shutdown(cp.connectionPool.Shutdown)
+}
+
+// Shutdown closes all channels, then the connections with closeConnections.
+func (cp *ChannelPool) shutdown(closeConnections func()) {
 	cp.poolLock.Lock()
 	defer cp.poolLock.Unlock()
 
@@ -395,8 +518,8 @@ func (cp *ChannelPool) Shutdown() {
 		done1 := make(chan bool, 1)
 		done2 := make(chan bool, 1)
 
-		go cp.shutdownChannels(done1)
-		go cp.shutdownAckChannels(done2)
+		models.Go("channelpool.shutdown", func() { cp.shutdownChannels(done1) })
+		models.Go("channelpool.shutdown", func() { cp.shutdownAckChannels(done2) })
 
 		<-done1
 		<-done2
@@ -406,8 +529,9 @@ func (cp *ChannelPool) Shutdown() {
 		cp.flaggedChannels = make(map[uint64]bool)
 		cp.channelID = 0
 		cp.Initialized = false
+		atomic.StoreInt64(&cp.pendingRemovals, 0)
 
-		cp.connectionPool.Shutdown()
+		closeConnections()
 	}
 
 	// Release channel lock (0)
@@ -420,7 +544,7 @@ func (cp *ChannelPool) shutdownChannels(done chan bool) {
 
 		for _, item := range items {
 			channelHost := item.(*ChannelHost)
-			channelHost.Channel.Close()
+			channelHost.Close()
 		}
 	}
 
@@ -433,7 +557,7 @@ func (cp *ChannelPool) shutdownAckChannels(done chan bool) {
 
 		for _, item := range items {
 			channelHost := item.(*ChannelHost)
-			channelHost.Channel.Close()
+			channelHost.Close()
 		}
 	}
 
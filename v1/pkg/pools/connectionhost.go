@@ -20,6 +20,8 @@ type ConnectionHost struct {
 	closeErrors        chan *amqp.Error
 	chanRWLock         *sync.RWMutex
 	ackChanRWLock      *sync.RWMutex
+	created            time.Time
+	serverProperties   *ServerProperties
 }
 
 // NewConnectionHost creates a simple ConnectionHost wrapper for management by end-user developer.
@@ -32,33 +34,13 @@ func NewConnectionHost(
 	maxChannel uint64,
 	maxAckChannelCount uint64) (*ConnectionHost, error) {
 
-	var amqpConn *amqp.Connection
-	var err error
-
-	amqpConn, err = amqp.DialConfig(uri, amqp.Config{
+	return dialConnectionHost(uri, connectionID, maxChannel, maxAckChannelCount, amqp.Config{
 		Heartbeat: heartbeat,
 		Dial:      amqp.DefaultDial(connectionTimeout),
 		Properties: amqp.Table{
 			"connection_name": connectionName,
 		},
 	})
-	if err != nil {
-		return nil, err
-	}
-
-	connectionHost := &ConnectionHost{
-		Connection:         amqpConn,
-		ConnectionID:       connectionID,
-		closeErrors:        make(chan *amqp.Error, 1),
-		chanRWLock:         &sync.RWMutex{},
-		ackChanRWLock:      &sync.RWMutex{},
-		maxChannelCount:    maxChannel,
-		maxAckChannelCount: maxAckChannelCount,
-	}
-
-	connectionHost.Connection.NotifyClose(connectionHost.closeErrors)
-
-	return connectionHost, nil
 }
 
 // NewConnectionHostWithTLS creates a simple ConnectionHost wrapper for management by end-user developer.
@@ -72,10 +54,7 @@ func NewConnectionHostWithTLS(
 	maxAckChannelCount uint64,
 	tlsConfig *tls.Config) (*ConnectionHost, error) {
 
-	var amqpConn *amqp.Connection
-	var err error
-
-	amqpConn, err = amqp.DialConfig("amqps://"+certServerName, amqp.Config{
+	return dialConnectionHost("amqps://"+certServerName, connectionID, maxChannel, maxAckChannelCount, amqp.Config{
 		Heartbeat:       heartbeat,
 		Dial:            amqp.DefaultDial(connectionTimeout),
 		TLSClientConfig: tlsConfig,
@@ -83,17 +62,31 @@ func NewConnectionHostWithTLS(
 			"connection_name": connectionName,
 		},
 	})
+}
+
+// DialConnectionHost dials the connection with the amqp.Config and wraps it in a ConnectionHost.
+func dialConnectionHost(
+	uri string,
+	connectionID uint64,
+	maxChannel uint64,
+	maxAckChannelCount uint64,
+	config amqp.Config) (*ConnectionHost, error) {
+
+	amqpConn, err := amqp.DialConfig(uri, config)
 	if err != nil {
 		return nil, err
 	}
 
 	connectionHost := &ConnectionHost{
-		Connection:      amqpConn,
-		ConnectionID:    connectionID,
-		closeErrors:     make(chan *amqp.Error, 1),
-		chanRWLock:      &sync.RWMutex{},
-		ackChanRWLock:   &sync.RWMutex{},
-		maxChannelCount: maxChannel,
+		Connection:         amqpConn,
+		ConnectionID:       connectionID,
+		closeErrors:        make(chan *amqp.Error, 1),
+		chanRWLock:         &sync.RWMutex{},
+		ackChanRWLock:      &sync.RWMutex{},
+		maxChannelCount:    maxChannel,
+		maxAckChannelCount: maxAckChannelCount,
+		created:            time.Now(),
+		serverProperties:   NewServerProperties(amqpConn),
 	}
 
 	connectionHost.Connection.NotifyClose(connectionHost.closeErrors)
@@ -106,6 +99,11 @@ func (ch *ConnectionHost) CloseErrors() <-chan *amqp.Error {
 	return ch.closeErrors
 }
 
+// Age is how long ago the connection was dialed.
+func (ch *ConnectionHost) Age() time.Duration {
+	return time.Since(ch.created)
+}
+
 // CanAddChannel provides a true or false based on whether this connection host can handle more channels on it's connection (based on initialization).
 func (ch *ConnectionHost) CanAddChannel() bool {
 	ch.chanRWLock.RLock()
@@ -136,6 +134,28 @@ func (ch *ConnectionHost) RemoveChannel() error {
 	return nil
 }
 
+// SetChannelLimits changes how many channels and ackable channels the connection may host.
+func (ch *ConnectionHost) setChannelLimits(maxChannelCount uint64, maxAckChannelCount uint64) {
+	ch.chanRWLock.Lock()
+	ch.maxChannelCount = maxChannelCount
+	ch.chanRWLock.Unlock()
+
+	ch.ackChanRWLock.Lock()
+	ch.maxAckChannelCount = maxAckChannelCount
+	ch.ackChanRWLock.Unlock()
+}
+
+// IsIdle returns true when no channels are open on the connection.
+func (ch *ConnectionHost) isIdle() bool {
+	ch.chanRWLock.RLock()
+	defer ch.chanRWLock.RUnlock()
+
+	ch.ackChanRWLock.RLock()
+	defer ch.ackChanRWLock.RUnlock()
+
+	return ch.channelCount == 0 && ch.ackChannelCount == 0
+}
+
 // CanAddAckChannel provides a true or false based on whether this connection host can handle more channels on it's connection (based on initialization).
 func (ch *ConnectionHost) CanAddAckChannel() bool {
 	ch.ackChanRWLock.RLock()
@@ -1,19 +1,24 @@
 package pools
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
-	"strconv"
+	"fmt"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/Workiva/go-datastructures/queue"
+	"github.com/streadway/amqp"
 
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/utils"
 )
 
+const defaultRestoreInterval = time.Duration(5) * time.Second
+
 // ConnectionPool houses the pool of RabbitMQ connections.
 type ConnectionPool struct {
 	config                     models.PoolConfig
@@ -23,6 +28,7 @@ type ConnectionPool struct {
 	enableTLS                  bool
 	tlsConfig                  *tls.Config
 	errors                     chan error
+	events                     chan *models.Event
 	heartbeat                  time.Duration
 	connectionTimeout          time.Duration
 	connections                *queue.Queue
@@ -35,6 +41,26 @@ type ConnectionPool struct {
 	connectionLock             int32
 	flaggedConnections         map[uint64]bool
 	sleepOnErrorInterval       time.Duration
+	minConnections             uint64
+	restoreInterval            time.Duration
+	missingConnections         []uint64
+	restoreStop                chan bool
+	channelCount               uint64 // channels the ChannelPool spreads over the connections
+	ackChannelCount            uint64
+	hosts                      map[uint64]*ConnectionHost
+	pendingRemovals            int64
+	watchdogInterval           time.Duration
+	watchdogTimeout            time.Duration
+	watchdogStop               chan bool
+	maxConnectionAge           time.Duration
+	recycleInterval            time.Duration
+	recycleStop                chan bool
+	dialer                     *net.Dialer
+	rotator                    *addressRotator // nil dials the URI host as is
+	replaceRetry               *models.RetryTracker
+	restoreRetry               *models.RetryTracker
+	droppedErrors              uint64
+	drain                      *drainer
 }
 
 // NewConnectionPool creates hosting structure for the ConnectionPool.
@@ -65,26 +91,55 @@ func NewConnectionPool(
 		if err != nil {
 			return nil, err
 		}
+
+		if cacheSize := config.ConnectionPoolConfig.TLSConfig.SessionCacheSize; cacheSize > 0 {
+			// shared by every connection (amqp clones the config per dial), so redials resume instead of
+			// doing the full handshake
+			tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(cacheSize)
+		}
+	}
+
+	connectionTimeout := time.Duration(config.ConnectionPoolConfig.ConnectionTimeout) * time.Second
+	dialer, err := newNetDialer(connectionTimeout, config.ConnectionPoolConfig)
+	if err != nil {
+		return nil, err
 	}
 
 	if config.ConnectionPoolConfig.ErrorBuffer == 0 {
 		return nil, errors.New("can't create a ConnectionPool when the ErrorBuffer value is 0")
 	}
 
-	maxChannelPerConnection := uint64(1)
-	if config.ConnectionPoolConfig.MaxConnectionCount == 1 {
-		maxChannelPerConnection = config.ChannelPoolConfig.MaxChannelCount
-	} else if config.ChannelPoolConfig.MaxChannelCount > 1 {
-		maxChannelPerConnection = config.ChannelPoolConfig.MaxChannelCount/config.ConnectionPoolConfig.MaxConnectionCount + 1
+	eventBuffer := config.ConnectionPoolConfig.EventBuffer
+	if eventBuffer == 0 {
+		eventBuffer = config.ConnectionPoolConfig.ErrorBuffer
+	}
+
+	if config.ConnectionPoolConfig.MinConnectionCount > config.ConnectionPoolConfig.MaxConnectionCount {
+		return nil, errors.New("connectionpool minconnectioncount can't be greater than maxconnectioncount")
 	}
 
-	maxAckChannelPerConnection := uint64(1)
-	if config.ConnectionPoolConfig.MaxConnectionCount == 1 {
-		maxAckChannelPerConnection = config.ChannelPoolConfig.MaxAckChannelCount
-	} else if config.ChannelPoolConfig.MaxChannelCount > 1 {
-		maxAckChannelPerConnection = config.ChannelPoolConfig.MaxAckChannelCount/config.ConnectionPoolConfig.MaxConnectionCount + 1
+	restoreInterval := time.Duration(config.ConnectionPoolConfig.RestoreInterval) * time.Millisecond
+	if restoreInterval == 0 {
+		restoreInterval = time.Duration(config.ConnectionPoolConfig.SleepOnErrorInterval) * time.Millisecond
 	}
 
+	if restoreInterval == 0 {
+		restoreInterval = defaultRestoreInterval
+	}
+
+	watchdogInterval := time.Duration(config.ConnectionPoolConfig.WatchdogInterval) * time.Millisecond
+	watchdogTimeout := time.Duration(config.ConnectionPoolConfig.WatchdogTimeout) * time.Millisecond
+	if watchdogTimeout == 0 {
+		watchdogTimeout = watchdogInterval
+	}
+
+	maxConnectionAge := time.Duration(config.ConnectionPoolConfig.MaxConnectionAge) * time.Millisecond
+	connectionRecycleInterval := recycleInterval(
+		config.ConnectionPoolConfig.RecycleInterval, maxConnectionAge, config.ConnectionPoolConfig.MaxConnectionCount)
+
+	maxChannelPerConnection := channelsPerConnection(config.ChannelPoolConfig.MaxChannelCount, config.ConnectionPoolConfig.MaxConnectionCount)
+	maxAckChannelPerConnection := channelsPerConnection(config.ChannelPoolConfig.MaxAckChannelCount, config.ConnectionPoolConfig.MaxConnectionCount)
+
 	cp := &ConnectionPool{
 		config:                     *config,
 		uri:                        config.ConnectionPoolConfig.URI,
@@ -92,8 +147,9 @@ func NewConnectionPool(
 		enableTLS:                  config.ConnectionPoolConfig.EnableTLS,
 		tlsConfig:                  tlsConfig,
 		errors:                     make(chan error, config.ConnectionPoolConfig.ErrorBuffer),
+		events:                     make(chan *models.Event, eventBuffer),
 		heartbeat:                  time.Duration(config.ConnectionPoolConfig.Heartbeat) * time.Second,
-		connectionTimeout:          time.Duration(config.ConnectionPoolConfig.ConnectionTimeout) * time.Second,
+		connectionTimeout:          connectionTimeout,
 		maxConnections:             config.ConnectionPoolConfig.MaxConnectionCount,
 		maxChannelPerConnection:    maxChannelPerConnection,
 		maxAckChannelPerConnection: maxAckChannelPerConnection,
@@ -102,6 +158,23 @@ func NewConnectionPool(
 		poolRWLock:                 &sync.RWMutex{},
 		flaggedConnections:         make(map[uint64]bool),
 		sleepOnErrorInterval:       time.Duration(config.ConnectionPoolConfig.SleepOnErrorInterval) * time.Millisecond,
+		minConnections:             config.ConnectionPoolConfig.MinConnectionCount,
+		restoreInterval:            restoreInterval,
+		channelCount:               config.ChannelPoolConfig.MaxChannelCount,
+		ackChannelCount:            config.ChannelPoolConfig.MaxAckChannelCount,
+		hosts:                      make(map[uint64]*ConnectionHost),
+		watchdogInterval:           watchdogInterval,
+		watchdogTimeout:            watchdogTimeout,
+		maxConnectionAge:           maxConnectionAge,
+		recycleInterval:            connectionRecycleInterval,
+		replaceRetry:               models.NewRetryTracker(),
+		restoreRetry:               models.NewRetryTracker(),
+		drain:                      &drainer{},
+		dialer:                     dialer,
+	}
+
+	if config.ConnectionPoolConfig.RotateAddresses {
+		cp.rotator = newAddressRotator(dialer, config.ConnectionPoolConfig.PreferHealthyAddresses)
 	}
 
 	if initializeNow {
@@ -116,81 +189,241 @@ func NewConnectionPool(
 // Initialize creates the ConnectionPool based on the config details.
 // Blocks on network/communication issues unless overridden by config.
 func (cp *ConnectionPool) Initialize() error {
+	return cp.InitializeWithContext(context.Background())
+}
+
+// InitializeWithContext creates the ConnectionPool based on the config details, giving up between
+// connection attempts once the context is done.
+func (cp *ConnectionPool) InitializeWithContext(ctx context.Context) error {
 	cp.poolLock.Lock()
 	defer cp.poolLock.Unlock()
 
+	cp.drain.reset()
+
 	if !cp.Initialized {
-		var ok bool
+		if err := cp.initialize(ctx); err != nil {
+			return err
+		}
 
-		if cp.config.ConnectionPoolConfig.EnableTLS {
-			ok = cp.initializeWithTLS()
-		} else {
-			ok = cp.initialize()
+		if cp.watchdogInterval > 0 {
+			cp.watchdogStop = make(chan bool)
+			watchdogStop := cp.watchdogStop
+			models.Go("connectionpool.watchdog", func() { cp.watchConnections(watchdogStop) })
 		}
 
-		if ok {
-			cp.Initialized = true
-		} else {
-			return errors.New("initialization failed during connection creation")
+		if cp.maxConnectionAge > 0 {
+			cp.recycleStop = make(chan bool)
+			recycleStop := cp.recycleStop
+			models.Go("connectionpool.recycle", func() { cp.recycleConnections(recycleStop) })
 		}
+
+		cp.Initialized = true
 	}
 
 	return nil
 }
 
-func (cp *ConnectionPool) initialize() bool {
+// With a MinConnectionCount, connections that fail to dial are left missing (degraded) as long as enough
+// connections are healthy, and are restored in the background.
+func (cp *ConnectionPool) initialize(ctx context.Context) error {
 
+	var missing []uint64
+	var lastErr error
 	for i := uint64(0); i < cp.maxConnections; i++ {
-		connectionHost, err := cp.createConnectionHost(cp.connectionID)
-		if err != nil {
-			cp.connectionID = 0
-			cp.connections = queue.New(int64(cp.config.ConnectionPoolConfig.MaxConnectionCount))
-			return false
+		if err := ctx.Err(); err != nil {
+			cp.resetConnections()
+			return err
 		}
 
+		connectionID := cp.connectionID
 		cp.connectionID++
+
+		connectionHost, err := cp.createConnectionHostByConfig(connectionID)
+		if err != nil {
+			if cp.minConnections == 0 { // all or nothing
+				cp.resetConnections()
+				return errors.New("initialization failed during connection creation")
+			}
+
+			missing = append(missing, connectionID)
+			lastErr = err
+			continue
+		}
+
 		if err = cp.connections.Put(connectionHost); err != nil {
-			cp.connectionID = 0
-			cp.connections = queue.New(int64(cp.config.ConnectionPoolConfig.MaxConnectionCount))
-			return false
+			cp.resetConnections()
+			return errors.New("initialization failed during connection creation")
 		}
 	}
 
-	return true
+	if len(missing) > 0 {
+		if cp.maxConnections-uint64(len(missing)) < cp.minConnections {
+			cp.resetConnections()
+			return fmt.Errorf("initialization failed, only %d of %d connections created: %s", cp.maxConnections-uint64(len(missing)), cp.maxConnections, lastErr)
+		}
+
+		cp.missingConnections = missing
+		cp.restoreStop = make(chan bool)
+		cp.restoreRetry.Failed(lastErr, cp.restoreInterval)
+		cp.handleError(lastErr)
+		cp.emitEvent(models.NewEvent(models.PoolDegraded, 0, 0, fmt.Sprintf("%d of %d connections missing: %s", len(missing), cp.maxConnections, lastErr)))
+
+		restoreStop := cp.restoreStop
+		models.Go("connectionpool.restore", func() { cp.restoreConnections(restoreStop) })
+	}
+
+	cp.setChannelLimits(cp.channelCount, cp.ackChannelCount)
+
+	return nil
 }
 
-func (cp *ConnectionPool) initializeWithTLS() bool {
+// RestoreConnections periodically retries the missing connections of a degraded pool until all are restored
+// or the pool is shutdown.
+func (cp *ConnectionPool) restoreConnections(stop chan bool) {
+	ticker := time.NewTicker(cp.restoreInterval)
+	defer ticker.Stop()
 
-	for i := uint64(0); i < cp.maxConnections; i++ {
-		connectionHost, err := cp.createConnectionHostWithTLS(cp.connectionID)
-		if err != nil {
-			cp.connectionID = 0
-			cp.connections = queue.New(int64(cp.config.ConnectionPoolConfig.MaxConnectionCount))
-			return false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
 		}
 
-		cp.connectionID++
-		if err = cp.connections.Put(connectionHost); err != nil {
-			cp.connectionID = 0
-			cp.connections = queue.New(int64(cp.config.ConnectionPoolConfig.MaxConnectionCount))
-			return false
+		cp.poolLock.Lock()
+		missing := cp.missingConnections
+		cp.poolLock.Unlock()
+
+		var stillMissing []uint64
+		var lastErr error
+		for _, connectionID := range missing {
+			connectionHost, err := cp.createConnectionHostByConfig(connectionID)
+			if err != nil {
+				stillMissing = append(stillMissing, connectionID)
+				lastErr = err
+				continue
+			}
+
+			cp.poolLock.Lock()
+			select {
+			case <-stop: // shutdown while dialing
+				cp.poolLock.Unlock()
+				connectionHost.Connection.Close()
+				return
+			default:
+			}
+
+			if err = cp.connections.Put(connectionHost); err != nil {
+				cp.handleError(err)
+			}
+			cp.poolLock.Unlock()
+
+			cp.emitEvent(models.NewEvent(models.ConnectionRestored, connectionID, 0, "missing connection restored"))
+		}
+
+		cp.poolLock.Lock()
+		cp.missingConnections = stillMissing
+		cp.setChannelLimits(cp.channelCount, cp.ackChannelCount) // the restored connections share the channels again
+		cp.poolLock.Unlock()
+
+		if len(stillMissing) == 0 {
+			cp.restoreRetry.Succeeded()
+			cp.emitEvent(models.NewEvent(models.PoolRestored, 0, 0, "all connections restored"))
+			return
+		}
+
+		cp.restoreRetry.Failed(lastErr, cp.restoreInterval)
+	}
+}
+
+// Degraded returns true while the pool is serving with some of its connections missing.
+func (cp *ConnectionPool) Degraded() bool {
+	return cp.MissingConnectionCount() > 0
+}
+
+// MissingConnectionCount returns how many connections a degraded pool is still trying to restore.
+func (cp *ConnectionPool) MissingConnectionCount() int {
+	cp.poolLock.Lock()
+	defer cp.poolLock.Unlock()
+
+	return len(cp.missingConnections)
+}
+
+// PoolState is a snapshot of a ConnectionPool for admin endpoints, including when it will next retry
+// replacing a dead connection or restoring the missing connections of a degraded pool.
+type PoolState struct {
+	Initialized        bool
+	MaxConnections     uint64
+	IdleConnections    int64 // connections waiting in the pool
+	FlaggedConnections int
+	MissingConnections int
+	ReplaceRetry       models.RetryState // replacing dead or flagged connections
+	RestoreRetry       models.RetryState // restoring the missing connections of a degraded pool
+}
+
+// State returns a snapshot of the pool's connections and retry state.
+func (cp *ConnectionPool) State() PoolState {
+	cp.poolLock.Lock()
+	state := PoolState{
+		Initialized:        cp.Initialized,
+		MaxConnections:     cp.maxConnections,
+		MissingConnections: len(cp.missingConnections),
+		IdleConnections:    cp.connections.Len(),
+	}
+	cp.poolLock.Unlock()
+
+	cp.poolRWLock.RLock()
+	for _, flagged := range cp.flaggedConnections {
+		if flagged {
+			state.FlaggedConnections++
 		}
 	}
+	cp.poolRWLock.RUnlock()
 
-	return true
+	state.ReplaceRetry = cp.replaceRetry.State()
+	state.RestoreRetry = cp.restoreRetry.State()
+
+	return state
+}
+
+// StopRestoring ends the background restore of a degraded pool, must be called while holding the pool lock.
+func (cp *ConnectionPool) stopRestoring() {
+	if cp.restoreStop != nil {
+		close(cp.restoreStop)
+		cp.restoreStop = nil
+	}
+
+	cp.missingConnections = nil
+}
+
+// CreateConnectionHostByConfig creates the Connection with or without TLS.
+func (cp *ConnectionPool) createConnectionHostByConfig(connectionID uint64) (*ConnectionHost, error) {
+	if cp.enableTLS {
+		return cp.createConnectionHostWithTLS(connectionID)
+	}
+
+	return cp.createConnectionHost(connectionID)
+}
+
+// ResetConnections closes any connections created by a failed initialization and empties the queue.
+func (cp *ConnectionPool) resetConnections() {
+	cp.shutdownConnections()
+	cp.connectionID = 0
+	cp.connections = queue.New(int64(cp.config.ConnectionPoolConfig.MaxConnectionCount))
 }
 
 // CreateConnectionHost creates the Connection with RabbitMQ server.
 func (cp *ConnectionPool) createConnectionHost(connectionID uint64) (*ConnectionHost, error) {
 
-	return NewConnectionHost(
-		cp.uri,
-		cp.connectionName+"-"+strconv.FormatUint(connectionID, 10),
-		connectionID,
-		cp.heartbeat,
-		cp.connectionTimeout,
-		cp.maxChannelPerConnection,
-		cp.maxAckChannelPerConnection)
+	connectionHost, err := cp.dialHost(cp.uri, connectionID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cp.registerHost(connectionHost)
+	models.Go("connectionpool.watch", func() { cp.watchConnection(connectionHost) })
+
+	return connectionHost, nil
 }
 
 // CreateConnectionHostWithTLS creates the Connection with RabbitMQ server.
@@ -199,19 +432,50 @@ func (cp *ConnectionPool) createConnectionHostWithTLS(connectionID uint64) (*Con
 		return nil, errors.New("tls enabled but tlsConfig has not been created")
 	}
 
-	return NewConnectionHostWithTLS(
-		cp.uri,
-		cp.connectionName+"-"+strconv.FormatUint(connectionID, 10),
-		connectionID,
-		cp.heartbeat,
-		cp.connectionTimeout,
-		cp.maxChannelPerConnection,
-		cp.maxAckChannelPerConnection,
-		cp.tlsConfig)
+	connectionHost, err := cp.dialHost("amqps://"+cp.uri, connectionID, cp.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	cp.registerHost(connectionHost)
+	models.Go("connectionpool.watch", func() { cp.watchConnection(connectionHost) })
+
+	return connectionHost, nil
+}
+
+// WatchConnection raises ConnectionLost, Blocked, and Unblocked events for the life of the connection.
+// Exits when the connection closes (amqp closes the notify channels).
+func (cp *ConnectionPool) watchConnection(connHost *ConnectionHost) {
+
+	closeErrors := connHost.Connection.NotifyClose(make(chan *amqp.Error, 1))
+	blockings := connHost.Connection.NotifyBlocked(make(chan amqp.Blocking, 1))
+
+	for {
+		select {
+		case amqpErr := <-closeErrors:
+			if amqpErr != nil { // nil on a graceful close
+				cp.emitEvent(models.NewEvent(models.ConnectionLost, connHost.ConnectionID, 0, amqpErr.Reason))
+			}
+			return
+		case blocking, ok := <-blockings:
+			if !ok {
+				return
+			}
+
+			if blocking.Active {
+				cp.emitEvent(models.NewEvent(models.Blocked, connHost.ConnectionID, 0, blocking.Reason))
+			} else {
+				cp.emitEvent(models.NewEvent(models.Unblocked, connHost.ConnectionID, 0, blocking.Reason))
+			}
+		}
+	}
 }
 
 func (cp *ConnectionPool) handleError(err error) {
-	go func() { cp.errors <- err }()
+	if !models.SendError("connectionpool.errors", cp.errors, err) {
+		atomic.AddUint64(&cp.droppedErrors, 1)
+		models.GetMetrics().Counter(models.MetricDroppedErrors, 1, models.Labels{"source": "connectionpool"})
+	}
 }
 
 // Errors yields all the internal errs for creating connections.
@@ -219,10 +483,43 @@ func (cp *ConnectionPool) Errors() <-chan error {
 	return cp.errors
 }
 
+// DroppedErrors returns how many errors were dropped instead of sent to Errors() (see models.SetErrorDelivery).
+func (cp *ConnectionPool) DroppedErrors() uint64 {
+	return atomic.LoadUint64(&cp.droppedErrors)
+}
+
+// EmitEvent sends the event without blocking, events are dropped when nobody is draining Events().
+func (cp *ConnectionPool) emitEvent(event *models.Event) {
+	models.CountEvent(event)
+	models.AuditEvent(event)
+
+	select {
+	case cp.events <- event:
+	default:
+	}
+}
+
+// Events yields typed connection (and channel, when shared with a ChannelPool) lifecycle events.
+// Events are dropped, not queued, when the buffer is full.
+func (cp *ConnectionPool) Events() <-chan *models.Event {
+	return cp.events
+}
+
 // GetConnection gets a connection based on whats in the ConnectionPool (blocking under bad network conditions).
 // Outages/transient network outages block until success connecting.
 // Uses the SleepOnErrorInterval to pause between retries.
 func (cp *ConnectionPool) GetConnection() (*ConnectionHost, error) {
+	return cp.GetConnectionWithContext(context.Background())
+}
+
+// GetConnectionWithContext gets a connection like GetConnection but stops waiting on the queue, or on
+// replacing a dead connection, once the context is done.
+func (cp *ConnectionPool) GetConnectionWithContext(ctx context.Context) (*ConnectionHost, error) {
+
+	if !cp.drain.enter() {
+		return nil, ErrDraining
+	}
+	defer cp.drain.leave()
 
 	if atomic.LoadInt32(&cp.connectionLock) > 0 {
 		return nil, errors.New("can't get connection - connection pool has been shutdown")
@@ -234,16 +531,26 @@ func (cp *ConnectionPool) GetConnection() (*ConnectionHost, error) {
 
 	// Pull from the queue.
 	// Pauses here if the queue is empty.
-	structs, err := cp.connections.Get(1)
+DequeueConnection:
+	item, err := dequeue(ctx, cp.connections)
 	if err != nil {
 		return nil, err
 	}
 
-	connectionHost, ok := structs[0].(*ConnectionHost)
+	connectionHost, ok := item.(*ConnectionHost)
 	if !ok {
 		return nil, errors.New("invalid struct type found in ConnectionPool queue")
 	}
 
+	if cp.removeIfPending(connectionHost) {
+		goto DequeueConnection // removed by a shrinking Resize
+	}
+
+	if cp.drain.isDraining() { // started while waiting on the queue
+		cp.returnConnection(connectionHost)
+		return nil, ErrDraining
+	}
+
 	healthy := true
 	select {
 	case <-connectionHost.CloseErrors():
@@ -263,38 +570,61 @@ func (cp *ConnectionPool) GetConnection() (*ConnectionHost, error) {
 		cp.FlagConnection(connectionHost.ConnectionID)
 
 		var err error
+		deadConnectionHost := connectionHost
 		replacementConnectionID := connectionHost.ConnectionID
 		connectionHost = nil
 
-		// Do not leave without a good Connection.
+		// Do not leave without a good Connection (or a done context).
 		for connectionHost == nil {
 
-			if cp.sleepOnErrorInterval > 0 {
-				time.Sleep(cp.sleepOnErrorInterval)
+			if err = sleepWithContext(ctx, cp.sleepOnErrorInterval); err != nil {
+				cp.returnConnection(deadConnectionHost) // still flagged, the next caller will replace it
+				return nil, err
 			}
 
 			if cp.enableTLS { // Replacement Connection
 				connectionHost, err = cp.createConnectionHostWithTLS(replacementConnectionID)
 				if err != nil {
+					cp.replaceRetry.Failed(err, cp.sleepOnErrorInterval)
 					continue
 				}
 			} else { // Replacement Connection
 				connectionHost, err = cp.createConnectionHost(replacementConnectionID)
 				if err != nil {
+					cp.replaceRetry.Failed(err, cp.sleepOnErrorInterval)
 					continue
 				}
 			}
 		}
 
+		cp.replaceRetry.Succeeded()
+
 		cp.UnflagConnection(replacementConnectionID)
+		cp.emitEvent(models.NewEvent(models.ConnectionRestored, replacementConnectionID, 0, "connection replaced"))
+	}
+
+	connectionHost.setChannelLimits(
+		atomic.LoadUint64(&cp.maxChannelPerConnection),
+		atomic.LoadUint64(&cp.maxAckChannelPerConnection))
+
+	if models.GetChaos().Inject(models.ChaosForceReconnect) {
+		_ = connectionHost.Connection.Close()
+		cp.emitEvent(models.NewEvent(models.ChaosInjected, connectionHost.ConnectionID, 0, models.ChaosForceReconnect.String()))
 	}
 
+	cp.drain.lease()
 	return connectionHost, nil
 }
 
 // ReturnConnection puts the connection back in the queue.
 // This helps maintain a Round Robin on Connections and their resources.
 func (cp *ConnectionPool) ReturnConnection(connHost *ConnectionHost) {
+	cp.drain.release()
+	cp.returnConnection(connHost)
+}
+
+// ReturnConnection puts a connection that wasn't handed out (no lease to release) back in the queue.
+func (cp *ConnectionPool) returnConnection(connHost *ConnectionHost) {
 	if err := cp.connections.Put(connHost); err != nil {
 		cp.handleError(err)
 	}
@@ -339,10 +669,14 @@ func (cp *ConnectionPool) Shutdown() {
 	atomic.AddInt32(&cp.connectionLock, 1)
 
 	if cp.Initialized {
+		cp.stopWatchdog()
+		cp.stopRecycling()
+		cp.stopRestoring()
 		cp.shutdownConnections()
 
 		cp.connections = queue.New(int64(cp.maxConnections))
 		cp.flaggedConnections = make(map[uint64]bool)
+		atomic.StoreInt64(&cp.pendingRemovals, 0)
 		cp.connectionID = 0
 		cp.Initialized = false
 
@@ -355,6 +689,10 @@ func (cp *ConnectionPool) Shutdown() {
 
 // ShutdownConnections actually closes all the connections.
 func (cp *ConnectionPool) shutdownConnections() {
+	cp.poolRWLock.Lock()
+	cp.hosts = make(map[uint64]*ConnectionHost)
+	cp.poolRWLock.Unlock()
+
 	for !cp.connections.Empty() {
 		items, _ := cp.connections.Get(cp.connections.Len())
 
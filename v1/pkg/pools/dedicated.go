@@ -0,0 +1,109 @@
+package pools
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// ErrAckChannelLimit is returned for a dedicated channel when the pool's connections are all at their ackable
+// channel limit (see ChannelPoolConfig.MaxAckChannelCount).
+var ErrAckChannelLimit = errors.New("can't open a dedicated channel - connections are at their ackable channel limit")
+
+// GetDedicatedAckableChannel opens an ackable channel for the caller alone. The channels GetAckableChannel hands
+// out stay in the round robin and are shared by everyone holding one, so a multiple ack or nack, or a channel wide
+// (global) prefetch, on them reaches other consumers' deliveries too. A consumer settling that way needs a
+// dedicated channel instead.
+func (cp *ChannelPool) GetDedicatedAckableChannel() (*ChannelHost, error) {
+	return cp.GetDedicatedAckableChannelWithContext(context.Background())
+}
+
+// GetDedicatedAckableChannelWithContext opens a dedicated ackable channel like GetDedicatedAckableChannel but
+// stops waiting on a connection once the context is done. The channel counts against the connection's ackable
+// channel limit like the pooled ones, ErrAckChannelLimit when no connection has room left for it, and is only
+// the caller's until it's given back with ReturnChannel, which closes it.
+func (cp *ChannelPool) GetDedicatedAckableChannelWithContext(ctx context.Context) (*ChannelHost, error) {
+	if !cp.drain.enter() {
+		return nil, ErrDraining
+	}
+	defer cp.drain.leave()
+
+	if atomic.LoadInt32(&cp.channelLock) > 0 {
+		return nil, errors.New("can't get channel - channel pool has been shutdown")
+	}
+
+	if !cp.Initialized {
+		if err := sleepWithContext(ctx, cp.sleepOnErrorInterval); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("can't get channel - channel pool has not been initialized")
+	}
+
+	cp.poolLock.Lock()
+	channelID := cp.channelID
+	cp.channelID++
+	cp.poolLock.Unlock()
+
+	connHost, err := cp.dedicatedConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cp.connectionPool.ReturnConnection(connHost)
+
+	channelHost, err := NewChannelHost(connHost.Connection, channelID, connHost.ConnectionID, true)
+	if err != nil {
+		cp.connectionPool.FlagConnection(connHost.ConnectionID) // flag connection as a problem
+		return nil, err
+	}
+
+	channelHost.dedicatedOn = connHost
+	connHost.AddAckChannel()
+
+	if cp.globalQosCount > 0 {
+		started := time.Now()
+		err = channelHost.Channel.Qos(cp.globalQosCount, 0, true)
+		models.Trace("qos", connHost.ConnectionID, channelID, started, err, "prefetch: %d, global: true", cp.globalQosCount)
+		if err != nil {
+			cp.handleError(err)
+		}
+	}
+
+	if cp.drain.isDraining() { // started while opening the channel
+		cp.closeDedicated(channelHost)
+		return nil, ErrDraining
+	}
+
+	cp.drain.lease()
+	return channelHost, nil
+}
+
+// DedicatedConnection gets a connection with room for one more ackable channel, ErrAckChannelLimit when the
+// connections tried in a row are all full.
+func (cp *ChannelPool) dedicatedConnection(ctx context.Context) (*ConnectionHost, error) {
+	for i := 0; i <= 3; i++ { // like createChannelHost, give up after a few full connections in a row
+		connHost, err := cp.connectionPool.GetConnectionWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if connHost.CanAddAckChannel() {
+			return connHost, nil
+		}
+
+		cp.connectionPool.ReturnConnection(connHost)
+	}
+
+	return nil, ErrAckChannelLimit
+}
+
+// CloseDedicated closes a dedicated channel given back to the pool, freeing its place on the connection.
+func (cp *ChannelPool) closeDedicated(chanHost *ChannelHost) {
+	_ = chanHost.Close() // fails for a channel the broker or its connection closed already, nothing left to close
+
+	if err := chanHost.dedicatedOn.RemoveAckChannel(); err != nil {
+		cp.handleError(err)
+	}
+}
@@ -0,0 +1,212 @@
+package pools
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+// AddressRotator resolves the broker host again on every dial (nothing is cached here, so the resolver's TTLs
+// apply) and rotates through the returned A/AAAA records, so connections follow a broker failover behind DNS
+// and are spread over every address. With preferHealthy, addresses that last dialed fine are tried before
+// unknown ones, and addresses that last failed are tried last.
+type addressRotator struct {
+	resolver      *net.Resolver
+	dialer        *net.Dialer
+	timeout       time.Duration
+	preferHealthy bool
+	rotation      map[string]int
+	succeeded     map[string]time.Time
+	failed        map[string]time.Time
+	rotatorLock   *sync.Mutex
+}
+
+func newAddressRotator(dialer *net.Dialer, preferHealthy bool) *addressRotator {
+	return &addressRotator{
+		resolver:      net.DefaultResolver,
+		dialer:        dialer,
+		timeout:       dialer.Timeout,
+		preferHealthy: preferHealthy,
+		rotation:      make(map[string]int),
+		succeeded:     make(map[string]time.Time),
+		failed:        make(map[string]time.Time),
+		rotatorLock:   &sync.Mutex{},
+	}
+}
+
+// Dial is an amqp.Config Dial, trying each address of the host in turn until one connects.
+// Like amqp.DefaultDial, a deadline covers the handshakes until the connection is open.
+func (ar *addressRotator) dial(network string, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := []string{host}
+	if net.ParseIP(host) == nil {
+		if addresses, err = ar.resolve(host); err != nil {
+			return nil, err
+		}
+	}
+
+	var lastErr error
+	for _, address := range addresses {
+		conn, err := ar.dialer.Dial(network, net.JoinHostPort(address, port))
+		if err != nil {
+			ar.record(address, false)
+			lastErr = err
+			continue
+		}
+
+		ar.record(address, true)
+
+		if err = conn.SetDeadline(time.Now().Add(ar.timeout)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+
+	return nil, lastErr
+}
+
+// Resolve looks the host up and orders its addresses for this dial.
+func (ar *addressRotator) resolve(host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ar.timeout)
+	defer cancel()
+
+	ipAddrs, err := ar.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, len(ipAddrs))
+	for i, ipAddr := range ipAddrs {
+		addresses[i] = ipAddr.String()
+	}
+
+	return ar.order(host, addresses), nil
+}
+
+// Order rotates the (sorted, resolvers shuffle) addresses one further on every dial of the host and, when
+// preferring healthy addresses, ranks them by their last dial.
+func (ar *addressRotator) order(host string, addresses []string) []string {
+	sort.Strings(addresses)
+
+	ar.rotatorLock.Lock()
+	defer ar.rotatorLock.Unlock()
+
+	offset := ar.rotation[host] % len(addresses)
+	ar.rotation[host]++
+
+	ordered := append(addresses[offset:len(addresses):len(addresses)], addresses[:offset]...)
+	if !ar.preferHealthy {
+		return ordered
+	}
+
+	rank := func(address string) int {
+		succeeded, hasSucceeded := ar.succeeded[address]
+		failed, hasFailed := ar.failed[address]
+
+		switch {
+		case hasSucceeded && (!hasFailed || succeeded.After(failed)):
+			return 0
+		case !hasFailed:
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool { return rank(ordered[i]) < rank(ordered[j]) })
+
+	return ordered
+}
+
+func (ar *addressRotator) record(address string, healthy bool) {
+	ar.rotatorLock.Lock()
+	defer ar.rotatorLock.Unlock()
+
+	if healthy {
+		ar.succeeded[address] = time.Now()
+	} else {
+		ar.failed[address] = time.Now()
+	}
+}
+
+// NewNetDialer creates the dialer for the pool's connections from the ConnectionPoolConfig's TCPKeepAlive and
+// LocalAddress.
+func newNetDialer(timeout time.Duration, config *models.ConnectionPoolConfig) (*net.Dialer, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	switch {
+	case config.TCPKeepAlive < 0:
+		dialer.KeepAlive = -1
+	case config.TCPKeepAlive > 0:
+		dialer.KeepAlive = time.Duration(config.TCPKeepAlive) * time.Millisecond
+	}
+
+	if config.LocalAddress != "" {
+		if ip := net.ParseIP(config.LocalAddress); ip != nil {
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		} else {
+			localAddr, err := net.ResolveTCPAddr("tcp", config.LocalAddress)
+			if err != nil {
+				return nil, fmt.Errorf("can't use local address %s: %w", config.LocalAddress, err)
+			}
+
+			dialer.LocalAddr = localAddr
+		}
+	}
+
+	return dialer, nil
+}
+
+// DeadlineDial is an amqp.Config Dial through the dialer, like amqp.DefaultDial a deadline covers the handshakes
+// until the connection is open.
+func deadlineDial(dialer *net.Dialer) func(network string, addr string) (net.Conn, error) {
+	return func(network string, addr string) (net.Conn, error) {
+		conn, err := dialer.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = conn.SetDeadline(time.Now().Add(dialer.Timeout)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
+
+// DialHost dials a ConnectionHost with the pool's dialer, through the address rotator when rotating addresses.
+func (cp *ConnectionPool) dialHost(uri string, connectionID uint64, tlsConfig *tls.Config) (*ConnectionHost, error) {
+	dial := deadlineDial(cp.dialer)
+	if cp.rotator != nil {
+		dial = cp.rotator.dial
+	}
+
+	return dialConnectionHost(
+		uri,
+		connectionID,
+		atomic.LoadUint64(&cp.maxChannelPerConnection),
+		atomic.LoadUint64(&cp.maxAckChannelPerConnection),
+		amqp.Config{
+			Heartbeat:       cp.heartbeat,
+			Dial:            dial,
+			TLSClientConfig: tlsConfig,
+			Properties: amqp.Table{
+				"connection_name": models.GetNamer().ConnectionName(cp.connectionName, connectionID),
+			},
+		})
+}
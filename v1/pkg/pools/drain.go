@@ -0,0 +1,168 @@
+package pools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+const drainPollInterval = 10 * time.Millisecond
+
+// ErrDraining is returned for a channel or connection asked of a pool that's draining or drained (see Drain).
+var ErrDraining = errors.New("pool is draining")
+
+// DrainState is how far a pool's Drain has come.
+type DrainState struct {
+	Draining    bool  // Drain was called and the pool hasn't been initialized again since
+	Outstanding int64 // channels or connections handed out and not returned yet
+	Waiting     int64 // callers inside a get, waiting on the queue or for a replacement
+	Drained     bool  // every lease came back (or the drain gave up) and the pool has been shut down
+}
+
+// Drainer counts the leases a pool hands out, so a drain can wait for them to come back. A get enters before
+// checking for a drain, so a drain that sees no getters and no leases knows none are on their way either.
+type drainer struct {
+	leases   int64
+	getters  int64
+	draining int32
+	drained  int32
+}
+
+// Enter registers a get, false when the pool is draining.
+func (d *drainer) enter() bool {
+	atomic.AddInt64(&d.getters, 1)
+	if atomic.LoadInt32(&d.draining) == 1 {
+		atomic.AddInt64(&d.getters, -1)
+		return false
+	}
+
+	return true
+}
+
+func (d *drainer) leave() {
+	atomic.AddInt64(&d.getters, -1)
+}
+
+func (d *drainer) isDraining() bool {
+	return atomic.LoadInt32(&d.draining) == 1
+}
+
+func (d *drainer) lease() {
+	atomic.AddInt64(&d.leases, 1)
+}
+
+// Release counts a lease returned, never going below zero for a host that wasn't handed out by the pool.
+func (d *drainer) release() {
+	for {
+		leases := atomic.LoadInt64(&d.leases)
+		if leases <= 0 || atomic.CompareAndSwapInt64(&d.leases, leases, leases-1) {
+			return
+		}
+	}
+}
+
+// Start begins a drain, false when one already has.
+func (d *drainer) start() bool {
+	return atomic.CompareAndSwapInt32(&d.draining, 0, 1)
+}
+
+// Wait waits for every get and lease to finish, or the context to be done.
+func (d *drainer) wait(ctx context.Context, leased string) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&d.getters) > 0 || atomic.LoadInt64(&d.leases) > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("can't drain, %d %s still out: %w", atomic.LoadInt64(&d.leases), leased, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+func (d *drainer) finish() {
+	atomic.StoreInt64(&d.leases, 0)
+	atomic.StoreInt32(&d.drained, 1)
+}
+
+// Reset ends a finished drain when the pool is initialized again.
+func (d *drainer) reset() {
+	if atomic.LoadInt32(&d.drained) == 1 {
+		atomic.StoreInt32(&d.drained, 0)
+		atomic.StoreInt32(&d.draining, 0)
+	}
+}
+
+func (d *drainer) state() DrainState {
+	return DrainState{
+		Draining:    atomic.LoadInt32(&d.draining) == 1,
+		Outstanding: atomic.LoadInt64(&d.leases),
+		Waiting:     atomic.LoadInt64(&d.getters),
+		Drained:     atomic.LoadInt32(&d.drained) == 1,
+	}
+}
+
+// Drain stops handing out channels (GetChannel and GetAckableChannel return ErrDraining), waits for the
+// channels handed out to be returned, then closes them and drains the ConnectionPool before shutting it down.
+// Only GetChannel's and dedicated ackable channels are waited on: GetAckableChannel's stay shared in the pool
+// (nobody owns one to return it), so settle what was received on them before draining.
+// When the context is done first the pool is shut down anyway, and the error says how many channels were still
+// out. The pool keeps returning ErrDraining until it's initialized again, see DrainState for the progress.
+func (cp *ChannelPool) Drain(ctx context.Context) error {
+	if !cp.drain.start() {
+		return errors.New("can't drain - channel pool is already draining")
+	}
+
+	cp.connectionPool.emitEvent(
+		models.NewEvent(models.PoolDraining, 0, 0, fmt.Sprintf("channel pool draining (%d channels out)", cp.drain.state().Outstanding)))
+
+	err := cp.drain.wait(ctx, "channels")
+
+	cp.shutdown(func() {
+		if connErr := cp.connectionPool.Drain(ctx); connErr != nil && err == nil {
+			err = connErr
+		}
+	})
+
+	cp.drain.finish()
+	cp.connectionPool.emitEvent(models.NewEvent(models.PoolDrained, 0, 0, "channel pool drained"))
+
+	return err
+}
+
+// DrainState returns how far the ChannelPool's Drain has come.
+func (cp *ChannelPool) DrainState() DrainState {
+	return cp.drain.state()
+}
+
+// Drain stops handing out connections (GetConnection returns ErrDraining), waits for the connections handed out
+// to be returned, then shuts the pool down. Channels a ChannelPool opened on the connections aren't leases, drain
+// the ChannelPool instead, which drains its ConnectionPool once its channels are back. When the context is done
+// first the pool is shut down anyway, and the error says how many connections were still out.
+func (cp *ConnectionPool) Drain(ctx context.Context) error {
+	if !cp.drain.start() {
+		return errors.New("can't drain - connection pool is already draining")
+	}
+
+	cp.emitEvent(models.NewEvent(models.PoolDraining, 0, 0, fmt.Sprintf("connection pool draining (%d connections out)", cp.drain.state().Outstanding)))
+
+	err := cp.drain.wait(ctx, "connections")
+
+	cp.Shutdown()
+
+	cp.drain.finish()
+	cp.emitEvent(models.NewEvent(models.PoolDrained, 0, 0, "connection pool drained"))
+
+	return err
+}
+
+// DrainState returns how far the ConnectionPool's Drain has come.
+func (cp *ConnectionPool) DrainState() DrainState {
+	return cp.drain.state()
+}
@@ -1,7 +1,11 @@
 package pools_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -323,6 +327,34 @@ func TestCreateChannelPool(t *testing.T) {
 	channelPool.Shutdown()
 }
 
+func TestGetChannelWithContextTimeout(t *testing.T) {
+	defer leaktest.Check(t)() // Fail on leaked goroutines.
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	// Lease every channel so the next GetChannel has to wait.
+	leased := make([]*pools.ChannelHost, 0)
+	for channelPool.ChannelCount() > 0 {
+		chanHost, err := channelPool.GetChannel()
+		assert.NoError(t, err)
+		leased = append(leased, chanHost)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(100)*time.Millisecond)
+	defer cancel()
+
+	chanHost, err := channelPool.GetChannelWithContext(ctx)
+	assert.Nil(t, chanHost)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	for _, chanHost := range leased {
+		channelPool.ReturnChannel(chanHost, false)
+	}
+
+	channelPool.Shutdown()
+}
+
 func TestCreateChannelPoolAndShutdown(t *testing.T) {
 
 	connectionPool, err := pools.NewConnectionPool(Seasoning.PoolConfig, false)
@@ -566,3 +598,575 @@ func TestGetChannelDuringOutage(t *testing.T) {
 	assert.Equal(t, iterations, maxIterationCount)
 	channelPool.Shutdown()
 }
+
+func TestResizeChannelPool(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	maxChannels := Seasoning.PoolConfig.ChannelPoolConfig.MaxChannelCount
+
+	assert.NoError(t, channelPool.Resize(maxChannels+10))
+	assert.Equal(t, int64(maxChannels+10), channelPool.ChannelCount())
+
+	// a checked out channel is only removed once it's returned
+	chanHost, err := channelPool.GetChannel()
+	assert.NoError(t, err)
+
+	assert.NoError(t, channelPool.Resize(1))
+	assert.Equal(t, int64(0), channelPool.ChannelCount())
+
+	channelPool.ReturnChannel(chanHost, false)
+	assert.Equal(t, int64(1), channelPool.ChannelCount())
+
+	assert.Error(t, channelPool.Resize(0))
+
+	channelPool.Shutdown()
+}
+
+func TestResizeConnectionPool(t *testing.T) {
+	connectionPool, err := pools.NewConnectionPool(Seasoning.PoolConfig, true)
+	assert.NoError(t, err)
+
+	maxConnections := Seasoning.PoolConfig.ConnectionPoolConfig.MaxConnectionCount
+
+	assert.NoError(t, connectionPool.Resize(maxConnections+2))
+	assert.Equal(t, int64(maxConnections+2), connectionPool.ConnectionCount())
+
+	assert.NoError(t, connectionPool.Resize(1))
+	assert.Equal(t, int64(1), connectionPool.ConnectionCount()) // no channels, every connection is idle
+
+	var resized bool
+EventLoop:
+	for {
+		select {
+		case event := <-connectionPool.Events():
+			if event.Type == models.PoolResized {
+				resized = true
+			}
+		default:
+			break EventLoop
+		}
+	}
+	assert.True(t, resized)
+
+	connectionPool.Shutdown()
+}
+
+func TestConnectionWatchdogKeepsHealthyConnections(t *testing.T) {
+	connectionPoolConfig := *Seasoning.PoolConfig.ConnectionPoolConfig
+	connectionPoolConfig.WatchdogInterval = 100
+	connectionPoolConfig.WatchdogTimeout = 2000
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.ConnectionPoolConfig = &connectionPoolConfig
+
+	connectionPool, err := pools.NewConnectionPool(&poolConfig, true)
+	assert.NoError(t, err)
+
+	time.Sleep(time.Duration(500) * time.Millisecond)
+
+EventLoop:
+	for {
+		select {
+		case event := <-connectionPool.Events():
+			assert.NotEqual(t, models.ConnectionStale, event.Type)
+		default:
+			break EventLoop
+		}
+	}
+
+	connHost, err := connectionPool.GetConnection()
+	assert.NoError(t, err)
+	assert.False(t, connectionPool.IsConnectionFlagged(connHost.ConnectionID))
+	connectionPool.ReturnConnection(connHost)
+
+	connectionPool.Shutdown()
+}
+
+func TestChannelPoolRecyclesOldChannels(t *testing.T) {
+	channelPoolConfig := *Seasoning.PoolConfig.ChannelPoolConfig
+	channelPoolConfig.MaxChannelAge = 50
+	channelPoolConfig.RecycleInterval = 10
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.ChannelPoolConfig = &channelPoolConfig
+
+	channelPool, err := pools.NewChannelPool(&poolConfig, nil, true)
+	assert.NoError(t, err)
+
+	time.Sleep(time.Duration(100) * time.Millisecond)
+
+	// checking out every channel at once, only the first one is recycled in this interval
+	chanHosts := make([]*pools.ChannelHost, channelPoolConfig.MaxChannelCount)
+	for i := range chanHosts {
+		chanHosts[i], err = channelPool.GetChannel()
+		assert.NoError(t, err)
+	}
+
+	recycled := 0
+	for _, chanHost := range chanHosts {
+		if chanHost.Age() < time.Duration(50)*time.Millisecond {
+			recycled++
+		}
+
+		channelPool.ReturnChannel(chanHost, false)
+	}
+
+	assert.Equal(t, 1, recycled)
+
+	event := <-channelPool.Events()
+	assert.Equal(t, models.ChannelRecycled, event.Type)
+
+	channelPool.Shutdown()
+}
+
+func TestConnectionPoolRecyclesOldConnections(t *testing.T) {
+	connectionPoolConfig := *Seasoning.PoolConfig.ConnectionPoolConfig
+	connectionPoolConfig.MaxConnectionAge = 100
+	connectionPoolConfig.RecycleInterval = 50
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.ConnectionPoolConfig = &connectionPoolConfig
+
+	connectionPool, err := pools.NewConnectionPool(&poolConfig, true)
+	assert.NoError(t, err)
+
+	event := <-connectionPool.Events()
+	assert.Equal(t, models.ConnectionRecycled, event.Type)
+	assert.True(t, connectionPool.IsConnectionFlagged(event.ConnectionID))
+
+	// the flagged connection is replaced on checkout, nothing else is recycled until then
+	for i := int64(0); i < connectionPool.ConnectionCount(); i++ {
+		connHost, err := connectionPool.GetConnection()
+		assert.NoError(t, err)
+		connectionPool.ReturnConnection(connHost)
+	}
+
+	assert.False(t, connectionPool.IsConnectionFlagged(event.ConnectionID))
+
+	connectionPool.Shutdown()
+}
+
+func TestConnectionPoolRotatesAddresses(t *testing.T) {
+	connectionPoolConfig := *Seasoning.PoolConfig.ConnectionPoolConfig
+	connectionPoolConfig.RotateAddresses = true
+	connectionPoolConfig.PreferHealthyAddresses = true
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.ConnectionPoolConfig = &connectionPoolConfig
+
+	connectionPool, err := pools.NewConnectionPool(&poolConfig, true)
+	assert.NoError(t, err)
+	assert.Equal(t, connectionPoolConfig.MaxConnectionCount, uint64(connectionPool.ConnectionCount()))
+
+	connHost, err := connectionPool.GetConnection()
+	assert.NoError(t, err)
+	assert.False(t, connHost.Connection.IsClosed())
+	connectionPool.ReturnConnection(connHost)
+
+	connectionPool.Shutdown()
+}
+
+func TestConnectionPoolDialerTuning(t *testing.T) {
+	connectionPoolConfig := *Seasoning.PoolConfig.ConnectionPoolConfig
+	connectionPoolConfig.TCPKeepAlive = 5000
+	connectionPoolConfig.LocalAddress = "127.0.0.1"
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.ConnectionPoolConfig = &connectionPoolConfig
+
+	connectionPool, err := pools.NewConnectionPool(&poolConfig, true)
+	assert.NoError(t, err)
+
+	connHost, err := connectionPool.GetConnection()
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", connHost.Connection.LocalAddr().(*net.TCPAddr).IP.String())
+	connectionPool.ReturnConnection(connHost)
+
+	connectionPool.Shutdown()
+
+	connectionPoolConfig.LocalAddress = "127.0.0.1:notaport"
+	_, err = pools.NewConnectionPool(&poolConfig, false)
+	assert.Error(t, err)
+}
+
+func TestConnectionPoolServerProperties(t *testing.T) {
+	connectionPool, err := pools.NewConnectionPool(Seasoning.PoolConfig, true)
+	assert.NoError(t, err)
+
+	properties := connectionPool.ServerProperties()
+	assert.Equal(t, int(Seasoning.PoolConfig.ConnectionPoolConfig.MaxConnectionCount), len(properties))
+
+	for _, serverProperties := range properties {
+		assert.Equal(t, "RabbitMQ", serverProperties.Product)
+		assert.Equal(t, 0, serverProperties.ProtocolMajor)
+		assert.Equal(t, 9, serverProperties.ProtocolMinor)
+		assert.True(t, serverProperties.VersionAtLeast(3, 0, 0))
+		assert.False(t, serverProperties.VersionAtLeast(99, 0, 0))
+	}
+
+	assert.True(t, connectionPool.Supports(pools.CapabilityPublisherConfirms))
+	assert.True(t, connectionPool.Supports(pools.CapabilityConsumerCancelNotify))
+	assert.False(t, connectionPool.Supports("not_a_capability"))
+
+	connectionPool.Shutdown()
+}
+
+func TestConnectionPoolState(t *testing.T) {
+	connectionPool, err := pools.NewConnectionPool(Seasoning.PoolConfig, true)
+	assert.NoError(t, err)
+
+	state := connectionPool.State()
+	assert.True(t, state.Initialized)
+	assert.Equal(t, Seasoning.PoolConfig.ConnectionPoolConfig.MaxConnectionCount, state.MaxConnections)
+	assert.Equal(t, 0, state.MissingConnections)
+	assert.False(t, state.ReplaceRetry.Retrying)
+	assert.False(t, state.RestoreRetry.Retrying)
+
+	connectionPool.Shutdown()
+}
+
+// brokerProxy forwards connections to the test broker, refusing all but the first while refusing is set.
+type brokerProxy struct {
+	listener net.Listener
+	accepted int32
+	refusing int32
+}
+
+func newBrokerProxy(t *testing.T) *brokerProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := &brokerProxy{listener: listener, refusing: 1}
+	go proxy.serve()
+
+	return proxy
+}
+
+func (bp *brokerProxy) serve() {
+	for {
+		conn, err := bp.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		if atomic.AddInt32(&bp.accepted, 1) > 1 && atomic.LoadInt32(&bp.refusing) == 1 {
+			conn.Close() // the broker is unreachable on this address
+			continue
+		}
+
+		broker, err := net.Dial("tcp", "localhost:5672")
+		if err != nil {
+			conn.Close()
+			continue
+		}
+
+		go func() { _, _ = io.Copy(broker, conn); broker.Close() }()
+		go func() { _, _ = io.Copy(conn, broker); conn.Close() }()
+	}
+}
+
+func (bp *brokerProxy) uri() string {
+	return fmt.Sprintf("amqp://guest:guest@%s/", bp.listener.Addr())
+}
+
+func TestConnectionPoolDegradedRestore(t *testing.T) {
+	proxy := newBrokerProxy(t)
+	defer proxy.listener.Close()
+
+	connectionPoolConfig := *Seasoning.PoolConfig.ConnectionPoolConfig
+	connectionPoolConfig.URI = proxy.uri()
+	connectionPoolConfig.MaxConnectionCount = 2
+	connectionPoolConfig.MinConnectionCount = 1
+	connectionPoolConfig.RestoreInterval = 50
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.ConnectionPoolConfig = &connectionPoolConfig
+
+	// the second connection can't be dialed, one healthy connection is enough to initialize degraded
+	connectionPool, err := pools.NewConnectionPool(&poolConfig, true)
+	assert.NoError(t, err)
+	assert.True(t, connectionPool.Degraded())
+	assert.Equal(t, 1, connectionPool.MissingConnectionCount())
+	assert.Equal(t, int64(1), connectionPool.ConnectionCount())
+
+	state := connectionPool.State()
+	assert.True(t, state.Initialized)
+	assert.Equal(t, 1, state.MissingConnections)
+	assert.True(t, state.RestoreRetry.Retrying)
+	assert.Error(t, state.RestoreRetry.LastError)
+
+	event := <-connectionPool.Events()
+	assert.Equal(t, models.PoolDegraded, event.Type)
+
+	// the degraded pool keeps serving from its healthy connection
+	connHost, err := connectionPool.GetConnection()
+	assert.NoError(t, err)
+	assert.False(t, connHost.Connection.IsClosed())
+	connectionPool.ReturnConnection(connHost)
+
+	time.Sleep(time.Duration(200) * time.Millisecond) // a few failed restores
+	assert.True(t, connectionPool.Degraded())
+	assert.True(t, connectionPool.State().RestoreRetry.Attempts > 1)
+
+	atomic.StoreInt32(&proxy.refusing, 0)
+
+	var restored, poolRestored bool
+	timeout := time.After(time.Duration(5) * time.Second)
+EventLoop:
+	for !poolRestored {
+		select {
+		case event := <-connectionPool.Events():
+			switch event.Type {
+			case models.ConnectionRestored:
+				restored = true
+				assert.Equal(t, uint64(1), event.ConnectionID)
+			case models.PoolRestored:
+				poolRestored = true
+			}
+		case <-timeout:
+			break EventLoop
+		}
+	}
+
+	assert.True(t, restored)
+	assert.True(t, poolRestored)
+	assert.False(t, connectionPool.Degraded())
+	assert.Equal(t, int64(2), connectionPool.ConnectionCount())
+
+	state = connectionPool.State()
+	assert.Equal(t, 0, state.MissingConnections)
+	assert.False(t, state.RestoreRetry.Retrying)
+
+	connectionPool.Shutdown()
+}
+
+func TestGoroutineSupervisor(t *testing.T) {
+	connectionPool, err := pools.NewConnectionPool(Seasoning.PoolConfig, true)
+	assert.NoError(t, err)
+
+	stats := models.Goroutines()
+	assert.True(t, stats.ByName["connectionpool.watch"] >= int64(Seasoning.PoolConfig.ConnectionPoolConfig.MaxConnectionCount))
+
+	warnings := make(chan string, 10)
+	models.SetGoroutineWarning(2, func(name string, live int64) { warnings <- name })
+
+	release := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		assert.True(t, models.TryGo("test.blocked", func() { <-release }))
+	}
+
+	assert.Equal(t, "test.blocked", <-warnings)
+	assert.Equal(t, 0, len(warnings)) // once per name
+
+	models.SetGoroutineLimit(models.Goroutines().Live)
+	assert.False(t, models.TryGo("test.blocked", func() {}))
+	assert.True(t, models.Goroutines().Refused > stats.Refused)
+
+	models.SetGoroutineLimit(0)
+	models.SetGoroutineWarning(0, nil)
+	close(release)
+
+	connectionPool.Shutdown()
+}
+
+type recordingLogger struct {
+	lines chan string
+}
+
+func (rl *recordingLogger) Printf(format string, args ...interface{}) {
+	rl.lines <- fmt.Sprintf(format, args...)
+}
+
+func TestErrorDrain(t *testing.T) {
+	logger := &recordingLogger{lines: make(chan string, 100)}
+	models.SetLogger(logger)
+	defer models.SetLogger(nil)
+
+	errs := make(chan error, 10)
+	errs <- fmt.Errorf("read by the application")
+
+	drain := models.DrainErrors("test", errs, 2)
+	time.Sleep(time.Duration(300) * time.Millisecond)
+	assert.Equal(t, 1, len(errs)) // under half full, left to the application
+	<-errs
+
+	for i := 0; i < 10; i++ {
+		errs <- fmt.Errorf("unread %d", i)
+	}
+
+	assert.Equal(t, "test: unread 0", <-logger.lines)
+	assert.Equal(t, "test: unread 1", <-logger.lines)
+
+	drain.Stop()
+	assert.Equal(t, uint64(2), drain.Logged())
+	assert.True(t, drain.Suppressed() >= 4)
+	assert.Equal(t, fmt.Sprintf("test: %d more errors suppressed", drain.Suppressed()), <-logger.lines)
+	assert.True(t, len(errs) < 5)
+}
+
+func TestChaos(t *testing.T) {
+	connectionPool, err := pools.NewConnectionPool(Seasoning.PoolConfig, true)
+	assert.NoError(t, err)
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, connectionPool, true)
+	assert.NoError(t, err)
+
+	chaos := models.NewChaos(&models.ChaosConfig{Enabled: true, Seed: 1, CloseChannel: 1})
+	models.SetChaos(chaos)
+
+	chanHost, err := channelPool.GetChannel()
+	assert.NoError(t, err)
+	assert.Error(t, chanHost.Channel.Publish("", "ConsumerTestQueue", false, false, amqp.Publishing{}))
+	channelPool.ReturnChannel(chanHost, true)
+
+	assert.Equal(t, uint64(1), chaos.Stats().ChannelsClosed)
+
+	event := <-connectionPool.Events()
+	for event.Type != models.ChaosInjected {
+		event = <-connectionPool.Events()
+	}
+	assert.Equal(t, models.ChaosCloseChannel.String(), event.Reason)
+
+	// with chaos off the flagged channel is replaced as usual
+	models.SetChaos(nil)
+
+	chanHost, err = channelPool.GetChannel()
+	assert.NoError(t, err)
+	assert.NoError(t, chanHost.Channel.Publish("", "ConsumerTestQueue", false, false, amqp.Publishing{}))
+	channelPool.ReturnChannel(chanHost, false)
+
+	channelPool.Shutdown()
+	connectionPool.Shutdown()
+}
+
+func TestTracing(t *testing.T) {
+	logger := &recordingLogger{lines: make(chan string, 100)}
+	models.SetLogger(logger)
+	defer models.SetLogger(nil)
+
+	models.Trace("qos", 1, 2, time.Now(), nil, "prefetch: %d", 10)
+	assert.Equal(t, 0, len(logger.lines)) // off by default
+
+	models.SetTracing(true)
+	defer models.SetTracing(false)
+
+	models.Trace("qos", 1, 2, time.Now(), fmt.Errorf("refused"), "prefetch: %d", 10)
+	line := <-logger.lines
+	assert.Contains(t, line, "trace qos [connection: 1, channel: 2] prefetch: 10 took ")
+	assert.Contains(t, line, " failed: refused")
+
+	connHost, err := ConnectionPool.GetConnection()
+	assert.NoError(t, err)
+	defer ConnectionPool.ReturnConnection(connHost)
+
+	chanHost, err := pools.NewChannelHost(connHost.Connection, 99, connHost.ConnectionID, false)
+	assert.NoError(t, err)
+	assert.NoError(t, chanHost.Close())
+
+	models.SetTracing(false)
+	traced := ""
+	for len(logger.lines) > 0 {
+		traced += <-logger.lines + "\n"
+	}
+
+	ids := fmt.Sprintf("[connection: %d, channel: 99]", connHost.ConnectionID)
+	assert.Contains(t, traced, "trace channel.open "+ids+" ackable: false took ")
+	assert.Contains(t, traced, "trace channel.close "+ids)
+}
+
+func TestChannelPoolDrain(t *testing.T) {
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	chanHost, err := channelPool.GetChannel()
+	assert.NoError(t, err)
+
+	drained := make(chan error, 1)
+	go func() { drained <- channelPool.Drain(context.Background()) }()
+
+	time.Sleep(time.Duration(50) * time.Millisecond)
+
+	_, err = channelPool.GetChannel()
+	assert.Equal(t, pools.ErrDraining, err)
+
+	_, err = channelPool.GetAckableChannel()
+	assert.Equal(t, pools.ErrDraining, err)
+
+	state := channelPool.DrainState()
+	assert.True(t, state.Draining)
+	assert.Equal(t, int64(1), state.Outstanding)
+	assert.False(t, state.Drained)
+
+	// the in-flight publish completes on the channel before it's closed
+	err = chanHost.Channel.Publish("", "TcrTestQueue", false, false, amqp.Publishing{Body: []byte("in flight")})
+	assert.NoError(t, err)
+	channelPool.ReturnChannel(chanHost, false)
+
+	assert.NoError(t, <-drained)
+	assert.True(t, channelPool.DrainState().Drained)
+	assert.Equal(t, int64(0), channelPool.DrainState().Outstanding)
+
+	_, err = channelPool.GetChannel()
+	assert.Equal(t, pools.ErrDraining, err)
+
+	assert.NoError(t, channelPool.Initialize())
+	chanHost, err = channelPool.GetChannel()
+	assert.NoError(t, err)
+	channelPool.ReturnChannel(chanHost, false)
+	channelPool.Shutdown()
+}
+
+func TestConnectionPoolDrainGivesUp(t *testing.T) {
+	connectionPool, err := pools.NewConnectionPool(Seasoning.PoolConfig, true)
+	assert.NoError(t, err)
+
+	_, err = connectionPool.GetConnection() // never returned
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(100)*time.Millisecond)
+	defer cancel()
+
+	err = connectionPool.Drain(ctx)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, connectionPool.DrainState().Drained)
+	assert.False(t, connectionPool.Initialized)
+
+	_, err = connectionPool.GetConnection()
+	assert.Equal(t, pools.ErrDraining, err)
+}
+
+func TestChannelPoolOnDegradedConnectionPool(t *testing.T) {
+	proxy := newBrokerProxy(t)
+	defer proxy.listener.Close()
+
+	connectionPoolConfig := *Seasoning.PoolConfig.ConnectionPoolConfig
+	connectionPoolConfig.URI = proxy.uri()
+	connectionPoolConfig.MaxConnectionCount = 3
+	connectionPoolConfig.MinConnectionCount = 1
+	connectionPoolConfig.RestoreInterval = 50
+
+	poolConfig := *Seasoning.PoolConfig
+	poolConfig.ConnectionPoolConfig = &connectionPoolConfig
+
+	connectionPool, err := pools.NewConnectionPool(&poolConfig, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, connectionPool.MissingConnectionCount())
+
+	// every channel fits on the one healthy connection
+	channelPool, err := pools.NewChannelPool(&poolConfig, connectionPool, true)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(poolConfig.ChannelPoolConfig.MaxChannelCount), channelPool.ChannelCount())
+	assert.Equal(t, int64(poolConfig.ChannelPoolConfig.MaxAckChannelCount), channelPool.AckChannelCount())
+
+	chanHost, err := channelPool.GetChannel()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), chanHost.ConnectionID)
+	channelPool.ReturnChannel(chanHost, false)
+
+	channelPool.Shutdown()
+	connectionPool.Shutdown()
+}
@@ -0,0 +1,57 @@
+package pools
+
+import (
+	"context"
+	"time"
+
+	"github.com/Workiva/go-datastructures/queue"
+)
+
+// How often a context-aware dequeue re-checks the context while waiting.
+const contextPollInterval = time.Duration(50) * time.Millisecond
+
+// Dequeue gets a single item from the queue, blocking until one is available or the context is done.
+func dequeue(ctx context.Context, q *queue.Queue) (interface{}, error) {
+
+	if ctx.Done() == nil { // can never be cancelled, block like a plain Get
+		items, err := q.Get(1)
+		if err != nil {
+			return nil, err
+		}
+
+		return items[0], nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		items, err := q.Poll(1, contextPollInterval)
+		if err == nil {
+			return items[0], nil
+		}
+
+		if err != queue.ErrTimeout {
+			return nil, err
+		}
+	}
+}
+
+// SleepWithContext sleeps for the duration unless the context is done first.
+func sleepWithContext(ctx context.Context, duration time.Duration) error {
+
+	if duration <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,119 @@
+package pools
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// RecycleInterval is the configured interval, or the age spread over the pool so every host is recycled
+// about once per age.
+func recycleInterval(interval uint32, maxAge time.Duration, count uint64) time.Duration {
+	if interval > 0 {
+		return time.Duration(interval) * time.Millisecond
+	}
+
+	if count == 0 {
+		return maxAge
+	}
+
+	return maxAge / time.Duration(count)
+}
+
+// RecycleConnections closes, one at a time and at most once per interval, the oldest connection past the
+// MaxConnectionAge. The connection is flagged first so it's replaced on the next GetConnection and its channels
+// are replaced as they are checked out, the same recovery a lost connection goes through.
+func (cp *ConnectionPool) recycleConnections(stop chan bool) {
+	ticker := time.NewTicker(cp.recycleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if connHost := cp.oldestExpiredConnection(); connHost != nil {
+			cp.recycleConnection(connHost)
+		}
+	}
+}
+
+// OldestExpiredConnection finds the oldest open connection past the MaxConnectionAge. Returns nil while any
+// connection is still flagged, the previous recycle (or an outage) is dealt with before the next one.
+func (cp *ConnectionPool) oldestExpiredConnection() *ConnectionHost {
+	cp.poolRWLock.RLock()
+	defer cp.poolRWLock.RUnlock()
+
+	var oldest *ConnectionHost
+	for _, connHost := range cp.hosts {
+		if cp.flaggedConnections[connHost.ConnectionID] {
+			return nil
+		}
+
+		if connHost.Connection.IsClosed() || connHost.Age() < cp.maxConnectionAge {
+			continue
+		}
+
+		if oldest == nil || connHost.created.Before(oldest.created) {
+			oldest = connHost
+		}
+	}
+
+	return oldest
+}
+
+// RecycleConnection flags the connection for replacement and closes it.
+func (cp *ConnectionPool) recycleConnection(connHost *ConnectionHost) {
+	cp.FlagConnection(connHost.ConnectionID)
+	cp.emitEvent(
+		models.NewEvent(
+			models.ConnectionRecycled, connHost.ConnectionID, 0, fmt.Sprintf("open for %s", connHost.Age().Round(time.Millisecond))))
+
+	models.Go("connectionpool.close", func() { _ = connHost.Connection.Close() })
+}
+
+// StopRecycling ends connection recycling, must be called while holding the pool lock.
+func (cp *ConnectionPool) stopRecycling() {
+	if cp.recycleStop != nil {
+		close(cp.recycleStop)
+		cp.recycleStop = nil
+	}
+}
+
+// ShouldRecycle is true when the (non-ackable) channel is past the MaxChannelAge and no other channel was
+// recycled within the last interval, claiming the interval for this channel.
+func (cp *ChannelPool) shouldRecycle(channelHost *ChannelHost) bool {
+	if cp.maxChannelAge == 0 || channelHost.Age() < cp.maxChannelAge {
+		return false
+	}
+
+	last := atomic.LoadInt64(&cp.lastRecycle)
+	now := time.Now().UnixNano()
+	if now-last < int64(cp.recycleInterval) {
+		return false
+	}
+
+	return atomic.CompareAndSwapInt64(&cp.lastRecycle, last, now)
+}
+
+// RecycleChannel opens the replacement before closing the old channel, when that fails the old channel is
+// kept and recycled in a later interval.
+func (cp *ChannelPool) recycleChannel(ctx context.Context, channelHost *ChannelHost) *ChannelHost {
+	newChannelHost, err := cp.createChannelHost(ctx, channelHost.ChannelID, channelHost.IsAckable())
+	if err != nil {
+		return channelHost
+	}
+
+	age := channelHost.Age().Round(time.Millisecond)
+	cp.closeChannelHost(channelHost)
+	cp.connectionPool.emitEvent(
+		models.NewEvent(
+			models.ChannelRecycled, newChannelHost.ConnectionID, newChannelHost.ChannelID, fmt.Sprintf("open for %s", age)))
+
+	return newChannelHost
+}
@@ -0,0 +1,309 @@
+package pools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Workiva/go-datastructures/queue"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// How long a shrinking Resize waits on the queue for an idle host before leaving the rest for later.
+const resizePollTimeout = time.Millisecond
+
+// ChannelsPerConnection spreads a channel count over the connections, leaving a little headroom for replacements.
+func channelsPerConnection(channelCount uint64, connectionCount uint64) uint64 {
+	if connectionCount == 1 {
+		return channelCount
+	}
+
+	if channelCount > 1 {
+		return channelCount/connectionCount + 1
+	}
+
+	return 1
+}
+
+// Resize grows or shrinks the ConnectionPool at runtime. Growing dials the new connections right away.
+// Shrinking only closes idle connections (no channels open on them): those idle now are closed right away,
+// the rest are closed as they become idle and are handed out by GetConnection. Progress is reported with
+// PoolResizing events and a PoolResized event once the pool is at its new size.
+func (cp *ConnectionPool) Resize(newSize uint64) error {
+	cp.poolLock.Lock()
+	defer cp.poolLock.Unlock()
+
+	if newSize == 0 {
+		return errors.New("can't resize a connectionpool to 0 connections")
+	}
+
+	if !cp.Initialized {
+		return errors.New("can't resize a connectionpool that has not been initialized")
+	}
+
+	if newSize < cp.minConnections {
+		return errors.New("can't resize a connectionpool below its minconnectioncount")
+	}
+
+	// connections still waiting to be removed by an earlier shrink count as alive
+	pending := uint64(atomic.SwapInt64(&cp.pendingRemovals, 0))
+	alive := cp.maxConnections + pending
+
+	cp.maxConnections = newSize
+	cp.setChannelLimits(cp.channelCount, cp.ackChannelCount)
+
+	switch {
+	case newSize > alive:
+		for i := alive; i < newSize; i++ {
+			connectionID := cp.connectionID
+			cp.connectionID++
+
+			connectionHost, err := cp.createConnectionHostByConfig(connectionID)
+			if err != nil {
+				cp.maxConnections = i // the pool is what we managed to grow it to
+				cp.setChannelLimits(cp.channelCount, cp.ackChannelCount)
+				return err
+			}
+
+			if err = cp.connections.Put(connectionHost); err != nil {
+				connectionHost.Connection.Close()
+				cp.maxConnections = i
+				cp.setChannelLimits(cp.channelCount, cp.ackChannelCount)
+				return err
+			}
+
+			cp.emitEvent(models.NewEvent(models.PoolResizing, connectionID, 0, fmt.Sprintf("connection added (%d of %d)", i+1, newSize)))
+		}
+	case newSize < alive:
+		atomic.StoreInt64(&cp.pendingRemovals, int64(alive-newSize))
+		cp.removeIdleConnections()
+
+		if atomic.LoadInt64(&cp.pendingRemovals) > 0 {
+			return nil // PoolResized comes once the busy connections are removed
+		}
+	}
+
+	cp.emitEvent(models.NewEvent(models.PoolResized, 0, 0, fmt.Sprintf("resized to %d connections", newSize)))
+
+	return nil
+}
+
+// RemoveIdleConnections closes the idle connections waiting in the queue while removals are pending.
+func (cp *ConnectionPool) removeIdleConnections() {
+	count := cp.connections.Len()
+	if count == 0 {
+		return
+	}
+
+	items, err := cp.connections.Poll(count, resizePollTimeout)
+	if err != nil {
+		return // queue drained by callers, busy connections are removed later
+	}
+
+	for _, item := range items {
+		connectionHost := item.(*ConnectionHost)
+		if !cp.removeIfPending(connectionHost) {
+			cp.returnConnection(connectionHost)
+		}
+	}
+}
+
+// RemoveIfPending closes an idle connection when a shrinking Resize still has removals pending,
+// returns true when the connection was removed.
+func (cp *ConnectionPool) removeIfPending(connectionHost *ConnectionHost) bool {
+	if atomic.LoadInt64(&cp.pendingRemovals) <= 0 || !connectionHost.isIdle() {
+		return false
+	}
+
+	remaining := atomic.AddInt64(&cp.pendingRemovals, -1)
+	if remaining < 0 {
+		atomic.AddInt64(&cp.pendingRemovals, 1) // lost the race to another removal
+		return false
+	}
+
+	cp.poolRWLock.Lock()
+	delete(cp.hosts, connectionHost.ConnectionID)
+	delete(cp.flaggedConnections, connectionHost.ConnectionID)
+	cp.poolRWLock.Unlock()
+
+	if !connectionHost.Connection.IsClosed() {
+		connectionHost.Connection.Close()
+	}
+
+	cp.emitEvent(models.NewEvent(models.PoolResizing, connectionHost.ConnectionID, 0, fmt.Sprintf("idle connection removed (%d pending)", remaining)))
+	if remaining == 0 {
+		cp.emitEvent(models.NewEvent(models.PoolResized, 0, 0, "connection removals complete"))
+	}
+
+	return true
+}
+
+// SetChannelLimits spreads the channel counts over the connections that are up (a degraded pool's missing ones
+// can't host any), connections pick up the new limits the next time they are handed out. Must be called while
+// holding the pool lock.
+func (cp *ConnectionPool) setChannelLimits(channelCount uint64, ackChannelCount uint64) {
+	cp.channelCount = channelCount
+	cp.ackChannelCount = ackChannelCount
+
+	connections := uint64(1)
+	if missing := uint64(len(cp.missingConnections)); cp.maxConnections > missing {
+		connections = cp.maxConnections - missing
+	}
+
+	atomic.StoreUint64(&cp.maxChannelPerConnection, channelsPerConnection(channelCount, connections))
+	atomic.StoreUint64(&cp.maxAckChannelPerConnection, channelsPerConnection(ackChannelCount, connections))
+}
+
+// RegisterHost tracks the connection by ID so channels removed by a Resize can be released from it.
+func (cp *ConnectionPool) registerHost(connectionHost *ConnectionHost) {
+	cp.poolRWLock.Lock()
+	cp.hosts[connectionHost.ConnectionID] = connectionHost
+	cp.poolRWLock.Unlock()
+}
+
+// ReleaseChannel gives a closed channel's slot back to its connection.
+func (cp *ConnectionPool) releaseChannel(connectionID uint64, ackable bool) {
+	cp.poolRWLock.RLock()
+	connectionHost, ok := cp.hosts[connectionID]
+	cp.poolRWLock.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	var err error
+	if ackable {
+		err = connectionHost.RemoveAckChannel()
+	} else {
+		err = connectionHost.RemoveChannel()
+	}
+
+	if err != nil {
+		cp.handleError(err)
+	}
+}
+
+// Resize grows or shrinks the (non-ackable) channels of the ChannelPool at runtime. Growing creates the new
+// channels right away, raising the per connection channel limits as needed. Shrinking only closes idle channels:
+// those waiting in the pool are closed right away, the rest as they are returned with ReturnChannel.
+// Ackable channels are shared by everyone holding one so they can't be drained and aren't resized.
+// Progress is reported on Events() with PoolResizing events and a PoolResized event once the pool is at its new size.
+func (cp *ChannelPool) Resize(newSize uint64) error {
+	cp.poolLock.Lock()
+	defer cp.poolLock.Unlock()
+
+	if newSize == 0 {
+		return errors.New("can't resize a channelpool to 0 channels")
+	}
+
+	if !cp.Initialized {
+		return errors.New("can't resize a channelpool that has not been initialized")
+	}
+
+	pending := uint64(atomic.SwapInt64(&cp.pendingRemovals, 0))
+	alive := cp.maxChannels + pending
+
+	cp.maxChannels = newSize
+
+	switch {
+	case newSize > alive:
+		cp.connectionPool.poolLock.Lock()
+		cp.connectionPool.setChannelLimits(newSize, cp.maxAckChannels)
+		cp.connectionPool.poolLock.Unlock()
+
+		for i := alive; i < newSize; i++ {
+			channelHost, err := cp.createChannelHost(context.Background(), cp.channelID, false)
+			if err != nil {
+				cp.maxChannels = i // the pool is what we managed to grow it to
+				return err
+			}
+
+			cp.channelID++
+			if err = cp.channels.Put(channelHost); err != nil {
+				cp.closeChannelHost(channelHost)
+				cp.maxChannels = i
+				return err
+			}
+
+			cp.connectionPool.emitEvent(
+				models.NewEvent(
+					models.PoolResizing, channelHost.ConnectionID, channelHost.ChannelID, fmt.Sprintf("channel added (%d of %d)", i+1, newSize)))
+		}
+	case newSize < alive:
+		atomic.StoreInt64(&cp.pendingRemovals, int64(alive-newSize))
+		cp.removeIdleChannels(alive - newSize)
+
+		if atomic.LoadInt64(&cp.pendingRemovals) > 0 {
+			return nil // PoolResized comes once the checked out channels are returned
+		}
+	}
+
+	cp.connectionPool.emitEvent(models.NewEvent(models.PoolResized, 0, 0, fmt.Sprintf("resized to %d channels", newSize)))
+
+	return nil
+}
+
+// RemoveIdleChannels closes up to count channels waiting in the queue.
+func (cp *ChannelPool) removeIdleChannels(count uint64) {
+	idle := uint64(cp.channels.Len())
+	if idle < count {
+		count = idle
+	}
+
+	if count == 0 {
+		return
+	}
+
+	items, err := cp.channels.Poll(int64(count), resizePollTimeout)
+	if err != nil && err != queue.ErrTimeout {
+		return
+	}
+
+	for _, item := range items {
+		channelHost := item.(*ChannelHost)
+		if !cp.removeIfPending(channelHost) {
+			if err := cp.channels.Put(channelHost); err != nil {
+				cp.handleError(err)
+			}
+		}
+	}
+}
+
+// RemoveIfPending closes the channel when a shrinking Resize still has removals pending,
+// returns true when the channel was removed (and must not go back in the queue).
+func (cp *ChannelPool) removeIfPending(channelHost *ChannelHost) bool {
+	if atomic.LoadInt64(&cp.pendingRemovals) <= 0 {
+		return false
+	}
+
+	remaining := atomic.AddInt64(&cp.pendingRemovals, -1)
+	if remaining < 0 {
+		atomic.AddInt64(&cp.pendingRemovals, 1) // lost the race to another removal
+		return false
+	}
+
+	cp.closeChannelHost(channelHost)
+
+	cp.connectionPool.emitEvent(
+		models.NewEvent(
+			models.PoolResizing, channelHost.ConnectionID, channelHost.ChannelID, fmt.Sprintf("idle channel removed (%d pending)", remaining)))
+	if remaining == 0 {
+		cp.connectionPool.emitEvent(models.NewEvent(models.PoolResized, 0, 0, "channel removals complete"))
+	}
+
+	return true
+}
+
+// CloseChannelHost closes a channel removed from the pool and frees its slot on the connection.
+func (cp *ChannelPool) closeChannelHost(channelHost *ChannelHost) {
+	_ = channelHost.Close()
+
+	cp.poolRWLock.Lock()
+	delete(cp.flaggedChannels, channelHost.ChannelID)
+	cp.poolRWLock.Unlock()
+
+	cp.connectionPool.releaseChannel(channelHost.ConnectionID, channelHost.IsAckable())
+}
@@ -0,0 +1,144 @@
+package pools
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+// Capabilities a RabbitMQ server advertises when a connection opens.
+const (
+	CapabilityPublisherConfirms        = "publisher_confirms"
+	CapabilityConsumerCancelNotify     = "consumer_cancel_notify"
+	CapabilityExchangeExchangeBindings = "exchange_exchange_bindings"
+	CapabilityBasicNack                = "basic.nack"
+	CapabilityConnectionBlocked        = "connection.blocked"
+	CapabilityConsumerPriorities       = "consumer_priorities"
+	CapabilityAuthenticationFailure    = "authentication_failure_close"
+	CapabilityPerConsumerQos           = "per_consumer_qos"
+	CapabilityDirectReplyTo            = "direct_reply_to"
+)
+
+// ServerProperties are what the server told a connection about itself during the handshake.
+type ServerProperties struct {
+	Product       string
+	Version       string
+	Platform      string
+	ClusterName   string
+	ProtocolMajor int
+	ProtocolMinor int
+	Capabilities  map[string]bool
+	Raw           amqp.Table // every server property as sent
+}
+
+// NewServerProperties reads the negotiated server properties off an open connection.
+func NewServerProperties(amqpConn *amqp.Connection) *ServerProperties {
+	sp := &ServerProperties{
+		Product:       tableString(amqpConn.Properties, "product"),
+		Version:       tableString(amqpConn.Properties, "version"),
+		Platform:      tableString(amqpConn.Properties, "platform"),
+		ClusterName:   tableString(amqpConn.Properties, "cluster_name"),
+		ProtocolMajor: amqpConn.Major,
+		ProtocolMinor: amqpConn.Minor,
+		Capabilities:  make(map[string]bool),
+		Raw:           amqpConn.Properties,
+	}
+
+	if capabilities, ok := amqpConn.Properties["capabilities"].(amqp.Table); ok {
+		for capability, value := range capabilities {
+			if supported, ok := value.(bool); ok {
+				sp.Capabilities[capability] = supported
+			}
+		}
+	}
+
+	return sp
+}
+
+// TableString reads a string property, which may arrive as a long string or bytes.
+func tableString(table amqp.Table, key string) string {
+	switch value := table[key].(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	}
+
+	return ""
+}
+
+// Supports returns true when the server advertised the capability.
+func (sp *ServerProperties) Supports(capability string) bool {
+	return sp.Capabilities[capability]
+}
+
+// VersionAtLeast compares the server's version (i.e. "3.13.1") to major.minor.patch.
+// Returns false when the version can't be parsed.
+func (sp *ServerProperties) VersionAtLeast(major, minor, patch int) bool {
+	parts := strings.SplitN(sp.Version, ".", 3)
+	if len(parts) == 0 || parts[0] == "" {
+		return false
+	}
+
+	want := []int{major, minor, patch}
+	for i, want := range want {
+		have := 0
+		if i < len(parts) {
+			// tolerate suffixes like 3.13.0-rc.1 or 4.0.0+2
+			digits := strings.IndexFunc(parts[i], func(r rune) bool { return r < '0' || r > '9' })
+			if digits == -1 {
+				digits = len(parts[i])
+			}
+
+			var err error
+			if have, err = strconv.Atoi(parts[i][:digits]); err != nil {
+				return false
+			}
+		}
+
+		if have != want {
+			return have > want
+		}
+	}
+
+	return true
+}
+
+// ServerProperties returns what the server told this connection when it opened.
+func (ch *ConnectionHost) ServerProperties() *ServerProperties {
+	return ch.serverProperties
+}
+
+// ServerProperties returns the server properties of every pooled connection by ConnectionID.
+// Connections can land on different nodes of a cluster, and on different versions mid-upgrade.
+func (cp *ConnectionPool) ServerProperties() map[uint64]*ServerProperties {
+	cp.poolRWLock.RLock()
+	defer cp.poolRWLock.RUnlock()
+
+	properties := make(map[uint64]*ServerProperties, len(cp.hosts))
+	for connectionID, connHost := range cp.hosts {
+		properties[connectionID] = connHost.serverProperties
+	}
+
+	return properties
+}
+
+// Supports returns true when every pooled connection's server advertised the capability,
+// false when some didn't or there are no connections.
+func (cp *ConnectionPool) Supports(capability string) bool {
+	cp.poolRWLock.RLock()
+	defer cp.poolRWLock.RUnlock()
+
+	if len(cp.hosts) == 0 {
+		return false
+	}
+
+	for _, connHost := range cp.hosts {
+		if !connHost.serverProperties.Supports(capability) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,93 @@
+package pools
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// WatchConnections probes every pooled connection each interval and flags those that don't answer within the
+// timeout, so half-open connections are replaced long before the AMQP heartbeat gives up on them.
+func (cp *ConnectionPool) watchConnections(stop chan bool) {
+	ticker := time.NewTicker(cp.watchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		cp.poolRWLock.RLock()
+		hosts := make([]*ConnectionHost, 0, len(cp.hosts))
+		for _, connHost := range cp.hosts {
+			if !cp.flaggedConnections[connHost.ConnectionID] {
+				hosts = append(hosts, connHost)
+			}
+		}
+		cp.poolRWLock.RUnlock()
+
+		wg := &sync.WaitGroup{}
+		for _, connHost := range hosts {
+			if connHost.Connection.IsClosed() {
+				continue // already dead, replaced on the next GetConnection
+			}
+
+			wg.Add(1)
+			connHost := connHost
+			models.Go("connectionpool.probe", func() {
+				defer wg.Done()
+
+				if !cp.probeConnection(connHost) {
+					cp.markStale(connHost)
+				}
+			})
+		}
+		wg.Wait()
+	}
+}
+
+// ProbeConnection opens and closes a channel, a full round trip to the server. Returns false when that doesn't
+// complete within the watchdog timeout. Errors (i.e. the connection closing) aren't staleness and return true.
+func (cp *ConnectionPool) probeConnection(connHost *ConnectionHost) bool {
+	done := make(chan struct{})
+	models.Go("connectionpool.probe", func() { // left behind on a stale connection until it finally closes
+		defer close(done)
+
+		amqpChan, err := connHost.Connection.Channel()
+		if err == nil {
+			_ = amqpChan.Close()
+		}
+	})
+
+	timer := time.NewTimer(cp.watchdogTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// MarkStale flags the connection (and through it the channels it hosts) for replacement and closes it.
+func (cp *ConnectionPool) markStale(connHost *ConnectionHost) {
+	cp.FlagConnection(connHost.ConnectionID)
+	cp.emitEvent(
+		models.NewEvent(
+			models.ConnectionStale, connHost.ConnectionID, 0, fmt.Sprintf("no response within %s", cp.watchdogTimeout)))
+
+	models.Go("connectionpool.close", func() { _ = connHost.Connection.Close() }) // blocks until the server answers or the heartbeat gives up
+}
+
+// StopWatchdog ends the connection watchdog, must be called while holding the pool lock.
+func (cp *ConnectionPool) stopWatchdog() {
+	if cp.watchdogStop != nil {
+		close(cp.watchdogStop)
+		cp.watchdogStop = nil
+	}
+}
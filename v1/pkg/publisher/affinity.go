@@ -0,0 +1,111 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
+)
+
+// ChannelShard owns a ChannelHost for the life of the Publisher, only ever used while holding its lock.
+type channelShard struct {
+	chanHost  *pools.ChannelHost
+	shardLock *sync.Mutex
+}
+
+// EnableChannelAffinity pins publishing to a fixed set of channels (shards) held by the Publisher instead of
+// checking a channel out of the ChannelPool per publish. Letters are assigned a shard by exchange and routing key,
+// so everything sent to the same destination goes out on the same channel in call order.
+// Must be called before publishing starts and can only be enabled once.
+func (pub *Publisher) EnableChannelAffinity(shardCount int) error {
+	pub.pubLock.Lock()
+	defer pub.pubLock.Unlock()
+
+	if shardCount < 1 {
+		return errors.New("channel affinity needs at least 1 shard")
+	}
+
+	if pub.shards != nil {
+		return errors.New("can't enable channel affinity, already enabled")
+	}
+
+	if pub.autoStarted {
+		return errors.New("can't enable channel affinity while auto publishing")
+	}
+
+	shards := make([]*channelShard, shardCount)
+	for i := range shards {
+		shards[i] = &channelShard{shardLock: &sync.Mutex{}}
+	}
+
+	pub.shards = shards
+
+	return nil
+}
+
+func (pub *Publisher) getShards() []*channelShard {
+	pub.pubLock.Lock()
+	defer pub.pubLock.Unlock()
+
+	return pub.shards
+}
+
+// ShardFor hashes the letter's destination to a shard.
+func shardFor(shards []*channelShard, letter *models.Letter) *channelShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(letter.Envelope.Exchange))
+	_, _ = hash.Write([]byte{0})
+	_, _ = hash.Write([]byte(letter.Envelope.RoutingKey))
+
+	return shards[hash.Sum32()%uint32(len(shards))]
+}
+
+// PublishWithAffinity publishes on the letter's shard channel, replacing the channel on error.
+func (pub *Publisher) publishWithAffinity(shards []*channelShard, letter *models.Letter) error {
+	return pub.publishWithAffinityContext(context.Background(), shards, letter, nil)
+}
+
+// PublishWithAffinityContext publishes like publishWithAffinity but stops waiting on a channel once the context is
+// done. With a handOff, the publish only goes ahead when handOff takes the outcome of getting the channel.
+func (pub *Publisher) publishWithAffinityContext(ctx context.Context, shards []*channelShard, letter *models.Letter, handOff handOff) error {
+	shard := shardFor(shards, letter)
+
+	shard.shardLock.Lock()
+	defer shard.shardLock.Unlock()
+
+	var err error
+	if shard.chanHost == nil {
+		shard.chanHost, err = pub.ChannelPool.GetChannelWithContext(ctx)
+	}
+
+	if handOff != nil && !handOff(err) {
+		return errNotHandedOff
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = pub.simplePublish(shard.chanHost, letter)
+	if err != nil {
+		pub.ChannelPool.ReturnChannel(shard.chanHost, true)
+		shard.chanHost = nil
+	}
+
+	return err
+}
+
+// ReleaseShards returns every shard's channel to the ChannelPool.
+func (pub *Publisher) releaseShards() {
+	for _, shard := range pub.getShards() {
+		shard.shardLock.Lock()
+		if shard.chanHost != nil {
+			pub.ChannelPool.ReturnChannel(shard.chanHost, false)
+			shard.chanHost = nil
+		}
+		shard.shardLock.Unlock()
+	}
+}
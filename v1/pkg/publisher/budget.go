@@ -0,0 +1,183 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// ErrBudgetExceeded is returned when a publish can't complete within its latency budget.
+var ErrBudgetExceeded = errors.New("publish didn't complete within its latency budget")
+
+// BudgetAction is what PublishWithBudget does with a letter it couldn't get a channel for within the budget.
+type BudgetAction int
+
+const (
+	// BudgetFailFast returns ErrBudgetExceeded.
+	BudgetFailFast BudgetAction = iota
+	// BudgetQueue queues the letter for AutoPublish when the letter buffer has room, failing fast otherwise.
+	BudgetQueue
+	// BudgetFallback hands the letter to the fallback sink (see SetFallbackSink), failing fast without one.
+	BudgetFallback
+)
+
+// LatencyBudget bounds how long PublishWithBudget may take and what happens to a letter that doesn't make it.
+type LatencyBudget struct {
+	Budget time.Duration
+	Action BudgetAction
+}
+
+// FallbackSink takes letters that missed their latency budget, i.e. to write them to a local spool.
+type FallbackSink func(letter *models.Letter) error
+
+// SetFallbackSink sets where BudgetFallback sends letters, nil removes it.
+func (pub *Publisher) SetFallbackSink(sink FallbackSink) {
+	pub.pubLock.Lock()
+	defer pub.pubLock.Unlock()
+
+	pub.fallbackSink = sink
+}
+
+// PublishWithBudget publishes the letter once, returning within the budget no matter how slow the broker is.
+// When no channel (pooled, confirm mode, or affinity shard) is available in time, the letter is failed, queued, or
+// handed to the fallback sink per the budget's Action, and nil is returned unless that failed too. A publish already
+// handed to a channel can't be taken back: when it runs out of budget ErrBudgetExceeded is returned, the publish
+// finishes in the background and its outcome is reported on Notifications (and Failures) as usual.
+func (pub *Publisher) PublishWithBudget(letter *models.Letter, budget LatencyBudget) error {
+
+	if err := pub.checkLetter(letter); err != nil {
+		pub.sendToNotifications(letter, err, 0)
+		pub.sendToFailures(letter, err, 1)
+		return err
+	}
+
+	if budget.Budget <= 0 {
+		return errors.New("can't publish with a latency budget of 0")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), budget.Budget)
+	defer cancel()
+
+	// the channel is acquired in the background (the confirm and shard channels are behind locks held for a
+	// whole publish), the letter is only published once it's been handed off before the budget ran out
+	acquired := make(chan error)
+	handOff := func(err error) bool {
+		select {
+		case acquired <- err:
+			return err == nil
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var publish func() error
+	if pub.requiresConfirm(letter) {
+		publish = func() error { return pub.publishWithConfirmContext(ctx, letter, handOff) }
+	} else if shards := pub.getShards(); shards != nil {
+		publish = func() error { return pub.publishWithAffinityContext(ctx, shards, letter, handOff) }
+	} else {
+		publish = func() error {
+			chanHost, err := pub.ChannelPool.GetChannelWithContext(ctx)
+			if !handOff(err) {
+				if err == nil {
+					pub.ChannelPool.ReturnChannel(chanHost, false)
+				}
+
+				return errNotHandedOff
+			}
+
+			err = pub.simplePublish(chanHost, letter)
+			pub.ChannelPool.ReturnChannel(chanHost, err != nil)
+			return err
+		}
+	}
+
+	done := make(chan error, 1)
+	models.Go("publisher.budget", func() {
+		err := publish()
+		if err == errNotHandedOff {
+			return // never published, the letter is left to PublishWithBudget
+		}
+
+		pub.sendToNotifications(letter, err, 0)
+		if err != nil {
+			pub.sendToFailures(letter, err, 1)
+		}
+
+		done <- err
+	})
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			if ctx.Err() != nil {
+				return pub.overBudget(letter, budget.Action)
+			}
+
+			pub.sendToNotifications(letter, err, 0)
+			pub.sendToFailures(letter, err, 1)
+			return err
+		}
+	case <-ctx.Done():
+		return pub.overBudget(letter, budget.Action)
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ErrBudgetExceeded
+	}
+}
+
+// HandOff takes the outcome of getting a channel for a budgeted publish, returning true when the publish should go
+// ahead. It's false when getting the channel failed or the budget ran out first, either way the letter is no longer
+// the publish's to report.
+type handOff func(err error) bool
+
+// ErrNotHandedOff is returned by a publish that stopped because its handOff returned false.
+var errNotHandedOff = errors.New("publish wasn't handed off a channel")
+
+// OverBudget applies the budget's action to a letter that never reached a channel.
+func (pub *Publisher) overBudget(letter *models.Letter, action BudgetAction) error {
+	switch action {
+	case BudgetQueue:
+		if pub.tryQueueLetter(letter) {
+			return nil
+		}
+	case BudgetFallback:
+		pub.pubLock.Lock()
+		sink := pub.fallbackSink
+		pub.pubLock.Unlock()
+
+		if sink != nil {
+			return sink(letter)
+		}
+	}
+
+	pub.sendToNotifications(letter, ErrBudgetExceeded, 0)
+	pub.sendToFailures(letter, ErrBudgetExceeded, 1)
+
+	return ErrBudgetExceeded
+}
+
+// TryQueueLetter queues the letter for AutoPublish without waiting, returns false when the letter buffer is full.
+func (pub *Publisher) tryQueueLetter(letter *models.Letter) bool {
+	pub.pubRWLock.Lock()
+	if pub.letterCount >= pub.letterBuffer+pub.maxOverBuffer {
+		pub.pubRWLock.Unlock()
+		return false
+	}
+	pub.letterCount++
+	pub.pubRWLock.Unlock()
+
+	select {
+	case pub.letters <- letter:
+		return true
+	default:
+		pub.reduceLetterCount()
+		return false
+	}
+}
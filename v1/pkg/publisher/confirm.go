@@ -0,0 +1,129 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
+	"github.com/streadway/amqp"
+)
+
+const defaultConfirmTimeout = time.Duration(5) * time.Second
+
+// ErrUnroutable is returned for a confirmed, mandatory letter the server couldn't route to any queue.
+var ErrUnroutable = errors.New("publish was returned unroutable")
+
+// ConfirmChannel is a channel in confirm mode held by the Publisher for publishes that must be confirmed.
+// Publishes are serialized so each confirmation belongs to the publish waiting on it.
+type confirmChannel struct {
+	chanHost    *pools.ChannelHost
+	confirms    chan amqp.Confirmation
+	returns     chan amqp.Return
+	confirmLock *sync.Mutex
+}
+
+// PublishWithConfirm publishes the letter and waits for the server to ack it, a nack or timeout is an error.
+// A mandatory letter the server returned (it arrives before the ack) fails with ErrUnroutable.
+func (pub *Publisher) publishWithConfirm(letter *models.Letter) error {
+	return pub.publishWithConfirmContext(context.Background(), letter, nil)
+}
+
+// PublishWithConfirmContext publishes like publishWithConfirm but stops waiting on a channel once the context is
+// done. With a handOff, the publish only goes ahead when handOff takes the outcome of getting the channel.
+func (pub *Publisher) publishWithConfirmContext(ctx context.Context, letter *models.Letter, handOff handOff) error {
+	cc := pub.confirmer
+
+	cc.confirmLock.Lock()
+	defer cc.confirmLock.Unlock()
+
+	err := pub.openConfirmChannel(ctx)
+	if handOff != nil && !handOff(err) {
+		return errNotHandedOff
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := pub.simplePublish(cc.chanHost, letter); err != nil {
+		pub.releaseConfirmChannel()
+		return err
+	}
+
+	timer := time.NewTimer(pub.confirmTimeout)
+	defer timer.Stop()
+
+	select {
+	case confirmation, ok := <-cc.confirms:
+		if !ok {
+			pub.releaseConfirmChannel()
+			return errors.New("channel closed before the publish was confirmed")
+		}
+
+		if models.GetChaos().Inject(models.ChaosDropConfirm) {
+			pub.emitEvent(models.NewEvent(models.ChaosInjected, cc.chanHost.ConnectionID, cc.chanHost.ChannelID, models.ChaosDropConfirm.String()))
+			<-timer.C
+			pub.releaseConfirmChannel()
+			return errors.New("timed out waiting for the publish to be confirmed")
+		}
+
+		if !confirmation.Ack {
+			return errors.New("publish was nacked by the server")
+		}
+
+		select {
+		case returned := <-cc.returns:
+			return fmt.Errorf("%w: %s", ErrUnroutable, returned.ReplyText)
+		default:
+			return nil
+		}
+	case <-timer.C:
+		pub.releaseConfirmChannel() // a late confirmation would be taken for the next publish's
+		return errors.New("timed out waiting for the publish to be confirmed")
+	}
+}
+
+// OpenConfirmChannel puts a channel from the ChannelPool in confirm mode unless one is open already, must be called
+// while holding the confirm lock.
+func (pub *Publisher) openConfirmChannel(ctx context.Context) error {
+	cc := pub.confirmer
+	if cc.chanHost != nil {
+		return nil
+	}
+
+	chanHost, err := pub.ChannelPool.GetChannelWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = chanHost.Channel.Confirm(false); err != nil {
+		pub.ChannelPool.ReturnChannel(chanHost, true)
+		return err
+	}
+
+	cc.chanHost = chanHost
+	cc.confirms = chanHost.Channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	cc.returns = chanHost.Channel.NotifyReturn(make(chan amqp.Return, 1))
+
+	return nil
+}
+
+// ReleaseConfirmChannel closes the confirm mode channel and hands it back to the ChannelPool to be replaced,
+// must be called while holding the confirm lock.
+func (pub *Publisher) releaseConfirmChannel() {
+	cc := pub.confirmer
+	if cc.chanHost == nil {
+		return
+	}
+
+	_ = cc.chanHost.Close()
+	pub.ChannelPool.ReturnChannel(cc.chanHost, true)
+
+	cc.chanHost = nil
+	cc.confirms = nil
+	cc.returns = nil
+}
@@ -0,0 +1,92 @@
+package publisher
+
+import (
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+// SetExchangeDefaults registers the publishing options for every letter sent to the exchange, so call sites
+// only need a body and routing key. Defaults fill in what the letter leaves unset (DeliveryMode, ContentType),
+// can't be turned off per letter (Mandatory, RequireConfirm), and Headers are merged under the letter's own.
+// Raw publishes (PublishRaw) are sent as is. A nil defaults removes the exchange's defaults.
+func (pub *Publisher) SetExchangeDefaults(exchange string, defaults *models.ExchangeDefaults) {
+	pub.defaultsLock.Lock()
+	defer pub.defaultsLock.Unlock()
+
+	if defaults == nil {
+		delete(pub.exchangeDefaults, exchange)
+		return
+	}
+
+	pub.exchangeDefaults[exchange] = defaults
+}
+
+// ExchangeDefaults returns the options registered for the exchange, or nil.
+func (pub *Publisher) ExchangeDefaults(exchange string) *models.ExchangeDefaults {
+	pub.defaultsLock.RLock()
+	defer pub.defaultsLock.RUnlock()
+
+	return pub.exchangeDefaults[exchange]
+}
+
+// RequiresConfirm returns true when the letter's exchange defaults require a publisher confirm.
+func (pub *Publisher) requiresConfirm(letter *models.Letter) bool {
+	defaults := pub.ExchangeDefaults(letter.Envelope.Exchange)
+	return defaults != nil && defaults.RequireConfirm
+}
+
+// BuildPublishing turns the letter into the amqp.Publishing (and mandatory flag) to send, applying the
+// exchange defaults and the stream filter value. The letter itself is left untouched.
+func (pub *Publisher) buildPublishing(letter *models.Letter) (amqp.Publishing, bool) {
+
+	envelope := letter.Envelope
+	if letter.Publishing != nil { // raw publish, sent as is
+		return *letter.Publishing, envelope.Mandatory
+	}
+
+	publishing := amqp.Publishing{
+		ContentType:   envelope.ContentType,
+		Body:          letter.Body,
+		Headers:       amqp.Table(envelope.Headers),
+		DeliveryMode:  envelope.DeliveryMode,
+		MessageId:     envelope.MessageID,
+		CorrelationId: envelope.CorrelationID,
+		Expiration:    envelope.Expiration,
+		Priority:      envelope.Priority,
+	}
+	mandatory := envelope.Mandatory
+
+	defaults := pub.ExchangeDefaults(envelope.Exchange)
+	if defaults != nil {
+		if publishing.DeliveryMode == 0 {
+			publishing.DeliveryMode = defaults.DeliveryMode
+		}
+
+		if publishing.ContentType == "" {
+			publishing.ContentType = defaults.ContentType
+		}
+
+		mandatory = mandatory || defaults.Mandatory
+	}
+
+	if (defaults != nil && len(defaults.Headers) > 0) || envelope.StreamFilterValue != "" {
+		headers := make(amqp.Table, len(envelope.Headers)+1) // copied so the letter's headers are left untouched
+		if defaults != nil {
+			for key, value := range defaults.Headers {
+				headers[key] = value
+			}
+		}
+
+		for key, value := range envelope.Headers {
+			headers[key] = value
+		}
+
+		if envelope.StreamFilterValue != "" {
+			headers[models.StreamFilterValueHeader] = envelope.StreamFilterValue
+		}
+
+		publishing.Headers = headers
+	}
+
+	return publishing, mandatory
+}
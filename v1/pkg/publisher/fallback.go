@@ -0,0 +1,131 @@
+package publisher
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// FallbackFromHeader carries the exchange a letter was meant for when it's published to a fallback exchange.
+const FallbackFromHeader = "x-fallback-from"
+
+// SetFallbacks registers where letters for the exchange go, tried in order, when their publish failed (after
+// every retry): another exchange (the letter's routing key when the fallback has none) or the fallback sink
+// (see SetFallbackSink). A letter only counts as unroutable when it's confirmed and mandatory (see
+// ExchangeDefaults), other publishes fail on channel and connection errors only. Each fallback is tried once,
+// without following the fallback exchange's own fallbacks, and the one used raises a FallbackUsed event.
+// A letter no fallback took is sent to Failures. Nil or empty fallbacks remove the exchange's.
+func (pub *Publisher) SetFallbacks(exchange string, fallbacks []*models.PublishFallback) {
+	pub.defaultsLock.Lock()
+	defer pub.defaultsLock.Unlock()
+
+	if len(fallbacks) == 0 {
+		delete(pub.fallbacks, exchange)
+		return
+	}
+
+	pub.fallbacks[exchange] = fallbacks
+}
+
+// Fallbacks returns the fallbacks registered for the exchange, or nil.
+func (pub *Publisher) Fallbacks(exchange string) []*models.PublishFallback {
+	pub.defaultsLock.RLock()
+	defer pub.defaultsLock.RUnlock()
+
+	return pub.fallbacks[exchange]
+}
+
+// FallBack hands the failed letter to its exchange's fallbacks, sending it to Failures when none took it.
+// Returns nil when a fallback took it, the original error otherwise.
+func (pub *Publisher) fallBack(letter *models.Letter, cause error, attempts uint32) error {
+
+	for _, fallback := range pub.Fallbacks(letter.Envelope.Exchange) {
+		if fallback == nil {
+			continue
+		}
+
+		destination, err := pub.publishFallback(letter, fallback)
+		if err != nil {
+			continue
+		}
+
+		pub.emitEvent(models.NewEvent(
+			models.FallbackUsed,
+			0,
+			0,
+			fmt.Sprintf("letter %d for exchange %q went to %s after: %s", letter.LetterID, letter.Envelope.Exchange, destination, cause)))
+
+		return nil
+	}
+
+	pub.sendToFailures(letter, cause, attempts)
+	return cause
+}
+
+// PublishFallback sends the letter to the fallback once, returning where it went.
+func (pub *Publisher) publishFallback(letter *models.Letter, fallback *models.PublishFallback) (string, error) {
+
+	if fallback.Sink {
+		pub.pubLock.Lock()
+		sink := pub.fallbackSink
+		pub.pubLock.Unlock()
+
+		if sink == nil {
+			return "", errors.New("can't fall back to the sink without one")
+		}
+
+		return "the fallback sink", sink(letter)
+	}
+
+	if letter.Publishing != nil {
+		return "", errors.New("can't fall back with a raw publish")
+	}
+
+	envelope := *letter.Envelope
+	envelope.Exchange = fallback.Exchange
+	if fallback.RoutingKey != "" {
+		envelope.RoutingKey = fallback.RoutingKey
+	}
+
+	envelope.Headers = make(map[string]interface{}, len(letter.Envelope.Headers)+1)
+	for key, value := range letter.Envelope.Headers { // copied so the letter's headers are left untouched
+		envelope.Headers[key] = value
+	}
+	envelope.Headers[FallbackFromHeader] = letter.Envelope.Exchange
+
+	rerouted := *letter
+	rerouted.Envelope = &envelope
+
+	destination := fmt.Sprintf("exchange %q (%s)", envelope.Exchange, envelope.RoutingKey)
+	if err := pub.checkLetter(&rerouted); err != nil {
+		return destination, err
+	}
+
+	err := pub.publishOnce(&rerouted)
+	pub.sendToNotifications(&rerouted, err, 0)
+
+	return destination, err
+}
+
+// PublishOnce makes a single publish attempt the way Publish would, without reporting it.
+func (pub *Publisher) publishOnce(letter *models.Letter) error {
+
+	if pub.requiresConfirm(letter) {
+		return pub.publishWithConfirm(letter)
+	}
+
+	if shards := pub.getShards(); shards != nil {
+		return pub.publishWithAffinity(shards, letter)
+	}
+
+	chanHost, err := pub.ChannelPool.GetChannel()
+	if err != nil {
+		return err
+	}
+
+	err = pub.simplePublish(chanHost, letter)
+	pub.ChannelPool.ReturnChannel(chanHost, err != nil)
+
+	return err
+}
@@ -0,0 +1,65 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+const flushPollInterval = time.Duration(10) * time.Millisecond
+
+// Flush publishes everything queued for AutoPublish (spooled letters included), stops AutoPublish once the letter
+// buffer is empty, and waits for the publishes in flight (including one waiting on its publisher confirm) to finish.
+// When the context ends first, the context error is returned with how many letters were left unsent.
+func (pub *Publisher) Flush(ctx context.Context) error {
+
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+
+	for pub.unsent() > 0 {
+		if !pub.AutoPublishStarted() {
+			return fmt.Errorf("can't flush queued letters without AutoPublish\r\n[unsent: %d]", pub.unsent())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return pub.flushErr(ctx.Err())
+		}
+	}
+
+	pub.StopAutoPublish()
+
+	for pub.AutoPublishStarted() { // stopped once every publish in flight has finished
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return pub.flushErr(ctx.Err())
+		}
+	}
+
+	confirmed := make(chan struct{})
+	models.Go("publisher.flush", func() {
+		pub.confirmer.confirmLock.Lock()
+		pub.confirmer.confirmLock.Unlock()
+		close(confirmed)
+	})
+
+	select {
+	case <-confirmed:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w\r\n[waiting on a publisher confirm]", ctx.Err())
+	}
+}
+
+func (pub *Publisher) flushErr(err error) error {
+	return fmt.Errorf("%w\r\n[unsent: %d]", err, pub.unsent())
+}
+
+func (pub *Publisher) unsent() uint64 {
+	return atomic.LoadUint64(&pub.letterCount) + uint64(pub.spooled())
+}
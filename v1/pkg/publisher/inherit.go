@@ -0,0 +1,96 @@
+package publisher
+
+import (
+	"context"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// PublishWithContext publishes the letter like Publish, inheriting from the message consumed in the context (see
+// models.Message.InheritContext) what the letter leaves unset: priority, correlation id, the PublisherConfig's
+// InheritHeaders, and the deadline (sent as the models.DeadlineHeader, the earlier of the message's and the
+// context's). A context that's done, or a deadline already passed, fails the letter without publishing it.
+func (pub *Publisher) PublishWithContext(ctx context.Context, letter *models.Letter) {
+
+	inherited, err := pub.inherit(ctx, letter)
+	if err != nil {
+		pub.sendToNotifications(letter, err, 0)
+		pub.sendToFailures(letter, err, 1)
+		return
+	}
+
+	pub.Publish(inherited)
+}
+
+// QueueLetterWithContext queues the letter for AutoPublish like QueueLetter, inheriting from the message
+// consumed in the context (see PublishWithContext).
+func (pub *Publisher) QueueLetterWithContext(ctx context.Context, letter *models.Letter) {
+
+	inherited, err := pub.inherit(ctx, letter)
+	if err != nil {
+		pub.sendToNotifications(letter, err, 0)
+		pub.sendToFailures(letter, err, 1)
+		return
+	}
+
+	pub.QueueLetter(inherited)
+}
+
+// Inherit returns a copy of the letter with what it leaves unset filled in from the context, or the letter
+// itself when there's nothing to fill in. Raw publishes (PublishRaw) are sent as is.
+func (pub *Publisher) inherit(ctx context.Context, letter *models.Letter) (*models.Letter, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if letter.Publishing != nil {
+		return letter, nil
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+	inherited, ok := models.InheritedFrom(ctx)
+	if ok && !inherited.Deadline.IsZero() && (!hasDeadline || inherited.Deadline.Before(deadline)) {
+		deadline, hasDeadline = inherited.Deadline, true
+	}
+
+	if hasDeadline && !time.Now().Before(deadline) {
+		return nil, context.DeadlineExceeded
+	}
+
+	if !ok && !hasDeadline {
+		return letter, nil
+	}
+
+	envelope := *letter.Envelope
+	envelope.Headers = make(map[string]interface{}, len(letter.Envelope.Headers)+len(pub.Config.PublisherConfig.InheritHeaders)+1)
+	for key, value := range letter.Envelope.Headers { // copied so the letter's headers are left untouched
+		envelope.Headers[key] = value
+	}
+
+	if ok {
+		if envelope.Priority == 0 {
+			envelope.Priority = inherited.Priority
+		}
+
+		if envelope.CorrelationID == "" {
+			envelope.CorrelationID = inherited.CorrelationID
+		}
+
+		for _, header := range pub.Config.PublisherConfig.InheritHeaders {
+			value, found := inherited.Headers[header]
+			if _, overridden := envelope.Headers[header]; found && !overridden {
+				envelope.Headers[header] = value
+			}
+		}
+	}
+
+	if _, overridden := envelope.Headers[models.DeadlineHeader]; hasDeadline && !overridden {
+		envelope.Headers[models.DeadlineHeader] = deadline.UnixNano() / int64(time.Millisecond)
+	}
+
+	copied := *letter
+	copied.Envelope = &envelope
+	return &copied, nil
+}
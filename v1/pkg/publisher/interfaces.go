@@ -0,0 +1,68 @@
+package publisher
+
+import (
+	"context"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
+	"github.com/streadway/amqp"
+)
+
+// IPublisher is the full API of a Publisher, depend on it instead of *Publisher to mock or decorate publishers.
+// An IPublisher is also a consumer.ResultPublisher.
+type IPublisher interface {
+	// Setup.
+	EnableChannelAffinity(shardCount int) error
+	SetExchangeDefaults(exchange string, defaults *models.ExchangeDefaults)
+	ExchangeDefaults(exchange string) *models.ExchangeDefaults
+	SetPublishPolicy(exchange string, policy *models.PublishPolicy)
+	PublishPolicy(exchange string) *models.PublishPolicy
+	SetNamingPolicy(naming *models.NamingPolicy)
+	SetFallbackSink(sink FallbackSink)
+	SetFallbacks(exchange string, fallbacks []*models.PublishFallback)
+	Fallbacks(exchange string) []*models.PublishFallback
+
+	// Publishing.
+	Publish(letter *models.Letter)
+	PublishWithRetry(letter *models.Letter)
+	PublishWithTTL(letter *models.Letter, ttl time.Duration) error
+	PublishWithBudget(letter *models.Letter, budget LatencyBudget) error
+	PublishWithContext(ctx context.Context, letter *models.Letter)
+	PublishRaw(exchange, key string, mandatory, immediate bool, publishing amqp.Publishing) error
+	PublishRawWithConfirm(exchange, key string, mandatory bool, publishing amqp.Publishing) error
+
+	// AutoPublish.
+	StartAutoPublish(allowRetry bool)
+	StopAutoPublish()
+	AutoPublishStarted() bool
+	QueueLetter(letter *models.Letter)
+	QueueLetters(letters []*models.Letter)
+	QueueLetterWithContext(ctx context.Context, letter *models.Letter)
+	Flush(ctx context.Context) error
+	FlushStops()
+	SpoolStats() SpoolStats
+
+	// Outcomes.
+	Notifications() <-chan *models.Notification
+	Failures() <-chan *models.Failure
+	Events() <-chan *models.Event
+
+	Shutdown(shutdownPools bool)
+}
+
+var _ IPublisher = (*Publisher)(nil)
+
+// New creates a Publisher (see NewPublisher) as an IPublisher.
+func New(
+	config *models.RabbitSeasoning,
+	chanPool *pools.ChannelPool,
+	connPool *pools.ConnectionPool) (IPublisher, error) {
+
+	pub, err := NewPublisher(config, chanPool, connPool)
+	if err != nil {
+		return nil, err // not a nil *Publisher in a non nil IPublisher
+	}
+
+	return pub, nil
+}
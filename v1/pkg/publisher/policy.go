@@ -0,0 +1,128 @@
+package publisher
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// PublishPolicyWildcard is the exchange whose PublishPolicy covers exchanges without their own.
+const PublishPolicyWildcard = "*"
+
+var (
+	// ErrBodyTooLarge is a letter's body over its exchange's MaxBodySize.
+	ErrBodyTooLarge = errors.New("body is too large")
+	// ErrContentTypeNotAllowed is a letter's content type missing from its exchange's ContentTypes.
+	ErrContentTypeNotAllowed = errors.New("content type isn't allowed")
+	// ErrMissingHeader is a letter without one of its exchange's RequiredHeaders.
+	ErrMissingHeader = errors.New("required header is missing")
+)
+
+// PolicyViolation is a letter rejected by its exchange's PublishPolicy before it reached the broker.
+// Err is ErrBodyTooLarge, ErrContentTypeNotAllowed, or ErrMissingHeader (see errors.Is).
+type PolicyViolation struct {
+	Exchange string
+	LetterID uint64
+	Err      error
+	Detail   string
+}
+
+func (pv *PolicyViolation) Error() string {
+	return fmt.Sprintf("[LetterID: %d] exchange %q publish policy: %s (%s)", pv.LetterID, pv.Exchange, pv.Err, pv.Detail)
+}
+
+// Unwrap returns the violated rule's error.
+func (pv *PolicyViolation) Unwrap() error {
+	return pv.Err
+}
+
+// SetPublishPolicy registers the guards every letter sent to the exchange must pass, PublishPolicyWildcard
+// covers exchanges without a policy of their own. Letters are checked as they'd be published (exchange
+// defaults applied, raw publishes as is), violations are sent to Notifications and Failures as a
+// PolicyViolation without being retried. A nil policy removes the exchange's policy.
+func (pub *Publisher) SetPublishPolicy(exchange string, policy *models.PublishPolicy) {
+	pub.defaultsLock.Lock()
+	defer pub.defaultsLock.Unlock()
+
+	if policy == nil {
+		delete(pub.publishPolicies, exchange)
+		return
+	}
+
+	pub.publishPolicies[exchange] = policy
+}
+
+// PublishPolicy returns the policy applied to letters sent to the exchange, or nil.
+func (pub *Publisher) PublishPolicy(exchange string) *models.PublishPolicy {
+	pub.defaultsLock.RLock()
+	defer pub.defaultsLock.RUnlock()
+
+	if policy, ok := pub.publishPolicies[exchange]; ok {
+		return policy
+	}
+
+	return pub.publishPolicies[PublishPolicyWildcard]
+}
+
+// CheckPolicy returns a PolicyViolation when the letter breaks its exchange's PublishPolicy.
+func (pub *Publisher) checkPolicy(letter *models.Letter) error {
+
+	policy := pub.PublishPolicy(letter.Envelope.Exchange)
+	if policy == nil {
+		return nil
+	}
+
+	publishing, _ := pub.buildPublishing(letter)
+	violation := func(err error, detail string, args ...interface{}) error {
+		return &PolicyViolation{
+			Exchange: letter.Envelope.Exchange,
+			LetterID: letter.LetterID,
+			Err:      err,
+			Detail:   fmt.Sprintf(detail, args...),
+		}
+	}
+
+	if policy.MaxBodySize > 0 && len(publishing.Body) > int(policy.MaxBodySize) {
+		return violation(ErrBodyTooLarge, "%d bytes, the limit is %d", len(publishing.Body), policy.MaxBodySize)
+	}
+
+	if len(policy.ContentTypes) > 0 && !contentTypeAllowed(publishing.ContentType, policy.ContentTypes) {
+		return violation(ErrContentTypeNotAllowed, "%q, allowed are %s", publishing.ContentType, strings.Join(policy.ContentTypes, ", "))
+	}
+
+	for _, header := range policy.RequiredHeaders {
+		if _, ok := publishing.Headers[header]; !ok {
+			return violation(ErrMissingHeader, "%s", header)
+		}
+	}
+
+	return nil
+}
+
+// ContentTypeAllowed matches the content type's media type (parameters ignored) against the allowed ones,
+// case insensitively, with type/* allowing every subtype.
+func contentTypeAllowed(contentType string, allowed []string) bool {
+
+	mediaType := strings.TrimSpace(contentType)
+	if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+		mediaType = strings.TrimSpace(mediaType[:i])
+	}
+
+	if mediaType == "" {
+		return false
+	}
+
+	for _, allow := range allowed {
+		if strings.EqualFold(allow, mediaType) {
+			return true
+		}
+
+		if strings.HasSuffix(allow, "/*") && len(mediaType) > len(allow)-1 && strings.EqualFold(allow[:len(allow)-1], mediaType[:len(allow)-1]) {
+			return true
+		}
+	}
+
+	return false
+}
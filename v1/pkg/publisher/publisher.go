@@ -1,6 +1,7 @@
 package publisher
 
 import (
+	"errors"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,6 +13,9 @@ import (
 )
 
 // Publisher contains everything you need to publish a message.
+// A Publisher is safe for concurrent use, share one instead of creating one per goroutine. An amqp.Channel is
+// never used by two goroutines at once: every publish either checks a channel out of the ChannelPool for its
+// duration or, with channel affinity enabled, publishes on a shard's channel while holding the shard's lock.
 type Publisher struct {
 	Config                   *models.RabbitSeasoning
 	ChannelPool              *pools.ChannelPool
@@ -21,11 +25,26 @@ type Publisher struct {
 	maxOverBuffer            uint64
 	autoStop                 chan bool
 	notifications            chan *models.Notification
+	failures                 chan *models.Failure
 	autoStarted              bool
 	autoPublishGroup         *sync.WaitGroup
 	sleepOnIdleInterval      time.Duration
 	sleepOnQueueFullInterval time.Duration
 	sleepOnErrorInterval     time.Duration
+	shards                   []*channelShard
+	receipts                 *receiptCache
+	exchangeDefaults         map[string]*models.ExchangeDefaults
+	publishPolicies          map[string]*models.PublishPolicy
+	fallbacks                map[string][]*models.PublishFallback
+	confirmer                *confirmChannel
+	confirmTimeout           time.Duration
+	deadLetters              *deadLetterCheck
+	naming                   *models.NamingPolicy
+	fallbackSink             FallbackSink
+	spool                    *Spool
+	spoolLock                *sync.Mutex
+	events                   chan *models.Event
+	defaultsLock             *sync.RWMutex
 	pubLock                  *sync.Mutex
 	pubRWLock                *sync.RWMutex
 }
@@ -45,6 +64,76 @@ func NewPublisher(
 		}
 	}
 
+	failureBuffer := config.PublisherConfig.FailureBuffer
+	if failureBuffer == 0 {
+		failureBuffer = config.PublisherConfig.NotificationBuffer
+	}
+
+	var receipts *receiptCache
+	if config.PublisherConfig.ReceiptDedupWindow > 0 {
+		receipts = newReceiptCache(time.Duration(config.PublisherConfig.ReceiptDedupWindow) * time.Millisecond)
+	}
+
+	exchangeDefaults := make(map[string]*models.ExchangeDefaults, len(config.PublisherConfig.ExchangeDefaults))
+	for exchange, defaults := range config.PublisherConfig.ExchangeDefaults {
+		if defaults != nil {
+			exchangeDefaults[exchange] = defaults
+		}
+	}
+
+	publishPolicies := make(map[string]*models.PublishPolicy, len(config.PublisherConfig.PublishPolicies))
+	for exchange, policy := range config.PublisherConfig.PublishPolicies {
+		if policy != nil {
+			publishPolicies[exchange] = policy
+		}
+	}
+
+	fallbacks := make(map[string][]*models.PublishFallback, len(config.PublisherConfig.Fallbacks))
+	for exchange, chain := range config.PublisherConfig.Fallbacks {
+		if len(chain) > 0 {
+			fallbacks[exchange] = chain
+		}
+	}
+
+	confirmTimeout := time.Duration(config.PublisherConfig.ConfirmTimeout) * time.Millisecond
+	if confirmTimeout == 0 {
+		confirmTimeout = defaultConfirmTimeout
+	}
+
+	var deadLetters *deadLetterCheck
+	if config.PublisherConfig.VerifyTTLDeadLetter {
+		if config.ManagementConfig == nil {
+			return nil, errors.New("can't verify ttl dead letter exchanges without a management config")
+		}
+
+		var err error
+		deadLetters, err = newDeadLetterCheck(config.ManagementConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var naming *models.NamingPolicy
+	if config.NamingPolicy != nil {
+		var err error
+		naming, err = models.NewNamingPolicy(config.NamingPolicy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var spool *Spool
+	if config.PublisherConfig.SpoolDir != "" {
+		var err error
+		spool, err = NewSpool(
+			config.PublisherConfig.SpoolDir,
+			int64(config.PublisherConfig.SpoolMaxBytes),
+			int64(config.PublisherConfig.SpoolSegmentSize))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &Publisher{
 		Config:                   config,
 		ChannelPool:              chanPool,
@@ -54,6 +143,19 @@ func NewPublisher(
 		autoStop:                 make(chan bool, 1),
 		autoPublishGroup:         &sync.WaitGroup{},
 		notifications:            make(chan *models.Notification, config.PublisherConfig.NotificationBuffer),
+		failures:                 make(chan *models.Failure, failureBuffer),
+		receipts:                 receipts,
+		exchangeDefaults:         exchangeDefaults,
+		publishPolicies:          publishPolicies,
+		fallbacks:                fallbacks,
+		confirmer:                &confirmChannel{confirmLock: &sync.Mutex{}},
+		confirmTimeout:           confirmTimeout,
+		deadLetters:              deadLetters,
+		naming:                   naming,
+		spool:                    spool,
+		spoolLock:                &sync.Mutex{},
+		events:                   make(chan *models.Event, config.PublisherConfig.NotificationBuffer),
+		defaultsLock:             &sync.RWMutex{},
 		sleepOnIdleInterval:      time.Duration(config.PublisherConfig.SleepOnIdleInterval) * time.Millisecond,
 		sleepOnQueueFullInterval: time.Duration(config.PublisherConfig.SleepOnQueueFullInterval) * time.Millisecond,
 		sleepOnErrorInterval:     time.Duration(config.PublisherConfig.SleepOnErrorInterval) * time.Millisecond,
@@ -67,48 +169,170 @@ func NewPublisher(
 // Subscribe to Notifications to see success and errors.
 func (pub *Publisher) Publish(letter *models.Letter) {
 
+	if err := pub.checkLetter(letter); err != nil {
+		pub.sendToNotifications(letter, err, 0)
+		pub.sendToFailures(letter, err, 1)
+		return
+	}
+
+	if pub.requiresConfirm(letter) {
+		err := pub.publishWithConfirm(letter)
+		pub.sendToNotifications(letter, err, 0)
+		if err != nil {
+			_ = pub.fallBack(letter, err, 1)
+		}
+		return
+	}
+
+	if shards := pub.getShards(); shards != nil {
+		err := pub.publishWithAffinity(shards, letter)
+		pub.sendToNotifications(letter, err, 0)
+		if err != nil {
+			_ = pub.fallBack(letter, err, 1)
+		}
+		return
+	}
+
 	chanHost, err := pub.ChannelPool.GetChannel()
 	if err != nil {
-		pub.sendToNotifications(letter, err)
+		pub.sendToNotifications(letter, err, 0)
+		_ = pub.fallBack(letter, err, 1)
 		return // exit out if you can't get a channel
 	}
 
-	err = pub.simplePublish(chanHost.Channel, letter)
+	err = pub.simplePublish(chanHost, letter)
 	if err != nil {
-		pub.handleErrorAndChannel(err, letter, chanHost)
+		pub.handleErrorAndChannel(err, letter, chanHost, 0)
+		_ = pub.fallBack(letter, err, 1)
 	} else {
-		pub.sendToNotifications(letter, err)
+		pub.sendToNotifications(letter, err, 0)
 		pub.ChannelPool.ReturnChannel(chanHost, false)
 	}
 }
 
+// SetNamingPolicy rejects (or only reports) letters for exchanges outside the policy, nil removes it.
+// Replaces the policy built from the NamingPolicy config, must be set before publishing starts.
+func (pub *Publisher) SetNamingPolicy(naming *models.NamingPolicy) {
+	pub.naming = naming
+}
+
 // PublishWithRetry sends a single message to the address on the letter with retry capabilities.
 // Subscribe to Notifications to see success and errors.
 // RetryCount is based on the letter property. Zero means it will try once.
+// The letter is sent to Failures once every attempt has failed.
 func (pub *Publisher) PublishWithRetry(letter *models.Letter) {
+	_ = pub.publishWithRetry(letter)
+}
+
+// PublishRaw publishes the amqp.Publishing exactly as given, for when the Letter's Envelope doesn't expose
+// what you need. It goes through the same pooled (or affinity) channels and retries as PublishWithRetry, using
+// the PublisherConfig's RawRetryCount, and is reported on Notifications and Failures like any other letter.
+// Returns the last error when every attempt failed.
+func (pub *Publisher) PublishRaw(exchange, key string, mandatory, immediate bool, publishing amqp.Publishing) error {
+	return pub.publishWithRetry(pub.rawLetter(exchange, key, mandatory, immediate, publishing))
+}
+
+// PublishRawWithConfirm publishes like PublishRaw, but on the confirm mode channel whatever the exchange defaults
+// say, so nil means the broker took the publish (and routed it, when mandatory).
+func (pub *Publisher) PublishRawWithConfirm(exchange, key string, mandatory bool, publishing amqp.Publishing) error {
+	return pub.retryPublish(pub.rawLetter(exchange, key, mandatory, false, publishing), true)
+}
+
+func (pub *Publisher) rawLetter(exchange, key string, mandatory, immediate bool, publishing amqp.Publishing) *models.Letter {
+	return &models.Letter{
+		RetryCount: pub.Config.PublisherConfig.RawRetryCount,
+		Body:       publishing.Body,
+		Envelope: &models.Envelope{
+			Exchange:      exchange,
+			RoutingKey:    key,
+			ContentType:   publishing.ContentType,
+			Mandatory:     mandatory,
+			Immediate:     immediate,
+			Headers:       publishing.Headers,
+			DeliveryMode:  publishing.DeliveryMode,
+			MessageID:     publishing.MessageId,
+			CorrelationID: publishing.CorrelationId,
+			Priority:      publishing.Priority,
+		},
+		Publishing: &publishing,
+	}
+}
+
+func (pub *Publisher) publishWithRetry(letter *models.Letter) error {
+	return pub.retryPublish(letter, pub.requiresConfirm(letter))
+}
+
+// RetryPublish publishes the letter with retry, on the confirm mode channel when confirm is set.
+func (pub *Publisher) retryPublish(letter *models.Letter, confirm bool) error {
 
+	if err := pub.checkLetter(letter); err != nil {
+		pub.sendToNotifications(letter, err, 0)
+		pub.sendToFailures(letter, err, 1)
+		return err
+	}
+
+	shards := pub.getShards()
+
+	var lastErr error
 	for i := letter.RetryCount + 1; i > 0; i-- {
+		retryCount := letter.RetryCount + 1 - i
+
+		if confirm {
+			lastErr = pub.publishWithConfirm(letter)
+			pub.sendToNotifications(letter, lastErr, retryCount)
+			if lastErr == nil {
+				return nil // finished
+			}
+
+			time.Sleep(pub.sleepOnErrorInterval * time.Millisecond)
+			continue
+		}
+
+		if shards != nil {
+			lastErr = pub.publishWithAffinity(shards, letter)
+			pub.sendToNotifications(letter, lastErr, retryCount)
+			if lastErr == nil {
+				return nil // finished
+			}
+
+			time.Sleep(pub.sleepOnErrorInterval * time.Millisecond)
+			continue
+		}
+
 		chanHost, err := pub.ChannelPool.GetChannel()
 		if err != nil {
+			lastErr = err
 			time.Sleep(pub.sleepOnErrorInterval * time.Millisecond)
 			continue // can't get a channel
 		}
 
-		err = pub.simplePublish(chanHost.Channel, letter)
+		err = pub.simplePublish(chanHost, letter)
 		if err != nil {
-			pub.handleErrorAndChannel(err, letter, chanHost)
+			lastErr = err
+			pub.handleErrorAndChannel(err, letter, chanHost, retryCount)
 			continue // flag channel and try again
 		}
 
-		pub.sendToNotifications(letter, err)
+		pub.sendToNotifications(letter, err, retryCount)
 		pub.ChannelPool.ReturnChannel(chanHost, false)
-		break // finished
+		return nil // finished
+	}
+
+	return pub.fallBack(letter, lastErr, letter.RetryCount+1)
+}
+
+// CheckLetter returns why the letter can't be published, if it breaks the naming policy or its publish policy.
+func (pub *Publisher) checkLetter(letter *models.Letter) error {
+	if err := pub.naming.CheckExchange(letter.Envelope.Exchange); err != nil {
+		return err
 	}
+
+	return pub.checkPolicy(letter)
 }
 
-func (pub *Publisher) handleErrorAndChannel(err error, letter *models.Letter, chanHost *pools.ChannelHost) {
+func (pub *Publisher) handleErrorAndChannel(err error, letter *models.Letter, chanHost *pools.ChannelHost, retryCount uint32) {
 	pub.ChannelPool.ReturnChannel(chanHost, true)
-	pub.sendToNotifications(letter, err)
+	pub.sendToNotifications(letter, err, retryCount)
 	time.Sleep(pub.sleepOnErrorInterval * time.Millisecond)
 }
 
@@ -118,11 +342,25 @@ func (pub *Publisher) Notifications() <-chan *models.Notification {
 	return pub.notifications
 }
 
+// Failures yields letters that permanently failed to publish, whole (body and envelope) so they can be
+// persisted or re-routed. Failures are dropped when the buffer is full and nobody is reading.
+func (pub *Publisher) Failures() <-chan *models.Failure {
+	return pub.failures
+}
+
 // StartAutoPublish starts auto-publishing letters queued up - is locking.
+// Does nothing if auto publishing has already started.
 func (pub *Publisher) StartAutoPublish(allowRetry bool) {
+	pub.pubLock.Lock()
+	defer pub.pubLock.Unlock()
+
+	if pub.autoStarted {
+		return
+	}
+
 	pub.FlushStops()
 
-	go func() {
+	models.Go("publisher.autopublish", func() {
 	PublishLoop:
 		for {
 			select {
@@ -134,11 +372,13 @@ func (pub *Publisher) StartAutoPublish(allowRetry bool) {
 				break
 			}
 
+			pub.replaySpool()
+
 			select {
 			case letter := <-pub.letters:
 				pub.autoPublishGroup.Add(1)
 
-				go func() {
+				models.Go("publisher.publish", func() {
 					defer pub.autoPublishGroup.Done()
 					if allowRetry {
 						pub.PublishWithRetry(letter)
@@ -147,7 +387,7 @@ func (pub *Publisher) StartAutoPublish(allowRetry bool) {
 					}
 
 					pub.reduceLetterCount()
-				}()
+				})
 
 			default:
 				if pub.sleepOnIdleInterval > 0 {
@@ -162,11 +402,9 @@ func (pub *Publisher) StartAutoPublish(allowRetry bool) {
 		pub.pubLock.Lock()
 		pub.autoStarted = false
 		pub.pubLock.Unlock()
-	}()
+	})
 
-	pub.pubLock.Lock()
 	pub.autoStarted = true
-	pub.pubLock.Unlock()
 }
 
 // StopAutoPublish stops publishing letters queued up - is locking.
@@ -178,14 +416,18 @@ func (pub *Publisher) StopAutoPublish() {
 		return
 	}
 
-	go func() { pub.autoStop <- true }() // signal auto publish to stop
+	models.Go("publisher.autostop", func() { pub.autoStop <- true }) // signal auto publish to stop
 }
 
 // QueueLetters allows you to bulk queue letters that will be consumed by AutoPublish.
-// Blocks on the Letter Buffer being full.
+// Blocks on the Letter Buffer being full, with a spool configured letters overflow to disk instead.
 func (pub *Publisher) QueueLetters(letters []*models.Letter) {
 
 	for i := 0; i < len(letters); i++ {
+		if pub.spoolLetter(letters[i]) {
+			continue
+		}
+
 		// Loop here until (buffer + maxOverBuffer) has room for you.
 		for atomic.LoadUint64(&pub.letterCount) >= (pub.letterBuffer + pub.maxOverBuffer) {
 			time.Sleep(pub.sleepOnQueueFullInterval)
@@ -197,9 +439,13 @@ func (pub *Publisher) QueueLetters(letters []*models.Letter) {
 }
 
 // QueueLetter queues up a letter that will be consumed by AutoPublish.
-// Blocks on the Letter Buffer being full.
+// Blocks on the Letter Buffer being full, with a spool configured letters overflow to disk instead.
 func (pub *Publisher) QueueLetter(letter *models.Letter) {
 
+	if pub.spoolLetter(letter) {
+		return
+	}
+
 	// Loop here until (buffer + maxOverBuffer) has room for you.
 	for atomic.LoadUint64(&pub.letterCount) >= (pub.letterBuffer + pub.maxOverBuffer) {
 		time.Sleep(pub.sleepOnQueueFullInterval)
@@ -218,6 +464,7 @@ func (pub *Publisher) queueLetter(letter *models.Letter) {
 func (pub *Publisher) increaseLetterCount() {
 	pub.pubRWLock.Lock()
 	pub.letterCount++
+	models.GetMetrics().Gauge(models.MetricQueuedLetters, float64(pub.letterCount), nil)
 	pub.pubRWLock.Unlock()
 }
 
@@ -225,41 +472,108 @@ func (pub *Publisher) increaseLetterCount() {
 func (pub *Publisher) reduceLetterCount() {
 	pub.pubRWLock.Lock()
 	pub.letterCount--
+	models.GetMetrics().Gauge(models.MetricQueuedLetters, float64(pub.letterCount), nil)
 	pub.pubRWLock.Unlock()
 }
 
 // SimplePublish performs the actual amqp.Publish.
-func (pub *Publisher) simplePublish(amqpChan *amqp.Channel, letter *models.Letter) error {
+func (pub *Publisher) simplePublish(chanHost *pools.ChannelHost, letter *models.Letter) error {
 
-	return amqpChan.Publish(
+	publishing, mandatory := pub.buildPublishing(letter)
+
+	started := time.Now()
+	err := chanHost.Channel.Publish(
 		letter.Envelope.Exchange,
 		letter.Envelope.RoutingKey,
-		letter.Envelope.Mandatory,
+		mandatory,
 		letter.Envelope.Immediate,
-		amqp.Publishing{
-			ContentType:  letter.Envelope.ContentType,
-			Body:         letter.Body,
-			Headers:      amqp.Table(letter.Envelope.Headers),
-			DeliveryMode: letter.Envelope.DeliveryMode,
-		},
+		publishing,
 	)
+
+	if models.Tracing() {
+		models.Trace(
+			"publish", chanHost.ConnectionID, chanHost.ChannelID, started, err,
+			"exchange: %s, routing key: %s, letter: %d, bytes: %d",
+			letter.Envelope.Exchange, letter.Envelope.RoutingKey, letter.LetterID, len(publishing.Body))
+	}
+
+	return err
 }
 
 // SendToNotifications sends the status to the notifications channel.
-func (pub *Publisher) sendToNotifications(letter *models.Letter, err error) {
+// A repeat success for a MessageID already confirmed within the dedup window is suppressed.
+func (pub *Publisher) sendToNotifications(letter *models.Letter, err error, retryCount uint32) {
 
 	notification := &models.Notification{
-		LetterID: letter.LetterID,
-		Error:    err,
+		LetterID:   letter.LetterID,
+		Error:      err,
+		RetryCount: retryCount,
+	}
+
+	if err == nil && pub.receipts != nil && !pub.receipts.firstSuccess(letter.Envelope.MessageID) {
+		return // duplicate success receipt, counted and audited with the first one
 	}
 
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	models.GetMetrics().Counter(models.MetricPublishes, 1, models.Labels{"exchange": letter.Envelope.Exchange, "result": result})
+
 	if err == nil {
+		if models.Auditing() {
+			action := models.AuditPublished
+			if pub.requiresConfirm(letter) {
+				action = models.AuditPublishConfirmed
+			}
+
+			pub.audit(action, letter, "")
+		}
+
 		notification.Success = true
 	} else {
 		notification.FailedLetter = letter
 	}
 
-	go func() { pub.notifications <- notification }()
+	models.TryGo("publisher.notifications", func() { pub.notifications <- notification })
+}
+
+// SendToFailures sends the permanently failed letter to the failures channel without blocking.
+func (pub *Publisher) sendToFailures(letter *models.Letter, err error, attempts uint32) {
+
+	failure := &models.Failure{
+		Letter:   letter,
+		Error:    err,
+		Attempts: attempts,
+		Time:     time.Now(),
+	}
+
+	if models.Auditing() {
+		detail := ""
+		if err != nil {
+			detail = err.Error()
+		}
+
+		pub.audit(models.AuditPublishFailed, letter, detail)
+	}
+
+	select {
+	case pub.failures <- failure:
+	default:
+	}
+}
+
+// Audit records the letter's publish outcome to the Auditor (see models.SetAuditor).
+func (pub *Publisher) audit(action models.AuditAction, letter *models.Letter, detail string) {
+	models.Audit(&models.AuditRecord{
+		Action:        action,
+		Exchange:      letter.Envelope.Exchange,
+		RoutingKey:    letter.Envelope.RoutingKey,
+		MessageID:     letter.Envelope.MessageID,
+		CorrelationID: letter.Envelope.CorrelationID,
+		LetterID:      letter.LetterID,
+		Detail:        detail,
+	})
 }
 
 // AutoPublishStarted allows you to see if the AutoPublish feature has started - is locking.
@@ -286,6 +600,15 @@ FlushLoop:
 // Shutdown cleanly shutsdown the publisher and resets it's internal state.
 func (pub *Publisher) Shutdown(shutdownPools bool) {
 	pub.StopAutoPublish()
+	pub.releaseShards()
+
+	pub.confirmer.confirmLock.Lock()
+	pub.releaseConfirmChannel()
+	pub.confirmer.confirmLock.Unlock()
+
+	if pub.spool != nil {
+		_ = pub.spool.Close() // what's left is replayed by the next Publisher on the SpoolDir
+	}
 
 	if shutdownPools { // in case the ChannelPool is shared between structs, you can prevent it from shuttingdown
 		pub.ChannelPool.Shutdown()
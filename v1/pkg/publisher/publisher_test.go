@@ -1,14 +1,20 @@
 package publisher_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/fortytw2/leaktest"
+	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/consumer"
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/publisher"
@@ -105,6 +111,528 @@ AssertLoop:
 	channelPool.Shutdown()
 }
 
+func TestPublishWithChannelAffinityConcurrently(t *testing.T) {
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, publisher.EnableChannelAffinity(2))
+	assert.Error(t, publisher.EnableChannelAffinity(2))
+
+	messageCount := 100
+	for i := 0; i < messageCount; i++ {
+		go publisher.Publish(utils.CreateMockRandomLetter("TestQueue"))
+	}
+
+	successCount := 0
+	timeout := time.After(10 * time.Second)
+
+AssertLoop:
+	for successCount < messageCount {
+		select {
+		case <-timeout:
+			break AssertLoop
+		case notification := <-publisher.Notifications():
+			assert.NoError(t, notification.Error)
+			if notification.Success {
+				successCount++
+			}
+		}
+	}
+
+	assert.Equal(t, messageCount, successCount)
+
+	publisher.Shutdown(true)
+}
+
+func TestPublishRaw(t *testing.T) {
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	err = publisher.PublishRaw("", "TestQueue", false, false, amqp.Publishing{
+		ContentType:  "plain/text",
+		Body:         []byte("SuperStreetFighter2Turbo"),
+		DeliveryMode: amqp.Persistent,
+		Priority:     5,
+		Expiration:   "60000",
+		AppId:        "TurboCookedRabbit",
+	})
+	assert.NoError(t, err)
+
+	notification := <-publisher.Notifications()
+	assert.True(t, notification.Success)
+
+	channelPool.Shutdown()
+}
+
+func TestPublishWithExchangeDefaults(t *testing.T) {
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	publisher.SetExchangeDefaults("", &models.ExchangeDefaults{
+		DeliveryMode:   amqp.Persistent,
+		ContentType:    "application/json",
+		Headers:        map[string]interface{}{"x-source": "TurboCookedRabbit"},
+		RequireConfirm: true,
+	})
+	assert.NotNil(t, publisher.ExchangeDefaults(""))
+
+	publisher.PublishWithRetry(utils.CreateMockRandomLetter("ConsumerTestQueue"))
+
+	notification := <-publisher.Notifications()
+	assert.True(t, notification.Success)
+
+	publisher.SetExchangeDefaults("", nil)
+	assert.Nil(t, publisher.ExchangeDefaults(""))
+
+	publisher.Shutdown(true)
+}
+
+func TestPublishPolicy(t *testing.T) {
+
+	pub, err := publisher.NewPublisher(Seasoning, ChannelPool, nil)
+	assert.NoError(t, err)
+
+	pub.SetExchangeDefaults("", &models.ExchangeDefaults{ContentType: "application/json"})
+	pub.SetPublishPolicy(publisher.PublishPolicyWildcard, &models.PublishPolicy{
+		MaxBodySize:     1024,
+		ContentTypes:    []string{"application/json", "text/*"},
+		RequiredHeaders: []string{"x-team"},
+	})
+
+	letter := utils.CreateMockRandomLetter("ConsumerTestQueue")
+	letter.Body = make([]byte, 2048)
+	letter.Envelope.Headers = map[string]interface{}{"x-team": "payments"}
+
+	err = pub.PublishRaw("", "ConsumerTestQueue", false, false, amqp.Publishing{Body: letter.Body, ContentType: "application/json"})
+	assert.True(t, errors.Is(err, publisher.ErrBodyTooLarge))
+	<-pub.Notifications()
+
+	letter.Body = []byte("{}")
+	letter.Envelope.ContentType = "application/xml"
+	pub.Publish(letter)
+
+	notification := <-pub.Notifications()
+	assert.False(t, notification.Success)
+	assert.True(t, errors.Is(notification.Error, publisher.ErrContentTypeNotAllowed))
+
+	var violation *publisher.PolicyViolation
+	assert.True(t, errors.As(notification.Error, &violation))
+	assert.Equal(t, "", violation.Exchange)
+
+	letter.Envelope.ContentType = "" // the exchange default applies
+	letter.Envelope.Headers = nil
+	pub.Publish(letter)
+
+	notification = <-pub.Notifications()
+	assert.True(t, errors.Is(notification.Error, publisher.ErrMissingHeader))
+
+	letter.Envelope.ContentType = "text/plain; charset=utf-8"
+	letter.Envelope.Headers = map[string]interface{}{"x-team": "payments"}
+	pub.Publish(letter)
+
+	notification = <-pub.Notifications()
+	assert.True(t, notification.Success)
+
+	pub.SetPublishPolicy(publisher.PublishPolicyWildcard, nil)
+	assert.Nil(t, pub.PublishPolicy(""))
+
+	pub.Shutdown(false)
+}
+
+func TestPublishWithContext(t *testing.T) {
+
+	seasoning := *Seasoning
+	publisherConfig := *Seasoning.PublisherConfig
+	publisherConfig.InheritHeaders = []string{"x-tenant-id"}
+	seasoning.PublisherConfig = &publisherConfig
+
+	pub, err := publisher.NewPublisher(&seasoning, ChannelPool, nil)
+	assert.NoError(t, err)
+
+	pub.SetPublishPolicy(publisher.PublishPolicyWildcard, &models.PublishPolicy{RequiredHeaders: []string{"x-tenant-id"}})
+
+	msg := models.NewMessage(false, nil, 0, nil)
+	msg.Priority = 5
+	msg.CorrelationID = "order-42"
+	msg.Headers = map[string]interface{}{"x-tenant-id": "acme", "x-other": "dropped"}
+
+	letter := utils.CreateMockRandomLetter("ConsumerTestQueue")
+	pub.PublishWithContext(msg.InheritContext(), letter) // the tenant header satisfies the policy
+
+	notification := <-pub.Notifications()
+	assert.True(t, notification.Success)
+	assert.Nil(t, letter.Envelope.Headers["x-tenant-id"]) // the letter itself is left untouched
+
+	msg.Headers[models.DeadlineHeader] = time.Now().Add(-time.Second).UnixNano() / int64(time.Millisecond)
+	pub.PublishWithContext(msg.InheritContext(), letter)
+
+	notification = <-pub.Notifications()
+	assert.False(t, notification.Success)
+	assert.True(t, errors.Is(notification.Error, context.DeadlineExceeded))
+
+	pub.Shutdown(false)
+}
+
+func TestPublishFallback(t *testing.T) {
+
+	pub, err := publisher.NewPublisher(Seasoning, ChannelPool, nil)
+	assert.NoError(t, err)
+
+	// the missing exchange closes the confirm channel, so the letter falls back to the queue
+	pub.SetExchangeDefaults("MissingExchange", &models.ExchangeDefaults{RequireConfirm: true})
+	pub.SetFallbacks("MissingExchange", []*models.PublishFallback{{Exchange: "", RoutingKey: "ConsumerTestQueue"}})
+
+	letter := utils.CreateMockRandomLetter("ConsumerTestQueue")
+	letter.Envelope.Exchange = "MissingExchange"
+	pub.Publish(letter)
+
+	notification := <-pub.Notifications()
+	assert.False(t, notification.Success)
+
+	notification = <-pub.Notifications()
+	assert.True(t, notification.Success)
+
+	event := <-pub.Events()
+	assert.Equal(t, models.FallbackUsed, event.Type)
+
+	pub.SetFallbacks("MissingExchange", nil)
+	assert.Nil(t, pub.Fallbacks("MissingExchange"))
+
+	pub.Shutdown(false)
+}
+
+func TestScheduler(t *testing.T) {
+
+	schedule, err := models.ParseSchedule("0 9-17 * * 1-5")
+	assert.NoError(t, err)
+
+	saturday := time.Date(2026, 10, 17, 10, 7, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC), schedule.Next(saturday))
+
+	_, err = models.ParseSchedule("61 * * * *")
+	assert.Error(t, err)
+
+	pub, err := publisher.NewPublisher(Seasoning, ChannelPool, nil)
+	assert.NoError(t, err)
+
+	scheduler := publisher.NewScheduler(pub)
+	err = scheduler.AddFromConfig(&models.ScheduledPublish{
+		Name:       "Heartbeat",
+		Schedule:   "@every 1s",
+		Jitter:     100,
+		RoutingKey: "ConsumerTestQueue",
+		Body:       `{"alive":true}`,
+	})
+	assert.NoError(t, err)
+
+	scheduler.Start()
+	late := func() *models.Letter { return utils.CreateMockRandomLetter("ConsumerTestQueue") }
+	assert.Error(t, scheduler.Add("Late", "@hourly", 0, late)) // can't add to a started scheduler
+
+	notification := <-pub.Notifications()
+	assert.True(t, notification.Success)
+
+	scheduler.Stop()
+	assert.True(t, scheduler.Runs()["Heartbeat"].Published >= 1)
+
+	pub.Shutdown(false)
+}
+
+func TestNewIPublisher(t *testing.T) {
+
+	pub, err := publisher.New(Seasoning, ChannelPool, nil)
+	assert.NoError(t, err)
+
+	var results consumer.ResultPublisher = pub // publishes a result handler's responses
+	assert.NotNil(t, results)
+
+	assert.False(t, pub.AutoPublishStarted())
+	pub.Shutdown(false)
+}
+
+func TestPublishWithTTL(t *testing.T) {
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	publisher, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	letter := utils.CreateMockRandomLetter("ConsumerTestQueue")
+	assert.Error(t, publisher.PublishWithTTL(letter, time.Microsecond))
+	assert.NoError(t, publisher.PublishWithTTL(letter, time.Minute))
+
+	notification := <-publisher.Notifications()
+	assert.True(t, notification.Success)
+	assert.Equal(t, "", letter.Envelope.Expiration) // the letter is left untouched
+
+	publisher.Shutdown(true)
+}
+
+func TestPublishWithBudget(t *testing.T) {
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	pub, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	// hold every channel so none can be acquired within the budget
+	held := make([]*pools.ChannelHost, 0)
+	for channelPool.ChannelCount() > 0 {
+		chanHost, err := channelPool.GetChannel()
+		assert.NoError(t, err)
+		held = append(held, chanHost)
+	}
+
+	letter := utils.CreateMockRandomLetter("ConsumerTestQueue")
+	budget := publisher.LatencyBudget{Budget: time.Duration(50) * time.Millisecond}
+
+	start := time.Now()
+	assert.Equal(t, publisher.ErrBudgetExceeded, pub.PublishWithBudget(letter, budget))
+	assert.True(t, time.Since(start) < time.Second)
+
+	var spooled *models.Letter
+	pub.SetFallbackSink(func(letter *models.Letter) error {
+		spooled = letter
+		return nil
+	})
+
+	budget.Action = publisher.BudgetFallback
+	assert.NoError(t, pub.PublishWithBudget(letter, budget))
+	assert.Equal(t, letter, spooled)
+
+	for _, chanHost := range held {
+		channelPool.ReturnChannel(chanHost, false)
+	}
+
+	budget.Budget = time.Duration(5) * time.Second
+	assert.NoError(t, pub.PublishWithBudget(letter, budget))
+
+	pub.Shutdown(true)
+}
+
+func TestPublishWithBudgetConfirmChannel(t *testing.T) {
+	testBudgetWaitingOnChannel(t, func(pub *publisher.Publisher) {
+		pub.SetExchangeDefaults("", &models.ExchangeDefaults{RequireConfirm: true})
+	})
+}
+
+func TestPublishWithBudgetShardChannel(t *testing.T) {
+	testBudgetWaitingOnChannel(t, func(pub *publisher.Publisher) {
+		assert.NoError(t, pub.EnableChannelAffinity(1))
+	})
+}
+
+// testBudgetWaitingOnChannel checks the budget's Action applies when the publisher, set up by configure, can't get
+// its channel in time.
+func testBudgetWaitingOnChannel(t *testing.T, configure func(pub *publisher.Publisher)) {
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	channelPool.FlushErrors()
+
+	pub, err := publisher.NewPublisher(Seasoning, channelPool, nil)
+	assert.NoError(t, err)
+
+	configure(pub)
+
+	// hold every channel so the publisher's own can't be acquired within the budget
+	held := make([]*pools.ChannelHost, 0)
+	for channelPool.ChannelCount() > 0 {
+		chanHost, err := channelPool.GetChannel()
+		assert.NoError(t, err)
+		held = append(held, chanHost)
+	}
+
+	var spooled *models.Letter
+	pub.SetFallbackSink(func(letter *models.Letter) error {
+		spooled = letter
+		return nil
+	})
+
+	letter := utils.CreateMockRandomLetter("ConsumerTestQueue")
+	budget := publisher.LatencyBudget{Budget: time.Duration(50) * time.Millisecond, Action: publisher.BudgetFallback}
+
+	start := time.Now()
+	assert.NoError(t, pub.PublishWithBudget(letter, budget))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, letter, spooled)
+
+	budget.Action = publisher.BudgetFailFast
+	assert.Equal(t, publisher.ErrBudgetExceeded, pub.PublishWithBudget(letter, budget))
+
+	for _, chanHost := range held {
+		channelPool.ReturnChannel(chanHost, false)
+	}
+
+	budget.Budget = time.Duration(5) * time.Second
+	assert.NoError(t, pub.PublishWithBudget(letter, budget))
+
+	pub.Shutdown(true)
+}
+
+func TestSpool(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tcrspool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spool, err := publisher.NewSpool(dir, 0, 4096)
+	assert.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		letter := utils.CreateMockRandomLetter("SpoolTestQueue")
+		letter.LetterID = uint64(i)
+		assert.NoError(t, spool.Append(letter))
+	}
+
+	assert.Equal(t, int64(50), spool.Len())
+	assert.True(t, spool.Stats().Segments > 1)
+
+	for i := 0; i < 20; i++ {
+		letter, err := spool.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(i), letter.LetterID)
+	}
+
+	assert.NoError(t, spool.Close())
+
+	// tear the last record, like a crash mid write
+	segments, err := filepath.Glob(filepath.Join(dir, "spool-*.seg"))
+	assert.NoError(t, err)
+	last := segments[len(segments)-1]
+	info, err := os.Stat(last)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(last, info.Size()-3))
+
+	spool, err = publisher.NewSpool(dir, 0, 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(29), spool.Len())
+
+	for i := 20; i < 49; i++ {
+		letter, err := spool.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(i), letter.LetterID)
+	}
+
+	letter, err := spool.Next()
+	assert.NoError(t, err)
+	assert.Nil(t, letter)
+
+	assert.NoError(t, spool.Close())
+}
+
+func TestSpoolRefillsOnceDrained(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tcrspool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the limit is under a segment, so everything lives in the one segment being written
+	spool, err := publisher.NewSpool(dir, 4096, 16384)
+	assert.NoError(t, err)
+
+	fill := func() int {
+		count := 0
+		for {
+			letter := utils.CreateMockRandomLetter("SpoolTestQueue")
+			letter.LetterID = uint64(count)
+			letter.Body = make([]byte, 256) // the same size every fill
+			if err := spool.Append(letter); err != nil {
+				assert.Equal(t, publisher.ErrSpoolFull, err)
+				return count
+			}
+			count++
+		}
+	}
+
+	drain := func(count int) {
+		for i := 0; i < count; i++ {
+			letter, err := spool.Next()
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(i), letter.LetterID)
+		}
+
+		letter, err := spool.Next()
+		assert.NoError(t, err)
+		assert.Nil(t, letter)
+	}
+
+	filled := fill()
+	assert.True(t, filled > 0)
+
+	drain(filled)
+	assert.Equal(t, int64(0), spool.Stats().Bytes)
+	assert.Equal(t, 1, spool.Stats().Segments)
+
+	assert.Equal(t, filled, fill())
+
+	// the cursor was rewound with the segment, a reopened spool replays the refill
+	assert.NoError(t, spool.Close())
+	spool, err = publisher.NewSpool(dir, 4096, 16384)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(filled), spool.Len())
+
+	drain(filled)
+	assert.NoError(t, spool.Close())
+}
+
+func TestPublisherSpool(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tcrspool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	publisherConfig := *Seasoning.PublisherConfig
+	publisherConfig.LetterBuffer = 10
+	publisherConfig.SpoolDir = dir
+
+	seasoning := *Seasoning
+	seasoning.PublisherConfig = &publisherConfig
+
+	pub, err := publisher.NewPublisher(&seasoning, ChannelPool, nil)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ { // doesn't block on the full buffer
+		pub.QueueLetter(utils.CreateMockRandomLetter("ConsumerTestQueue"))
+	}
+
+	assert.Equal(t, int64(90), pub.SpoolStats().Letters)
+
+	pub.StartAutoPublish(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10)*time.Second)
+	defer cancel()
+
+	assert.NoError(t, pub.Flush(ctx))
+	assert.Equal(t, int64(0), pub.SpoolStats().Letters)
+
+	pub.Shutdown(false)
+}
+
 func TestAutoPublishSingleMessage(t *testing.T) {
 
 	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
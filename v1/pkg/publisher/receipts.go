@@ -0,0 +1,64 @@
+package publisher
+
+import (
+	"sync"
+	"time"
+)
+
+// ReceiptCache remembers which MessageIDs were successfully published within a window, so a letter
+// re-published (i.e. retried by the caller after a timeout) doesn't produce a second success receipt.
+type receiptCache struct {
+	window       time.Duration
+	seen         map[string]time.Time
+	order        []receipt // oldest first, for pruning
+	receiptsLock *sync.Mutex
+}
+
+type receipt struct {
+	messageID string
+	time      time.Time
+}
+
+func newReceiptCache(window time.Duration) *receiptCache {
+	return &receiptCache{
+		window:       window,
+		seen:         make(map[string]time.Time),
+		receiptsLock: &sync.Mutex{},
+	}
+}
+
+// FirstSuccess records the success and returns false if the MessageID already succeeded within the window.
+// Letters without a MessageID are never deduplicated.
+func (rc *receiptCache) firstSuccess(messageID string) bool {
+	if messageID == "" {
+		return true
+	}
+
+	rc.receiptsLock.Lock()
+	defer rc.receiptsLock.Unlock()
+
+	now := time.Now()
+	rc.prune(now)
+
+	if _, ok := rc.seen[messageID]; ok {
+		return false
+	}
+
+	rc.seen[messageID] = now
+	rc.order = append(rc.order, receipt{messageID: messageID, time: now})
+
+	return true
+}
+
+// Prune forgets receipts older than the window, must be called while holding the lock.
+func (rc *receiptCache) prune(now time.Time) {
+	expired := 0
+	for expired < len(rc.order) && now.Sub(rc.order[expired].time) >= rc.window {
+		delete(rc.seen, rc.order[expired].messageID)
+		expired++
+	}
+
+	if expired > 0 {
+		rc.order = append(rc.order[:0], rc.order[expired:]...)
+	}
+}
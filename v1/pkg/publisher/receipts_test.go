@@ -0,0 +1,51 @@
+package publisher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiptCacheSuppressesRepeats(t *testing.T) {
+
+	receipts := newReceiptCache(time.Minute)
+
+	assert.True(t, receipts.firstSuccess("a"))
+	assert.True(t, receipts.firstSuccess("b"))
+	assert.False(t, receipts.firstSuccess("a"))
+	assert.False(t, receipts.firstSuccess("b"))
+
+	// letters without a MessageID are never deduplicated
+	assert.True(t, receipts.firstSuccess(""))
+	assert.True(t, receipts.firstSuccess(""))
+	assert.Len(t, receipts.order, 2)
+}
+
+func TestReceiptCachePrunesWindow(t *testing.T) {
+
+	window := time.Second
+	receipts := newReceiptCache(window)
+
+	assert.True(t, receipts.firstSuccess("a"))
+	assert.True(t, receipts.firstSuccess("b"))
+
+	now := time.Now()
+	receipts.seen["a"] = now.Add(-2 * window)
+	receipts.order[0].time = now.Add(-2 * window)
+
+	// only the receipt older than the window is forgotten
+	receipts.prune(now)
+	assert.Len(t, receipts.order, 1)
+	assert.Equal(t, "b", receipts.order[0].messageID)
+	assert.NotContains(t, receipts.seen, "a")
+
+	assert.True(t, receipts.firstSuccess("a"))
+	assert.False(t, receipts.firstSuccess("b"))
+
+	// every receipt expires once the window has passed
+	receipts.prune(now.Add(2 * window))
+	assert.Empty(t, receipts.order)
+	assert.Empty(t, receipts.seen)
+	assert.True(t, receipts.firstSuccess("b"))
+}
@@ -0,0 +1,226 @@
+package publisher
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+const defaultScheduleRetryInterval = time.Duration(1) * time.Second
+
+// ScheduledRun is how a scheduled publish has gone so far.
+type ScheduledRun struct {
+	Name      string
+	Published uint64
+	Failed    uint64 // attempts that failed and were retried
+	Last      time.Time
+	Next      time.Time
+}
+
+type scheduledJob struct {
+	name      string
+	schedule  models.Schedule
+	jitter    time.Duration
+	newLetter func() *models.Letter
+	published uint64
+	failed    uint64
+	last      atomic.Value // time.Time
+	next      atomic.Value // time.Time
+}
+
+// Scheduler publishes letters on schedules (cron expressions or intervals, see models.ParseSchedule) through a
+// Publisher, i.e. heartbeats, cache invalidation ticks, or batch kickoffs, without a cron container. Nothing is
+// persisted: every run is published at least once while the process is up, retried until it's published (with
+// the Publisher's retries and confirms) or the Scheduler stops, runs missed meanwhile are skipped. Every run is
+// delayed by a random jitter, so replicas running the same schedule spread out. Outcomes are reported on the
+// Publisher's Notifications and Failures like any other letter.
+type Scheduler struct {
+	publisher     *Publisher
+	jobs          map[string]*scheduledJob
+	retryInterval time.Duration
+	started       bool
+	stop          chan struct{}
+	running       *sync.WaitGroup
+	schedLock     *sync.Mutex
+}
+
+// NewScheduler creates a Scheduler publishing through the Publisher.
+func NewScheduler(pub *Publisher) *Scheduler {
+	return &Scheduler{
+		publisher:     pub,
+		jobs:          make(map[string]*scheduledJob),
+		retryInterval: defaultScheduleRetryInterval,
+		running:       &sync.WaitGroup{},
+		schedLock:     &sync.Mutex{},
+	}
+}
+
+// NewSchedulerFromConfig creates a Scheduler with the PublisherConfig's Schedules added.
+func NewSchedulerFromConfig(pub *Publisher, schedules []*models.ScheduledPublish) (*Scheduler, error) {
+	scheduler := NewScheduler(pub)
+	for _, scheduled := range schedules {
+		if err := scheduler.AddFromConfig(scheduled); err != nil {
+			return nil, err
+		}
+	}
+
+	return scheduler, nil
+}
+
+// Add schedules a publish, newLetter builds the letter for every run (and every retry of a failed run).
+// Must be called before Start.
+func (sch *Scheduler) Add(name, spec string, jitter time.Duration, newLetter func() *models.Letter) error {
+	if name == "" || newLetter == nil {
+		return errors.New("can't schedule a publish without a name and letter")
+	}
+
+	schedule, err := models.ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	sch.schedLock.Lock()
+	defer sch.schedLock.Unlock()
+
+	if sch.started {
+		return errors.New("can't schedule a publish on a started scheduler")
+	}
+
+	if _, ok := sch.jobs[name]; ok {
+		return fmt.Errorf("can't schedule publish %s twice", name)
+	}
+
+	sch.jobs[name] = &scheduledJob{name: name, schedule: schedule, jitter: jitter, newLetter: newLetter}
+
+	return nil
+}
+
+// AddFromConfig schedules the configured publish, its body is sent as is.
+func (sch *Scheduler) AddFromConfig(scheduled *models.ScheduledPublish) error {
+	return sch.Add(
+		scheduled.Name,
+		scheduled.Schedule,
+		time.Duration(scheduled.Jitter)*time.Millisecond,
+		func() *models.Letter {
+			return &models.Letter{
+				RetryCount: scheduled.RetryCount,
+				Body:       []byte(scheduled.Body),
+				Envelope: &models.Envelope{
+					Exchange:     scheduled.Exchange,
+					RoutingKey:   scheduled.RoutingKey,
+					ContentType:  scheduled.ContentType,
+					Headers:      scheduled.Headers,
+					DeliveryMode: scheduled.DeliveryMode,
+				},
+			}
+		})
+}
+
+// Start runs every scheduled publish until Stop.
+func (sch *Scheduler) Start() {
+	sch.schedLock.Lock()
+	defer sch.schedLock.Unlock()
+
+	if sch.started {
+		return
+	}
+
+	sch.started = true
+	sch.stop = make(chan struct{})
+
+	for _, job := range sch.jobs {
+		job := job
+		sch.running.Add(1)
+		models.Go("publisher.scheduler", func() {
+			defer sch.running.Done()
+			sch.run(job, sch.stop)
+		})
+	}
+}
+
+// Stop stops the scheduled publishes, waiting for a publish in progress to finish. A run being retried is dropped.
+func (sch *Scheduler) Stop() {
+	sch.schedLock.Lock()
+	if !sch.started {
+		sch.schedLock.Unlock()
+		return
+	}
+
+	sch.started = false
+	close(sch.stop)
+	sch.schedLock.Unlock()
+
+	sch.running.Wait()
+}
+
+// Runs returns how every scheduled publish has gone so far, keyed by name.
+func (sch *Scheduler) Runs() map[string]ScheduledRun {
+	sch.schedLock.Lock()
+	defer sch.schedLock.Unlock()
+
+	runs := make(map[string]ScheduledRun, len(sch.jobs))
+	for name, job := range sch.jobs {
+		run := ScheduledRun{
+			Name:      name,
+			Published: atomic.LoadUint64(&job.published),
+			Failed:    atomic.LoadUint64(&job.failed),
+		}
+		run.Last, _ = job.last.Load().(time.Time)
+		run.Next, _ = job.next.Load().(time.Time)
+
+		runs[name] = run
+	}
+
+	return runs
+}
+
+// Run waits for each of the job's runs and publishes it, until the stop channel closes.
+func (sch *Scheduler) run(job *scheduledJob, stop <-chan struct{}) {
+	for {
+		next := job.schedule.Next(time.Now())
+		if next.IsZero() {
+			return // the schedule never runs again
+		}
+
+		if job.jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(job.jitter))))
+		}
+		job.next.Store(next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !sch.publish(job, stop) {
+			return
+		}
+	}
+}
+
+// Publish publishes one run, retrying until it's published, returns false when stopped first.
+func (sch *Scheduler) publish(job *scheduledJob, stop <-chan struct{}) bool {
+	for {
+		if err := sch.publisher.publishWithRetry(job.newLetter()); err == nil {
+			atomic.AddUint64(&job.published, 1)
+			job.last.Store(time.Now())
+			return true
+		}
+
+		atomic.AddUint64(&job.failed, 1)
+
+		select {
+		case <-stop:
+			return false
+		case <-time.After(sch.retryInterval):
+		}
+	}
+}
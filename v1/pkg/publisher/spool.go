@@ -0,0 +1,568 @@
+package publisher
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+const (
+	spoolSegmentPrefix      = "spool-"
+	spoolSegmentSuffix      = ".seg"
+	spoolCursorFile         = "cursor"
+	spoolRecordHeader       = 8 // payload length and crc32, both uint32
+	defaultSpoolSegmentSize = int64(16 * 1024 * 1024)
+)
+
+// ErrSpoolFull is returned when appending would take the spool over its size limit.
+var ErrSpoolFull = errors.New("spool is full")
+
+// SpoolStats is a snapshot of a Spool.
+type SpoolStats struct {
+	Letters   int64 // spooled and not replayed yet
+	Bytes     int64 // on disk, including replayed records of segments still being read
+	Segments  int
+	Corrupted uint64 // records skipped for a bad length or checksum
+}
+
+// Spool is a disk-backed FIFO of letters, it keeps the Publisher's memory bounded through broker outages.
+// Letters are appended as records (length, crc32, JSON letter) to segment files in the directory, and read back in
+// order, a segment is deleted once fully read (the one being written is emptied instead). The read position is
+// kept in a cursor file, so a reopened Spool carries on where it left off. A record torn by a crash, or otherwise
+// corrupted, fails its checksum and is skipped (along with the rest of its segment) instead of being replayed as
+// garbage. Headers round trip through JSON, so numeric header values come back as float64.
+type Spool struct {
+	dir         string
+	maxBytes    int64
+	segmentSize int64
+	segments    []uint64 // oldest first, the last one is written to
+	writer      *os.File
+	writeSize   int64
+	reader      *bufio.Reader
+	readFile    *os.File
+	readOffset  int64
+	letters     int64
+	bytes       int64
+	corrupted   uint64
+	spoolLock   *sync.Mutex
+}
+
+// NewSpool opens (or creates) a Spool in the directory. MaxBytes bounds the disk used, 0 is unlimited, and
+// segmentSize is the size segment files roll over at, 0 is 16 MiB.
+func NewSpool(dir string, maxBytes int64, segmentSize int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	if segmentSize <= 0 {
+		segmentSize = defaultSpoolSegmentSize
+	}
+
+	sp := &Spool{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		segmentSize: segmentSize,
+		spoolLock:   &sync.Mutex{},
+	}
+
+	if err := sp.open(); err != nil {
+		sp.Close()
+		return nil, err
+	}
+
+	return sp, nil
+}
+
+// Open finds the segments, restores the cursor, counts what's left to replay, and cuts a torn record off the end.
+func (sp *Spool) open() error {
+	entries, err := ioutil.ReadDir(sp.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, spoolSegmentPrefix) || !strings.HasSuffix(name, spoolSegmentSuffix) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, spoolSegmentPrefix), spoolSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		sp.segments = append(sp.segments, id)
+	}
+
+	sort.Slice(sp.segments, func(i, j int) bool { return sp.segments[i] < sp.segments[j] })
+
+	cursorID, cursorOffset := sp.readCursor()
+	for len(sp.segments) > 1 && sp.segments[0] < cursorID {
+		_ = os.Remove(sp.segmentPath(sp.segments[0])) // fully read before the Spool was closed
+		sp.segments = sp.segments[1:]
+	}
+
+	if len(sp.segments) == 0 || sp.segments[0] != cursorID {
+		cursorOffset = 0
+	}
+
+	for i, id := range sp.segments {
+		offset := int64(0)
+		if i == 0 {
+			offset = cursorOffset
+		}
+
+		letters, valid, size, err := scanSegment(sp.segmentPath(id), offset)
+		if err != nil {
+			return err
+		}
+
+		if i == len(sp.segments)-1 && valid < size { // the tail a crash tore off, new records go after the valid ones
+			if err = os.Truncate(sp.segmentPath(id), valid); err != nil {
+				return err
+			}
+
+			size = valid
+		}
+
+		sp.letters += letters
+		sp.bytes += size
+	}
+
+	if len(sp.segments) == 0 {
+		sp.segments = []uint64{1}
+	}
+
+	last := sp.segments[len(sp.segments)-1]
+	if sp.writer, err = os.OpenFile(sp.segmentPath(last), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return err
+	}
+
+	info, err := sp.writer.Stat()
+	if err != nil {
+		return err
+	}
+	sp.writeSize = info.Size()
+
+	return sp.openReader(sp.segments[0], cursorOffset)
+}
+
+// ScanSegment counts the valid records from the offset, returning where the valid records end and the file size.
+func scanSegment(path string, offset int64) (letters int64, valid int64, size int64, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, 0, err
+	}
+
+	reader := bufio.NewReader(file)
+	valid = offset
+	for {
+		payload, err := readRecord(reader)
+		if err != nil {
+			return letters, valid, info.Size(), nil
+		}
+
+		letters++
+		valid += int64(spoolRecordHeader + len(payload))
+	}
+}
+
+// ReadRecord reads one record, io.EOF at a clean end and io.ErrUnexpectedEOF or a checksum error otherwise.
+func readRecord(reader *bufio.Reader) ([]byte, error) {
+	header := make([]byte, spoolRecordHeader)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if int64(length) > 4*defaultSpoolSegmentSize { // a corrupt length, don't try to allocate it
+		return nil, errors.New("spool record length is corrupt")
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errors.New("spool record checksum doesn't match")
+	}
+
+	return payload, nil
+}
+
+// Append writes the letter to the end of the spool, ErrSpoolFull when it doesn't fit under the size limit.
+func (sp *Spool) Append(letter *models.Letter) error {
+	payload, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, spoolRecordHeader+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[spoolRecordHeader:], payload)
+
+	sp.spoolLock.Lock()
+	defer sp.spoolLock.Unlock()
+
+	if sp.writer == nil {
+		return errors.New("can't append to a closed spool")
+	}
+
+	if sp.maxBytes > 0 && sp.bytes+int64(len(record)) > sp.maxBytes {
+		return ErrSpoolFull
+	}
+
+	if sp.writeSize > 0 && sp.writeSize+int64(len(record)) > sp.segmentSize {
+		if err = sp.rollSegment(); err != nil {
+			return err
+		}
+	}
+
+	if _, err = sp.writer.Write(record); err != nil {
+		_ = sp.writer.Truncate(sp.writeSize) // i.e. the disk filled up, don't leave half a record behind
+		return err
+	}
+
+	sp.writeSize += int64(len(record))
+	sp.bytes += int64(len(record))
+	sp.letters++
+
+	return nil
+}
+
+// RollSegment syncs and closes the segment being written and starts the next one.
+func (sp *Spool) rollSegment() error {
+	if err := sp.writer.Sync(); err != nil {
+		return err
+	}
+
+	if err := sp.writer.Close(); err != nil {
+		return err
+	}
+
+	next := sp.segments[len(sp.segments)-1] + 1
+
+	writer, err := os.OpenFile(sp.segmentPath(next), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		sp.writer = nil
+		return err
+	}
+
+	sp.writer = writer
+	sp.writeSize = 0
+	sp.segments = append(sp.segments, next)
+
+	return nil
+}
+
+// Next takes the oldest letter off the spool, nil when the spool is empty.
+func (sp *Spool) Next() (*models.Letter, error) {
+	sp.spoolLock.Lock()
+	defer sp.spoolLock.Unlock()
+
+	for sp.letters > 0 {
+		if sp.reader == nil {
+			return nil, errors.New("can't read from a closed spool")
+		}
+
+		payload, err := readRecord(sp.reader)
+		if err == nil {
+			sp.readOffset += int64(spoolRecordHeader + len(payload))
+			sp.letters--
+
+			letter := &models.Letter{}
+			if err = json.Unmarshal(payload, letter); err != nil {
+				sp.corrupted++
+				continue
+			}
+
+			if sp.caughtUp() {
+				return letter, sp.rewind()
+			}
+
+			return letter, sp.writeCursor()
+		}
+
+		if err == io.EOF && len(sp.segments) == 1 { // caught up with the writer
+			sp.letters = 0
+			if sp.caughtUp() {
+				return nil, sp.rewind()
+			}
+
+			return nil, nil
+		}
+
+		if err != io.EOF {
+			sp.corrupted++ // the rest of the segment can't be trusted
+
+			if len(sp.segments) == 1 { // write after the corruption in a new segment
+				if err = sp.rollSegment(); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if err = sp.nextSegment(); err != nil {
+			return nil, err
+		}
+	}
+
+	if sp.reader != nil && sp.caughtUp() { // the last records didn't decode
+		return nil, sp.rewind()
+	}
+
+	return nil, nil
+}
+
+// CaughtUp returns true when every record of the segment being written has been read.
+func (sp *Spool) caughtUp() bool {
+	return sp.writer != nil && len(sp.segments) == 1 && sp.readOffset == sp.writeSize && sp.writeSize > 0
+}
+
+// Rewind empties the segment being written once it's been read to the end, only a rolled over segment is ever
+// deleted, so without this a drained spool would keep counting (and refusing appends over) the bytes it replayed.
+// The cursor moves back first: a crash before the truncate replays the segment again instead of losing letters.
+func (sp *Spool) rewind() error {
+	offset := sp.readOffset
+	sp.readOffset = 0
+	if err := sp.writeCursor(); err != nil {
+		sp.readOffset = offset
+		return err
+	}
+
+	if err := sp.writer.Truncate(0); err != nil {
+		sp.readOffset = offset
+		_ = sp.writeCursor() // back where reading stopped, the truncate's error is the one to report
+		return err
+	}
+
+	sp.bytes -= sp.writeSize
+	sp.writeSize = 0
+
+	_ = sp.readFile.Close()
+	return sp.openReader(sp.segments[0], 0)
+}
+
+// NextSegment deletes the segment that was read and starts reading the one after it.
+func (sp *Spool) nextSegment() error {
+	_ = sp.readFile.Close()
+
+	done := sp.segments[0]
+	if info, err := os.Stat(sp.segmentPath(done)); err == nil {
+		sp.bytes -= info.Size()
+	}
+
+	if err := os.Remove(sp.segmentPath(done)); err != nil {
+		return err
+	}
+
+	sp.segments = sp.segments[1:]
+
+	// the letters left in a corrupt tail are gone with it, recount what's left
+	letters := int64(0)
+	for _, id := range sp.segments {
+		count, _, _, err := scanSegment(sp.segmentPath(id), 0)
+		if err != nil {
+			return err
+		}
+		letters += count
+	}
+	sp.letters = letters
+
+	if err := sp.openReader(sp.segments[0], 0); err != nil {
+		return err
+	}
+
+	return sp.writeCursor()
+}
+
+func (sp *Spool) openReader(id uint64, offset int64) error {
+	file, err := os.Open(sp.segmentPath(id))
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	sp.readFile = file
+	sp.reader = bufio.NewReader(file)
+	sp.readOffset = offset
+
+	return nil
+}
+
+// ReadCursor returns the segment and offset reading stopped at, zeros without a cursor.
+func (sp *Spool) readCursor() (uint64, int64) {
+	data, err := ioutil.ReadFile(filepath.Join(sp.dir, spoolCursorFile))
+	if err != nil || len(data) != 16 {
+		return 0, 0
+	}
+
+	return binary.BigEndian.Uint64(data[:8]), int64(binary.BigEndian.Uint64(data[8:]))
+}
+
+// WriteCursor replaces the cursor file in one rename, so it's never half written.
+func (sp *Spool) writeCursor() error {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data[:8], sp.segments[0])
+	binary.BigEndian.PutUint64(data[8:], uint64(sp.readOffset))
+
+	path := filepath.Join(sp.dir, spoolCursorFile)
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func (sp *Spool) segmentPath(id uint64) string {
+	return filepath.Join(sp.dir, fmt.Sprintf("%s%020d%s", spoolSegmentPrefix, id, spoolSegmentSuffix))
+}
+
+// Len returns how many letters are waiting to be replayed.
+func (sp *Spool) Len() int64 {
+	sp.spoolLock.Lock()
+	defer sp.spoolLock.Unlock()
+
+	return sp.letters
+}
+
+// Stats returns a snapshot of the Spool.
+func (sp *Spool) Stats() SpoolStats {
+	sp.spoolLock.Lock()
+	defer sp.spoolLock.Unlock()
+
+	return SpoolStats{
+		Letters:   sp.letters,
+		Bytes:     sp.bytes,
+		Segments:  len(sp.segments),
+		Corrupted: sp.corrupted,
+	}
+}
+
+// Close syncs and closes the segment files, what's left is replayed when the directory is opened again.
+func (sp *Spool) Close() error {
+	sp.spoolLock.Lock()
+	defer sp.spoolLock.Unlock()
+
+	var err error
+	if sp.writer != nil {
+		err = sp.writer.Sync()
+		if closeErr := sp.writer.Close(); err == nil {
+			err = closeErr
+		}
+		sp.writer = nil
+	}
+
+	if sp.readFile != nil {
+		_ = sp.readFile.Close()
+		sp.readFile = nil
+		sp.reader = nil
+	}
+
+	return err
+}
+
+// SpoolLetter queues the letter through the spool when the letter buffer is full, or when letters are already
+// spooled so they keep their order. A full spool is waited on. Returns false to queue the letter in memory as
+// usual: without a spool, or when the disk write failed.
+func (pub *Publisher) spoolLetter(letter *models.Letter) bool {
+	if pub.spool == nil {
+		return false
+	}
+
+	for {
+		pub.spoolLock.Lock()
+		if pub.spool.Len() == 0 && atomic.LoadUint64(&pub.letterCount) < pub.letterBuffer {
+			pub.queueLetter(letter) // room in the buffer, so this doesn't block
+			pub.spoolLock.Unlock()
+			return true
+		}
+
+		err := pub.spool.Append(letter)
+		models.GetMetrics().Gauge(models.MetricSpooledLetters, float64(pub.spool.Len()), nil)
+		pub.spoolLock.Unlock()
+
+		switch err {
+		case nil:
+			return true
+		case ErrSpoolFull:
+			time.Sleep(pub.sleepOnQueueFullInterval) // AutoPublish replays to make room
+		default:
+			return false
+		}
+	}
+}
+
+// ReplaySpool moves spooled letters, oldest first, into the letter buffer while it has room. AutoPublish calls
+// it, so the spool only drains as fast as the broker takes the publishes.
+func (pub *Publisher) replaySpool() {
+	if pub.spool == nil {
+		return
+	}
+
+	pub.spoolLock.Lock()
+	defer pub.spoolLock.Unlock()
+
+	for atomic.LoadUint64(&pub.letterCount) < pub.letterBuffer {
+		letter, err := pub.spool.Next()
+		if err != nil || letter == nil {
+			return
+		}
+
+		pub.queueLetter(letter)
+		models.GetMetrics().Gauge(models.MetricSpooledLetters, float64(pub.spool.Len()), nil)
+	}
+}
+
+// SpoolStats returns a snapshot of the publisher's spool, zeros without one.
+func (pub *Publisher) SpoolStats() SpoolStats {
+	if pub.spool == nil {
+		return SpoolStats{}
+	}
+
+	return pub.spool.Stats()
+}
+
+// Spooled returns how many letters are waiting in the spool.
+func (pub *Publisher) spooled() int64 {
+	if pub.spool == nil {
+		return 0
+	}
+
+	return pub.spool.Len()
+}
@@ -0,0 +1,138 @@
+package publisher
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/topology"
+)
+
+// DeadLetterCheck looks up, once per destination, whether the queues a TTL letter lands in have a dead letter
+// exchange. Without one an expired message is silently dropped.
+type deadLetterCheck struct {
+	management *topology.ManagementClient
+	checked    map[string]bool // keyed by exchange and routing key
+	checkLock  *sync.Mutex
+}
+
+func newDeadLetterCheck(config *models.ManagementConfig) (*deadLetterCheck, error) {
+
+	management, err := topology.NewManagementClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deadLetterCheck{
+		management: management,
+		checked:    make(map[string]bool),
+		checkLock:  &sync.Mutex{},
+	}, nil
+}
+
+// PublishWithTTL publishes the letter (with retry) with a per-message expiration, the letter itself is left untouched.
+// Returns the last error when every attempt failed. With VerifyTTLDeadLetter set, the queues the letter is routed
+// to are checked (once per exchange and routing key) for a dead letter exchange through the management API, raising
+// a DeadLetterMissing event on Events() when one is missing. Only bindings with exactly the letter's routing key
+// (or #) are followed: other topic patterns aren't matched, and a fanout exchange's queues bound with any other key
+// (i.e. "") aren't checked.
+func (pub *Publisher) PublishWithTTL(letter *models.Letter, ttl time.Duration) error {
+
+	if ttl < time.Millisecond {
+		return errors.New("can't publish with a ttl under 1ms")
+	}
+
+	envelope := *letter.Envelope
+	envelope.Expiration = strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+
+	expiring := *letter
+	expiring.Envelope = &envelope
+
+	if pub.deadLetters != nil {
+		pub.deadLetters.verify(pub, envelope.Exchange, envelope.RoutingKey)
+	}
+
+	return pub.publishWithRetry(&expiring)
+}
+
+// Verify starts the check for the destination unless it has already been checked.
+func (dlc *deadLetterCheck) verify(pub *Publisher, exchange, routingKey string) {
+
+	key := exchange + "\x00" + routingKey
+
+	dlc.checkLock.Lock()
+	if dlc.checked[key] {
+		dlc.checkLock.Unlock()
+		return
+	}
+	dlc.checked[key] = true
+	dlc.checkLock.Unlock()
+
+	models.Go("publisher.deadletters", func() {
+		missing, err := dlc.queuesWithoutDeadLetter(exchange, routingKey)
+		if err != nil { // try again on the next publish
+			dlc.checkLock.Lock()
+			delete(dlc.checked, key)
+			dlc.checkLock.Unlock()
+			return
+		}
+
+		for _, queueName := range missing {
+			pub.emitEvent(models.NewEvent(
+				models.DeadLetterMissing,
+				0,
+				0,
+				fmt.Sprintf("queue %s has no dead letter exchange, expired messages from exchange %q (%s) are dropped", queueName, exchange, routingKey)))
+		}
+	})
+}
+
+func (dlc *deadLetterCheck) queuesWithoutDeadLetter(exchange, routingKey string) ([]string, error) {
+
+	queueNames := []string{routingKey} // the default exchange routes straight to the queue
+	if exchange != "" {
+		bindings, err := dlc.management.ListExchangeBindings("", exchange)
+		if err != nil {
+			return nil, err
+		}
+
+		queueNames = queueNames[:0]
+		for _, binding := range bindings {
+			if binding.DestinationType == "queue" && (binding.RoutingKey == routingKey || binding.RoutingKey == "#") {
+				queueNames = append(queueNames, binding.Destination)
+			}
+		}
+	}
+
+	missing := make([]string, 0)
+	for _, queueName := range queueNames {
+		queue, err := dlc.management.GetQueue("", queueName)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := queue.DeadLetterExchange(); !ok {
+			missing = append(missing, queueName)
+		}
+	}
+
+	return missing, nil
+}
+
+// EmitEvent sends the event without blocking, events are dropped when nobody is draining Events().
+func (pub *Publisher) emitEvent(event *models.Event) {
+	models.CountEvent(event)
+
+	select {
+	case pub.events <- event:
+	default:
+	}
+}
+
+// Events yields the Publisher's warnings (i.e. DeadLetterMissing), dropped when not drained.
+func (pub *Publisher) Events() <-chan *models.Event {
+	return pub.events
+}
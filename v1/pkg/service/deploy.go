@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/consumer"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+const defaultDeployTimeout = time.Duration(25) * time.Second
+
+// DeployReport is the outcome of a graceful deploy.
+type DeployReport struct {
+	ConsumersDrained int
+	Unsettled        int64 // deliveries still unsettled at the deadline, the broker requeues them as the channels close
+	Errors           []error
+	Duration         time.Duration
+}
+
+// GracefulDeploy takes the service down for a rollout, in order: every started consumer's consume is cancelled
+// and its in-flight deliveries drained and settled, then the Publisher flushes its queued letters and waits
+// for publisher confirms, and finally the monitors stop, the ChannelPool shuts down, and SafeToTerminate closes.
+// The context bounds the whole sequence, stages that run out of time are cut short and reported. Only the first
+// call runs the sequence, later calls wait for it and return the same report.
+func (rs *RabbitService) GracefulDeploy(ctx context.Context) *DeployReport {
+	rs.deployOnce.Do(func() {
+		rs.deployReport = rs.deploy(ctx)
+		close(rs.safeToTerminate)
+		rs.markDone()
+	})
+
+	return rs.deployReport
+}
+
+func (rs *RabbitService) deploy(ctx context.Context) *DeployReport {
+	start := time.Now()
+	report := &DeployReport{}
+
+	started := make([]*consumer.Consumer, 0, len(rs.consumers))
+	for _, con := range rs.consumers {
+		if con.State().Started {
+			started = append(started, con)
+		}
+	}
+
+	// 1. drain the consumers, then flush the publisher (see ShutdownOrdered)
+	report.Errors = rs.ShutdownOrdered(ctx).Errors
+
+	for _, con := range started {
+		report.ConsumersDrained++
+		report.Unsettled += con.Unsettled()
+	}
+
+	// 2. let go of everything
+	select {
+	case rs.stopServiceSignal <- true:
+	default:
+	}
+
+	rs.shutdownLocalPools()
+	shutdownTargets(rs.targets)
+	rs.ChannelPool.Shutdown()
+
+	report.Duration = time.Since(start)
+
+	return report
+}
+
+// SafeToTerminate is closed once a graceful deploy has finished, i.e. for a preStop hook or readiness endpoint to
+// tell the orchestrator the process can exit.
+func (rs *RabbitService) SafeToTerminate() <-chan struct{} {
+	return rs.safeToTerminate
+}
+
+// DeployOnSignal runs GracefulDeploy, bounded by the configured DeployTimeout, when the process receives one
+// of the signals (SIGTERM when none are given), a second signal cuts the deploy short. The report is handed
+// to onDone (when not nil) right after SafeToTerminate closes. The returned func stops listening for the signals.
+func (rs *RabbitService) DeployOnSignal(onDone func(*DeployReport), signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGTERM}
+	}
+
+	return rs.deployOnSignal(signals, rs.deployTimeout(), onDone)
+}
+
+// DeployOnSignal runs GracefulDeploy within the timeout on the first of the signals, cancelling it on a second.
+func (rs *RabbitService) deployOnSignal(signals []os.Signal, timeout time.Duration, onDone func(*DeployReport)) (stop func()) {
+
+	received := make(chan os.Signal, 2)
+	stopped := make(chan struct{})
+	signal.Notify(received, signals...)
+
+	once := &sync.Once{}
+	stop = func() {
+		once.Do(func() {
+			signal.Stop(received)
+			close(stopped)
+		})
+	}
+
+	models.Go("service.deploysignal", func() {
+		select {
+		case <-received:
+		case <-stopped:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		models.Go("service.deploysignal", func() {
+			select {
+			case <-received:
+				cancel() // asked twice, stop waiting on the stages
+			case <-stopped:
+			case <-rs.safeToTerminate:
+			}
+		})
+
+		report := rs.GracefulDeploy(ctx)
+		stop()
+
+		if onDone != nil {
+			onDone(report)
+		}
+	})
+
+	return stop
+}
+
+func (rs *RabbitService) deployTimeout() time.Duration {
+	if rs.Config.ServiceConfig.DeployTimeout == 0 {
+		return defaultDeployTimeout
+	}
+
+	return time.Duration(rs.Config.ServiceConfig.DeployTimeout) * time.Millisecond
+}
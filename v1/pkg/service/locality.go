@@ -0,0 +1,102 @@
+package services
+
+import (
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/publisher"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/topology"
+)
+
+// NewLocalPools creates the per node pools for QueueLocality, nil when it's off.
+func newLocalPools(config *models.RabbitSeasoning, fallback *pools.ChannelPool) (*topology.LocalPools, error) {
+
+	if config.ServiceConfig == nil || !config.ServiceConfig.QueueLocality {
+		return nil, nil
+	}
+
+	management, err := topology.NewManagementClient(config.ManagementConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	locator := topology.NewQueueLocator(
+		management,
+		config.ServiceConfig.NodeAddresses,
+		time.Duration(config.ServiceConfig.LocalityRefresh)*time.Millisecond)
+
+	return topology.NewLocalPools(locator, config.PoolConfig, fallback)
+}
+
+// ConsumerPool returns the ChannelPool a consumer of the queue uses: with QueueLocality the pool connected to the
+// node hosting the queue, the ChannelPool otherwise. A consumer stays on the pool it was created with, so it
+// follows a leader moving after a failover only once it's created again. Why a queue couldn't be located is
+// reported on CentralErr, and the consumer uses the ChannelPool.
+func (rs *RabbitService) consumerPool(queueName string) *pools.ChannelPool {
+	if rs.localPools == nil {
+		return rs.ChannelPool
+	}
+
+	pool, err := rs.localPools.ChannelPoolFor(queueName)
+	if err != nil {
+		rs.reportErrors([]error{err})
+	}
+
+	return pool
+}
+
+// PublisherFor returns a Publisher connected to the node hosting the queue, for letters routed to that queue
+// (i.e. through the default exchange), so they aren't forwarded across the cluster. Without QueueLocality, or
+// when the queue can't be located, it's the service's Publisher. Publishers are shared per node and don't spool,
+// letters overflowing a node's Publisher aren't spilled to the SpoolDir.
+func (rs *RabbitService) PublisherFor(queueName string) (*publisher.Publisher, error) {
+	if rs.localPools == nil {
+		return rs.Publisher, nil
+	}
+
+	pool, err := rs.localPools.ChannelPoolFor(queueName)
+	if err != nil {
+		rs.reportErrors([]error{err})
+	}
+
+	if pool == rs.ChannelPool {
+		return rs.Publisher, nil
+	}
+
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	if pub, ok := rs.localPublishers[pool]; ok {
+		return pub, nil
+	}
+
+	config := *rs.Config
+	publisherConfig := *rs.Config.PublisherConfig
+	publisherConfig.SpoolDir = "" // the spool belongs to the service's Publisher
+	config.PublisherConfig = &publisherConfig
+
+	pub, err := publisher.NewPublisher(&config, pool, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rs.localPublishers[pool] = pub
+	return pub, nil
+}
+
+// ShutdownLocalPools shuts the per node publishers and pools down.
+func (rs *RabbitService) shutdownLocalPools() {
+	if rs.localPools == nil {
+		return
+	}
+
+	rs.serviceLock.Lock()
+	for pool, pub := range rs.localPublishers {
+		pub.Shutdown(false)
+		delete(rs.localPublishers, pool)
+	}
+	rs.serviceLock.Unlock()
+
+	rs.localPools.Shutdown()
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/consumer"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// ConsumerStatus is how provisioning went for one consumer.
+type ConsumerStatus struct {
+	Name      string // the consumer's key in ConsumerConfigs
+	QueueName string
+	Handler   string
+	Declared  bool // the queue was declared from the config's Queue, otherwise it was only checked to exist
+	Started   bool
+	Consuming bool // the broker accepted the basic.consume
+	Standby   bool // started as a warm standby, it consumes once promoted (see Consumer.EnableStandby)
+	Skipped   string
+	Err       error
+}
+
+// ProvisionReport is the combined startup status of ProvisionConsumers, one ConsumerStatus per configured
+// consumer, sorted by name.
+type ProvisionReport struct {
+	Consumers []*ConsumerStatus
+	Started   int
+	Consuming int
+	Errors    []error
+	Duration  time.Duration
+}
+
+// RegisterHandler adds a handler for ProvisionConsumers to start consumers with, by the name their ConsumerConfig's
+// Handler (or, when that's empty, their key in ConsumerConfigs) refers to.
+func (rs *RabbitService) RegisterHandler(name string, handler consumer.MessageHandler) error {
+	if handler == nil {
+		return errors.New("can't register a nil handler")
+	}
+
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	if _, ok := rs.handlers[name]; ok {
+		return fmt.Errorf("can't register handler %s twice", name)
+	}
+
+	rs.handlers[name] = handler
+
+	return nil
+}
+
+// ProvisionConsumers starts every enabled consumer in ConsumerConfigs with its registered handler. For each one
+// the queue is declared from the config's Queue, or checked to exist (passively declared) without one, the handler
+// is looked up (see RegisterHandler), and the consumer is started and waited on until the broker accepts its
+// basic.consume or the context ends, standby consumers aren't waited on. Continues past failures, collecting
+// them in the report, and returns the first one. Already started consumers are left alone.
+func (rs *RabbitService) ProvisionConsumers(ctx context.Context) (*ProvisionReport, error) {
+	start := time.Now()
+	report := &ProvisionReport{}
+
+	names := make([]string, 0, len(rs.Config.ConsumerConfigs))
+	for name := range rs.Config.ConsumerConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	started := make(map[*ConsumerStatus]*consumer.Consumer)
+	for _, name := range names {
+		status := rs.provisionConsumer(name, rs.Config.ConsumerConfigs[name])
+		report.Consumers = append(report.Consumers, status)
+
+		if status.Started {
+			report.Started++
+			if status.Standby { // consumes once promoted
+				continue
+			}
+
+			started[status] = rs.consumers[name]
+		}
+	}
+
+	statusLock := &sync.Mutex{}
+	waiting := &sync.WaitGroup{}
+	for status, con := range started {
+		status, con := status, con
+		waiting.Add(1)
+		models.Go("service.provisionwait", func() {
+			defer waiting.Done()
+			err := con.WaitUntilConsuming(ctx)
+
+			statusLock.Lock()
+			defer statusLock.Unlock()
+
+			status.Consuming = err == nil
+			if err != nil {
+				status.Err = fmt.Errorf("consumer %s isn't consuming: %w", status.Name, err)
+			}
+		})
+	}
+	waiting.Wait()
+
+	for _, status := range report.Consumers {
+		if status.Consuming {
+			report.Consuming++
+		}
+
+		if status.Err != nil {
+			report.Errors = append(report.Errors, status.Err)
+		}
+	}
+
+	report.Duration = time.Since(start)
+
+	if len(report.Errors) > 0 {
+		return report, report.Errors[0]
+	}
+
+	return report, nil
+}
+
+// ProvisionConsumer readies the consumer's queue and starts it with its handler.
+func (rs *RabbitService) provisionConsumer(name string, config *models.ConsumerConfig) *ConsumerStatus {
+	status := &ConsumerStatus{
+		Name:      name,
+		QueueName: config.QueueName,
+		Handler:   config.Handler,
+	}
+
+	if status.Handler == "" {
+		status.Handler = name
+	}
+
+	con, ok := rs.consumers[name]
+	switch {
+	case !config.Enabled:
+		status.Skipped = "disabled"
+		return status
+	case !ok:
+		status.Err = fmt.Errorf("consumer %s was not created", name)
+		return status
+	case con.State().Started:
+		status.Skipped = "already started"
+		return status
+	}
+
+	rs.serviceLock.Lock()
+	handler, ok := rs.handlers[status.Handler]
+	rs.serviceLock.Unlock()
+
+	if !ok {
+		status.Err = fmt.Errorf("consumer %s: no handler is registered as %s", name, status.Handler)
+		return status
+	}
+
+	topologer, err := rs.consumerTopologer(config)
+	if err != nil {
+		status.Err = fmt.Errorf("consumer %s: %w", name, err)
+		return status
+	}
+
+	if config.Queue != nil {
+		queue := *config.Queue
+		if queue.Name == "" {
+			queue.Name = config.QueueName
+		}
+
+		_, err = topologer.DeclareQueue(&queue)
+		status.Declared = err == nil
+	} else {
+		err = topologer.CreateQueue(config.QueueName, true, false, false, false, false, nil)
+	}
+
+	if err != nil {
+		status.Err = fmt.Errorf("consumer %s: queue %s: %w", name, config.QueueName, err)
+		return status
+	}
+
+	if err = con.StartConsumingWithHandler(handler); err != nil {
+		status.Err = fmt.Errorf("consumer %s: %w", name, err)
+		return status
+	}
+
+	status.Started = true
+	status.Standby = con.InStandby()
+
+	return status
+}
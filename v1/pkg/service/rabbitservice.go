@@ -1,8 +1,9 @@
 package services
 
 import (
+	"context"
 	"errors"
-	"os"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,14 +22,26 @@ type RabbitService struct {
 	ChannelPool          *pools.ChannelPool
 	Topologer            *topology.Topologer
 	Publisher            *publisher.Publisher
+	Scheduler            *publisher.Scheduler
+	errorDrain           *models.ErrorDrain
+	localPools           *topology.LocalPools // per node pools with QueueLocality, nil without
+	localPublishers      map[*pools.ChannelPool]*publisher.Publisher
+	targets              map[string]*Target // the seasoning's Targets by name
 	encryptionConfigured bool
 	centralErr           chan error
 	consumers            map[string]*consumer.Consumer
+	handlers             map[string]consumer.MessageHandler
+	shutdowns            map[string]*shutdownComponent
 	stopServiceSignal    chan bool
 	stop                 bool
 	retryCount           uint32
 	letterCount          uint64
 	monitorSleepInterval time.Duration
+	safeToTerminate      chan struct{}
+	deployOnce           *sync.Once
+	deployReport         *DeployReport
+	done                 chan struct{}
+	doneOnce             *sync.Once
 	serviceLock          *sync.Mutex
 }
 
@@ -40,7 +53,7 @@ func NewRabbitService(config *models.RabbitSeasoning) (*RabbitService, error) {
 		return nil, err
 	}
 
-	publisher, err := publisher.NewPublisher(config, channelPool, nil)
+	pub, err := publisher.NewPublisher(config, channelPool, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -50,16 +63,41 @@ func NewRabbitService(config *models.RabbitSeasoning) (*RabbitService, error) {
 		return nil, err
 	}
 
+	scheduler, err := publisher.NewSchedulerFromConfig(pub, config.PublisherConfig.Schedules)
+	if err != nil {
+		return nil, err
+	}
+
+	localPools, err := newLocalPools(config, channelPool)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := newTargets(config)
+	if err != nil {
+		return nil, err
+	}
+
 	rs := &RabbitService{
 		ChannelPool:          channelPool,
 		Config:               config,
-		Publisher:            publisher,
+		Publisher:            pub,
 		Topologer:            topologer,
+		Scheduler:            scheduler,
+		localPools:           localPools,
+		localPublishers:      make(map[*pools.ChannelPool]*publisher.Publisher),
+		targets:              targets,
 		centralErr:           make(chan error, config.ServiceConfig.ErrorBuffer),
 		stopServiceSignal:    make(chan bool, 1),
 		consumers:            make(map[string]*consumer.Consumer),
+		handlers:             make(map[string]consumer.MessageHandler),
+		shutdowns:            make(map[string]*shutdownComponent),
 		retryCount:           10,
 		monitorSleepInterval: time.Duration(3) * time.Second,
+		safeToTerminate:      make(chan struct{}),
+		deployOnce:           &sync.Once{},
+		done:                 make(chan struct{}),
+		doneOnce:             &sync.Once{},
 		serviceLock:          &sync.Mutex{},
 	}
 
@@ -68,24 +106,84 @@ func NewRabbitService(config *models.RabbitSeasoning) (*RabbitService, error) {
 		return nil, err
 	}
 
+	if config.NamingPolicy != nil {
+		naming, err := models.NewNamingPolicy(config.NamingPolicy)
+		if err != nil {
+			return nil, err
+		}
+
+		naming.OnViolation(rs.namingViolation)
+		rs.Topologer.SetNamingPolicy(naming)
+		rs.Publisher.SetNamingPolicy(naming)
+		for _, target := range rs.targets {
+			target.Topologer.SetNamingPolicy(naming)
+			target.Publisher.SetNamingPolicy(naming)
+		}
+	}
+
+	if config.ServiceConfig.ErrorDelivery == models.ErrorDeliveryDeferredName {
+		models.SetErrorDelivery(models.ErrorDeliveryDeferred)
+	} else {
+		models.SetErrorDelivery(models.ErrorDeliveryImmediate)
+	}
+
+	if config.ChaosConfig != nil && config.ChaosConfig.Enabled {
+		models.SetChaos(models.NewChaos(config.ChaosConfig))
+	} else {
+		models.SetChaos(nil)
+	}
+
+	if config.ServiceConfig.Trace {
+		models.SetTracing(true)
+	}
+
+	models.SetGoroutineLimit(config.ServiceConfig.GoroutineLimit)
+	if config.ServiceConfig.GoroutineWarnThreshold > 0 {
+		models.SetGoroutineWarning(config.ServiceConfig.GoroutineWarnThreshold, rs.goroutineWarning)
+	}
+
+	if config.ServiceConfig.HandleSignals {
+		if _, err = rs.HandleShutdownSignals(); err != nil {
+			return nil, err
+		}
+	}
+
 	return rs, nil
 }
 
+// NamingViolation reports every naming policy violation on CentralErr without blocking.
+func (rs *RabbitService) namingViolation(violation *models.NamingViolation) {
+	select {
+	case rs.centralErr <- violation:
+	default:
+	}
+}
+
+// GoroutineWarning reports piling up goroutines (usually an Errors() nobody drains) on CentralErr without blocking.
+func (rs *RabbitService) goroutineWarning(name string, live int64) {
+	select {
+	case rs.centralErr <- fmt.Errorf("%d %s goroutines are live, is something draining them?", live, name):
+	default:
+	}
+}
+
 // CreateConsumers takes a config from the Config and builds all the consumers (errors if config is missing).
 func (rs *RabbitService) CreateConsumers(consumerConfigs map[string]*models.ConsumerConfig) error {
 
 	for consumerName, consumerConfig := range consumerConfigs {
 
-		consumer, err := consumer.NewConsumerFromConfig(consumerConfig, rs.ChannelPool)
+		channelPool, err := rs.consumerChannelPool(consumerConfig)
 		if err != nil {
 			return err
 		}
 
-		hostName, err := os.Hostname()
-		if err == nil {
-			consumer.ConsumerName = hostName + "-" + consumer.ConsumerName
+		consumer, err := consumer.NewConsumerFromConfig(consumerConfig, channelPool)
+		if err != nil {
+			return err
 		}
 
+		consumer.ConsumerName = models.GetNamer().ConsumerTag(consumer.ConsumerName, consumer.QueueName)
+
 		rs.consumers[consumerName] = consumer
 	}
 
@@ -96,7 +194,12 @@ func (rs *RabbitService) CreateConsumers(consumerConfigs map[string]*models.Cons
 func (rs *RabbitService) CreateConsumerFromConfig(consumerName string) error {
 
 	if consumerConfig, ok := rs.Config.ConsumerConfigs[consumerName]; ok {
-		consumer, err := consumer.NewConsumerFromConfig(consumerConfig, rs.ChannelPool)
+		channelPool, err := rs.consumerChannelPool(consumerConfig)
+		if err != nil {
+			return err
+		}
+
+		consumer, err := consumer.NewConsumerFromConfig(consumerConfig, channelPool)
 		if err != nil {
 			return err
 		}
@@ -213,12 +316,24 @@ func (rs *RabbitService) Publish(input interface{}, exchangeName, routingKey str
 func (rs *RabbitService) StartService(allowRetry bool) {
 
 	// Start the background monitors and logging.
-	go rs.collectChannelPoolErrors()
-	go rs.collectConsumerErrors()
-	go rs.monitorStopService()
+	models.Go("service.channelpoolerrors", rs.collectChannelPoolErrors)
+	models.Go("service.consumererrors", rs.collectConsumerErrors)
+	models.Go("service.monitor", rs.monitorStopService)
+
+	if rs.Config.ServiceConfig.LogUnreadErrors {
+		rs.serviceLock.Lock()
+		if rs.errorDrain == nil {
+			rs.errorDrain = models.DrainErrors("service", rs.centralErr, rs.Config.ServiceConfig.ErrorLogLimit)
+		}
+		rs.serviceLock.Unlock()
+	}
 
-	// Start the AutoPublisher
+	// Start the AutoPublisher and the scheduled publishes
 	rs.Publisher.StartAutoPublish(allowRetry)
+	for _, target := range rs.targets {
+		target.Publisher.StartAutoPublish(allowRetry)
+	}
+	rs.Scheduler.Start()
 }
 
 func (rs *RabbitService) monitorStopService() {
@@ -248,6 +363,7 @@ MonitorLoop:
 		case err := <-rs.ChannelPool.Errors():
 			rs.centralErr <- err
 		default:
+			rs.collectTargetErrors()
 			time.Sleep(rs.monitorSleepInterval)
 			break
 		}
@@ -292,28 +408,51 @@ func (rs *RabbitService) GetConsumer(consumerName string) (*consumer.Consumer, e
 // StopService stops the AutoPublisher, Consumer, and Monitoring.
 func (rs *RabbitService) StopService() {
 
+	rs.Scheduler.Stop()
 	rs.Publisher.StopAutoPublish()
+	for _, target := range rs.targets {
+		target.Publisher.StopAutoPublish()
+	}
 
 	time.Sleep(1 * time.Second)
 	rs.stopServiceSignal <- true
 	time.Sleep(1 * time.Second)
+
+	rs.serviceLock.Lock()
+	if rs.errorDrain != nil {
+		rs.errorDrain.Stop()
+		rs.errorDrain = nil
+	}
+	rs.serviceLock.Unlock()
 }
 
-// Shutdown stops the service and shuts down the ChannelPool.
+// Shutdown stops the service and shuts down the ChannelPool. With stopConsumers, the consumers and Publisher
+// are stopped in dependency order first through GracefulDeploy (see ShutdownOrdered), bounded by the
+// DeployTimeout, with the errors reported on CentralErr, so it's safe alongside a signalled shutdown.
 func (rs *RabbitService) Shutdown(stopConsumers bool) {
 
-	rs.StopService()
-
 	if stopConsumers {
-		for _, consumer := range rs.consumers {
-			err := consumer.StopConsuming(true, true)
-			if err != nil {
-				rs.centralErr <- err
-			}
-		}
+		ctx, cancel := context.WithTimeout(context.Background(), rs.deployTimeout())
+		rs.reportErrors(rs.GracefulDeploy(ctx).Errors)
+		cancel()
+		return
 	}
 
+	rs.StopService()
+	rs.shutdownLocalPools()
+	shutdownTargets(rs.targets)
 	rs.ChannelPool.Shutdown()
+	rs.markDone()
+}
+
+// ReportErrors sends the errors to CentralErr without blocking.
+func (rs *RabbitService) reportErrors(errs []error) {
+	for _, err := range errs {
+		select {
+		case rs.centralErr <- err:
+		default:
+		}
+	}
 }
 
 // CentralErr yields all the internal errs for sub-process.
@@ -2,6 +2,7 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"github.com/fortytw2/leaktest"
 	"github.com/houseofcat/turbocookedrabbit/models"
 	"github.com/houseofcat/turbocookedrabbit/utils"
+	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -333,3 +335,129 @@ func TestPublishCompressionEncryptionWithWrapAndConsume(t *testing.T) {
 	assert.Equal(t, timeStamp, returnedData.UTCDateTime)
 	assert.Equal(t, unmodifiedPayload, returnedData.Data)
 }
+
+func TestProvisionConsumers(t *testing.T) {
+
+	for consumerName := range Config.ConsumerConfigs {
+		err := Service.RegisterHandler(consumerName, func(msg *models.Message) error {
+			return msg.Acknowledge()
+		})
+		assert.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5)*time.Second)
+	defer cancel()
+
+	report, err := Service.ProvisionConsumers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(Config.ConsumerConfigs), len(report.Consumers))
+	assert.Equal(t, report.Started, report.Consuming)
+
+	for _, status := range report.Consumers {
+		if status.Skipped == "" {
+			assert.True(t, status.Consuming)
+		}
+	}
+
+	// a second pass leaves the started consumers alone
+	report, err = Service.ProvisionConsumers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Started)
+}
+
+func TestBridge(t *testing.T) {
+
+	config := *Config
+	config.Targets = map[string]*models.TargetConfig{
+		"Upstream": {PoolConfig: Config.PoolConfig}, // stands in for a second cluster
+	}
+
+	sourceConfig := *Config.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+	sourceConfig.QueueName = "TcrBridgeSource"
+	sourceConfig.Target = "Upstream"
+
+	sinkConfig := *Config.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"]
+	sinkConfig.QueueName = "TcrBridgeSink"
+
+	config.ConsumerConfigs = map[string]*models.ConsumerConfig{"BridgeSource": &sourceConfig, "BridgeSink": &sinkConfig}
+
+	service, err := NewRabbitService(&config)
+	assert.NoError(t, err)
+	defer service.Shutdown(true)
+
+	upstream, err := service.GetTarget("Upstream")
+	assert.NoError(t, err)
+	assert.NoError(t, upstream.Topologer.CreateQueue("TcrBridgeSource", false, true, false, false, false, nil))
+	assert.NoError(t, service.Topologer.CreateQueue("TcrBridgeSink", false, true, false, false, false, nil))
+
+	_, err = service.TargetPublisher("Elsewhere")
+	assert.Error(t, err)
+
+	err = service.Bridge("BridgeSource", "", "", "TcrBridgeSink", func(msg *models.Message) ([]byte, error) {
+		return append([]byte("bridged "), msg.Body...), nil
+	})
+	assert.NoError(t, err)
+
+	err = upstream.Publisher.PublishRaw("", "TcrBridgeSource", false, false, amqp.Publishing{
+		MessageId:       "bridge-1",
+		ContentType:     "text/plain",
+		ContentEncoding: "identity",
+		Body:            []byte("hello"),
+	})
+	assert.NoError(t, err)
+
+	sink, err := service.GetConsumer("BridgeSink")
+	assert.NoError(t, err)
+
+	var msg *models.Message
+	for i := 0; i < 50 && msg == nil; i++ {
+		msg, err = sink.Get("TcrBridgeSink", true)
+		assert.NoError(t, err)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "bridged hello", string(msg.Body))
+		assert.Equal(t, "bridge-1", msg.MessageID)
+		assert.Equal(t, "text/plain", msg.ContentType)
+		assert.Equal(t, "identity", msg.ContentEncoding)
+	}
+}
+
+func TestShutdownStages(t *testing.T) {
+
+	stop := func(ctx context.Context) error { return nil }
+	components := map[string]*shutdownComponent{
+		ShutdownPublisher: {stop: stop},
+		"Ingest":          {stop: stop, dependsOn: []string{ShutdownPublisher}},
+		"Audit":           {stop: stop, dependsOn: []string{ShutdownPublisher}},
+		"HTTP":            {stop: stop, dependsOn: []string{"Ingest", "Audit"}},
+	}
+
+	stages, cycle := shutdownStages(components)
+	assert.Empty(t, cycle)
+	assert.Equal(t, [][]string{{"HTTP"}, {"Audit", "Ingest"}, {ShutdownPublisher}}, stages)
+
+	// the publisher depending back on a consumer leaves both for last
+	components[ShutdownPublisher].dependsOn = []string{"Ingest"}
+
+	stages, cycle = shutdownStages(components)
+	assert.Equal(t, [][]string{{"HTTP"}, {"Audit"}}, stages)
+	assert.Equal(t, []string{"Ingest", ShutdownPublisher}, cycle)
+}
+
+func TestHandleShutdownSignals(t *testing.T) {
+
+	rs := &RabbitService{Config: &models.RabbitSeasoning{
+		ServiceConfig: &models.ServiceConfig{ShutdownSignals: []string{"SIGTERM", "SIGKILL"}},
+	}}
+
+	_, err := rs.HandleShutdownSignals()
+	assert.Error(t, err)
+
+	rs.Config.ServiceConfig.ShutdownSignals = []string{"SIGHUP"}
+	stop, err := rs.HandleShutdownSignals()
+	assert.NoError(t, err)
+	stop()
+	stop() // safe to call twice
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/consumer"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+const (
+	// ShutdownPublisher is the service's Publisher in the shutdown graph, every consumer depends on it.
+	ShutdownPublisher = "Publisher"
+	// ShutdownScheduler is the service's Scheduler in the shutdown graph, it depends on the Publisher.
+	ShutdownScheduler = "Scheduler"
+)
+
+// ShutdownFunc stops a component of the service within the context.
+type ShutdownFunc func(ctx context.Context) error
+
+// ShutdownReport is how an ordered shutdown went.
+type ShutdownReport struct {
+	Stages   [][]string // components stopped together, in the order they were stopped
+	Errors   []error
+	Duration time.Duration
+}
+
+type shutdownComponent struct {
+	stop      ShutdownFunc
+	dependsOn []string
+}
+
+// RegisterShutdown adds a component of your own (i.e. an HTTP ingest server, another Publisher) to the shutdown
+// graph. It's stopped before the components it depends on, and after the components depending on it.
+// Consumers are in the graph by their ConsumerConfigs key, the service's Publisher as ShutdownPublisher, and a
+// Target's Publisher as TargetShutdown(name).
+func (rs *RabbitService) RegisterShutdown(name string, stop ShutdownFunc, dependsOn ...string) error {
+	if stop == nil {
+		return fmt.Errorf("can't register a nil shutdown for %s", name)
+	}
+
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	if _, ok := rs.consumers[name]; ok || name == ShutdownPublisher || name == ShutdownScheduler || rs.shutdowns[name] != nil || rs.isTargetShutdown(name) {
+		return fmt.Errorf("can't register shutdown %s twice", name)
+	}
+
+	rs.shutdowns[name] = &shutdownComponent{stop: stop, dependsOn: dependsOn}
+
+	return nil
+}
+
+// ShutdownOrdered stops the service's components in dependency order: a component is only stopped once every
+// component depending on it has stopped, so handlers are never left publishing on a closed Publisher.
+// Consumers (drained, see Consumer.Drain) depend on the Publisher (flushed, then shut down), further dependencies
+// come from the ServiceConfig's ShutdownDependencies and RegisterShutdown. Components without dependencies
+// between them stop concurrently. A dependency cycle, or a dependency on an unknown component, is reported and
+// the components involved stop last. The ChannelPool is left running.
+func (rs *RabbitService) ShutdownOrdered(ctx context.Context) *ShutdownReport {
+	start := time.Now()
+	report := &ShutdownReport{}
+
+	components, errs := rs.shutdownGraph()
+	report.Errors = append(report.Errors, errs...)
+
+	stages, cycle := shutdownStages(components)
+	if len(cycle) > 0 {
+		report.Errors = append(report.Errors, fmt.Errorf("shutdown dependencies have a cycle between %s", strings.Join(cycle, ", ")))
+		stages = append(stages, cycle)
+	}
+
+	reportLock := &sync.Mutex{}
+	for _, stage := range stages {
+		stopping := &sync.WaitGroup{}
+		for _, name := range stage {
+			name, stop := name, components[name].stop
+			stopping.Add(1)
+			models.Go("service.shutdown", func() {
+				defer stopping.Done()
+
+				if err := stop(ctx); err != nil {
+					reportLock.Lock()
+					report.Errors = append(report.Errors, fmt.Errorf("%s: %w", name, err))
+					reportLock.Unlock()
+				}
+			})
+		}
+		stopping.Wait()
+
+		report.Stages = append(report.Stages, stage)
+	}
+
+	report.Duration = time.Since(start)
+
+	return report
+}
+
+// ShutdownGraph gathers the consumers, the Publisher, and the registered components with their dependencies.
+func (rs *RabbitService) shutdownGraph() (map[string]*shutdownComponent, []error) {
+	rs.serviceLock.Lock()
+	defer rs.serviceLock.Unlock()
+
+	components := make(map[string]*shutdownComponent, len(rs.consumers)+len(rs.shutdowns)+1)
+	components[ShutdownPublisher] = &shutdownComponent{stop: rs.shutdownPublisher}
+	components[ShutdownScheduler] = &shutdownComponent{stop: rs.stopScheduler, dependsOn: []string{ShutdownPublisher}}
+
+	publishers := []string{ShutdownPublisher}
+	for name, target := range rs.targets {
+		components[TargetShutdown(name)] = &shutdownComponent{stop: shutdownTargetPublisher(target)}
+		publishers = append(publishers, TargetShutdown(name))
+	}
+
+	for name, con := range rs.consumers {
+		components[name] = &shutdownComponent{stop: drainConsumer(con), dependsOn: append([]string(nil), publishers...)}
+	}
+
+	for name, component := range rs.shutdowns {
+		components[name] = &shutdownComponent{stop: component.stop, dependsOn: append([]string(nil), component.dependsOn...)}
+	}
+
+	for name, dependsOn := range rs.Config.ServiceConfig.ShutdownDependencies {
+		if component, ok := components[name]; ok {
+			component.dependsOn = append(component.dependsOn, dependsOn...)
+		}
+	}
+
+	var errs []error
+	for _, name := range sortedComponents(components) {
+		component := components[name]
+		known := component.dependsOn[:0]
+		for _, dependency := range component.dependsOn {
+			if _, ok := components[dependency]; !ok {
+				errs = append(errs, fmt.Errorf("%s depends on unknown shutdown component %s", name, dependency))
+				continue
+			}
+
+			known = append(known, dependency)
+		}
+		component.dependsOn = known
+	}
+
+	for _, name := range sortedKeys(rs.Config.ServiceConfig.ShutdownDependencies) {
+		if _, ok := components[name]; !ok {
+			errs = append(errs, fmt.Errorf("shutdown dependencies for unknown component %s", name))
+		}
+	}
+
+	return components, errs
+}
+
+// ShutdownStages orders the components into stages (sorted by name), each stopping once the stages before it
+// have stopped everything depending on it. Returns the components left over by a cycle, sorted.
+func shutdownStages(components map[string]*shutdownComponent) ([][]string, []string) {
+	dependents := make(map[string]int, len(components))
+	for _, component := range components {
+		for _, dependency := range component.dependsOn {
+			dependents[dependency]++
+		}
+	}
+
+	var stages [][]string
+	remaining := sortedComponents(components)
+	for len(remaining) > 0 {
+		var stage, waiting []string
+		for _, name := range remaining {
+			if dependents[name] == 0 {
+				stage = append(stage, name)
+			} else {
+				waiting = append(waiting, name)
+			}
+		}
+
+		if len(stage) == 0 {
+			return stages, waiting
+		}
+
+		for _, name := range stage {
+			for _, dependency := range components[name].dependsOn {
+				dependents[dependency]--
+			}
+		}
+
+		stages = append(stages, stage)
+		remaining = waiting
+	}
+
+	return stages, nil
+}
+
+func sortedComponents(components map[string]*shutdownComponent) []string {
+	names := make([]string, 0, len(components))
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func sortedKeys(dependencies map[string][]string) []string {
+	names := make([]string, 0, len(dependencies))
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DrainConsumer stops a started consumer once its in-flight deliveries are settled.
+func drainConsumer(con *consumer.Consumer) ShutdownFunc {
+	return func(ctx context.Context) error {
+		if !con.State().Started {
+			return nil
+		}
+
+		return con.Drain(ctx)
+	}
+}
+
+// StopScheduler stops the scheduled publishes before the Publisher goes.
+func (rs *RabbitService) stopScheduler(ctx context.Context) error {
+	rs.Scheduler.Stop()
+	return nil
+}
+
+// ShutdownPublisher flushes the letters queued for AutoPublish and shuts the Publisher down.
+func (rs *RabbitService) shutdownPublisher(ctx context.Context) error {
+	var err error
+	if rs.Publisher.AutoPublishStarted() {
+		err = rs.Publisher.Flush(ctx)
+	}
+
+	rs.Publisher.Shutdown(false)
+
+	return err
+}
+
+// ShutdownTargetPublisher flushes and shuts a Target's Publisher down, its ChannelPool is left running.
+func shutdownTargetPublisher(target *Target) ShutdownFunc {
+	return func(ctx context.Context) error {
+		var err error
+		if target.Publisher.AutoPublishStarted() {
+			err = target.Publisher.Flush(ctx)
+		}
+
+		target.Publisher.Shutdown(false)
+
+		return err
+	}
+}
+
+// IsTargetShutdown returns whether the name is a Target's Publisher in the shutdown graph.
+func (rs *RabbitService) isTargetShutdown(name string) bool {
+	for targetName := range rs.targets {
+		if name == TargetShutdown(targetName) {
+			return true
+		}
+	}
+
+	return false
+}
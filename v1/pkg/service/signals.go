@@ -0,0 +1,58 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+var shutdownSignals = map[string]os.Signal{
+	"SIGTERM": syscall.SIGTERM,
+	"SIGINT":  syscall.SIGINT,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGQUIT": syscall.SIGQUIT,
+}
+
+// HandleShutdownSignals shuts the service down gracefully (see GracefulDeploy) when the process receives one of the
+// ServiceConfig's ShutdownSignals (SIGTERM and SIGINT when none are configured), bounded by the ShutdownGracePeriod.
+// A second signal cuts the grace period short, the deploy's errors are reported on CentralErr, and Done closes once
+// the service is down. Called by NewRabbitService when HandleSignals is set. The returned func stops listening.
+func (rs *RabbitService) HandleShutdownSignals() (stop func(), err error) {
+
+	names := rs.Config.ServiceConfig.ShutdownSignals
+	if len(names) == 0 {
+		names = []string{"SIGTERM", "SIGINT"}
+	}
+
+	signals := make([]os.Signal, 0, len(names))
+	for _, name := range names {
+		sig, ok := shutdownSignals[name]
+		if !ok {
+			return nil, fmt.Errorf("can't handle shutdown signal %s", name)
+		}
+
+		signals = append(signals, sig)
+	}
+
+	gracePeriod := rs.deployTimeout()
+	if rs.Config.ServiceConfig.ShutdownGracePeriod > 0 {
+		gracePeriod = time.Duration(rs.Config.ServiceConfig.ShutdownGracePeriod) * time.Millisecond
+	}
+
+	return rs.deployOnSignal(signals, gracePeriod, func(report *DeployReport) {
+		rs.reportErrors(report.Errors)
+	}), nil
+}
+
+// Done is closed once the service has shut down, by a signal, GracefulDeploy, or Shutdown, for main() to wait on
+// before it returns.
+func (rs *RabbitService) Done() <-chan struct{} {
+	return rs.done
+}
+
+func (rs *RabbitService) markDone() {
+	rs.doneOnce.Do(func() {
+		close(rs.done)
+	})
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/consumer"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/publisher"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/topology"
+	"github.com/streadway/amqp"
+)
+
+// Target is a broker (i.e. a second cluster or vhost) the service talks to alongside its ChannelPool's, from the
+// seasoning's Targets, with its own pools, Publisher, and Topologer. A consumer consumes from the Target named in
+// its ConsumerConfig, and Bridge forwards what a consumer consumes to a Target.
+type Target struct {
+	Name        string
+	ChannelPool *pools.ChannelPool
+	Publisher   *publisher.Publisher
+	Topologer   *topology.Topologer
+}
+
+// TargetShutdown is a Target's Publisher in the shutdown graph, every consumer depends on it (as on ShutdownPublisher).
+func TargetShutdown(targetName string) string {
+	return ShutdownPublisher + "@" + targetName
+}
+
+// NewTargets creates the seasoning's Targets, shutting down the ones already created when one can't be.
+func newTargets(config *models.RabbitSeasoning) (map[string]*Target, error) {
+
+	targets := make(map[string]*Target, len(config.Targets))
+	for name, targetConfig := range config.Targets {
+		target, err := newTarget(name, config, targetConfig)
+		if err != nil {
+			shutdownTargets(targets)
+			return nil, fmt.Errorf("can't create target %s: %w", name, err)
+		}
+
+		targets[name] = target
+	}
+
+	return targets, nil
+}
+
+func newTarget(name string, config *models.RabbitSeasoning, targetConfig *models.TargetConfig) (*Target, error) {
+
+	if targetConfig == nil || targetConfig.PoolConfig == nil {
+		return nil, errors.New("a target needs a pool config")
+	}
+
+	channelPool, err := pools.NewChannelPool(targetConfig.PoolConfig, nil, true)
+	if err != nil {
+		return nil, err
+	}
+
+	seasoning := *config
+	seasoning.PoolConfig = targetConfig.PoolConfig
+	seasoning.PublisherConfig = targetConfig.PublisherConfig
+	if seasoning.PublisherConfig == nil {
+		publisherConfig := *config.PublisherConfig
+		publisherConfig.SpoolDir = "" // the spool belongs to the service's Publisher
+		seasoning.PublisherConfig = &publisherConfig
+	}
+
+	pub, err := publisher.NewPublisher(&seasoning, channelPool, nil)
+	if err != nil {
+		channelPool.Shutdown()
+		return nil, err
+	}
+
+	topologer, err := topology.NewTopologer(channelPool)
+	if err != nil {
+		pub.Shutdown(true)
+		return nil, err
+	}
+
+	return &Target{
+		Name:        name,
+		ChannelPool: channelPool,
+		Publisher:   pub,
+		Topologer:   topologer,
+	}, nil
+}
+
+// GetTarget allows you to get a Target by its name in the seasoning's Targets.
+func (rs *RabbitService) GetTarget(targetName string) (*Target, error) {
+
+	if target, ok := rs.targets[targetName]; ok {
+		return target, nil
+	}
+
+	return nil, fmt.Errorf("target %s was not found", targetName)
+}
+
+// TargetPublisher returns the Publisher of the named Target, the service's Publisher for an empty name.
+func (rs *RabbitService) TargetPublisher(targetName string) (*publisher.Publisher, error) {
+	if targetName == "" {
+		return rs.Publisher, nil
+	}
+
+	target, err := rs.GetTarget(targetName)
+	if err != nil {
+		return nil, err
+	}
+
+	return target.Publisher, nil
+}
+
+// ConsumerChannelPool returns the ChannelPool a consumer consumes on, its Target's when it has one.
+func (rs *RabbitService) consumerChannelPool(config *models.ConsumerConfig) (*pools.ChannelPool, error) {
+	if config.Target == "" {
+		return rs.consumerPool(config.QueueName), nil
+	}
+
+	target, err := rs.GetTarget(config.Target)
+	if err != nil {
+		return nil, fmt.Errorf("can't create a consumer of %s: %w", config.QueueName, err)
+	}
+
+	return target.ChannelPool, nil
+}
+
+// ConsumerTopologer returns the Topologer declaring a consumer's queue, its Target's when it has one.
+func (rs *RabbitService) consumerTopologer(config *models.ConsumerConfig) (*topology.Topologer, error) {
+	if config.Target == "" {
+		return rs.Topologer, nil
+	}
+
+	target, err := rs.GetTarget(config.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	return target.Topologer, nil
+}
+
+// BridgeFunc transforms a Message consumed on one broker into the body published to another. A nil body publishes
+// nothing (the Message is acked, i.e. filtered out) and an error rejects the Message (dead lettered when its queue
+// has a DLX).
+type BridgeFunc func(msg *models.Message) ([]byte, error)
+
+// BridgeHandler returns a MessageHandler forwarding each Message to the named Target's broker (the service's own for
+// an empty name): it's published to the exchange with the routing key (the Message's own when empty) through the
+// Target's Publisher, carrying the Message's headers, message id, correlation id, type, content type and encoding,
+// and priority, persistent, and only acked once the Target's broker confirmed the publish, so a Message isn't lost
+// between the brokers (though it may be forwarded twice when the ack doesn't make it). A Message that can't be
+// published (or isn't confirmed) is nacked for redelivery. A nil transform forwards the body
+// unchanged. Register it (see RegisterHandler) for ProvisionConsumers, or use Bridge.
+func (rs *RabbitService) BridgeHandler(targetName, exchange, routingKey string, transform BridgeFunc) (consumer.MessageHandler, error) {
+
+	pub, err := rs.TargetPublisher(targetName)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(msg *models.Message) error {
+		body := msg.Body
+		if transform != nil {
+			var err error
+			if body, err = transform(msg); err != nil {
+				if rejectErr := settleBridged(msg, func() error { return msg.Reject(false) }); rejectErr != nil {
+					return fmt.Errorf("%w\r\n[reject error: %s]", err, rejectErr)
+				}
+
+				return err
+			}
+		}
+
+		if body != nil {
+			key := routingKey
+			if key == "" {
+				key = msg.RoutingKey
+			}
+
+			if publishErr := pub.PublishRawWithConfirm(exchange, key, false, bridgePublishing(msg, body)); publishErr != nil {
+				_ = settleBridged(msg, func() error { return msg.Nack(true) })
+				return fmt.Errorf("can't bridge the message to %s: %w", exchange, publishErr)
+			}
+		}
+
+		return settleBridged(msg, msg.Acknowledge)
+	}, nil
+}
+
+// Bridge starts the consumer (consuming from its own Target, see ConsumerConfig.Target) forwarding everything it
+// consumes to the named Target, see BridgeHandler. An auto ack consumer's messages are acked before they're
+// forwarded, so use an ackable one for messages that mustn't be lost.
+func (rs *RabbitService) Bridge(consumerName, targetName, exchange, routingKey string, transform BridgeFunc) error {
+
+	con, err := rs.GetConsumer(consumerName)
+	if err != nil {
+		return err
+	}
+
+	handler, err := rs.BridgeHandler(targetName, exchange, routingKey, transform)
+	if err != nil {
+		return err
+	}
+
+	return con.StartConsumingWithHandler(handler)
+}
+
+// SettleBridged settles an ackable Message, auto acked ones have nothing to settle.
+func settleBridged(msg *models.Message, settle func() error) error {
+	if !msg.IsAckable {
+		return nil
+	}
+
+	return settle()
+}
+
+func bridgePublishing(msg *models.Message, body []byte) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         msg.Headers,
+		MessageId:       msg.MessageID,
+		CorrelationId:   msg.CorrelationID,
+		Type:            msg.Type,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		Priority:        msg.Priority,
+		DeliveryMode:    amqp.Persistent,
+		Body:            models.CopyBody(body), // the Message is recycled once the handler returns
+	}
+}
+
+// CollectTargetErrors sends the errors waiting on the Targets' ChannelPools to CentralErr.
+func (rs *RabbitService) collectTargetErrors() {
+	for name, target := range rs.targets {
+	TargetLoop:
+		for {
+			select {
+			case err := <-target.ChannelPool.Errors():
+				rs.centralErr <- fmt.Errorf("target %s: %w", name, err)
+			default:
+				break TargetLoop
+			}
+		}
+	}
+}
+
+// ShutdownTargets shuts the Targets' publishers and pools down.
+func shutdownTargets(targets map[string]*Target) {
+	for _, target := range targets {
+		target.Publisher.Shutdown(true)
+	}
+}
@@ -260,3 +260,57 @@ func TestUnbindQueue(t *testing.T) {
 	err = topologer.UnbindQueue("QueueAttachedToExch01", "RoutingKey1", "MyTestExchange.Child01", nil)
 	assert.NoError(t, err)
 }
+
+func TestMoveMessages(t *testing.T) {
+
+	channelPool, err := pools.NewChannelPool(Seasoning.PoolConfig, nil, true)
+	assert.NoError(t, err)
+
+	topologer, err := topology.NewTopologer(channelPool)
+	assert.NoError(t, err)
+
+	for _, queueName := range []string{"MoveSourceQueue", "MoveDestinationQueue"} {
+		assert.NoError(t, topologer.CreateQueue(queueName, false, false, true, false, false, nil))
+		_, err = topologer.PurgeQueue(queueName, false)
+		assert.NoError(t, err)
+	}
+
+	chanHost, err := channelPool.GetChannel()
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, chanHost.Channel.Publish("", "MoveSourceQueue", false, false, amqp.Publishing{Body: []byte(fmt.Sprintf("v1-%d", i))}))
+	}
+	channelPool.ReturnChannel(chanHost, false)
+
+	progress := make([]topology.MoveProgress, 0)
+	transform := func(delivery *amqp.Delivery) (amqp.Publishing, error) {
+		publishing := topology.PublishingFromDelivery(delivery)
+		publishing.Body = []byte(strings.Replace(string(delivery.Body), "v1", "v2", 1))
+		return publishing, nil
+	}
+
+	moved, err := topologer.MoveMessages("MoveSourceQueue", "MoveDestinationQueue", 6, transform, &topology.MoveOptions{
+		Rate:          100,
+		ProgressEvery: 2,
+		Progress:      func(p topology.MoveProgress) { progress = append(progress, p) },
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, moved)
+	assert.Equal(t, 4, len(progress)) // every 2 and once at the end
+
+	deliveries, err := topologer.PeekMessages("MoveDestinationQueue", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(deliveries))
+	assert.Equal(t, "v2-0", string(deliveries[0].Body))
+
+	// the copy can't be routed, so the message stays in the source
+	moved, err = topologer.MoveMessages("MoveSourceQueue", "MoveMissingQueue", 0, nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 0, moved)
+
+	deliveries, err = topologer.PeekMessages("MoveSourceQueue", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(deliveries))
+
+	channelPool.Shutdown()
+}
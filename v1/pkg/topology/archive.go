@@ -0,0 +1,389 @@
+package topology
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+const defaultArchiveBatchSize = 100
+
+// ArchivedMessage is a message drained from a queue by ArchiveQueue, one JSON object per line of the archive.
+// Headers go through JSON, so numbers come back as float64 and tables as maps.
+type ArchivedMessage struct {
+	Queue           string     `json:"Queue"`
+	Archived        time.Time  `json:"Archived"`
+	Exchange        string     `json:"Exchange"`
+	RoutingKey      string     `json:"RoutingKey"`
+	Redelivered     bool       `json:"Redelivered"`
+	ContentType     string     `json:"ContentType"`
+	ContentEncoding string     `json:"ContentEncoding"`
+	DeliveryMode    uint8      `json:"DeliveryMode"`
+	Priority        uint8      `json:"Priority"`
+	CorrelationID   string     `json:"CorrelationID"`
+	ReplyTo         string     `json:"ReplyTo"`
+	Expiration      string     `json:"Expiration"`
+	MessageID       string     `json:"MessageID"`
+	Timestamp       time.Time  `json:"Timestamp"`
+	Type            string     `json:"Type"`
+	UserID          string     `json:"UserID"`
+	AppID           string     `json:"AppID"`
+	Headers         amqp.Table `json:"Headers"`
+	Body            []byte     `json:"Body"` // base64 in the archive
+}
+
+// NewArchivedMessage copies the delivery's address, properties, and body.
+func NewArchivedMessage(queue string, delivery *amqp.Delivery, archived time.Time) *ArchivedMessage {
+	return &ArchivedMessage{
+		Queue:           queue,
+		Archived:        archived,
+		Exchange:        delivery.Exchange,
+		RoutingKey:      delivery.RoutingKey,
+		Redelivered:     delivery.Redelivered,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    delivery.DeliveryMode,
+		Priority:        delivery.Priority,
+		CorrelationID:   delivery.CorrelationId,
+		ReplyTo:         delivery.ReplyTo,
+		Expiration:      delivery.Expiration,
+		MessageID:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		UserID:          delivery.UserId,
+		AppID:           delivery.AppId,
+		Headers:         delivery.Headers,
+		Body:            delivery.Body,
+	}
+}
+
+// Publishing turns the archived message back into the publishing it was sent as.
+func (am *ArchivedMessage) Publishing() amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         am.Headers,
+		ContentType:     am.ContentType,
+		ContentEncoding: am.ContentEncoding,
+		DeliveryMode:    am.DeliveryMode,
+		Priority:        am.Priority,
+		CorrelationId:   am.CorrelationID,
+		ReplyTo:         am.ReplyTo,
+		Expiration:      am.Expiration,
+		MessageId:       am.MessageID,
+		Timestamp:       am.Timestamp,
+		Type:            am.Type,
+		UserId:          am.UserID,
+		AppId:           am.AppID,
+		Body:            am.Body,
+	}
+}
+
+// ArchiveCheckpoint is how far an ArchiveQueue or RestoreArchive got, saved after every batch so an interrupted
+// run picks up where it left off.
+type ArchiveCheckpoint struct {
+	Queue    string    `json:"Queue"`
+	Offset   int64     `json:"Offset"`   // archive bytes written (archiving) or read (restoring)
+	Messages int       `json:"Messages"` // archived or restored so far
+	Updated  time.Time `json:"Updated"`
+}
+
+// ArchiveOptions tune ArchiveQueue and RestoreArchive, nil uses the defaults.
+type ArchiveOptions struct {
+	BatchSize      int                     // messages per checkpoint, 0 is 100
+	CheckpointPath string                  // "" is the archive path plus ".checkpoint" (archiving) or ".restored" (restoring)
+	ConfirmTimeout time.Duration           // restoring waits for each publisher confirm, 0 is 5 seconds
+	Progress       func(ArchiveCheckpoint) // called after every checkpoint
+}
+
+// ArchiveQueue drains up to n messages (0 drains until the queue is empty) from the queue to the archive at path,
+// newline delimited ArchivedMessages, so a queue can be decommissioned without purging it. Each batch is written,
+// synced, and checkpointed before its messages are acked off the queue. Running it again with the same path resumes
+// an interrupted drain: the archive is cut back to the last checkpoint, dropping the lines of an unfinished batch
+// whose messages are still in the queue. An interruption between a checkpoint and its ack archives that batch twice.
+// Archives are plain files, ready to be shipped to an object store. Returns how many messages this run archived.
+func (top *Topologer) ArchiveQueue(queue, path string, n int, options *ArchiveOptions) (int, error) {
+
+	if queue == "" || path == "" {
+		return 0, errors.New("can't archive a queue without a queue name and archive path")
+	}
+
+	options, checkpointPath := archiveDefaults(options, path, ".checkpoint")
+	checkpoint, err := loadArchiveCheckpoint(checkpointPath)
+	if err != nil {
+		return 0, err
+	}
+
+	if checkpoint.Queue != "" && checkpoint.Queue != queue {
+		return 0, fmt.Errorf("can't archive queue %s, the archive belongs to queue %s", queue, checkpoint.Queue)
+	}
+	checkpoint.Queue = queue
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if err = file.Truncate(checkpoint.Offset); err != nil {
+		return 0, err
+	}
+
+	if _, err = file.Seek(checkpoint.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	chanHost, err := top.channelPool.GetChannel()
+	if err != nil {
+		return 0, err
+	}
+
+	// closing requeues the unacked messages of an unfinished batch
+	defer func() {
+		_ = chanHost.Close()
+		top.channelPool.ReturnChannel(chanHost, true)
+	}()
+
+	archived := 0
+	for n == 0 || archived < n {
+		var lines []byte
+		var last *amqp.Delivery
+		batch := 0
+
+		for batch < options.BatchSize && (n == 0 || archived+batch < n) {
+			started := time.Now()
+			delivery, ok, err := chanHost.Channel.Get(queue, false)
+			models.Trace("basic.get", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, autoack: false, empty: %t", queue, !ok)
+			if err != nil {
+				return archived, err
+			}
+
+			if !ok {
+				break // queue is empty
+			}
+
+			line, err := json.Marshal(NewArchivedMessage(queue, &delivery, time.Now()))
+			if err != nil {
+				return archived, fmt.Errorf("can't archive message %d: %w", checkpoint.Messages+batch+1, err)
+			}
+
+			lines = append(append(lines, line...), '\n')
+			last = &delivery
+			batch++
+		}
+
+		if batch == 0 {
+			break
+		}
+
+		if _, err = file.Write(lines); err != nil {
+			return archived, err
+		}
+
+		if err = file.Sync(); err != nil {
+			return archived, err
+		}
+
+		checkpoint.Offset += int64(len(lines))
+		checkpoint.Messages += batch
+		if err = saveArchiveCheckpoint(checkpointPath, &checkpoint, options.Progress); err != nil {
+			return archived, err
+		}
+
+		started := time.Now()
+		err = last.Ack(true)
+		models.Trace("ack", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, delivery tag: %d, multiple: true", queue, last.DeliveryTag)
+		if err != nil {
+			return archived, fmt.Errorf("%w (the last batch may be archived twice)", err)
+		}
+
+		archived += batch
+		if batch < options.BatchSize {
+			break // queue is empty
+		}
+	}
+
+	return archived, nil
+}
+
+// RestoreArchive re-publishes the messages of an ArchiveQueue archive, to the target queue (default exchange) or,
+// when target is "", to the exchange and routing key each was originally published to. Every message is published
+// mandatory and confirmed, an unroutable or nacked message stops the restore. Running it again with the same path
+// resumes an interrupted restore from its last checkpoint, the messages published after it are published again.
+// Returns how many messages this run restored.
+func (top *Topologer) RestoreArchive(path, target string, options *ArchiveOptions) (int, error) {
+
+	if path == "" {
+		return 0, errors.New("can't restore an archive without its path")
+	}
+
+	options, checkpointPath := archiveDefaults(options, path, ".restored")
+	checkpoint, err := loadArchiveCheckpoint(checkpointPath)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if _, err = file.Seek(checkpoint.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	chanHost, err := top.channelPool.GetChannel()
+	if err != nil {
+		return 0, err
+	}
+
+	// confirm mode can't be turned off, so the channel is closed and replaced afterwards
+	defer func() {
+		_ = chanHost.Close()
+		top.channelPool.ReturnChannel(chanHost, true)
+	}()
+
+	started := time.Now()
+	err = chanHost.Channel.Confirm(false)
+	models.Trace("confirm.select", chanHost.ConnectionID, chanHost.ChannelID, started, err, "archive: %s", path)
+	if err != nil {
+		return 0, err
+	}
+
+	confirms := chanHost.Channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	returns := chanHost.Channel.NotifyReturn(make(chan amqp.Return, 1))
+
+	reader := bufio.NewReader(file)
+	restored := 0
+	batch := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return restored, fmt.Errorf("can't restore message %d, its line is incomplete", checkpoint.Messages+batch+1)
+			}
+			break
+		}
+
+		if err != nil {
+			return restored, err
+		}
+
+		message := &ArchivedMessage{}
+		if err = json.Unmarshal(line, message); err != nil {
+			return restored, fmt.Errorf("can't restore message %d: %w", checkpoint.Messages+batch+1, err)
+		}
+
+		exchange, key := message.Exchange, message.RoutingKey
+		if target != "" {
+			exchange, key = "", target
+		}
+
+		publishing := message.Publishing()
+		started = time.Now()
+		err = chanHost.Channel.Publish(exchange, key, true, false, publishing)
+		models.Trace("publish", chanHost.ConnectionID, chanHost.ChannelID, started, err, "exchange: %s, routing key: %s, bytes: %d", exchange, key, len(publishing.Body))
+		if err != nil {
+			return restored, err
+		}
+
+		if err = waitForMoveConfirm(confirms, returns, options.ConfirmTimeout); err != nil {
+			return restored, err
+		}
+
+		checkpoint.Offset += int64(len(line))
+		restored++
+		batch++
+
+		if batch == options.BatchSize {
+			checkpoint.Messages += batch
+			batch = 0
+			if err = saveArchiveCheckpoint(checkpointPath, &checkpoint, options.Progress); err != nil {
+				return restored, err
+			}
+		}
+	}
+
+	if batch > 0 {
+		checkpoint.Messages += batch
+		if err = saveArchiveCheckpoint(checkpointPath, &checkpoint, options.Progress); err != nil {
+			return restored, err
+		}
+	}
+
+	return restored, nil
+}
+
+// ArchiveDefaults fills in the options left unset and returns the checkpoint's path.
+func archiveDefaults(options *ArchiveOptions, path, checkpointSuffix string) (*ArchiveOptions, string) {
+
+	filled := ArchiveOptions{}
+	if options != nil {
+		filled = *options
+	}
+
+	if filled.BatchSize <= 0 {
+		filled.BatchSize = defaultArchiveBatchSize
+	}
+
+	if filled.ConfirmTimeout <= 0 {
+		filled.ConfirmTimeout = defaultMoveConfirmTimeout
+	}
+
+	if filled.CheckpointPath == "" {
+		filled.CheckpointPath = path + checkpointSuffix
+	}
+
+	return &filled, filled.CheckpointPath
+}
+
+// LoadArchiveCheckpoint reads the checkpoint at path, a missing checkpoint starts from the beginning.
+func loadArchiveCheckpoint(path string) (ArchiveCheckpoint, error) {
+
+	checkpoint := ArchiveCheckpoint{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+
+	if err != nil {
+		return checkpoint, err
+	}
+
+	if err = json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("can't read archive checkpoint %s: %w", path, err)
+	}
+
+	return checkpoint, nil
+}
+
+// SaveArchiveCheckpoint replaces the checkpoint at path through a rename, so it's never half written.
+func saveArchiveCheckpoint(path string, checkpoint *ArchiveCheckpoint, progress func(ArchiveCheckpoint)) error {
+
+	checkpoint.Updated = time.Now()
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	if progress != nil {
+		progress(*checkpoint)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package topology
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchivedMessageRoundTrip(t *testing.T) {
+
+	delivery := &amqp.Delivery{
+		Exchange:      "Orders",
+		RoutingKey:    "orders.created",
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		Priority:      3,
+		CorrelationId: "order-42",
+		MessageId:     "m-1",
+		Headers:       amqp.Table{"x-tenant-id": "acme"},
+		Body:          []byte(`{"id":42}`),
+	}
+
+	line, err := json.Marshal(NewArchivedMessage("OrdersQueue", delivery, time.Now()))
+	assert.NoError(t, err)
+
+	message := &ArchivedMessage{}
+	assert.NoError(t, json.Unmarshal(line, message))
+	assert.Equal(t, "OrdersQueue", message.Queue)
+	assert.Equal(t, "orders.created", message.RoutingKey)
+
+	publishing := message.Publishing()
+	assert.Equal(t, delivery.Body, publishing.Body)
+	assert.Equal(t, delivery.CorrelationId, publishing.CorrelationId)
+	assert.Equal(t, delivery.Priority, publishing.Priority)
+	assert.Equal(t, "acme", publishing.Headers["x-tenant-id"])
+}
+
+func TestArchiveCheckpoint(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "archive")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "orders.archive")
+	options, checkpointPath := archiveDefaults(nil, path, ".checkpoint")
+	assert.Equal(t, path+".checkpoint", checkpointPath)
+	assert.Equal(t, defaultArchiveBatchSize, options.BatchSize)
+
+	checkpoint, err := loadArchiveCheckpoint(checkpointPath)
+	assert.NoError(t, err) // a missing checkpoint starts from the beginning
+	assert.Equal(t, int64(0), checkpoint.Offset)
+
+	var progressed ArchiveCheckpoint
+	checkpoint = ArchiveCheckpoint{Queue: "OrdersQueue", Offset: 2048, Messages: 10}
+	assert.NoError(t, saveArchiveCheckpoint(checkpointPath, &checkpoint, func(cp ArchiveCheckpoint) { progressed = cp }))
+	assert.Equal(t, 10, progressed.Messages)
+
+	loaded, err := loadArchiveCheckpoint(checkpointPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "OrdersQueue", loaded.Queue)
+	assert.Equal(t, int64(2048), loaded.Offset)
+	assert.Equal(t, 10, loaded.Messages)
+}
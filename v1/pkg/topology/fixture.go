@@ -0,0 +1,240 @@
+package topology
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/utils"
+	"github.com/streadway/amqp"
+)
+
+// Fixture builds a uniquely prefixed copy of a TopologyConfig for integration tests, so tests sharing a broker
+// (or running in parallel) never collide on names. Every exchange and queue in the config is renamed with the
+// prefix, along with the bindings and dead letter exchanges pointing at them. Everything is declared
+// (never passive) and deleted again on Teardown.
+// Policies and Parameters are server wide and left out of the copy.
+type Fixture struct {
+	Prefix    string
+	Topology  *models.TopologyConfig // the prefixed copy
+	topologer *Topologer
+	names     map[string]string
+}
+
+// NewFixture creates a new Fixture for the TopologyConfig with a unique prefix.
+func NewFixture(topologer *Topologer, config *models.TopologyConfig) (*Fixture, error) {
+
+	if topologer == nil {
+		return nil, errors.New("can't create a fixture without a topologer")
+	}
+
+	if config == nil {
+		return nil, errors.New("can't create a fixture without a topology config")
+	}
+
+	prefix := models.GetNamer().TemporaryName("tcr") + "."
+	topology, names := prefixTopology(prefix, config)
+
+	return &Fixture{
+		Prefix:    prefix,
+		Topology:  topology,
+		topologer: topologer,
+		names:     names,
+	}, nil
+}
+
+// NewFixtureFromFile creates a new Fixture from a TopologyConfig JSON file.
+func NewFixtureFromFile(topologer *Topologer, fileNamePath string) (*Fixture, error) {
+
+	config, err := utils.ConvertJSONFileToTopologyConfig(fileNamePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFixture(topologer, config)
+}
+
+// Name returns the prefixed name of an exchange or queue from the original config.
+// Names not in the config are returned as is.
+func (fix *Fixture) Name(original string) string {
+	if name, ok := fix.names[original]; ok {
+		return name
+	}
+
+	return original
+}
+
+// Setup builds the prefixed topology - stops on first error.
+func (fix *Fixture) Setup() error {
+	return fix.topologer.BuildToplogy(fix.Topology, false)
+}
+
+// SeedQueue publishes the bodies (persistent) straight to the prefixed queue through the default exchange.
+func (fix *Fixture) SeedQueue(queueName string, bodies ...[]byte) error {
+
+	chanHost, err := fix.topologer.channelPool.GetChannel()
+	if err != nil {
+		return err
+	}
+
+	defer fix.topologer.channelPool.ReturnChannel(chanHost, false)
+
+	for _, body := range bodies {
+		err = chanHost.Channel.Publish("", fix.Name(queueName), false, false, amqp.Publishing{
+			Body:         body,
+			DeliveryMode: amqp.Persistent,
+		})
+		if err != nil {
+			fix.topologer.channelPool.FlagChannel(chanHost.ChannelID)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Teardown deletes every prefixed queue and exchange, continuing past errors and returning the first one.
+func (fix *Fixture) Teardown() error {
+
+	var firstErr error
+	for _, queue := range fix.Topology.Queues {
+		if _, err := fix.topologer.QueueDelete(queue.Name, false, false, false); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, exchange := range fix.Topology.ConsistentHashExchanges {
+		if err := fix.topologer.ExchangeDelete(exchange.Name, false, false); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, exchange := range fix.Topology.Exchanges {
+		if err := fix.topologer.ExchangeDelete(exchange.Name, false, false); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Run sets up the topology, runs the test, and always tears down. The test's error wins over a teardown error.
+func (fix *Fixture) Run(test func(fix *Fixture) error) error {
+
+	if err := fix.Setup(); err != nil {
+		teardownErr := fix.Teardown()
+		if teardownErr != nil {
+			return fmt.Errorf("fixture setup failed: %s (teardown: %s)", err, teardownErr)
+		}
+
+		return err
+	}
+
+	testErr := test(fix)
+	teardownErr := fix.Teardown()
+
+	if testErr != nil {
+		return testErr
+	}
+
+	return teardownErr
+}
+
+// PrefixTopology copies the config with every exchange and queue name prefixed, returning the copy and the renames.
+func prefixTopology(prefix string, config *models.TopologyConfig) (*models.TopologyConfig, map[string]string) {
+
+	names := make(map[string]string)
+	for _, exchange := range config.Exchanges {
+		names[exchange.Name] = prefixName(prefix, exchange.Name)
+	}
+
+	for _, exchange := range config.ConsistentHashExchanges {
+		names[exchange.Name] = prefixName(prefix, exchange.Name)
+	}
+
+	for _, queue := range config.Queues {
+		names[queue.Name] = prefixName(prefix, queue.Name)
+	}
+
+	rename := func(name string) string {
+		if renamed, ok := names[name]; ok {
+			return renamed
+		}
+
+		return name
+	}
+
+	topology := &models.TopologyConfig{}
+	for _, exchange := range config.Exchanges {
+		copied := *exchange
+		copied.Name = rename(exchange.Name)
+		copied.PassiveDeclare = false
+		copied.Args = renameArgs(exchange.Args, rename)
+		topology.Exchanges = append(topology.Exchanges, &copied)
+	}
+
+	for _, queue := range config.Queues {
+		copied := *queue
+		copied.Name = rename(queue.Name)
+		copied.PassiveDeclare = false
+		copied.Args = renameArgs(queue.Args, rename)
+		topology.Queues = append(topology.Queues, &copied)
+	}
+
+	for _, binding := range config.QueueBindings {
+		copied := *binding
+		copied.QueueName = rename(binding.QueueName)
+		copied.ExchangeName = rename(binding.ExchangeName)
+		topology.QueueBindings = append(topology.QueueBindings, &copied)
+	}
+
+	for _, binding := range config.ExchangeBindings {
+		copied := *binding
+		copied.ExchangeName = rename(binding.ExchangeName)
+		copied.ParentExchangeName = rename(binding.ParentExchangeName)
+		topology.ExchangeBindings = append(topology.ExchangeBindings, &copied)
+	}
+
+	for _, exchange := range config.ConsistentHashExchanges {
+		copied := *exchange
+		copied.Name = rename(exchange.Name)
+		copied.Bindings = nil
+		for _, binding := range exchange.Bindings {
+			copied.Bindings = append(copied.Bindings, &models.WeightedBinding{
+				QueueName: rename(binding.QueueName),
+				Weight:    binding.Weight,
+			})
+		}
+		topology.ConsistentHashExchanges = append(topology.ConsistentHashExchanges, &copied)
+	}
+
+	return topology, names
+}
+
+// PrefixName leaves the default exchange and the reserved amq.* names alone.
+func prefixName(prefix, name string) string {
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return name
+	}
+
+	return prefix + name
+}
+
+// RenameArgs copies the args, pointing dead letter exchanges at their prefixed names.
+func renameArgs(args amqp.Table, rename func(string) string) amqp.Table {
+	if args == nil {
+		return nil
+	}
+
+	copied := make(amqp.Table, len(args))
+	for key, value := range args {
+		copied[key] = value
+	}
+
+	if exchange, ok := copied["x-dead-letter-exchange"].(string); ok {
+		copied["x-dead-letter-exchange"] = rename(exchange)
+	}
+
+	return copied
+}
@@ -0,0 +1,76 @@
+package topology
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/utils"
+)
+
+func TestFixturePrefixTopology(t *testing.T) {
+
+	config, err := utils.ConvertJSONFileToTopologyConfig("../tests/testtopology.json")
+	assert.NoError(t, err)
+
+	config.Queues[0].Args = map[string]interface{}{"x-dead-letter-exchange": "MyTestExchangeRoot"}
+
+	topology, names := prefixTopology("tcr-test.", config)
+	assert.Equal(t, len(config.Exchanges)+len(config.Queues), len(names))
+
+	for _, exchange := range topology.Exchanges {
+		assert.True(t, strings.HasPrefix(exchange.Name, "tcr-test."))
+		assert.False(t, exchange.PassiveDeclare)
+	}
+
+	for _, queue := range topology.Queues {
+		assert.True(t, strings.HasPrefix(queue.Name, "tcr-test."))
+		assert.False(t, queue.PassiveDeclare)
+	}
+
+	for _, binding := range topology.QueueBindings {
+		assert.True(t, strings.HasPrefix(binding.QueueName, "tcr-test."))
+		assert.True(t, strings.HasPrefix(binding.ExchangeName, "tcr-test."))
+	}
+
+	for _, binding := range topology.ExchangeBindings {
+		assert.True(t, strings.HasPrefix(binding.ExchangeName, "tcr-test."))
+		assert.True(t, strings.HasPrefix(binding.ParentExchangeName, "tcr-test."))
+	}
+
+	assert.Equal(t, "tcr-test.MyTestExchangeRoot", topology.Queues[0].Args["x-dead-letter-exchange"])
+
+	// the original is untouched
+	assert.Equal(t, "MyTestExchangeRoot", config.Queues[0].Args["x-dead-letter-exchange"])
+	assert.True(t, config.Queues[0].PassiveDeclare)
+}
+
+func TestFixturePrefixTopologyReservedNames(t *testing.T) {
+
+	config := &models.TopologyConfig{
+		QueueBindings: []*models.QueueBinding{
+			{QueueName: "TcrTestQueue", ExchangeName: "amq.topic"},
+		},
+		Queues: []*models.Queue{{Name: "TcrTestQueue"}},
+	}
+
+	topology, _ := prefixTopology("tcr-test.", config)
+	assert.Equal(t, "tcr-test.TcrTestQueue", topology.QueueBindings[0].QueueName)
+	assert.Equal(t, "amq.topic", topology.QueueBindings[0].ExchangeName)
+}
+
+func TestFixturePrefixTopologyKeepsExpires(t *testing.T) {
+
+	config := &models.TopologyConfig{
+		Queues: []*models.Queue{{Name: "TcrSessionQueue", Expires: 60000, Args: map[string]interface{}{"x-max-length": 10}}},
+	}
+
+	topology, _ := prefixTopology("tcr-test.", config)
+
+	args := topology.Queues[0].Arguments()
+	assert.Equal(t, int64(60000), args[models.QueueExpiresArg])
+	assert.Equal(t, 10, args["x-max-length"])
+	assert.NotContains(t, config.Queues[0].Args, models.QueueExpiresArg) // the Args are left untouched
+}
@@ -0,0 +1,130 @@
+package topology
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// QueueKeepAlive touches (passively declares) queues on an interval so queues with an x-expires (i.e. per-session
+// reply queues) aren't deleted while idle but still wanted. The interval needs to be well under the queues' expiry.
+// A queue that has already expired (or was deleted) can't be revived, it's reported on Errors() and dropped.
+type QueueKeepAlive struct {
+	topologer *Topologer
+	interval  time.Duration
+	queues    map[string]struct{}
+	errors    chan error
+	stop      chan struct{}
+	stopOnce  *sync.Once
+	aliveLock *sync.Mutex
+}
+
+// NewQueueKeepAlive starts touching the queues every interval until Stop is called, more can be added (and removed)
+// at any time.
+func (top *Topologer) NewQueueKeepAlive(interval time.Duration, queueNames ...string) (*QueueKeepAlive, error) {
+
+	if interval <= 0 {
+		return nil, errors.New("keep alive interval must be greater than 0")
+	}
+
+	qka := &QueueKeepAlive{
+		topologer: top,
+		interval:  interval,
+		queues:    make(map[string]struct{}, len(queueNames)),
+		errors:    make(chan error, 10),
+		stop:      make(chan struct{}),
+		stopOnce:  &sync.Once{},
+		aliveLock: &sync.Mutex{},
+	}
+
+	for _, queueName := range queueNames {
+		qka.queues[queueName] = struct{}{}
+	}
+
+	models.Go("topology.keepalive", qka.run)
+
+	return qka, nil
+}
+
+// Add keeps the queue alive from the next interval on.
+func (qka *QueueKeepAlive) Add(queueName string) {
+	qka.aliveLock.Lock()
+	defer qka.aliveLock.Unlock()
+
+	qka.queues[queueName] = struct{}{}
+}
+
+// Remove stops keeping the queue alive, it expires as configured once unused.
+func (qka *QueueKeepAlive) Remove(queueName string) {
+	qka.aliveLock.Lock()
+	defer qka.aliveLock.Unlock()
+
+	delete(qka.queues, queueName)
+}
+
+// Queues returns the names of the queues being kept alive.
+func (qka *QueueKeepAlive) Queues() []string {
+	qka.aliveLock.Lock()
+	defer qka.aliveLock.Unlock()
+
+	queueNames := make([]string, 0, len(qka.queues))
+	for queueName := range qka.queues {
+		queueNames = append(queueNames, queueName)
+	}
+
+	return queueNames
+}
+
+// Errors yields the queues that couldn't be touched, dropped when not drained.
+func (qka *QueueKeepAlive) Errors() <-chan error {
+	return qka.errors
+}
+
+// Stop stops touching the queues, safe to call more than once.
+func (qka *QueueKeepAlive) Stop() {
+	qka.stopOnce.Do(func() { close(qka.stop) })
+}
+
+func (qka *QueueKeepAlive) run() {
+	ticker := time.NewTicker(qka.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-qka.stop:
+			return
+		case <-ticker.C:
+			for _, queueName := range qka.Queues() {
+				qka.touch(queueName)
+			}
+		}
+	}
+}
+
+// Touch passively declares the queue, renewing its expiry. A missing queue is dropped.
+func (qka *QueueKeepAlive) touch(queueName string) {
+
+	chanHost, err := qka.topologer.channelPool.GetChannel()
+	if err != nil {
+		qka.handleError(err)
+		return
+	}
+
+	defer qka.topologer.channelPool.ReturnChannel(chanHost, false)
+
+	if _, err = chanHost.Channel.QueueInspect(queueName); err != nil {
+		qka.topologer.channelPool.FlagChannel(chanHost.ChannelID)
+		qka.Remove(queueName)
+		qka.handleError(fmt.Errorf("queue %s can't be kept alive, dropped: %s", queueName, err))
+	}
+}
+
+func (qka *QueueKeepAlive) handleError(err error) {
+	select {
+	case qka.errors <- err:
+	default:
+	}
+}
@@ -0,0 +1,264 @@
+package topology
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
+)
+
+const defaultLocalityRefresh = 30 * time.Second
+
+// QueueLocator learns through the management API which cluster node hosts each queue (a classic queue's master,
+// a quorum queue's or stream's leader) and the AMQP address to reach that node on. Answers are trusted for the
+// refresh interval, so a leader moving after a failover is picked up by the next lookup after that.
+type QueueLocator struct {
+	management    *ManagementClient
+	nodeAddresses map[string]string // configured addresses, win over the listeners
+	refresh       time.Duration
+	queues        map[string]*located
+	listeners     []*models.ListenerInfo
+	listenedAt    time.Time
+	locatorLock   *sync.Mutex
+}
+
+type located struct {
+	node      string
+	locatedAt time.Time
+}
+
+// NewQueueLocator creates a QueueLocator. NodeAddresses maps node names (i.e. rabbit@node-1) to their AMQP
+// host:port, for nodes whose names don't resolve from the client, a zero refresh is 30 seconds.
+func NewQueueLocator(management *ManagementClient, nodeAddresses map[string]string, refresh time.Duration) *QueueLocator {
+	if refresh <= 0 {
+		refresh = defaultLocalityRefresh
+	}
+
+	return &QueueLocator{
+		management:    management,
+		nodeAddresses: nodeAddresses,
+		refresh:       refresh,
+		queues:        make(map[string]*located),
+		locatorLock:   &sync.Mutex{},
+	}
+}
+
+// Node returns the name of the node hosting the queue in the management client's default vhost.
+func (ql *QueueLocator) Node(queueName string) (string, error) {
+
+	ql.locatorLock.Lock()
+	if entry, ok := ql.queues[queueName]; ok && time.Since(entry.locatedAt) < ql.refresh {
+		ql.locatorLock.Unlock()
+		return entry.node, nil
+	}
+	ql.locatorLock.Unlock()
+
+	queue, err := ql.management.GetQueue("", queueName)
+	if err != nil {
+		return "", err
+	}
+
+	node := queue.HostNode()
+	if node == "" {
+		return "", fmt.Errorf("can't locate queue %s, the management api reported no node", queueName)
+	}
+
+	ql.locatorLock.Lock()
+	ql.queues[queueName] = &located{node: node, locatedAt: time.Now()}
+	ql.locatorLock.Unlock()
+
+	return node, nil
+}
+
+// Address returns the host:port to reach the node's AMQP listener (its amqp/ssl listener with tls) on: the
+// configured address, else the listener's own address, else the host part of the node name with the listener's
+// port (listeners on every interface report a wildcard address), else with the fallback port.
+func (ql *QueueLocator) Address(node string, tls bool, fallbackPort string) (string, error) {
+
+	if address, ok := ql.nodeAddresses[node]; ok {
+		return address, nil
+	}
+
+	protocol := "amqp"
+	if tls {
+		protocol = "amqp/ssl"
+	}
+
+	listeners, err := ql.nodeListeners()
+	if err != nil {
+		return "", err
+	}
+
+	host := node
+	if i := strings.LastIndex(node, "@"); i >= 0 {
+		host = node[i+1:]
+	}
+
+	if host == "" {
+		return "", fmt.Errorf("can't tell the host of node %s", node)
+	}
+
+	for _, listener := range listeners {
+		if listener.Node != node || listener.Protocol != protocol {
+			continue
+		}
+
+		if ip := net.ParseIP(listener.IPAddress); ip != nil && !ip.IsUnspecified() {
+			host = listener.IPAddress
+		}
+
+		return net.JoinHostPort(host, strconv.Itoa(listener.Port)), nil
+	}
+
+	if fallbackPort == "" {
+		return "", fmt.Errorf("can't find an %s listener on node %s", protocol, node)
+	}
+
+	return net.JoinHostPort(host, fallbackPort), nil
+}
+
+// NodeListeners returns the cluster's listeners, asking the management API again once they're older than refresh.
+func (ql *QueueLocator) nodeListeners() ([]*models.ListenerInfo, error) {
+
+	ql.locatorLock.Lock()
+	defer ql.locatorLock.Unlock()
+
+	if ql.listeners != nil && time.Since(ql.listenedAt) < ql.refresh {
+		return ql.listeners, nil
+	}
+
+	listeners, err := ql.management.ListListeners()
+	if err != nil {
+		return nil, err
+	}
+
+	ql.listeners = listeners
+	ql.listenedAt = time.Now()
+
+	return listeners, nil
+}
+
+// LocalPools keeps a ChannelPool per cluster node, connected straight to that node instead of the configured
+// (usually load balanced) URI, so a queue's consumers and publishers talk to the node hosting it and their
+// messages don't cross the cluster. A pool is created on the first queue hosted on its node, and every pool
+// uses the PoolConfig with only the URI's host replaced (the credentials and vhost stay).
+type LocalPools struct {
+	locator   *QueueLocator
+	config    models.PoolConfig
+	fallback  *pools.ChannelPool
+	pools     map[string]*pools.ChannelPool // keyed by node
+	poolsLock *sync.Mutex
+}
+
+// NewLocalPools creates LocalPools, fallback is the pool for queues that can't be located.
+func NewLocalPools(locator *QueueLocator, config *models.PoolConfig, fallback *pools.ChannelPool) (*LocalPools, error) {
+
+	if locator == nil || config == nil || config.ConnectionPoolConfig == nil {
+		return nil, errors.New("local pools can't be created without a locator and pool config")
+	}
+
+	return &LocalPools{
+		locator:   locator,
+		config:    *config,
+		fallback:  fallback,
+		pools:     make(map[string]*pools.ChannelPool),
+		poolsLock: &sync.Mutex{},
+	}, nil
+}
+
+// ChannelPoolFor returns the ChannelPool connected to the node hosting the queue. It always returns a usable
+// pool: when the queue can't be located (i.e. it isn't declared yet) or its node can't be dialed, it's the
+// fallback pool, alongside the error saying why.
+func (lp *LocalPools) ChannelPoolFor(queueName string) (*pools.ChannelPool, error) {
+
+	node, err := lp.locator.Node(queueName)
+	if err != nil {
+		return lp.fallback, err
+	}
+
+	lp.poolsLock.Lock()
+	defer lp.poolsLock.Unlock()
+
+	if pool, ok := lp.pools[node]; ok {
+		return pool, nil
+	}
+
+	pool, err := lp.createPool(node)
+	if err != nil {
+		return lp.fallback, fmt.Errorf("can't connect to node %s for queue %s: %w", node, queueName, err)
+	}
+
+	lp.pools[node] = pool
+	return pool, nil
+}
+
+// Nodes returns the nodes with a pool.
+func (lp *LocalPools) Nodes() []string {
+	lp.poolsLock.Lock()
+	defer lp.poolsLock.Unlock()
+
+	nodes := make([]string, 0, len(lp.pools))
+	for node := range lp.pools {
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+// Shutdown shuts every node's pool down, the fallback pool is left to its owner.
+func (lp *LocalPools) Shutdown() {
+	lp.poolsLock.Lock()
+	defer lp.poolsLock.Unlock()
+
+	for node, pool := range lp.pools {
+		pool.Shutdown()
+		delete(lp.pools, node)
+	}
+}
+
+func (lp *LocalPools) createPool(node string) (*pools.ChannelPool, error) {
+
+	connectionConfig := *lp.config.ConnectionPoolConfig
+	uri, port, err := nodeURI(connectionConfig.URI, connectionConfig.EnableTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := lp.locator.Address(node, connectionConfig.EnableTLS, port)
+	if err != nil {
+		return nil, err
+	}
+
+	uri.Host = address
+	connectionConfig.URI = uri.String()
+	if connectionConfig.EnableTLS && !strings.Contains(lp.config.ConnectionPoolConfig.URI, "://") {
+		connectionConfig.URI = strings.TrimPrefix(connectionConfig.URI, "amqps://") // the pool adds the scheme itself
+	}
+	connectionConfig.RotateAddresses = false
+
+	config := lp.config
+	config.ConnectionPoolConfig = &connectionConfig
+
+	return pools.NewChannelPool(&config, nil, true)
+}
+
+// NodeURI parses the configured URI (which may leave the scheme out with tls) and returns its port.
+func nodeURI(configured string, tls bool) (*url.URL, string, error) {
+	if !strings.Contains(configured, "://") && tls {
+		configured = "amqps://" + configured
+	}
+
+	uri, err := url.Parse(configured)
+	if err != nil {
+		return nil, "", fmt.Errorf("can't parse the connection uri: %w", err)
+	}
+
+	return uri, uri.Port(), nil
+}
@@ -0,0 +1,225 @@
+package topology
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+const defaultManagementVHost = "/"
+
+// ManagementClient talks to the RabbitMQ management HTTP API for the topology AMQP can't declare.
+type ManagementClient struct {
+	baseURL    string
+	username   string
+	password   string
+	vhost      string
+	httpClient *http.Client
+}
+
+// NewManagementClient creates a ManagementClient from the ManagementConfig.
+func NewManagementClient(config *models.ManagementConfig) (*ManagementClient, error) {
+
+	if config == nil || config.URI == "" {
+		return nil, errors.New("management config uri can't be empty")
+	}
+
+	if _, err := url.ParseRequestURI(config.URI); err != nil {
+		return nil, err
+	}
+
+	vhost := config.VHost
+	if vhost == "" {
+		vhost = defaultManagementVHost
+	}
+
+	return &ManagementClient{
+		baseURL:  strings.TrimRight(config.URI, "/"),
+		username: config.Username,
+		password: config.Password,
+		vhost:    vhost,
+		httpClient: &http.Client{
+			Timeout: time.Duration(config.Timeout) * time.Second,
+		},
+	}, nil
+}
+
+// PutPolicy creates or updates a policy.
+func (mc *ManagementClient) PutPolicy(policy *models.Policy) error {
+
+	if policy == nil || policy.Name == "" || policy.Pattern == "" {
+		return errors.New("policy name and pattern can't be empty")
+	}
+
+	definition := make(map[string]interface{})
+	if policy.Definition != nil {
+		definition = policy.Definition.ToTable()
+	}
+
+	if len(definition) == 0 {
+		return fmt.Errorf("policy %s has an empty definition", policy.Name)
+	}
+
+	applyTo := policy.ApplyTo
+	if applyTo == "" {
+		applyTo = "all"
+	}
+
+	body := map[string]interface{}{
+		"pattern":    policy.Pattern,
+		"apply-to":   applyTo,
+		"priority":   policy.Priority,
+		"definition": definition,
+	}
+
+	return mc.do(http.MethodPut, mc.path("policies", policy.VHost, policy.Name), body, nil)
+}
+
+// DeletePolicy removes a policy, an empty vhost uses the default vhost.
+func (mc *ManagementClient) DeletePolicy(vhost, name string) error {
+	return mc.do(http.MethodDelete, mc.path("policies", vhost, name), nil, nil)
+}
+
+// PutParameter creates or updates a runtime parameter.
+func (mc *ManagementClient) PutParameter(parameter *models.Parameter) error {
+
+	if parameter == nil || parameter.Component == "" || parameter.Name == "" {
+		return errors.New("parameter component and name can't be empty")
+	}
+
+	body := map[string]interface{}{
+		"value": parameter.Value,
+	}
+
+	return mc.do(
+		http.MethodPut,
+		"/api/parameters/"+url.PathEscape(parameter.Component)+mc.path("", parameter.VHost, parameter.Name),
+		body,
+		nil)
+}
+
+// DeleteParameter removes a runtime parameter, an empty vhost uses the default vhost.
+func (mc *ManagementClient) DeleteParameter(component, vhost, name string) error {
+	return mc.do(
+		http.MethodDelete,
+		"/api/parameters/"+url.PathEscape(component)+mc.path("", vhost, name),
+		nil,
+		nil)
+}
+
+// ListQueues returns every queue in the vhost with its depth, an empty vhost uses the default vhost.
+func (mc *ManagementClient) ListQueues(vhost string) ([]*models.QueueInfo, error) {
+	if vhost == "" {
+		vhost = mc.vhost
+	}
+
+	queues := make([]*models.QueueInfo, 0)
+	if err := mc.do(http.MethodGet, "/api/queues/"+url.PathEscape(vhost), nil, &queues); err != nil {
+		return nil, err
+	}
+
+	return queues, nil
+}
+
+// GetQueue returns a single queue with its arguments and effective policy, an empty vhost uses the default vhost.
+func (mc *ManagementClient) GetQueue(vhost, name string) (*models.QueueInfo, error) {
+
+	queue := &models.QueueInfo{}
+	if err := mc.do(http.MethodGet, mc.path("queues", vhost, name), nil, queue); err != nil {
+		return nil, err
+	}
+
+	return queue, nil
+}
+
+// ListListeners returns the protocol listeners of every node in the cluster.
+func (mc *ManagementClient) ListListeners() ([]*models.ListenerInfo, error) {
+
+	overview := struct {
+		Listeners []*models.ListenerInfo `json:"listeners"`
+	}{}
+
+	if err := mc.do(http.MethodGet, "/api/overview", nil, &overview); err != nil {
+		return nil, err
+	}
+
+	return overview.Listeners, nil
+}
+
+// ListExchangeBindings returns every binding with the exchange as its source, an empty vhost uses the default vhost.
+func (mc *ManagementClient) ListExchangeBindings(vhost, exchange string) ([]*models.BindingInfo, error) {
+
+	bindings := make([]*models.BindingInfo, 0)
+	if err := mc.do(http.MethodGet, mc.path("exchanges", vhost, exchange)+"/bindings/source", nil, &bindings); err != nil {
+		return nil, err
+	}
+
+	return bindings, nil
+}
+
+// Path builds /api/{resource}/{vhost}/{name} (or /{vhost}/{name} without a resource) with escaped segments.
+func (mc *ManagementClient) path(resource, vhost, name string) string {
+	if vhost == "" {
+		vhost = mc.vhost
+	}
+
+	path := "/" + url.PathEscape(vhost) + "/" + url.PathEscape(name)
+	if resource != "" {
+		path = "/api/" + resource + path
+	}
+
+	return path
+}
+
+// Do performs the request, marshalling body and unmarshalling into result when provided.
+func (mc *ManagementClient) do(method, path string, body interface{}, result interface{}) error {
+
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	request, err := http.NewRequest(method, mc.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+
+	request.SetBasicAuth(mc.username, mc.password)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := mc.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("management api %s %s failed [status: %d] %s", method, path, response.StatusCode, string(data))
+	}
+
+	if result != nil && len(data) > 0 {
+		return json.Unmarshal(data, result)
+	}
+
+	return nil
+}
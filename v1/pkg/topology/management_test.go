@@ -0,0 +1,89 @@
+package topology
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+func TestManagementPutPolicy(t *testing.T) {
+
+	var path string
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "guest", user)
+		assert.Equal(t, "guest", pass)
+
+		data, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(data, &body))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client, err := NewManagementClient(&models.ManagementConfig{URI: server.URL, Username: "guest", Password: "guest"})
+	assert.NoError(t, err)
+
+	err = client.PutPolicy(&models.Policy{
+		Name:    "TcrTestPolicy",
+		Pattern: "^TcrTest",
+		ApplyTo: "queues",
+		Definition: &models.PolicyDefinition{
+			HAMode:     "all",
+			MessageTTL: 60000,
+			MaxLength:  1000,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "/api/policies/%2F/TcrTestPolicy", path)
+	assert.Equal(t, "queues", body["apply-to"])
+
+	definition := body["definition"].(map[string]interface{})
+	assert.Equal(t, "all", definition["ha-mode"])
+	assert.Equal(t, float64(60000), definition["message-ttl"])
+	assert.Equal(t, float64(1000), definition["max-length"])
+}
+
+func TestManagementPutParameter(t *testing.T) {
+
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, err := NewManagementClient(&models.ManagementConfig{URI: server.URL, VHost: "tenant"})
+	assert.NoError(t, err)
+
+	err = client.PutParameter(&models.Parameter{
+		Component: "federation-upstream",
+		Name:      "upstream-1",
+		Value:     map[string]interface{}{"uri": "amqp://remote"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "/api/parameters/federation-upstream/tenant/upstream-1", path)
+}
+
+func TestManagementErrorStatus(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client, err := NewManagementClient(&models.ManagementConfig{URI: server.URL})
+	assert.NoError(t, err)
+
+	assert.Error(t, client.DeletePolicy("", "TcrTestPolicy"))
+	assert.Error(t, client.PutPolicy(&models.Policy{Name: "Empty", Pattern: ".*"}))
+}
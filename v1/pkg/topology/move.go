@@ -0,0 +1,186 @@
+package topology
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+const defaultMoveConfirmTimeout = time.Duration(5) * time.Second
+
+// MoveTransform rewrites a message on its way to the destination queue, i.e. to migrate its schema.
+// An error stops the move, leaving that message (and the rest) in the source queue.
+type MoveTransform func(delivery *amqp.Delivery) (amqp.Publishing, error)
+
+// MoveProgress is how far a move has come.
+type MoveProgress struct {
+	Moved   int
+	Elapsed time.Duration
+}
+
+// MoveOptions tune MoveMessages, nil uses the defaults.
+type MoveOptions struct {
+	Rate           float64            // messages moved per second, 0 is unlimited
+	ConfirmTimeout time.Duration      // wait for each publisher confirm, 0 is 5 seconds
+	ProgressEvery  int                // moved messages between Progress calls, 0 is 100
+	Progress       func(MoveProgress) // also called once the move ends
+}
+
+// PublishingFromDelivery copies the delivery's body and properties so a moved message is unchanged.
+func PublishingFromDelivery(delivery *amqp.Delivery) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         delivery.Headers,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    delivery.DeliveryMode,
+		Priority:        delivery.Priority,
+		CorrelationId:   delivery.CorrelationId,
+		ReplyTo:         delivery.ReplyTo,
+		Expiration:      delivery.Expiration,
+		MessageId:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		UserId:          delivery.UserId,
+		AppId:           delivery.AppId,
+		Body:            delivery.Body,
+	}
+}
+
+// MoveMessages moves up to n messages (0 moves until the source is empty) from the src queue to the dst queue,
+// through the transform when not nil. Each message is published to dst (default exchange, mandatory) and only
+// acked off src once the broker confirmed the copy, so a failure at any point leaves the message in src: at worst
+// the last message is in both queues. Returns how many messages were moved.
+func (top *Topologer) MoveMessages(src, dst string, n int, transform MoveTransform, options *MoveOptions) (int, error) {
+
+	if src == "" || dst == "" {
+		return 0, errors.New("can't move messages without a source and destination queue")
+	}
+
+	if src == dst {
+		return 0, errors.New("can't move messages into the queue they came from")
+	}
+
+	if options == nil {
+		options = &MoveOptions{}
+	}
+
+	confirmTimeout := options.ConfirmTimeout
+	if confirmTimeout <= 0 {
+		confirmTimeout = defaultMoveConfirmTimeout
+	}
+
+	progressEvery := options.ProgressEvery
+	if progressEvery <= 0 {
+		progressEvery = 100
+	}
+
+	chanHost, err := top.channelPool.GetChannel()
+	if err != nil {
+		return 0, err
+	}
+
+	// confirm mode can't be turned off, so the channel is closed and replaced afterwards,
+	// closing also requeues a message that was got but not acked
+	defer func() {
+		_ = chanHost.Close()
+		top.channelPool.ReturnChannel(chanHost, true)
+	}()
+
+	started := time.Now()
+	err = chanHost.Channel.Confirm(false)
+	models.Trace("confirm.select", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s", src)
+	if err != nil {
+		return 0, err
+	}
+
+	confirms := chanHost.Channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	returns := chanHost.Channel.NotifyReturn(make(chan amqp.Return, 1))
+
+	start := time.Now()
+	moved := 0
+	report := func() {
+		if options.Progress != nil {
+			options.Progress(MoveProgress{Moved: moved, Elapsed: time.Since(start)})
+		}
+	}
+	defer report()
+
+	for n == 0 || moved < n {
+		if options.Rate > 0 {
+			next := start.Add(time.Duration(float64(moved) / options.Rate * float64(time.Second)))
+			time.Sleep(time.Until(next))
+		}
+
+		started := time.Now()
+		delivery, ok, err := chanHost.Channel.Get(src, false)
+		models.Trace("basic.get", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, autoack: false, empty: %t", src, !ok)
+		if err != nil {
+			return moved, err
+		}
+
+		if !ok {
+			break // source is empty
+		}
+
+		publishing := PublishingFromDelivery(&delivery)
+		if transform != nil {
+			if publishing, err = transform(&delivery); err != nil {
+				return moved, fmt.Errorf("can't transform message %d: %w", moved+1, err)
+			}
+		}
+
+		started = time.Now()
+		err = chanHost.Channel.Publish("", dst, true, false, publishing)
+		models.Trace("publish", chanHost.ConnectionID, chanHost.ChannelID, started, err, "routing key: %s, bytes: %d", dst, len(publishing.Body))
+		if err != nil {
+			return moved, err
+		}
+
+		if err = waitForMoveConfirm(confirms, returns, confirmTimeout); err != nil {
+			return moved, err
+		}
+
+		started = time.Now()
+		err = delivery.Ack(false)
+		models.Trace("ack", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, delivery tag: %d, multiple: false", src, delivery.DeliveryTag)
+		if err != nil {
+			return moved, fmt.Errorf("%w (the last message may be in both queues)", err)
+		}
+
+		moved++
+		if moved%progressEvery == 0 {
+			report()
+		}
+	}
+
+	return moved, nil
+}
+
+// WaitForMoveConfirm waits for the copy's confirm, a return (no such queue) arrives before its confirm.
+func waitForMoveConfirm(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case confirmation, ok := <-confirms:
+		if !ok {
+			return errors.New("channel closed before the moved message was confirmed")
+		}
+
+		if !confirmation.Ack {
+			return errors.New("moved message was nacked by the server")
+		}
+	case <-timer.C:
+		return errors.New("timed out waiting for the moved message to be confirmed")
+	}
+
+	select {
+	case returned := <-returns:
+		return fmt.Errorf("moved message was returned: %s", returned.ReplyText)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,58 @@
+package topology
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+func TestNamingPolicy(t *testing.T) {
+
+	naming, err := models.NewNamingPolicy(&models.NamingPolicyConfig{
+		Exchanges: &models.NamingRule{Prefixes: []string{"orders."}},
+		Queues:    &models.NamingRule{Prefixes: []string{"orders."}, Pattern: `^[a-z0-9.-]+$`},
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, naming.CheckExchange("orders.events"))
+	assert.NoError(t, naming.CheckExchange(""))          // default exchange
+	assert.NoError(t, naming.CheckExchange("amq.topic")) // built in
+	assert.NoError(t, naming.CheckQueue("orders.created-v2"))
+
+	err = naming.CheckQueue("billing.invoices")
+	violation, ok := err.(*models.NamingViolation)
+	assert.True(t, ok)
+	assert.Equal(t, "queue", violation.Kind)
+
+	assert.Error(t, naming.CheckQueue("orders.Created")) // pattern
+
+	// a topologer with the policy rejects before touching the broker
+	top := &Topologer{}
+	top.SetNamingPolicy(naming)
+	assert.Error(t, top.CreateQueueFromConfig(&models.Queue{Name: "billing.invoices"}))
+	assert.Error(t, top.CreateExchange("billing", "topic", false, true, false, false, false, nil))
+
+	var nilPolicy *models.NamingPolicy
+	assert.NoError(t, nilPolicy.CheckQueue("anything"))
+}
+
+func TestNamingPolicyWarnOnly(t *testing.T) {
+
+	naming, err := models.NewNamingPolicy(&models.NamingPolicyConfig{
+		Exchanges: &models.NamingRule{Pattern: `^orders\.`},
+		WarnOnly:  true,
+	})
+	assert.NoError(t, err)
+
+	violations := make([]*models.NamingViolation, 0)
+	naming.OnViolation(func(violation *models.NamingViolation) { violations = append(violations, violation) })
+
+	assert.NoError(t, naming.CheckExchange("billing"))
+	assert.Equal(t, 1, len(violations))
+	assert.Equal(t, "billing", violations[0].Name)
+
+	_, err = models.NewNamingPolicy(&models.NamingPolicyConfig{Queues: &models.NamingRule{Pattern: "("}})
+	assert.Error(t, err)
+}
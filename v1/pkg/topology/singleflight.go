@@ -0,0 +1,117 @@
+package topology
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/streadway/amqp"
+)
+
+// DeclareGroup runs one queue declare per identical declaration at a time, callers arriving while it's in
+// flight wait for it and share its result instead of sending their own (singleflight).
+type declareGroup struct {
+	flights   map[string]*declareFlight
+	shared    uint64
+	groupLock *sync.Mutex
+}
+
+type declareFlight struct {
+	done     chan struct{}
+	declared amqp.Queue
+	err      error
+}
+
+func newDeclareGroup() *declareGroup {
+	return &declareGroup{
+		flights:   make(map[string]*declareFlight),
+		groupLock: &sync.Mutex{},
+	}
+}
+
+// Do runs the declare unless an identical one is in flight, returns true when the result was shared.
+func (dg *declareGroup) do(key string, declare func() (amqp.Queue, error)) (amqp.Queue, error, bool) {
+
+	dg.groupLock.Lock()
+	if flight, ok := dg.flights[key]; ok {
+		dg.groupLock.Unlock()
+		atomic.AddUint64(&dg.shared, 1)
+
+		<-flight.done
+		return flight.declared, flight.err, true
+	}
+
+	flight := &declareFlight{done: make(chan struct{})}
+	dg.flights[key] = flight
+	dg.groupLock.Unlock()
+
+	defer func() {
+		dg.groupLock.Lock()
+		delete(dg.flights, key)
+		dg.groupLock.Unlock()
+
+		close(flight.done)
+	}()
+
+	flight.declared, flight.err = declare()
+
+	return flight.declared, flight.err, false
+}
+
+// DeclareKey identifies the declaration, only declares of the same queue with the same properties and
+// arguments share a flight. Map arguments print in key order, so equal tables give equal keys.
+func declareKey(queue *models.Queue, noWait bool) string {
+	return fmt.Sprintf(
+		"%s\x00%t/%t/%t/%t/%t\x00%v",
+		queue.Name, queue.PassiveDeclare, queue.Durable, queue.AutoDelete, queue.Exclusive, noWait, queue.Arguments())
+}
+
+// SharedDeclares returns how many queue declares were answered by an identical declare already in flight.
+func (top *Topologer) SharedDeclares() uint64 {
+	return atomic.LoadUint64(&top.declares.shared)
+}
+
+// DeclareQueueOnce declares the queue (passively when set) on a pooled channel, identical concurrent declares
+// share a single trip to the server. Server named and exclusive queues are always declared by the caller,
+// the first is a new queue every time and the second belongs to the declaring connection.
+func (top *Topologer) declareQueueOnce(queue *models.Queue, noWait bool) (amqp.Queue, error) {
+
+	declare := func() (amqp.Queue, error) {
+		chanHost, err := top.channelPool.GetChannel()
+		if err != nil {
+			return amqp.Queue{}, err
+		}
+
+		defer top.channelPool.ReturnChannel(chanHost, false)
+
+		var declared amqp.Queue
+		started := time.Now()
+		if queue.PassiveDeclare {
+			declared, err = chanHost.Channel.QueueDeclarePassive(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, noWait, queue.Arguments())
+		} else {
+			declared, err = chanHost.Channel.QueueDeclare(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, noWait, queue.Arguments())
+		}
+		models.Trace("queue.declare", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, passive: %t", queue.Name, queue.PassiveDeclare)
+
+		if err != nil {
+			top.channelPool.FlagChannel(chanHost.ChannelID)
+			return amqp.Queue{}, err
+		}
+
+		if !queue.PassiveDeclare {
+			auditTopology("", declared.Name, "queue declared")
+		}
+
+		return declared, nil
+	}
+
+	if queue.Name == "" || queue.Exclusive {
+		return declare()
+	}
+
+	declared, err, _ := top.declares.do(declareKey(queue, noWait), declare)
+
+	return declared, err
+}
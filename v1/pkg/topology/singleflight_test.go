@@ -0,0 +1,76 @@
+package topology
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+func TestDeclareGroupSharesInFlightDeclares(t *testing.T) {
+
+	group := newDeclareGroup()
+	release := make(chan struct{})
+	var declares int32
+
+	declare := func() (amqp.Queue, error) {
+		atomic.AddInt32(&declares, 1)
+		<-release
+		return amqp.Queue{Name: "OrdersQueue", Consumers: 1}, nil
+	}
+
+	results := make(chan amqp.Queue, 80)
+	started := &sync.WaitGroup{}
+	finished := &sync.WaitGroup{}
+	for i := 0; i < 80; i++ {
+		started.Add(1)
+		finished.Add(1)
+		go func() {
+			defer finished.Done()
+			started.Done()
+
+			declared, err, _ := group.do("OrdersQueue", declare)
+			assert.NoError(t, err)
+			results <- declared
+		}()
+	}
+
+	started.Wait()
+	for atomic.LoadUint64(&group.shared)+uint64(atomic.LoadInt32(&declares)) < 80 { // every caller joined the flight
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	finished.Wait()
+	close(results)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&declares))
+	assert.Equal(t, uint64(79), group.shared)
+	for declared := range results {
+		assert.Equal(t, "OrdersQueue", declared.Name)
+	}
+
+	// once finished, the next declare goes to the server again
+	failed := errors.New("channel closed")
+	_, err, shared := group.do("OrdersQueue", func() (amqp.Queue, error) { return amqp.Queue{}, failed })
+	assert.Equal(t, failed, err)
+	assert.False(t, shared)
+}
+
+func TestDeclareKey(t *testing.T) {
+
+	queue := &models.Queue{Name: "OrdersQueue", Durable: true, Args: amqp.Table{"x-queue-type": "quorum", "x-max-length": int64(10)}}
+	same := &models.Queue{Name: "OrdersQueue", Durable: true, Args: amqp.Table{"x-max-length": int64(10), "x-queue-type": "quorum"}}
+	passive := &models.Queue{Name: "OrdersQueue", Durable: true, PassiveDeclare: true, Args: queue.Args}
+	expiring := &models.Queue{Name: "OrdersQueue", Durable: true, Expires: 60000, Args: queue.Args}
+
+	assert.Equal(t, declareKey(queue, false), declareKey(same, false))
+	assert.NotEqual(t, declareKey(queue, false), declareKey(queue, true))
+	assert.NotEqual(t, declareKey(queue, false), declareKey(passive, false))
+	assert.NotEqual(t, declareKey(queue, false), declareKey(expiring, false))
+}
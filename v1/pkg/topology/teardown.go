@@ -0,0 +1,254 @@
+package topology
+
+import (
+	"fmt"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/utils"
+)
+
+// TeardownOptions are the safety flags for tearing down a topology.
+type TeardownOptions struct {
+	OnlyIfEmpty bool // keep queues still holding messages, along with their bindings and the exchanges they're bound to
+	DryRun      bool // report the plan without changing anything (queues are still inspected for OnlyIfEmpty)
+}
+
+// TeardownResult is what a teardown did (or would do on a dry run) and what it kept.
+type TeardownResult struct {
+	Actions []string
+	Skipped []string // with the reason
+	Errors  []error
+}
+
+type teardownAction int
+
+const (
+	exchangeUnbind teardownAction = iota
+	queueUnbind
+	queueDelete
+	exchangeDelete
+)
+
+type teardownStep struct {
+	action     teardownAction
+	name       string
+	routingKey string
+	parent     string // the exchange a binding is on
+	binding    interface{}
+}
+
+func (ts *teardownStep) String() string {
+	switch ts.action {
+	case exchangeUnbind:
+		return fmt.Sprintf("unbind exchange %s from %s (%s)", ts.name, ts.parent, ts.routingKey)
+	case queueUnbind:
+		return fmt.Sprintf("unbind queue %s from %s (%s)", ts.name, ts.parent, ts.routingKey)
+	case queueDelete:
+		return fmt.Sprintf("delete queue %s", ts.name)
+	default:
+		return fmt.Sprintf("delete exchange %s", ts.name)
+	}
+}
+
+// TeardownFromFile tears down the topology declared in a TopologyConfig JSON file.
+func (top *Topologer) TeardownFromFile(fileNamePath string, options *TeardownOptions) (*TeardownResult, error) {
+
+	config, err := utils.ConvertJSONFileToTopologyConfig(fileNamePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return top.Teardown(config, options)
+}
+
+// Teardown deletes the bindings, queues, and exchanges declared in the TopologyConfig in reverse dependency order:
+// exchange bindings, queue bindings, queues, then exchanges. Passively declared queues and exchanges were never
+// created by the config and are left alone, as are Policies and Parameters.
+// Continues past errors, collecting them in the result, and returns the first one.
+func (top *Topologer) Teardown(config *models.TopologyConfig, options *TeardownOptions) (*TeardownResult, error) {
+
+	if options == nil {
+		options = &TeardownOptions{}
+	}
+
+	result := &TeardownResult{}
+	retained := make(map[string]bool)
+
+	if options.OnlyIfEmpty {
+		for _, queue := range config.Queues {
+			if queue.PassiveDeclare {
+				continue
+			}
+
+			count, err := top.queueMessageCount(queue.Name)
+			if err != nil {
+				// missing already, nothing to keep
+				continue
+			}
+
+			if count > 0 {
+				retained[queue.Name] = true
+				result.Skipped = append(result.Skipped, fmt.Sprintf("queue %s: %d messages", queue.Name, count))
+			}
+		}
+	}
+
+	steps, skipped := planTeardown(config, retained)
+	result.Skipped = append(result.Skipped, skipped...)
+
+	for _, step := range steps {
+		result.Actions = append(result.Actions, step.String())
+		if options.DryRun {
+			continue
+		}
+
+		if err := top.runTeardownStep(step, options); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("%s: %w", step, err))
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		return result, result.Errors[0]
+	}
+
+	return result, nil
+}
+
+func (top *Topologer) runTeardownStep(step *teardownStep, options *TeardownOptions) error {
+	switch step.action {
+	case exchangeUnbind:
+		binding := step.binding.(*models.ExchangeBinding)
+		return top.ExchangeUnbind(binding.ExchangeName, binding.RoutingKey, binding.ParentExchangeName, false, binding.Args)
+	case queueUnbind:
+		binding := step.binding.(*models.QueueBinding)
+		return top.UnbindQueue(binding.QueueName, binding.RoutingKey, binding.ExchangeName, binding.Args)
+	case queueDelete:
+		_, err := top.QueueDelete(step.name, false, options.OnlyIfEmpty, false)
+		return err
+	default:
+		return top.ExchangeDelete(step.name, false, false)
+	}
+}
+
+// QueueMessageCount passively inspects the queue for its ready message count.
+func (top *Topologer) queueMessageCount(queueName string) (int, error) {
+
+	chanHost, err := top.channelPool.GetChannel()
+	if err != nil {
+		return 0, err
+	}
+
+	defer top.channelPool.ReturnChannel(chanHost, false)
+
+	queue, err := chanHost.Channel.QueueInspect(queueName)
+	if err != nil {
+		top.channelPool.FlagChannel(chanHost.ChannelID)
+		return 0, err
+	}
+
+	return queue.Messages, nil
+}
+
+// PlanTeardown orders the teardown, leaving out anything passively declared, the retained queues with their
+// bindings, and every exchange routing into a retained queue (directly or through exchange bindings).
+func planTeardown(config *models.TopologyConfig, retained map[string]bool) ([]*teardownStep, []string) {
+
+	skipped := make([]string, 0)
+	routing := make(map[string]bool) // exchanges routing into a retained queue
+
+	for _, binding := range config.QueueBindings {
+		if retained[binding.QueueName] {
+			keepExchange(config, routing, binding.ExchangeName)
+		}
+	}
+
+	for _, exchange := range config.ConsistentHashExchanges {
+		for _, binding := range exchange.Bindings {
+			if retained[binding.QueueName] {
+				keepExchange(config, routing, exchange.Name)
+			}
+		}
+	}
+
+	steps := make([]*teardownStep, 0)
+	for i := len(config.ExchangeBindings) - 1; i >= 0; i-- {
+		binding := config.ExchangeBindings[i]
+		if routing[binding.ExchangeName] {
+			continue
+		}
+
+		steps = append(steps, &teardownStep{
+			action:     exchangeUnbind,
+			name:       binding.ExchangeName,
+			routingKey: binding.RoutingKey,
+			parent:     binding.ParentExchangeName,
+			binding:    binding,
+		})
+	}
+
+	for i := len(config.QueueBindings) - 1; i >= 0; i-- {
+		binding := config.QueueBindings[i]
+		if retained[binding.QueueName] {
+			continue
+		}
+
+		steps = append(steps, &teardownStep{
+			action:     queueUnbind,
+			name:       binding.QueueName,
+			routingKey: binding.RoutingKey,
+			parent:     binding.ExchangeName,
+			binding:    binding,
+		})
+	}
+
+	for i := len(config.Queues) - 1; i >= 0; i-- {
+		queue := config.Queues[i]
+		if queue.PassiveDeclare {
+			skipped = append(skipped, fmt.Sprintf("queue %s: passively declared", queue.Name))
+			continue
+		}
+
+		if !retained[queue.Name] {
+			steps = append(steps, &teardownStep{action: queueDelete, name: queue.Name})
+		}
+	}
+
+	exchanges := make([]string, 0, len(config.Exchanges)+len(config.ConsistentHashExchanges))
+	for _, exchange := range config.Exchanges {
+		if exchange.PassiveDeclare {
+			skipped = append(skipped, fmt.Sprintf("exchange %s: passively declared", exchange.Name))
+			continue
+		}
+
+		exchanges = append(exchanges, exchange.Name)
+	}
+
+	for _, exchange := range config.ConsistentHashExchanges {
+		exchanges = append(exchanges, exchange.Name)
+	}
+
+	for i := len(exchanges) - 1; i >= 0; i-- {
+		if routing[exchanges[i]] {
+			skipped = append(skipped, fmt.Sprintf("exchange %s: routes to a retained queue", exchanges[i]))
+			continue
+		}
+
+		steps = append(steps, &teardownStep{action: exchangeDelete, name: exchanges[i]})
+	}
+
+	return steps, skipped
+}
+
+// KeepExchange keeps the exchange and every exchange routing into it, so retained queues stay reachable.
+func keepExchange(config *models.TopologyConfig, kept map[string]bool, exchangeName string) {
+	if kept[exchangeName] {
+		return
+	}
+
+	kept[exchangeName] = true
+	for _, binding := range config.ExchangeBindings {
+		if binding.ExchangeName == exchangeName {
+			keepExchange(config, kept, binding.ParentExchangeName)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package topology
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+func teardownTestConfig() *models.TopologyConfig {
+	return &models.TopologyConfig{
+		Exchanges: []*models.Exchange{
+			{Name: "TenantRoot"},
+			{Name: "TenantChild"},
+			{Name: "SharedExchange", PassiveDeclare: true},
+		},
+		Queues: []*models.Queue{
+			{Name: "TenantQueue01"},
+			{Name: "TenantQueue02"},
+			{Name: "SharedQueue", PassiveDeclare: true},
+		},
+		QueueBindings: []*models.QueueBinding{
+			{QueueName: "TenantQueue01", ExchangeName: "TenantRoot", RoutingKey: "one"},
+			{QueueName: "TenantQueue02", ExchangeName: "TenantChild", RoutingKey: "two"},
+		},
+		ExchangeBindings: []*models.ExchangeBinding{
+			{ExchangeName: "TenantChild", ParentExchangeName: "TenantRoot", RoutingKey: "child"},
+		},
+	}
+}
+
+func TestPlanTeardown(t *testing.T) {
+
+	steps, skipped := planTeardown(teardownTestConfig(), map[string]bool{})
+
+	actions := make([]string, len(steps))
+	for i, step := range steps {
+		actions[i] = step.String()
+	}
+
+	assert.Equal(t, []string{
+		"unbind exchange TenantChild from TenantRoot (child)",
+		"unbind queue TenantQueue02 from TenantChild (two)",
+		"unbind queue TenantQueue01 from TenantRoot (one)",
+		"delete queue TenantQueue02",
+		"delete queue TenantQueue01",
+		"delete exchange TenantChild",
+		"delete exchange TenantRoot",
+	}, actions)
+
+	assert.Equal(t, []string{
+		"queue SharedQueue: passively declared",
+		"exchange SharedExchange: passively declared",
+	}, skipped)
+}
+
+func TestPlanTeardownRetainedQueue(t *testing.T) {
+
+	// TenantQueue02 still holds messages, it stays reachable through TenantChild and TenantRoot
+	steps, skipped := planTeardown(teardownTestConfig(), map[string]bool{"TenantQueue02": true})
+
+	actions := make([]string, len(steps))
+	for i, step := range steps {
+		actions[i] = step.String()
+	}
+
+	assert.Equal(t, []string{
+		"unbind queue TenantQueue01 from TenantRoot (one)",
+		"delete queue TenantQueue01",
+	}, actions)
+
+	assert.Contains(t, skipped, "exchange TenantChild: routes to a retained queue")
+	assert.Contains(t, skipped, "exchange TenantRoot: routes to a retained queue")
+}
@@ -2,6 +2,9 @@ package topology
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
 	"github.com/houseofcat/turbocookedrabbit/v1/pkg/pools"
@@ -11,6 +14,9 @@ import (
 // Topologer allows you to build RabbitMQ topology backed by a ChannelPool.
 type Topologer struct {
 	channelPool *pools.ChannelPool
+	management  *ManagementClient
+	naming      *models.NamingPolicy
+	declares    *declareGroup
 }
 
 // NewTopologer builds you a new Topologer.
@@ -28,9 +34,43 @@ func NewTopologer(channelPool *pools.ChannelPool) (*Topologer, error) {
 
 	return &Topologer{
 		channelPool: channelPool,
+		declares:    newDeclareGroup(),
 	}, nil
 }
 
+// NewTopologerWithManagement builds you a new Topologer that can also manage policies and parameters
+// through the management API.
+func NewTopologerWithManagement(channelPool *pools.ChannelPool, managementConfig *models.ManagementConfig) (*Topologer, error) {
+
+	top, err := NewTopologer(channelPool)
+	if err != nil {
+		return nil, err
+	}
+
+	top.management, err = NewManagementClient(managementConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return top, nil
+}
+
+// SetNamingPolicy makes every non passive exchange and queue declare check its name against the policy first,
+// nil removes it. Must be set before the Topologer is in use.
+func (top *Topologer) SetNamingPolicy(naming *models.NamingPolicy) {
+	top.naming = naming
+}
+
+// AuditTopology records a topology change to the Auditor (see models.SetAuditor).
+func auditTopology(exchange, queue, change string) {
+	models.Audit(&models.AuditRecord{
+		Action:   models.AuditTopologyChanged,
+		Exchange: exchange,
+		Queue:    queue,
+		Detail:   change,
+	})
+}
+
 // BuildToplogy builds a topology based on a ToplogyConfig - stops on first error.
 func (top *Topologer) BuildToplogy(config *models.TopologyConfig, ignoreErrors bool) error {
 	err := top.BuildExchanges(config.Exchanges, ignoreErrors)
@@ -43,6 +83,11 @@ func (top *Topologer) BuildToplogy(config *models.TopologyConfig, ignoreErrors b
 		return err
 	}
 
+	err = top.BuildConsistentHashExchanges(config.ConsistentHashExchanges, ignoreErrors)
+	if err != nil && !ignoreErrors {
+		return err
+	}
+
 	err = top.BindQueues(config.QueueBindings, ignoreErrors)
 	if err != nil && !ignoreErrors {
 		return err
@@ -53,6 +98,16 @@ func (top *Topologer) BuildToplogy(config *models.TopologyConfig, ignoreErrors b
 		return err
 	}
 
+	err = top.BuildParameters(config.Parameters, ignoreErrors)
+	if err != nil && !ignoreErrors {
+		return err
+	}
+
+	err = top.BuildPolicies(config.Policies, ignoreErrors)
+	if err != nil && !ignoreErrors {
+		return err
+	}
+
 	return nil
 }
 
@@ -127,6 +182,12 @@ func (top *Topologer) CreateExchange(
 	passiveDeclare, durable, autoDelete, internal, noWait bool,
 	args map[string]interface{}) error {
 
+	if !passiveDeclare {
+		if err := top.naming.CheckExchange(exchangeName); err != nil {
+			return err
+		}
+	}
+
 	chanHost, err := top.channelPool.GetChannel()
 	if err != nil {
 		return err
@@ -135,7 +196,9 @@ func (top *Topologer) CreateExchange(
 	defer top.channelPool.ReturnChannel(chanHost, false)
 
 	if passiveDeclare {
+		started := time.Now()
 		err = chanHost.Channel.ExchangeDeclarePassive(exchangeName, exchangeType, durable, autoDelete, internal, noWait, amqp.Table(args))
+		models.Trace("exchange.declare", chanHost.ConnectionID, chanHost.ChannelID, started, err, "exchange: %s, type: %s, passive: true", exchangeName, exchangeType)
 		if err != nil {
 			top.channelPool.FlagChannel(chanHost.ChannelID)
 			return err
@@ -144,18 +207,28 @@ func (top *Topologer) CreateExchange(
 		return nil
 	}
 
+	started := time.Now()
 	err = chanHost.Channel.ExchangeDeclare(exchangeName, exchangeType, durable, autoDelete, internal, noWait, amqp.Table(args))
+	models.Trace("exchange.declare", chanHost.ConnectionID, chanHost.ChannelID, started, err, "exchange: %s, type: %s", exchangeName, exchangeType)
 	if err != nil {
 		top.channelPool.FlagChannel(chanHost.ChannelID)
 		return err
 	}
 
+	auditTopology(exchangeName, "", "exchange declared")
+
 	return nil
 }
 
 // CreateExchangeFromConfig builds an Exchange toplogy from a config Exchange element.
 func (top *Topologer) CreateExchangeFromConfig(exchange *models.Exchange) error {
 
+	if !exchange.PassiveDeclare {
+		if err := top.naming.CheckExchange(exchange.Name); err != nil {
+			return err
+		}
+	}
+
 	chanHost, err := top.channelPool.GetChannel()
 	if err != nil {
 		return err
@@ -164,6 +237,7 @@ func (top *Topologer) CreateExchangeFromConfig(exchange *models.Exchange) error
 	defer top.channelPool.ReturnChannel(chanHost, false)
 
 	if exchange.PassiveDeclare {
+		started := time.Now()
 		err = chanHost.Channel.ExchangeDeclarePassive(
 			exchange.Name,
 			exchange.Type,
@@ -172,6 +246,7 @@ func (top *Topologer) CreateExchangeFromConfig(exchange *models.Exchange) error
 			exchange.InternalOnly,
 			exchange.NoWait,
 			exchange.Args)
+		models.Trace("exchange.declare", chanHost.ConnectionID, chanHost.ChannelID, started, err, "exchange: %s, type: %s, passive: true", exchange.Name, exchange.Type)
 
 		if err != nil {
 			top.channelPool.FlagChannel(chanHost.ChannelID)
@@ -181,6 +256,7 @@ func (top *Topologer) CreateExchangeFromConfig(exchange *models.Exchange) error
 		return nil
 	}
 
+	started := time.Now()
 	err = chanHost.Channel.ExchangeDeclare(
 		exchange.Name,
 		exchange.Type,
@@ -189,12 +265,15 @@ func (top *Topologer) CreateExchangeFromConfig(exchange *models.Exchange) error
 		exchange.InternalOnly,
 		exchange.NoWait,
 		exchange.Args)
+	models.Trace("exchange.declare", chanHost.ConnectionID, chanHost.ChannelID, started, err, "exchange: %s, type: %s", exchange.Name, exchange.Type)
 
 	if err != nil {
 		top.channelPool.FlagChannel(chanHost.ChannelID)
 		return err
 	}
 
+	auditTopology(exchange.Name, "", "exchange declared")
+
 	return nil
 }
 
@@ -208,18 +287,22 @@ func (top *Topologer) ExchangeBind(exchangeBinding *models.ExchangeBinding) erro
 
 	defer top.channelPool.ReturnChannel(chanHost, false)
 
+	started := time.Now()
 	err = chanHost.Channel.ExchangeBind(
 		exchangeBinding.ExchangeName,
 		exchangeBinding.RoutingKey,
 		exchangeBinding.ParentExchangeName,
 		exchangeBinding.NoWait,
 		exchangeBinding.Args)
+	models.Trace("exchange.bind", chanHost.ConnectionID, chanHost.ChannelID, started, err, "exchange: %s, source: %s, routing key: %s", exchangeBinding.ExchangeName, exchangeBinding.ParentExchangeName, exchangeBinding.RoutingKey)
 
 	if err != nil {
 		top.channelPool.FlagChannel(chanHost.ChannelID)
 		return err
 	}
 
+	auditTopology(exchangeBinding.ExchangeName, "", fmt.Sprintf("exchange bound to %s (%s)", exchangeBinding.ParentExchangeName, exchangeBinding.RoutingKey))
+
 	return nil
 }
 
@@ -235,12 +318,17 @@ func (top *Topologer) ExchangeDelete(
 
 	defer top.channelPool.ReturnChannel(chanHost, false)
 
+	started := time.Now()
 	err = chanHost.Channel.ExchangeDelete(exchangeName, ifUnused, noWait)
+	models.Trace("exchange.delete", chanHost.ConnectionID, chanHost.ChannelID, started, err, "exchange: %s, if unused: %t", exchangeName, ifUnused)
+
 	if err != nil {
 		top.channelPool.FlagChannel(chanHost.ChannelID)
 		return err
 	}
 
+	auditTopology(exchangeName, "", "exchange deleted")
+
 	return nil
 }
 
@@ -254,22 +342,26 @@ func (top *Topologer) ExchangeUnbind(exchangeName, routingKey, parentExchangeNam
 
 	defer top.channelPool.ReturnChannel(chanHost, false)
 
+	started := time.Now()
 	err = chanHost.Channel.ExchangeUnbind(
 		exchangeName,
 		routingKey,
 		parentExchangeName,
 		noWait,
 		amqp.Table(args))
+	models.Trace("exchange.unbind", chanHost.ConnectionID, chanHost.ChannelID, started, err, "exchange: %s, source: %s, routing key: %s", exchangeName, parentExchangeName, routingKey)
 
 	if err != nil {
 		top.channelPool.FlagChannel(chanHost.ChannelID)
 		return err
 	}
 
+	auditTopology(exchangeName, "", fmt.Sprintf("exchange unbound from %s (%s)", parentExchangeName, routingKey))
+
 	return nil
 }
 
-// CreateQueue builds a Queue topology.
+// CreateQueue builds a Queue topology. Identical declares made concurrently share one trip to the server.
 func (top *Topologer) CreateQueue(
 	queueName string,
 	passiveDeclare bool,
@@ -279,59 +371,56 @@ func (top *Topologer) CreateQueue(
 	noWait bool,
 	args map[string]interface{}) error {
 
-	chanHost, err := top.channelPool.GetChannel()
-	if err != nil {
-		return err
-	}
-
-	defer top.channelPool.ReturnChannel(chanHost, false)
-
-	if passiveDeclare {
-		_, err = chanHost.Channel.QueueDeclarePassive(queueName, durable, autoDelete, exclusive, noWait, amqp.Table(args))
-		if err != nil {
-			top.channelPool.FlagChannel(chanHost.ChannelID)
+	if !passiveDeclare {
+		if err := top.naming.CheckQueue(queueName); err != nil {
 			return err
 		}
-
-		return nil
 	}
 
-	_, err = chanHost.Channel.QueueDeclare(queueName, durable, autoDelete, exclusive, noWait, amqp.Table(args))
-	if err != nil {
-		top.channelPool.FlagChannel(chanHost.ChannelID)
-		return err
-	}
+	_, err := top.declareQueueOnce(&models.Queue{
+		Name:           queueName,
+		PassiveDeclare: passiveDeclare,
+		Durable:        durable,
+		AutoDelete:     autoDelete,
+		Exclusive:      exclusive,
+		Args:           amqp.Table(args),
+	}, noWait)
 
-	return nil
+	return err
 }
 
 // CreateQueueFromConfig builds a Queue topology from a config Exchange element.
 func (top *Topologer) CreateQueueFromConfig(queue *models.Queue) error {
 
-	chanHost, err := top.channelPool.GetChannel()
-	if err != nil {
-		return err
+	if !queue.PassiveDeclare {
+		if err := top.naming.CheckQueue(queue.Name); err != nil {
+			return err
+		}
 	}
 
-	defer top.channelPool.ReturnChannel(chanHost, false)
+	_, err := top.declareQueueOnce(queue, queue.NoWait)
 
-	if queue.PassiveDeclare {
-		_, err = chanHost.Channel.QueueDeclarePassive(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.NoWait, queue.Args)
-		if err != nil {
-			top.channelPool.FlagChannel(chanHost.ChannelID)
-			return err
-		}
+	return err
+}
 
-		return nil
+// DeclareQueue declares the queue and returns the server's view of it: its name, ready messages, and consumers.
+// An empty Name has the server assign one (amq.gen-...), returned in the result (see consumer.NewConsumerFromQueue).
+// Exclusive queues belong to the connection that declared them and the pool can't guarantee the consumer
+// gets a channel on that connection, so a server named queue can't be Exclusive, use AutoDelete or Expires instead.
+// NoWait is ignored, the declare's reply is the result.
+func (top *Topologer) DeclareQueue(queue *models.Queue) (amqp.Queue, error) {
+
+	if queue.Name == "" && queue.Exclusive {
+		return amqp.Queue{}, errors.New("can't declare an exclusive server named queue through a channel pool")
 	}
 
-	_, err = chanHost.Channel.QueueDeclare(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, queue.NoWait, queue.Args)
-	if err != nil {
-		top.channelPool.FlagChannel(chanHost.ChannelID)
-		return err
+	if !queue.PassiveDeclare {
+		if err := top.naming.CheckQueue(queue.Name); err != nil {
+			return amqp.Queue{}, err
+		}
 	}
 
-	return nil
+	return top.declareQueueOnce(queue, false)
 }
 
 // QueueDelete removes the queue from the server (and all bindings) and returns messages purged (count).
@@ -344,12 +433,17 @@ func (top *Topologer) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (
 
 	defer top.channelPool.ReturnChannel(chanHost, false)
 
+	started := time.Now()
 	count, err := chanHost.Channel.QueueDelete(name, ifUnused, ifEmpty, noWait)
+	models.Trace("queue.delete", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, if unused: %t, if empty: %t, purged: %d", name, ifUnused, ifEmpty, count)
+
 	if err != nil {
 		top.channelPool.FlagChannel(chanHost.ChannelID)
 		return 0, err
 	}
 
+	auditTopology("", name, fmt.Sprintf("queue deleted, %d messages purged", count))
+
 	return count, nil
 }
 
@@ -363,18 +457,22 @@ func (top *Topologer) QueueBind(queueBinding *models.QueueBinding) error {
 
 	defer top.channelPool.ReturnChannel(chanHost, false)
 
+	started := time.Now()
 	err = chanHost.Channel.QueueBind(
 		queueBinding.QueueName,
 		queueBinding.RoutingKey,
 		queueBinding.ExchangeName,
 		queueBinding.NoWait,
 		queueBinding.Args)
+	models.Trace("queue.bind", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, exchange: %s, routing key: %s", queueBinding.QueueName, queueBinding.ExchangeName, queueBinding.RoutingKey)
 
 	if err != nil {
 		top.channelPool.FlagChannel(chanHost.ChannelID)
 		return err
 	}
 
+	auditTopology(queueBinding.ExchangeName, queueBinding.QueueName, fmt.Sprintf("queue bound (%s)", queueBinding.RoutingKey))
+
 	return nil
 }
 
@@ -408,15 +506,19 @@ func (top *Topologer) PurgeQueue(queueName string, noWait bool) (int, error) {
 
 	defer top.channelPool.ReturnChannel(chanHost, false)
 
+	started := time.Now()
 	count, err := chanHost.Channel.QueuePurge(
 		queueName,
 		noWait)
+	models.Trace("queue.purge", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, purged: %d", queueName, count)
 
 	if err != nil {
 		top.channelPool.FlagChannel(chanHost.ChannelID)
 		return 0, err
 	}
 
+	auditTopology("", queueName, fmt.Sprintf("queue purged, %d messages", count))
+
 	return count, nil
 }
 
@@ -430,16 +532,215 @@ func (top *Topologer) UnbindQueue(queueName, routingKey, exchangeName string, ar
 
 	defer top.channelPool.ReturnChannel(chanHost, false)
 
+	started := time.Now()
 	err = chanHost.Channel.QueueUnbind(
 		queueName,
 		routingKey,
 		exchangeName,
 		amqp.Table(args))
+	models.Trace("queue.unbind", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, exchange: %s, routing key: %s", queueName, exchangeName, routingKey)
 
 	if err != nil {
 		top.channelPool.FlagChannel(chanHost.ChannelID)
 		return err
 	}
 
+	auditTopology(exchangeName, queueName, fmt.Sprintf("queue unbound (%s)", routingKey))
+
+	return nil
+}
+
+// BuildConsistentHashExchanges loops through and builds ConsistentHashExchanges with their bindings - stops on first error.
+func (top *Topologer) BuildConsistentHashExchanges(exchanges []*models.ConsistentHashExchange, ignoreErrors bool) error {
+	if len(exchanges) == 0 {
+		return nil
+	}
+
+	for _, exchange := range exchanges {
+		err := top.CreateConsistentHashExchange(exchange)
+		if err != nil && !ignoreErrors {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateConsistentHashExchange validates and builds an x-consistent-hash Exchange and binds its Queues by weight.
+// Queues must already exist.
+func (top *Topologer) CreateConsistentHashExchange(exchange *models.ConsistentHashExchange) error {
+
+	if err := exchange.Validate(); err != nil {
+		return err
+	}
+
+	err := top.CreateExchange(
+		exchange.Name,
+		models.ConsistentHashExchangeType,
+		false,
+		exchange.Durable,
+		exchange.AutoDelete,
+		false,
+		false,
+		exchange.Args())
+	if err != nil {
+		return err
+	}
+
+	for _, binding := range exchange.Bindings {
+		err = top.QueueBind(&models.QueueBinding{
+			QueueName:    binding.QueueName,
+			ExchangeName: exchange.Name,
+			RoutingKey:   strconv.FormatUint(uint64(binding.Weight), 10), // the binding key is the weight
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BuildPolicies loops through and creates/updates Policies - stops on first error.
+func (top *Topologer) BuildPolicies(policies []*models.Policy, ignoreErrors bool) error {
+	if len(policies) == 0 {
+		return nil
+	}
+
+	for _, policy := range policies {
+		err := top.CreatePolicy(policy)
+		if err != nil && !ignoreErrors {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BuildParameters loops through and creates/updates runtime Parameters - stops on first error.
+func (top *Topologer) BuildParameters(parameters []*models.Parameter, ignoreErrors bool) error {
+	if len(parameters) == 0 {
+		return nil
+	}
+
+	for _, parameter := range parameters {
+		err := top.CreateParameter(parameter)
+		if err != nil && !ignoreErrors {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreatePolicy creates or updates a Policy through the management API.
+func (top *Topologer) CreatePolicy(policy *models.Policy) error {
+	if top.management == nil {
+		return errors.New("can't create a policy without a management config")
+	}
+
+	if err := top.management.PutPolicy(policy); err != nil {
+		return err
+	}
+
+	auditTopology("", "", "policy set"+" "+policy.Name)
+
+	return nil
+}
+
+// DeletePolicy removes a Policy through the management API, an empty vhost uses the configured vhost.
+func (top *Topologer) DeletePolicy(vhost, name string) error {
+	if top.management == nil {
+		return errors.New("can't delete a policy without a management config")
+	}
+
+	if err := top.management.DeletePolicy(vhost, name); err != nil {
+		return err
+	}
+
+	auditTopology("", "", "policy deleted"+" "+name)
+
+	return nil
+}
+
+// CreateParameter creates or updates a runtime Parameter through the management API.
+func (top *Topologer) CreateParameter(parameter *models.Parameter) error {
+	if top.management == nil {
+		return errors.New("can't create a parameter without a management config")
+	}
+
+	if err := top.management.PutParameter(parameter); err != nil {
+		return err
+	}
+
+	auditTopology("", "", "parameter set"+" "+parameter.Component+"/"+parameter.Name)
+
 	return nil
 }
+
+// DeleteParameter removes a runtime Parameter through the management API, an empty vhost uses the configured vhost.
+func (top *Topologer) DeleteParameter(component, vhost, name string) error {
+	if top.management == nil {
+		return errors.New("can't delete a parameter without a management config")
+	}
+
+	if err := top.management.DeleteParameter(component, vhost, name); err != nil {
+		return err
+	}
+
+	auditTopology("", "", "parameter deleted"+" "+component+"/"+name)
+
+	return nil
+}
+
+// ListQueues returns the queues in a vhost with their depths through the management API, an empty vhost uses
+// the configured vhost.
+func (top *Topologer) ListQueues(vhost string) ([]*models.QueueInfo, error) {
+	if top.management == nil {
+		return nil, errors.New("can't list queues without a management config")
+	}
+
+	return top.management.ListQueues(vhost)
+}
+
+// PeekMessages gets up to count messages from the Queue and requeues them all, the queue is left as it was
+// (though peeked messages come back flagged as redelivered).
+func (top *Topologer) PeekMessages(queueName string, count int) ([]amqp.Delivery, error) {
+
+	chanHost, err := top.channelPool.GetChannel()
+	if err != nil {
+		return nil, err
+	}
+
+	defer top.channelPool.ReturnChannel(chanHost, false)
+
+	deliveries := make([]amqp.Delivery, 0, count)
+	for len(deliveries) < count {
+		started := time.Now()
+		delivery, ok, err := chanHost.Channel.Get(queueName, false)
+		models.Trace("basic.get", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, autoack: false, empty: %t", queueName, !ok)
+		if err != nil {
+			top.channelPool.FlagChannel(chanHost.ChannelID)
+			return nil, err // channel closing requeues anything already got
+		}
+
+		if !ok {
+			break // queue is empty
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	if len(deliveries) > 0 {
+		last := deliveries[len(deliveries)-1].DeliveryTag
+		started := time.Now()
+		err := chanHost.Channel.Nack(last, true, true)
+		models.Trace("nack", chanHost.ConnectionID, chanHost.ChannelID, started, err, "queue: %s, delivery tag: %d, multiple: true", queueName, last)
+		if err != nil {
+			top.channelPool.FlagChannel(chanHost.ChannelID)
+			return nil, err
+		}
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBloomExpectedCount     = 100000
+	defaultBloomFalsePositiveRate = 0.01
+)
+
+// BloomFilter is a fixed size probabilistic set. Contains can return false positives but never false negatives.
+type BloomFilter struct {
+	bits   []uint64
+	size   uint64 // number of bits
+	hashes uint64 // number of hash functions
+}
+
+// NewBloomFilter sizes a BloomFilter for the expected count of keys at the desired false positive rate.
+// Zero/invalid values fall back to 100,000 keys and a 1% false positive rate.
+func NewBloomFilter(expectedCount uint32, falsePositiveRate float64) *BloomFilter {
+
+	if expectedCount == 0 {
+		expectedCount = defaultBloomExpectedCount
+	}
+
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = defaultBloomFalsePositiveRate
+	}
+
+	// m = -n*ln(p) / ln(2)^2 and k = m/n * ln(2)
+	size := uint64(math.Ceil(-float64(expectedCount) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	hashes := uint64(math.Max(1, math.Round(float64(size)/float64(expectedCount)*math.Ln2)))
+
+	return &BloomFilter{
+		bits:   make([]uint64, (size+63)/64),
+		size:   size,
+		hashes: hashes,
+	}
+}
+
+// Add inserts the key.
+func (bf *BloomFilter) Add(key string) {
+	h1, h2 := bloomHashes(key)
+	for i := uint64(0); i < bf.hashes; i++ {
+		bit := (h1 + i*h2) % bf.size
+		bf.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// Contains checks if the key has (probably) been added.
+func (bf *BloomFilter) Contains(key string) bool {
+	h1, h2 := bloomHashes(key)
+	for i := uint64(0); i < bf.hashes; i++ {
+		bit := (h1 + i*h2) % bf.size
+		if bf.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// BloomHashes creates the two base hashes for double hashing (h1 + i*h2).
+func bloomHashes(key string) (uint64, uint64) {
+	hash1 := fnv.New64a()
+	_, _ = hash1.Write([]byte(key))
+
+	hash2 := fnv.New64()
+	_, _ = hash2.Write([]byte(key))
+
+	return hash1.Sum64(), hash2.Sum64() | 1 // odd, so every probe differs
+}
+
+// RotatingBloomFilter remembers keys for a time window by rotating between two BloomFilters.
+// A key is remembered for at least one window and at most two.
+type RotatingBloomFilter struct {
+	current           *BloomFilter
+	previous          *BloomFilter
+	window            time.Duration
+	rotated           time.Time
+	expectedCount     uint32
+	falsePositiveRate float64
+	filterLock        *sync.Mutex
+}
+
+// NewRotatingBloomFilter creates a RotatingBloomFilter, the expected count is per window.
+func NewRotatingBloomFilter(window time.Duration, expectedCount uint32, falsePositiveRate float64) *RotatingBloomFilter {
+	return &RotatingBloomFilter{
+		current:           NewBloomFilter(expectedCount, falsePositiveRate),
+		previous:          NewBloomFilter(expectedCount, falsePositiveRate),
+		window:            window,
+		rotated:           time.Now(),
+		expectedCount:     expectedCount,
+		falsePositiveRate: falsePositiveRate,
+		filterLock:        &sync.Mutex{},
+	}
+}
+
+// Add inserts the key into the current window.
+func (rbf *RotatingBloomFilter) Add(key string) {
+	rbf.filterLock.Lock()
+	defer rbf.filterLock.Unlock()
+
+	rbf.rotate()
+	rbf.current.Add(key)
+}
+
+// Contains checks if the key has (probably) been added within the window.
+func (rbf *RotatingBloomFilter) Contains(key string) bool {
+	rbf.filterLock.Lock()
+	defer rbf.filterLock.Unlock()
+
+	rbf.rotate()
+	return rbf.current.Contains(key) || rbf.previous.Contains(key)
+}
+
+// Rotate lazily ages out windows, must be called while holding the lock.
+func (rbf *RotatingBloomFilter) rotate() {
+	elapsed := time.Since(rbf.rotated)
+	if elapsed < rbf.window {
+		return
+	}
+
+	if elapsed >= 2*rbf.window { // idle for both windows, forget everything
+		rbf.previous = NewBloomFilter(rbf.expectedCount, rbf.falsePositiveRate)
+	} else {
+		rbf.previous = rbf.current
+	}
+
+	rbf.current = NewBloomFilter(rbf.expectedCount, rbf.falsePositiveRate)
+	rbf.rotated = time.Now()
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+
+	filter := NewBloomFilter(10000, 0.01)
+
+	for i := 0; i < 10000; i++ {
+		filter.Add("message-" + strconv.Itoa(i))
+	}
+
+	for i := 0; i < 10000; i++ {
+		assert.True(t, filter.Contains("message-"+strconv.Itoa(i)))
+	}
+
+	falsePositives := 0
+	for i := 10000; i < 20000; i++ {
+		if filter.Contains("message-" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+
+	t.Logf("False Positives: %d / 10000", falsePositives)
+	assert.True(t, falsePositives < 300) // 1% expected, leave plenty of room
+}
+
+func TestRotatingBloomFilter(t *testing.T) {
+
+	filter := NewRotatingBloomFilter(50*time.Millisecond, 100, 0.01)
+
+	filter.Add("SuperStreetFighter2Turbo")
+	assert.True(t, filter.Contains("SuperStreetFighter2Turbo"))
+
+	time.Sleep(60 * time.Millisecond) // rotated once, still in the previous window
+	assert.True(t, filter.Contains("SuperStreetFighter2Turbo"))
+
+	time.Sleep(60 * time.Millisecond) // rotated twice, forgotten
+	assert.False(t, filter.Contains("SuperStreetFighter2Turbo"))
+}
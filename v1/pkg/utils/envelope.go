@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// Envelope wire format, version 1.
+//
+// An envelope is the message body as a UTF-8 JSON object (models.ModdedLetter), published with the
+// EnvelopeContentType content type. Decoders must ignore keys they don't know.
+//
+//	{
+//	  "Version": 1,                      // absent on envelopes from before versioning, decode those as 1
+//	  "LetterID": 42,                    // unsigned 64 bit integer
+//	  "Body": {
+//	    "Encrypted": true,
+//	    "EncryptionType": "aes",         // absent or empty means aes
+//	    "Compressed": true,
+//	    "CompressionType": "gzip",       // gzip or zstd, absent or empty means gzip
+//	    "Signed": true,                  // absent means false
+//	    "SignatureType": "hmac-sha256",
+//	    "Signature": "<base64>",         // standard base64 with padding
+//	    "UTCDateTime": "2020-01-02T03:04:05Z", // RFC 3339, UTC
+//	    "Data": "<base64>"               // standard base64 with padding
+//	  },
+//	  "LetterMetadata": "..."
+//	}
+//
+// Data is the payload after each enabled step, applied in this order (decode in reverse):
+//
+//  1. Compressed: a gzip (RFC 1952) member, or a zstd (RFC 8878) frame.
+//  2. Encrypted: AES-GCM with the 16, 24, or 32 byte key, laid out as the 12 byte nonce, the ciphertext,
+//     then the 16 byte tag. There is no additional data.
+//  3. Signed: HMAC-SHA256 with the signing key over the signing input below, the Data itself isn't changed.
+//
+// The signing input is, in order, the decimal Version, the decimal LetterID, LetterMetadata, CompressionType
+// (empty when not compressed), EncryptionType (empty when not encrypted), UTCDateTime, and the decoded Data,
+// each as a 4 byte big endian length followed by its bytes. The types are the exact strings in the envelope.
+//
+// A decoder must refuse a Version it doesn't know, and a signed envelope whose signature doesn't match.
+// Envelopes produced by CreateWrappedPayload are JSON encoded payloads wrapped this way.
+const (
+	// EnvelopeVersion is the wire format version WrapEnvelope produces.
+	EnvelopeVersion = 1
+	// EnvelopeContentType is the content type to publish envelopes with.
+	EnvelopeContentType = "application/vnd.turbocookedrabbit.envelope+json"
+	// SignatureHMACSHA256 is the only signature type in version 1.
+	SignatureHMACSHA256 = "hmac-sha256"
+
+	envelopeNonceSize = 12
+)
+
+var (
+	// ErrEnvelopeVersion is returned for an envelope of a newer wire format version.
+	ErrEnvelopeVersion = errors.New("unsupported envelope version")
+	// ErrEnvelopeSignature is returned for a signed envelope that doesn't verify, or can't be verified without a key.
+	ErrEnvelopeSignature = errors.New("envelope signature doesn't match")
+)
+
+// EnvelopeOptions are the steps applied to an envelope's Data, and the keys to reverse them.
+type EnvelopeOptions struct {
+	Compression *models.CompressionConfig // nil or disabled leaves Data uncompressed
+	Encryption  *models.EncryptionConfig  // nil or disabled leaves Data in the clear
+	SigningKey  []byte                    // HMAC-SHA256 key, nil leaves the envelope unsigned (and unwrapping unverified)
+	Timestamp   time.Time                 // UTCDateTime, zero uses the current time
+}
+
+// WrapEnvelope wraps the data in a version 1 envelope (see EnvelopeVersion for the format).
+func WrapEnvelope(data []byte, letterID uint64, metadata string, options *EnvelopeOptions) ([]byte, error) {
+	if options == nil {
+		options = &EnvelopeOptions{}
+	}
+
+	timestamp := options.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	letter := &models.ModdedLetter{
+		Version:        EnvelopeVersion,
+		LetterID:       letterID,
+		LetterMetadata: metadata,
+		Body:           &models.ModdedBody{UTCDateTime: timestamp.UTC().Format(time.RFC3339)},
+	}
+
+	buffer := &bytes.Buffer{}
+	if options.Compression != nil && options.Compression.Enabled {
+		if err := handleCompression(options.Compression, data, buffer); err != nil {
+			return nil, err
+		}
+
+		letter.Body.Compressed = true
+		letter.Body.CType = gzipCompressionType
+		if options.Compression.Type == zstdCompressionType {
+			letter.Body.CType = zstdCompressionType
+		}
+		data = buffer.Bytes()
+	}
+
+	if options.Encryption != nil && options.Encryption.Enabled {
+		if err := handleEncryption(options.Encryption, data, buffer); err != nil {
+			return nil, err
+		}
+
+		letter.Body.Encrypted = true
+		letter.Body.EType = aesSymmetricType
+		data = buffer.Bytes()
+	}
+
+	letter.Body.Data = data
+
+	if options.SigningKey != nil {
+		letter.Body.Signed = true
+		letter.Body.SType = SignatureHMACSHA256
+		letter.Body.Signature = signEnvelope(letter, options.SigningKey)
+	}
+
+	var json = jsoniter.ConfigFastest
+	return json.Marshal(letter)
+}
+
+// UnwrapEnvelope verifies (with a SigningKey) and unwraps an envelope, returning it and the original data.
+// Data is decompressed and decrypted by the envelope's own flags, options only provide the keys.
+func UnwrapEnvelope(envelope []byte, options *EnvelopeOptions) (*models.ModdedLetter, []byte, error) {
+	if options == nil {
+		options = &EnvelopeOptions{}
+	}
+
+	letter := &models.ModdedLetter{}
+	var json = jsoniter.ConfigFastest
+	if err := json.Unmarshal(envelope, letter); err != nil {
+		return nil, nil, fmt.Errorf("can't unwrap envelope: %w", err)
+	}
+
+	if letter.Version > EnvelopeVersion || letter.Version < 0 {
+		return nil, nil, fmt.Errorf("%w: %d", ErrEnvelopeVersion, letter.Version)
+	}
+
+	if letter.Body == nil {
+		return nil, nil, errors.New("can't unwrap envelope without a body")
+	}
+
+	if options.SigningKey != nil || letter.Body.Signed {
+		if err := verifyEnvelope(letter, options.SigningKey); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	buffer := bytes.NewBuffer(letter.Body.Data)
+	if letter.Body.Encrypted {
+		if options.Encryption == nil || len(options.Encryption.Hashkey) == 0 {
+			return nil, nil, errors.New("can't unwrap an encrypted envelope without a key")
+		}
+
+		if envelopeType(letter.Body.EType, aesSymmetricType) != aesSymmetricType {
+			return nil, nil, fmt.Errorf("can't unwrap envelope encrypted with %s", letter.Body.EType)
+		}
+
+		data, err := DecryptWithAes(buffer.Bytes(), options.Encryption.Hashkey, envelopeNonceSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		buffer = bytes.NewBuffer(data)
+	}
+
+	if letter.Body.Compressed {
+		switch envelopeType(letter.Body.CType, gzipCompressionType) {
+		case gzipCompressionType:
+			if err := DecompressWithGzip(buffer); err != nil {
+				return nil, nil, err
+			}
+		case zstdCompressionType:
+			if err := DecompressWithZstd(buffer); err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("can't unwrap envelope compressed with %s", letter.Body.CType)
+		}
+	}
+
+	return letter, buffer.Bytes(), nil
+}
+
+// VerifyEnvelope checks a signed envelope's signature, an unsigned envelope fails when a key expects one.
+func verifyEnvelope(letter *models.ModdedLetter, key []byte) error {
+	if !letter.Body.Signed {
+		return fmt.Errorf("%w: envelope isn't signed", ErrEnvelopeSignature)
+	}
+
+	if key == nil {
+		return fmt.Errorf("%w: no key to verify it", ErrEnvelopeSignature)
+	}
+
+	if letter.Body.SType != SignatureHMACSHA256 {
+		return fmt.Errorf("%w: unknown signature type %s", ErrEnvelopeSignature, letter.Body.SType)
+	}
+
+	if !hmac.Equal(letter.Body.Signature, signEnvelope(letter, key)) {
+		return ErrEnvelopeSignature
+	}
+
+	return nil
+}
+
+// SignEnvelope computes the HMAC-SHA256 of the envelope's signing input.
+func signEnvelope(letter *models.ModdedLetter, key []byte) []byte {
+	version := letter.Version
+	if version == 0 {
+		version = EnvelopeVersion
+	}
+
+	mac := hmac.New(sha256.New, key)
+	for _, field := range [][]byte{
+		[]byte(strconv.Itoa(version)),
+		[]byte(strconv.FormatUint(letter.LetterID, 10)),
+		[]byte(letter.LetterMetadata),
+		[]byte(letter.Body.CType),
+		[]byte(letter.Body.EType),
+		[]byte(letter.Body.UTCDateTime),
+		letter.Body.Data,
+	} {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(field)))
+		mac.Write(length)
+		mac.Write(field)
+	}
+
+	return mac.Sum(nil)
+}
+
+func envelopeType(configured, fallback string) string {
+	if configured == "" {
+		return fallback
+	}
+
+	return configured
+}
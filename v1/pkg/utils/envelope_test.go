@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+// Reference test vectors for the version 1 envelope, implementations in other languages should decode every
+// vector to vectorData, and produce the unencrypted, uncompressed ones byte for byte.
+var (
+	vectorData       = `{"hello":"world"}`
+	vectorSigningKey = []byte("tcr-signing-key")
+	vectorAESKey     = []byte("0123456789abcdef0123456789abcdef")
+	vectorTimestamp  = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	vectorPlain   = `{"Version":1,"LetterID":42,"Body":{"Encrypted":false,"Compressed":false,"UTCDateTime":"2020-01-02T03:04:05Z","Data":"eyJoZWxsbyI6IndvcmxkIn0="},"LetterMetadata":"orders"}`
+	vectorSigned  = `{"Version":1,"LetterID":42,"Body":{"Encrypted":false,"Compressed":false,"Signed":true,"SignatureType":"hmac-sha256","Signature":"1GVkQVqEebHk7BEHuYE/GEDDHXkWW2ITAKuEX06Y6Tw=","UTCDateTime":"2020-01-02T03:04:05Z","Data":"eyJoZWxsbyI6IndvcmxkIn0="},"LetterMetadata":"orders"}`
+	vectorGzip    = `{"Version":1,"LetterID":42,"Body":{"Encrypted":false,"Compressed":true,"CompressionType":"gzip","Signed":true,"SignatureType":"hmac-sha256","Signature":"E/0uQ+Nwj+V2INIP0Ecp4OdDPa8Nn6iL7sE+3hMoXoA=","UTCDateTime":"2020-01-02T03:04:05Z","Data":"H4sIAAAAAAAA/wARAO7/eyJoZWxsbyI6IndvcmxkIn0DANFBCdgRAAAA"},"LetterMetadata":"orders"}`
+	vectorZstdAES = `{"Version":1,"LetterID":42,"Body":{"Encrypted":true,"EncryptionType":"aes","Compressed":true,"CompressionType":"zstd","Signed":true,"SignatureType":"hmac-sha256","Signature":"uLdAyqaKlFvGubjpWvNzPq46JFykq7uLgaD493mNlMc=","UTCDateTime":"2020-01-02T03:04:05Z","Data":"AvG4C9AhywgARlOVjK8SLOlxCbNdcetCpBfumdU2RxFSinv61yAOWM/V0s0y/c29q9bqjv6TiFYkNw=="},"LetterMetadata":"orders"}`
+	vectorLegacy  = `{"LetterID":7,"Body":{"Encrypted":false,"Compressed":false,"UTCDateTime":"2020-01-02T03:04:05Z","Data":"eyJoZWxsbyI6IndvcmxkIn0="},"LetterMetadata":""}`
+)
+
+func TestWrapEnvelopeVectors(t *testing.T) {
+
+	envelope, err := WrapEnvelope([]byte(vectorData), 42, "orders", &EnvelopeOptions{Timestamp: vectorTimestamp})
+	assert.NoError(t, err)
+	assert.Equal(t, vectorPlain, string(envelope))
+
+	envelope, err = WrapEnvelope([]byte(vectorData), 42, "orders", &EnvelopeOptions{Timestamp: vectorTimestamp, SigningKey: vectorSigningKey})
+	assert.NoError(t, err)
+	assert.Equal(t, vectorSigned, string(envelope))
+}
+
+func TestUnwrapEnvelopeVectors(t *testing.T) {
+
+	options := &EnvelopeOptions{
+		SigningKey: vectorSigningKey,
+		Encryption: &models.EncryptionConfig{Hashkey: vectorAESKey},
+	}
+
+	for _, vector := range []string{vectorSigned, vectorGzip, vectorZstdAES} {
+		letter, data, err := UnwrapEnvelope([]byte(vector), options)
+		assert.NoError(t, err)
+		assert.Equal(t, vectorData, string(data))
+		assert.Equal(t, uint64(42), letter.LetterID)
+		assert.Equal(t, "orders", letter.LetterMetadata)
+	}
+
+	for _, vector := range []string{vectorPlain, vectorLegacy} {
+		_, data, err := UnwrapEnvelope([]byte(vector), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, vectorData, string(data))
+	}
+}
+
+func TestUnwrapEnvelopeRefuses(t *testing.T) {
+
+	_, _, err := UnwrapEnvelope([]byte(strings.Replace(vectorSigned, `"LetterID":42`, `"LetterID":43`, 1)), &EnvelopeOptions{SigningKey: vectorSigningKey})
+	assert.True(t, errors.Is(err, ErrEnvelopeSignature))
+
+	_, _, err = UnwrapEnvelope([]byte(vectorSigned), &EnvelopeOptions{SigningKey: []byte("wrong-key")})
+	assert.True(t, errors.Is(err, ErrEnvelopeSignature))
+
+	_, _, err = UnwrapEnvelope([]byte(vectorSigned), nil) // can't be verified
+	assert.True(t, errors.Is(err, ErrEnvelopeSignature))
+
+	_, _, err = UnwrapEnvelope([]byte(vectorPlain), &EnvelopeOptions{SigningKey: vectorSigningKey}) // expected a signature
+	assert.True(t, errors.Is(err, ErrEnvelopeSignature))
+
+	_, _, err = UnwrapEnvelope([]byte(strings.Replace(vectorPlain, `"Version":1`, `"Version":2`, 1)), nil)
+	assert.True(t, errors.Is(err, ErrEnvelopeVersion))
+
+	_, _, err = UnwrapEnvelope([]byte(vectorZstdAES), &EnvelopeOptions{SigningKey: vectorSigningKey})
+	assert.Error(t, err) // no AES key
+}
+
+func TestCreateWrappedPayloadIsAnEnvelope(t *testing.T) {
+
+	compression := &models.CompressionConfig{Enabled: true, Type: "zstd"}
+	encryption := &models.EncryptionConfig{Enabled: true, Hashkey: vectorAESKey}
+
+	input := struct {
+		Hello string `json:"hello"`
+	}{Hello: "world"}
+
+	payload, err := CreateWrappedPayload(input, 42, "orders", compression, encryption)
+	assert.NoError(t, err)
+
+	letter, data, err := UnwrapEnvelope(payload, &EnvelopeOptions{Encryption: encryption})
+	assert.NoError(t, err)
+	assert.Equal(t, EnvelopeVersion, letter.Version)
+	assert.Equal(t, vectorData, string(data))
+}
@@ -3,7 +3,6 @@ package utils
 import (
 	"bytes"
 	"io/ioutil"
-	"time"
 
 	jsoniter "github.com/json-iterator/go"
 
@@ -99,6 +98,7 @@ func CreatePayload(
 }
 
 // CreateWrappedPayload wraps your data in a plaintext wrapper called ModdedLetter and performs the selected modifications to data.
+// The result is a version 1 envelope, see EnvelopeVersion for the wire format and WrapEnvelope to sign it too.
 func CreateWrappedPayload(
 	input interface{},
 	letterID uint64,
@@ -106,54 +106,13 @@ func CreateWrappedPayload(
 	compression *models.CompressionConfig,
 	encryption *models.EncryptionConfig) ([]byte, error) {
 
-	moddedLetter := &models.ModdedLetter{
-		LetterID:       letterID,
-		LetterMetadata: metadata,
-		Body:           &models.ModdedBody{},
-	}
-
 	var json = jsoniter.ConfigFastest
-	var err error
-	var innerData []byte
-	innerData, err = json.Marshal(&input)
+	innerData, err := json.Marshal(&input)
 	if err != nil {
 		return nil, err
 	}
 
-	buffer := &bytes.Buffer{}
-	if compression.Enabled {
-		err := handleCompression(compression, innerData, buffer)
-		if err != nil {
-			return nil, err
-		}
-
-		// Data is now compressed
-		moddedLetter.Body.Compressed = true
-		moddedLetter.Body.CType = compression.Type
-		innerData = buffer.Bytes()
-	}
-
-	if encryption.Enabled {
-		err := handleEncryption(encryption, innerData, buffer)
-		if err != nil {
-			return nil, err
-		}
-
-		// Data is now encrypted
-		moddedLetter.Body.Encrypted = true
-		moddedLetter.Body.EType = encryption.Type
-		innerData = buffer.Bytes()
-	}
-
-	moddedLetter.Body.UTCDateTime = time.Now().UTC().Format(time.RFC3339)
-	moddedLetter.Body.Data = innerData
-
-	data, err := json.Marshal(&moddedLetter)
-	if err != nil {
-		return nil, err
-	}
-
-	return data, nil
+	return WrapEnvelope(innerData, letterID, metadata, &EnvelopeOptions{Compression: compression, Encryption: encryption})
 }
 
 func handleCompression(compression *models.CompressionConfig, data []byte, buffer *bytes.Buffer) error {
@@ -207,3 +207,105 @@ func TestCreateAndReadLZCompressedEncryptedPayload(t *testing.T) {
 	assert.Equal(t, test.PropertyString3, outputData.PropertyString3)
 	assert.Equal(t, test.PropertyString4, outputData.PropertyString4)
 }
+
+func TestValidateSeasonings(t *testing.T) {
+
+	seasonings := []string{
+		"../tests/testseasoning.json",
+		"../consumer/testconsumerseasoning.json",
+		"../publisher/testpublisherseasoning.json",
+		"../pools/testpoolseasoning.json",
+	}
+
+	for _, seasoning := range seasonings {
+		config, err := ConvertJSONFileToConfig(seasoning)
+		assert.NoError(t, err)
+		assert.NoError(t, config.Validate(), seasoning)
+	}
+}
+
+func TestValidateSeasoningAggregatesErrors(t *testing.T) {
+
+	config, err := ConvertJSONFileToConfig("../tests/testseasoning.json")
+	assert.NoError(t, err)
+
+	config.PoolConfig.ConnectionPoolConfig.URI = "http://localhost:5672/"
+	config.PoolConfig.ConnectionPoolConfig.TCPKeepAlive = -5
+	config.PoolConfig.ConnectionPoolConfig.LocalAddress = "not an address"
+	config.PoolConfig.ChannelPoolConfig.MaxChannelCount = 1
+	config.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"].MessageBuffer = 0
+	config.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"].AckBatchSize = 10
+	config.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"].ShadowMode = true
+	config.PublisherConfig.VerifyTTLDeadLetter = true
+	config.ManagementConfig = nil
+	config.NamingPolicy = &models.NamingPolicyConfig{Queues: &models.NamingRule{Pattern: "("}}
+	config.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"].AckPolicy = &models.AckPolicyConfig{
+		Rules: []*models.AckRuleConfig{{Pattern: "timeout", Outcome: "later"}},
+	}
+	config.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"].BufferStrategy = "lifo"
+	config.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"].ConsumerTimeout = 1000
+	config.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"].ConsumerTimeoutMargin = 500
+	config.ServiceConfig.ErrorDelivery = "eventually"
+	config.ServiceConfig.ShutdownDependencies = map[string][]string{"Publisher": {"Publisher"}}
+	config.ServiceConfig.ShutdownSignals = []string{"SIGTERM", "SIGKILL"}
+	config.ServiceConfig.QueueLocality = true
+	config.ServiceConfig.NodeAddresses = map[string]string{"rabbit@node-1": "node-1"}
+	config.PublisherConfig.Fallbacks = map[string][]*models.PublishFallback{"Orders": {{Exchange: "Orders"}, {Sink: true}}}
+	config.PublisherConfig.Schedules = []*models.ScheduledPublish{{Name: "Heartbeat", Schedule: "61 * * * *"}}
+	config.PublisherConfig.SpoolMaxBytes = 1024
+	config.ChaosConfig = &models.ChaosConfig{Enabled: true, CloseChannel: 1.5, DelayAck: 0.1}
+	config.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"].DedupHeader = "x-posting-id"
+	config.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"].Queue = &models.Queue{Name: "SomeOtherQueue"}
+	config.ConsumerConfigs["TurboCookedRabbitConsumer-AutoAck"].StandbyFailover = true
+	config.PublisherConfig.SpoolSegmentSize = 4096
+	config.PublisherConfig.PublishPolicies = map[string]*models.PublishPolicy{"orders": {ContentTypes: []string{"json"}}}
+	config.Targets = map[string]*models.TargetConfig{
+		"Archive": {},
+		"Ingest":  {PoolConfig: &models.PoolConfig{ChannelPoolConfig: &models.ChannelPoolConfig{}}},
+	}
+	config.ConsumerConfigs["TurboCookedRabbitConsumer-Ackable"].Target = "Elsewhere"
+
+	err = config.Validate()
+	assert.Error(t, err)
+
+	errs, ok := err.(models.ConfigErrors)
+	assert.True(t, ok)
+
+	fields := make([]string, len(errs))
+	for i, configErr := range errs {
+		fields[i] = configErr.Field
+	}
+
+	assert.Contains(t, fields, "PoolConfig.ConnectionPoolConfig.URI")
+	assert.Contains(t, fields, "PoolConfig.ConnectionPoolConfig.TCPKeepAlive")
+	assert.Contains(t, fields, "PoolConfig.ConnectionPoolConfig.LocalAddress")
+	assert.Contains(t, fields, "PoolConfig.ChannelPoolConfig.MaxChannelCount")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-AutoAck].MessageBuffer")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-AutoAck].AckBatchSize")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-AutoAck].StandbyFailover")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-AutoAck].ShadowMode")
+	assert.Contains(t, fields, "PublisherConfig.VerifyTTLDeadLetter")
+	assert.Contains(t, fields, "NamingPolicy.Queues.Pattern")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-AutoAck].AckPolicy")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-AutoAck].AckPolicy.Rules[0].Outcome")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-AutoAck].BufferStrategy")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-AutoAck].ConsumerTimeout")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-AutoAck].ConsumerTimeoutMargin")
+	assert.Contains(t, fields, "ServiceConfig.ErrorDelivery")
+	assert.Contains(t, fields, "ServiceConfig.ShutdownDependencies.Publisher")
+	assert.Contains(t, fields, "ServiceConfig.ShutdownSignals[1]")
+	assert.Contains(t, fields, "ServiceConfig.QueueLocality")
+	assert.Contains(t, fields, "ServiceConfig.NodeAddresses")
+	assert.Contains(t, fields, "PublisherConfig.Fallbacks[Orders][0].Exchange")
+	assert.NotContains(t, fields, "PublisherConfig.Fallbacks[Orders][1]")
+	assert.Contains(t, fields, "PublisherConfig.Schedules[0].Schedule")
+	assert.Contains(t, fields, "PublisherConfig.SpoolMaxBytes")
+	assert.Contains(t, fields, "PublisherConfig.PublishPolicies[orders].ContentTypes")
+	assert.Contains(t, fields, "ChaosConfig.CloseChannel")
+	assert.Contains(t, fields, "ChaosConfig.AckDelay")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-AutoAck].DedupTTL")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-AutoAck].Queue.Name")
+	assert.Contains(t, fields, "Targets[Archive].PoolConfig")
+	assert.Contains(t, fields, "Targets[Ingest].PoolConfig.ConnectionPoolConfig")
+	assert.Contains(t, fields, "ConsumerConfigs[TurboCookedRabbitConsumer-Ackable].Target")
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"math/rand"
 	"sync/atomic"
 	"time"
@@ -29,6 +30,42 @@ func CreateLetter(letterID uint64, exchangeName string, queueName string, body [
 	}
 }
 
+// CreateConsistentHashLetter creates a letter for a ConsistentHashExchange with the hash key placed where the
+// exchange hashes on (routing key, header, or property).
+func CreateConsistentHashLetter(letterID uint64, exchange *models.ConsistentHashExchange, hashKey string, body []byte) (*models.Letter, error) {
+
+	if err := exchange.Validate(); err != nil {
+		return nil, err
+	}
+
+	if hashKey == "" {
+		return nil, fmt.Errorf("can't publish to consistent hash exchange %s with an empty hash key", exchange.Name)
+	}
+
+	envelope := &models.Envelope{
+		Exchange:    exchange.Name,
+		ContentType: "application/json",
+	}
+
+	switch {
+	case exchange.HashHeader != "":
+		envelope.Headers = map[string]interface{}{exchange.HashHeader: hashKey}
+	case exchange.HashProperty == models.HashPropertyMessageID:
+		envelope.MessageID = hashKey
+	case exchange.HashProperty == models.HashPropertyCorrelationID:
+		envelope.CorrelationID = hashKey
+	default:
+		envelope.RoutingKey = hashKey
+	}
+
+	return &models.Letter{
+		LetterID:   letterID,
+		RetryCount: uint32(3),
+		Body:       body,
+		Envelope:   envelope,
+	}, nil
+}
+
 // CreateMockLetter creates a mock letter for publishing.
 func CreateMockLetter(letterID uint64, exchangeName string, queueName string, body []byte) *models.Letter {
 
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/houseofcat/turbocookedrabbit/v1/pkg/models"
+)
+
+func TestCreateConsistentHashLetter(t *testing.T) {
+
+	exchange := &models.ConsistentHashExchange{
+		Name: "ConsistentHashTestExchange",
+		Bindings: []*models.WeightedBinding{
+			{QueueName: "ConsistentHashTestQueue1", Weight: 1},
+			{QueueName: "ConsistentHashTestQueue2", Weight: 2},
+		},
+	}
+
+	letter, err := CreateConsistentHashLetter(1, exchange, "customer-42", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "customer-42", letter.Envelope.RoutingKey)
+
+	exchange.HashHeader = "x-customer"
+	letter, err = CreateConsistentHashLetter(1, exchange, "customer-42", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", letter.Envelope.RoutingKey)
+	assert.Equal(t, "customer-42", letter.Envelope.Headers["x-customer"])
+
+	exchange.HashHeader = ""
+	exchange.HashProperty = models.HashPropertyMessageID
+	letter, err = CreateConsistentHashLetter(1, exchange, "customer-42", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "customer-42", letter.Envelope.MessageID)
+
+	_, err = CreateConsistentHashLetter(1, exchange, "", nil)
+	assert.Error(t, err)
+}
+
+func TestConsistentHashExchangeValidate(t *testing.T) {
+
+	exchange := &models.ConsistentHashExchange{
+		Name:         "ConsistentHashTestExchange",
+		HashHeader:   "x-customer",
+		HashProperty: models.HashPropertyMessageID,
+		Bindings: []*models.WeightedBinding{
+			{QueueName: "ConsistentHashTestQueue1", Weight: 1},
+		},
+	}
+	assert.Error(t, exchange.Validate())
+
+	exchange.HashProperty = ""
+	assert.NoError(t, exchange.Validate())
+
+	exchange.Bindings = append(exchange.Bindings, &models.WeightedBinding{QueueName: "ConsistentHashTestQueue1", Weight: 1})
+	assert.Error(t, exchange.Validate())
+
+	exchange.Bindings = []*models.WeightedBinding{{QueueName: "ConsistentHashTestQueue1", Weight: 0}}
+	assert.Error(t, exchange.Validate())
+}